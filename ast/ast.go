@@ -5,6 +5,7 @@ package ast
 import (
 	"bytes"
 	"capuchin/token"
+	"strings"
 )
 
 // Node is a base interface for all AST elements.
@@ -25,6 +26,14 @@ type Expression interface {
 	expressionNode()
 }
 
+// TypeExpression is the base interface for all AST nodes which represent type
+// annotations, such as the int in "let x: int = 5;". Annotations are only read by the
+// type checker and have no effect when a program runs.
+type TypeExpression interface {
+	Node
+	typeNode()
+}
+
 // Program is the root node for a capuchin program AST.
 type Program struct {
 	Statements []Statement
@@ -55,6 +64,21 @@ func (p *Program) String() string {
 type Identifier struct {
 	Token token.Token // The token.IDENT token
 	Value string
+
+	// Binding is filled in by the resolver and is nil until the identifier has
+	// been resolved, or if it refers to nothing.
+	Binding *Binding `ast:"-"`
+}
+
+// Binding records the declaration an identifier refers to.
+type Binding struct {
+	// Decl is the identifier in the let statement or parameter list that declares
+	// the binding. It is nil for builtins.
+	Decl *Identifier
+
+	// Depth is the depth of the scope holding the binding: 0 for builtins, 1 for
+	// the top level of the program and one more for each enclosing function.
+	Depth int
 }
 
 func (i *Identifier) expressionNode() {}
@@ -69,6 +93,7 @@ func (i *Identifier) String() string {
 type LetStatement struct {
 	Token token.Token // The token.LET token
 	Name  *Identifier
+	Type  TypeExpression // The optional type annotation, nil if there is none
 	Value Expression
 }
 
@@ -81,6 +106,9 @@ func (ls *LetStatement) String() string {
 
 	out.WriteString(ls.TokenLiteral() + " ")
 	out.WriteString(ls.Name.String())
+	if ls.Type != nil {
+		out.WriteString(": " + ls.Type.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -115,6 +143,61 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// ThrowStatement represents a "throw" token and the value it throws, which unwinds
+// the program to the nearest enclosing try.
+type ThrowStatement struct {
+	Token token.Token // The 'throw' token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode() {}
+func (ts *ThrowStatement) TokenLiteral() string {
+	return ts.Token.Literal
+}
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ts.TokenLiteral() + " ")
+	if ts.Value != nil {
+		out.WriteString(ts.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
+// ImportStatement represents the import of a module, such as
+// `import "lib/math" as m;`, which binds the module to the name after "as".
+type ImportStatement struct {
+	Token token.Token // The 'import' token
+	Path  *StringLiteral
+	Name  *Identifier
+}
+
+func (is *ImportStatement) statementNode() {}
+func (is *ImportStatement) TokenLiteral() string {
+	return is.Token.Literal
+}
+func (is *ImportStatement) String() string {
+	return is.TokenLiteral() + " " + is.Path.String() + " as " + is.Name.String() + ";"
+}
+
+// ExportStatement represents a let statement marked with "export", whose binding is
+// made available to the programs importing the module.
+type ExportStatement struct {
+	Token     token.Token // The 'export' token
+	Statement *LetStatement
+}
+
+func (es *ExportStatement) statementNode() {}
+func (es *ExportStatement) TokenLiteral() string {
+	return es.Token.Literal
+}
+func (es *ExportStatement) String() string {
+	return es.TokenLiteral() + " " + es.Statement.String()
+}
+
 // ExpressionStatement represents an expression, such as "x + 5".
 type ExpressionStatement struct {
 	Token      token.Token // The first token of the expression
@@ -131,3 +214,567 @@ func (es *ExpressionStatement) String() string {
 	}
 	return ""
 }
+
+// StringLiteral represents a STRING token and the string it stands for.
+type StringLiteral struct {
+	Token token.Token // The token.STRING token, whose literal is the quoted string
+	Value string
+}
+
+func (sl *StringLiteral) expressionNode() {}
+func (sl *StringLiteral) TokenLiteral() string {
+	return sl.Token.Literal
+}
+func (sl *StringLiteral) String() string {
+	return sl.Token.Literal
+}
+
+// IntegerLiteral represents an INT token and its integer value.
+type IntegerLiteral struct {
+	Token token.Token // The token.INT token
+	Value int64
+}
+
+func (il *IntegerLiteral) expressionNode() {}
+func (il *IntegerLiteral) TokenLiteral() string {
+	return il.Token.Literal
+}
+func (il *IntegerLiteral) String() string {
+	return il.Token.Literal
+}
+
+// Boolean represents a TRUE or FALSE token and its boolean value.
+type Boolean struct {
+	Token token.Token // The token.TRUE or token.FALSE token
+	Value bool
+}
+
+func (b *Boolean) expressionNode() {}
+func (b *Boolean) TokenLiteral() string {
+	return b.Token.Literal
+}
+func (b *Boolean) String() string {
+	return b.Token.Literal
+}
+
+// PrefixExpression represents an operator applied to the expression on its right,
+// such as "!true" or "-5".
+type PrefixExpression struct {
+	Token    token.Token // The prefix token, eg ! or -
+	Operator string
+	Right    Expression
+}
+
+func (pe *PrefixExpression) expressionNode() {}
+func (pe *PrefixExpression) TokenLiteral() string {
+	return pe.Token.Literal
+}
+func (pe *PrefixExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(pe.Operator)
+	out.WriteString(pe.Right.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
+// InfixExpression represents an operator applied to the expressions either side of
+// it, such as "5 + 5".
+type InfixExpression struct {
+	Token    token.Token // The operator token, eg +
+	Left     Expression
+	Operator string
+	Right    Expression
+}
+
+func (ie *InfixExpression) expressionNode() {}
+func (ie *InfixExpression) TokenLiteral() string {
+	return ie.Token.Literal
+}
+func (ie *InfixExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ie.Left.String())
+	out.WriteString(" " + ie.Operator + " ")
+	out.WriteString(ie.Right.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
+// IfExpression represents an "if" token, its condition and the blocks evaluated
+// when the condition holds (Consequence) and when it does not (Alternative).
+type IfExpression struct {
+	Token       token.Token // The 'if' token
+	Condition   Expression
+	Consequence *BlockStatement
+	Alternative *BlockStatement
+}
+
+func (ie *IfExpression) expressionNode() {}
+func (ie *IfExpression) TokenLiteral() string {
+	return ie.Token.Literal
+}
+func (ie *IfExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("if")
+	out.WriteString(ie.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ie.Consequence.String())
+
+	if ie.Alternative != nil {
+		out.WriteString("else ")
+		out.WriteString(ie.Alternative.String())
+	}
+
+	return out.String()
+}
+
+// TryExpression represents a "try" token, the block it protects and the blocks run
+// when the protected block throws (Catch, binding the thrown value to Parameter) and
+// however it ends (Finally). Either Catch or Finally may be nil, but not both.
+type TryExpression struct {
+	Token     token.Token // The 'try' token
+	Body      *BlockStatement
+	Parameter *Identifier // The name bound in Catch, nil if there is no catch
+	Catch     *BlockStatement
+	Finally   *BlockStatement
+}
+
+func (te *TryExpression) expressionNode() {}
+func (te *TryExpression) TokenLiteral() string {
+	return te.Token.Literal
+}
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Body.String())
+
+	if te.Catch != nil {
+		out.WriteString("catch(" + te.Parameter.String() + ") ")
+		out.WriteString(te.Catch.String())
+	}
+	if te.Finally != nil {
+		out.WriteString("finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+
+// BlockStatement represents the statements enclosed by a pair of braces.
+type BlockStatement struct {
+	Token      token.Token // The '{' token
+	Statements []Statement
+}
+
+func (bs *BlockStatement) statementNode() {}
+func (bs *BlockStatement) TokenLiteral() string {
+	return bs.Token.Literal
+}
+func (bs *BlockStatement) String() string {
+	var out bytes.Buffer
+
+	for _, s := range bs.Statements {
+		out.WriteString(s.String())
+	}
+
+	return out.String()
+}
+
+// FunctionLiteral represents a "fn" token, the function's parameters and its body.
+type FunctionLiteral struct {
+	Token      token.Token // The 'fn' token
+	Parameters []*Identifier
+
+	// ParameterTypes holds the optional annotation of each parameter, with nil for
+	// those which have none. It may be nil if no parameter is annotated.
+	ParameterTypes []TypeExpression
+	ReturnType     TypeExpression // The optional return annotation
+
+	Body *BlockStatement
+}
+
+// ParameterType returns the annotation of the i-th parameter, or nil if it has none.
+func (fl *FunctionLiteral) ParameterType(i int) TypeExpression {
+	if i < len(fl.ParameterTypes) {
+		return fl.ParameterTypes[i]
+	}
+	return nil
+}
+
+func (fl *FunctionLiteral) expressionNode() {}
+func (fl *FunctionLiteral) TokenLiteral() string {
+	return fl.Token.Literal
+}
+func (fl *FunctionLiteral) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for i, p := range fl.Parameters {
+		param := p.String()
+		if t := fl.ParameterType(i); t != nil {
+			param += ": " + t.String()
+		}
+		params = append(params, param)
+	}
+
+	out.WriteString(fl.TokenLiteral())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") ")
+	if fl.ReturnType != nil {
+		out.WriteString("-> " + fl.ReturnType.String() + " ")
+	}
+	out.WriteString(fl.Body.String())
+
+	return out.String()
+}
+
+// CallExpression represents the application of a function to a list of arguments,
+// such as "add(1, 2)".
+type CallExpression struct {
+	Token     token.Token // The '(' token
+	Function  Expression  // Identifier or FunctionLiteral
+	Arguments []Expression
+}
+
+func (ce *CallExpression) expressionNode() {}
+func (ce *CallExpression) TokenLiteral() string {
+	return ce.Token.Literal
+}
+func (ce *CallExpression) String() string {
+	var out bytes.Buffer
+
+	args := []string{}
+	for _, a := range ce.Arguments {
+		args = append(args, a.String())
+	}
+
+	out.WriteString(ce.Function.String())
+	out.WriteString("(")
+	out.WriteString(strings.Join(args, ", "))
+	out.WriteString(")")
+
+	return out.String()
+}
+
+// MemberExpression represents the lookup of a named property of a value, such as
+// "err.message".
+type MemberExpression struct {
+	Token    token.Token // The '.' token
+	Object   Expression
+	Property *Identifier
+}
+
+func (me *MemberExpression) expressionNode() {}
+func (me *MemberExpression) TokenLiteral() string {
+	return me.Token.Literal
+}
+func (me *MemberExpression) String() string {
+	return me.Object.String() + "." + me.Property.String()
+}
+
+// ArrayLiteral represents a list of expressions between brackets, such as
+// "[1, 2 * 2]".
+type ArrayLiteral struct {
+	Token    token.Token // The '[' token
+	Elements []Expression
+}
+
+func (al *ArrayLiteral) expressionNode() {}
+func (al *ArrayLiteral) TokenLiteral() string {
+	return al.Token.Literal
+}
+func (al *ArrayLiteral) String() string {
+	elements := []string{}
+	for _, e := range al.Elements {
+		elements = append(elements, e.String())
+	}
+
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// HashLiteral represents pairs of keys and values between braces, such as
+// {"one": 1, "two": 2}. Keys[i] is the key of Values[i], in the order they were
+// written.
+type HashLiteral struct {
+	Token  token.Token // The '{' token
+	Keys   []Expression
+	Values []Expression
+}
+
+func (hl *HashLiteral) expressionNode() {}
+func (hl *HashLiteral) TokenLiteral() string {
+	return hl.Token.Literal
+}
+func (hl *HashLiteral) String() string {
+	pairs := []string{}
+	for i, key := range hl.Keys {
+		pairs = append(pairs, key.String()+": "+hl.Values[i].String())
+	}
+
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// IndexExpression represents the lookup of an element of an array or hash, such as
+// "xs[0]".
+type IndexExpression struct {
+	Token token.Token // The '[' token
+	Left  Expression
+	Index Expression
+}
+
+func (ie *IndexExpression) expressionNode() {}
+func (ie *IndexExpression) TokenLiteral() string {
+	return ie.Token.Literal
+}
+func (ie *IndexExpression) String() string {
+	return "(" + ie.Left.String() + "[" + ie.Index.String() + "])"
+}
+
+// NamedType represents a type annotation naming a type, such as "int".
+type NamedType struct {
+	Token token.Token // The token.IDENT token
+	Name  string
+}
+
+func (nt *NamedType) typeNode() {}
+func (nt *NamedType) TokenLiteral() string {
+	return nt.Token.Literal
+}
+func (nt *NamedType) String() string {
+	return nt.Name
+}
+
+// ArrayType represents the type annotation of an array, such as "[int]".
+type ArrayType struct {
+	Token   token.Token // The '[' token
+	Element TypeExpression
+}
+
+func (at *ArrayType) typeNode() {}
+func (at *ArrayType) TokenLiteral() string {
+	return at.Token.Literal
+}
+func (at *ArrayType) String() string {
+	return "[" + at.Element.String() + "]"
+}
+
+// HashType represents the type annotation of a hash, such as "{string: int}".
+type HashType struct {
+	Token token.Token // The '{' token
+	Key   TypeExpression
+	Value TypeExpression
+}
+
+func (ht *HashType) typeNode() {}
+func (ht *HashType) TokenLiteral() string {
+	return ht.Token.Literal
+}
+func (ht *HashType) String() string {
+	return "{" + ht.Key.String() + ": " + ht.Value.String() + "}"
+}
+
+// FunctionType represents the type annotation of a function, such as
+// "fn(int, int) -> bool".
+type FunctionType struct {
+	Token      token.Token // The 'fn' token
+	Parameters []TypeExpression
+	Return     TypeExpression
+}
+
+func (ft *FunctionType) typeNode() {}
+func (ft *FunctionType) TokenLiteral() string {
+	return ft.Token.Literal
+}
+func (ft *FunctionType) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range ft.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("fn(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") -> ")
+	out.WriteString(ft.Return.String())
+
+	return out.String()
+}
+
+// Pos returns the position of the first token of node in the source code. For
+// expressions that is the position of their leftmost operand, rather than of the
+// operator token they hold.
+func Pos(node Node) token.Position {
+	switch n := node.(type) {
+	case *Program:
+		if len(n.Statements) > 0 {
+			return Pos(n.Statements[0])
+		}
+	case *ExpressionStatement:
+		if n.Expression != nil {
+			return Pos(n.Expression)
+		}
+		return n.Token.Pos
+	case *InfixExpression:
+		return Pos(n.Left)
+	case *CallExpression:
+		return Pos(n.Function)
+	case *MemberExpression:
+		return Pos(n.Object)
+	case *IndexExpression:
+		return Pos(n.Left)
+	case *Identifier:
+		return n.Token.Pos
+	case *IntegerLiteral:
+		return n.Token.Pos
+	case *StringLiteral:
+		return n.Token.Pos
+	case *Boolean:
+		return n.Token.Pos
+	case *PrefixExpression:
+		return n.Token.Pos
+	case *IfExpression:
+		return n.Token.Pos
+	case *TryExpression:
+		return n.Token.Pos
+	case *FunctionLiteral:
+		return n.Token.Pos
+	case *ArrayLiteral:
+		return n.Token.Pos
+	case *HashLiteral:
+		return n.Token.Pos
+	case *LetStatement:
+		return n.Token.Pos
+	case *ReturnStatement:
+		return n.Token.Pos
+	case *ThrowStatement:
+		return n.Token.Pos
+	case *ImportStatement:
+		return n.Token.Pos
+	case *ExportStatement:
+		return n.Token.Pos
+	case *BlockStatement:
+		return n.Token.Pos
+	case *NamedType:
+		return n.Token.Pos
+	case *ArrayType:
+		return n.Token.Pos
+	case *HashType:
+		return n.Token.Pos
+	case *FunctionType:
+		return n.Token.Pos
+	}
+	return token.Position{}
+}
+
+// End returns the position just after the last token of node in the source code.
+// Closing brackets and braces are not kept in the tree, so the end of a call, group
+// or block is taken from the last operand or statement within it.
+func End(node Node) token.Position {
+	switch n := node.(type) {
+	case *Program:
+		if len(n.Statements) > 0 {
+			return End(n.Statements[len(n.Statements)-1])
+		}
+	case *LetStatement:
+		if n.Value != nil {
+			return End(n.Value)
+		}
+		return End(n.Name)
+	case *ReturnStatement:
+		if n.ReturnValue != nil {
+			return End(n.ReturnValue)
+		}
+		return tokenEnd(n.Token)
+	case *ThrowStatement:
+		if n.Value != nil {
+			return End(n.Value)
+		}
+		return tokenEnd(n.Token)
+	case *ImportStatement:
+		return End(n.Name)
+	case *ExportStatement:
+		return End(n.Statement)
+	case *ExpressionStatement:
+		if n.Expression != nil {
+			return End(n.Expression)
+		}
+		return tokenEnd(n.Token)
+	case *BlockStatement:
+		if len(n.Statements) > 0 {
+			return End(n.Statements[len(n.Statements)-1])
+		}
+		return tokenEnd(n.Token)
+	case *PrefixExpression:
+		return End(n.Right)
+	case *InfixExpression:
+		return End(n.Right)
+	case *IfExpression:
+		if n.Alternative != nil {
+			return End(n.Alternative)
+		}
+		return End(n.Consequence)
+	case *TryExpression:
+		if n.Finally != nil {
+			return End(n.Finally)
+		}
+		if n.Catch != nil {
+			return End(n.Catch)
+		}
+		return End(n.Body)
+	case *MemberExpression:
+		return End(n.Property)
+	case *IndexExpression:
+		return End(n.Index)
+	case *ArrayLiteral:
+		if len(n.Elements) > 0 {
+			return End(n.Elements[len(n.Elements)-1])
+		}
+		return tokenEnd(n.Token)
+	case *HashLiteral:
+		if len(n.Values) > 0 {
+			return End(n.Values[len(n.Values)-1])
+		}
+		return tokenEnd(n.Token)
+	case *FunctionLiteral:
+		return End(n.Body)
+	case *CallExpression:
+		if len(n.Arguments) > 0 {
+			return End(n.Arguments[len(n.Arguments)-1])
+		}
+		return tokenEnd(n.Token)
+	case *Identifier:
+		return tokenEnd(n.Token)
+	case *IntegerLiteral:
+		return tokenEnd(n.Token)
+	case *StringLiteral:
+		return tokenEnd(n.Token)
+	case *Boolean:
+		return tokenEnd(n.Token)
+	case *NamedType:
+		return tokenEnd(n.Token)
+	case *ArrayType:
+		return End(n.Element)
+	case *HashType:
+		return End(n.Value)
+	case *FunctionType:
+		return End(n.Return)
+	}
+	return token.Position{}
+}
+
+// tokenEnd returns the position just after tok.
+func tokenEnd(tok token.Token) token.Position {
+	if !tok.Pos.IsValid() {
+		return tok.Pos
+	}
+	return token.Position{Line: tok.Pos.Line, Column: tok.Pos.Column + len(tok.Literal)}
+}
@@ -26,3 +26,36 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() returned wrong value, got=%q", program.String())
 	}
 }
+
+func TestPos(t *testing.T) {
+	at := func(line, column int) token.Position {
+		return token.Position{Line: line, Column: column}
+	}
+
+	call := &CallExpression{
+		Token: token.Token{Type: token.LPAREN, Literal: "(", Pos: at(2, 4)},
+		Function: &Identifier{
+			Token: token.Token{Type: token.IDENT, Literal: "add", Pos: at(2, 1)},
+			Value: "add",
+		},
+	}
+	sum := &InfixExpression{
+		Token:    token.Token{Type: token.PLUS, Literal: "+", Pos: at(2, 10)},
+		Left:     call,
+		Operator: "+",
+		Right: &IntegerLiteral{
+			Token: token.Token{Type: token.INT, Literal: "1", Pos: at(2, 12)},
+			Value: 1,
+		},
+	}
+
+	if Pos(sum) != at(2, 1) {
+		t.Errorf("Pos(sum) wrong. got=%s", Pos(sum))
+	}
+	if Pos(sum.Right) != at(2, 12) {
+		t.Errorf("Pos(sum.Right) wrong. got=%s", Pos(sum.Right))
+	}
+	if Pos(&Program{}).IsValid() {
+		t.Errorf("Pos of an empty program should not be valid")
+	}
+}
@@ -0,0 +1,85 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Dump returns an indented outline of the tree rooted at node, one node per line,
+// for debugging. Each line names the field holding the node, its type, its values
+// such as an identifier's name or an operator, and its position:
+//
+//	Program 1:1
+//	  Statements[0]: ExpressionStatement 1:1
+//	    Expression: InfixExpression Operator="+" 1:1
+//	      Left: IntegerLiteral Value=1 1:1
+//	      Right: IntegerLiteral Value=2 1:5
+func Dump(node Node) string {
+	d := &dumper{}
+	d.node("", reflect.ValueOf(&node).Elem(), 0)
+	return d.out.String()
+}
+
+type dumper struct {
+	out bytes.Buffer
+}
+
+// node prints the node held by v, labelled with the field it was found in, followed
+// by its children.
+func (d *dumper) node(label string, v reflect.Value, depth int) {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	line := []string{v.Type().Name()}
+	if label != "" {
+		line[0] = label + ": " + line[0]
+	}
+
+	type child struct {
+		label string
+		value reflect.Value
+	}
+	children := []child{}
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		value := v.Field(i)
+		if !field.IsExported() || field.Tag.Get("ast") == "-" {
+			continue
+		}
+
+		switch {
+		case value.Type() == tokenType:
+			// Only the position is shown, the rest of the token repeats the
+			// node's values
+		case value.Kind() == reflect.Slice:
+			for j := 0; j < value.Len(); j++ {
+				children = append(children, child{fmt.Sprintf("%s[%d]", field.Name, j), value.Index(j)})
+			}
+		case value.Kind() == reflect.Interface || value.Kind() == reflect.Ptr:
+			children = append(children, child{field.Name, value})
+		default:
+			line = append(line, fmt.Sprintf("%s=%#v", field.Name, value.Interface()))
+		}
+	}
+
+	if n, ok := v.Addr().Interface().(Node); ok {
+		if pos := Pos(n); pos.IsValid() {
+			line = append(line, pos.String())
+		}
+	}
+
+	d.out.WriteString(strings.Repeat("  ", depth))
+	d.out.WriteString(strings.Join(line, " "))
+	d.out.WriteString("\n")
+
+	for _, c := range children {
+		d.node(c.label, c.value, depth+1)
+	}
+}
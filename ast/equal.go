@@ -0,0 +1,158 @@
+package ast
+
+import (
+	"capuchin/token"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// CompareOptions controls which parts of two trees are considered by Equal and Diff.
+type CompareOptions struct {
+	// IgnorePositions skips the source positions of tokens, so the same program
+	// laid out differently compares as equal.
+	IgnorePositions bool
+
+	// IgnoreTokens skips tokens entirely and only compares the values held by the
+	// nodes, which suits trees built by hand without tokens.
+	IgnoreTokens bool
+
+	// All makes Diff report every mismatching subtree rather than stopping at the
+	// first one. Equal ignores it.
+	All bool
+}
+
+// Difference describes a single mismatch found by Diff.
+type Difference struct {
+	Path string // The route from the root to the mismatch, eg "Program.Statements[0].Value"
+	A    string // The value found in the first tree
+	B    string // The value found in the second tree
+}
+
+// String returns the difference in the "path: a != b" form.
+func (d Difference) String() string {
+	return fmt.Sprintf("%s: %s != %s", d.Path, d.A, d.B)
+}
+
+// Equal reports whether the trees rooted at a and b are structurally identical, taking
+// the supplied options into account.
+func Equal(a, b Node, opts CompareOptions) bool {
+	opts.All = false
+	return len(Diff(a, b, opts)) == 0
+}
+
+// Diff compares the trees rooted at a and b field by field and returns the mismatching
+// subtrees. Unless opts.All is set only the first mismatch is returned. An empty result
+// means the trees are equal.
+func Diff(a, b Node, opts CompareOptions) []Difference {
+	c := &comparer{opts: opts}
+
+	root := "Node"
+	if a != nil {
+		root = reflect.Indirect(reflect.ValueOf(a)).Type().Name()
+	}
+
+	c.compare(root, reflect.ValueOf(&a).Elem(), reflect.ValueOf(&b).Elem())
+
+	return c.diffs
+}
+
+// comparer walks two trees in step, collecting the differences between them.
+type comparer struct {
+	opts  CompareOptions
+	diffs []Difference
+}
+
+var tokenType = reflect.TypeOf(token.Token{})
+
+// done reports whether the comparison can stop early.
+func (c *comparer) done() bool {
+	return !c.opts.All && len(c.diffs) > 0
+}
+
+func (c *comparer) report(path string, a, b string) {
+	c.diffs = append(c.diffs, Difference{Path: path, A: a, B: b})
+}
+
+// compare records the differences between a and b, which are values of the same static
+// type found at path in each tree.
+func (c *comparer) compare(path string, a, b reflect.Value) {
+	if c.done() {
+		return
+	}
+
+	switch a.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			if a.IsNil() != b.IsNil() {
+				c.report(path, describe(a), describe(b))
+			}
+			return
+		}
+		if a.Elem().Type() != b.Elem().Type() {
+			c.report(path, describe(a), describe(b))
+			return
+		}
+		c.compare(path, a.Elem(), b.Elem())
+
+	case reflect.Struct:
+		if a.Type() == tokenType {
+			c.compareTokens(path, a.Interface().(token.Token), b.Interface().(token.Token))
+			return
+		}
+
+		for i := 0; i < a.NumField(); i++ {
+			field := a.Type().Field(i)
+
+			// Unexported fields and fields tagged `ast:"-"` carry no syntax
+			if !field.IsExported() || field.Tag.Get("ast") == "-" {
+				continue
+			}
+
+			c.compare(path+"."+field.Name, a.Field(i), b.Field(i))
+		}
+
+	case reflect.Slice:
+		if a.Len() != b.Len() {
+			c.report(path, "len "+strconv.Itoa(a.Len()), "len "+strconv.Itoa(b.Len()))
+			return
+		}
+		for i := 0; i < a.Len(); i++ {
+			c.compare(fmt.Sprintf("%s[%d]", path, i), a.Index(i), b.Index(i))
+		}
+
+	default:
+		if a.Interface() != b.Interface() {
+			c.report(path, describe(a), describe(b))
+		}
+	}
+}
+
+func (c *comparer) compareTokens(path string, a, b token.Token) {
+	if c.opts.IgnoreTokens {
+		return
+	}
+
+	if a.Type != b.Type || a.Literal != b.Literal {
+		c.report(path, fmt.Sprintf("%s %q", a.Type, a.Literal),
+			fmt.Sprintf("%s %q", b.Type, b.Literal))
+		return
+	}
+
+	if !c.opts.IgnorePositions && a.Pos != b.Pos {
+		c.report(path+".Pos", a.Pos.String(), b.Pos.String())
+	}
+}
+
+// describe renders a value for a Difference, using the source form of nodes.
+func describe(v reflect.Value) string {
+	if (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) && v.IsNil() {
+		return "nil"
+	}
+
+	if n, ok := v.Interface().(Node); ok {
+		return fmt.Sprintf("%T(%s)", n, n.String())
+	}
+
+	return fmt.Sprintf("%#v", v.Interface())
+}
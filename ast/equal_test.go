@@ -0,0 +1,163 @@
+package ast_test
+
+import (
+	"capuchin/ast"
+	"capuchin/parsertest"
+	"testing"
+)
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		opts     ast.CompareOptions
+		expected bool
+	}{
+		{"let x = 1 + y;", "let x = 1 + y;", ast.CompareOptions{}, true},
+		{"let x = 1 + y;", "let x = 1 + z;", ast.CompareOptions{}, false},
+		{"let x = 1 + y;", "let x =\n  1 + y", ast.CompareOptions{}, false},
+		{"let x = 1 + y;", "let x =\n  1 + y", ast.CompareOptions{IgnorePositions: true}, true},
+		{"let x = (1 + y);", "let x = 1 + y;", ast.CompareOptions{IgnorePositions: true}, true},
+		{"fn(x, y) { x + y; }", "fn(x, y) { x + y }", ast.CompareOptions{IgnorePositions: true}, true},
+		{"fn(x, y) { x + y; }", "fn(x) { x + y; }", ast.CompareOptions{IgnorePositions: true}, false},
+		{"if (a) { b }", "if (a) { b } else { c }", ast.CompareOptions{IgnorePositions: true}, false},
+		{"-a", "!a", ast.CompareOptions{IgnorePositions: true}, false},
+		{"a * b", "a + b", ast.CompareOptions{IgnoreTokens: true}, false},
+	}
+
+	for _, tt := range tests {
+		a := parsertest.Parse(t, tt.a)
+		b := parsertest.Parse(t, tt.b)
+
+		if got := ast.Equal(a, b, tt.opts); got != tt.expected {
+			t.Errorf("Equal(%q, %q, %+v) wrong. want=%t, got=%t (%v)",
+				tt.a, tt.b, tt.opts, tt.expected, got, ast.Diff(a, b, tt.opts))
+		}
+	}
+}
+
+func TestEqualIgnoreTokens(t *testing.T) {
+	// A tree built without tokens only matches the parsed tree when tokens are
+	// left out of the comparison.
+	built := &ast.Program{
+		Statements: []ast.Statement{
+			&ast.LetStatement{
+				Name:  &ast.Identifier{Value: "x"},
+				Value: &ast.IntegerLiteral{Value: 5},
+			},
+		},
+	}
+	parsed := parsertest.Parse(t, "let x = 5;")
+
+	if ast.Equal(built, parsed, ast.CompareOptions{IgnorePositions: true}) {
+		t.Errorf("trees without tokens should not equal parsed trees")
+	}
+	if !ast.Equal(built, parsed, ast.CompareOptions{IgnoreTokens: true}) {
+		t.Errorf("trees should be equal when ignoring tokens. diff=%v",
+			ast.Diff(built, parsed, ast.CompareOptions{IgnoreTokens: true}))
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := parsertest.Parse(t, "let x = 1 + y; add(1, 2);")
+	b := parsertest.Parse(t, "let x = 1 - y; add(1, 3);")
+	opts := ast.CompareOptions{IgnorePositions: true}
+
+	first := ast.Diff(a, b, opts)
+	if len(first) != 1 {
+		t.Fatalf("Diff should stop at the first difference. got=%v", first)
+	}
+	if first[0].Path != "Program.Statements[0].Value.Token" {
+		t.Errorf("first difference has wrong path. got=%q", first[0].Path)
+	}
+
+	opts.All = true
+	all := ast.Diff(a, b, opts)
+
+	expected := []string{
+		`Program.Statements[0].Value.Token: + "+" != - "-"`,
+		`Program.Statements[0].Value.Operator: "+" != "-"`,
+		`Program.Statements[1].Expression.Arguments[1].Token: INT "2" != INT "3"`,
+		`Program.Statements[1].Expression.Arguments[1].Value: 2 != 3`,
+	}
+	if len(all) != len(expected) {
+		t.Fatalf("wrong number of differences. want=%d, got=%d (%v)",
+			len(expected), len(all), all)
+	}
+	for i, d := range all {
+		if d.String() != expected[i] {
+			t.Errorf("difference %d wrong. want=%q, got=%q", i, expected[i], d.String())
+		}
+	}
+}
+
+func TestDiffReportsPositions(t *testing.T) {
+	a := parsertest.Parse(t, "x")
+	b := parsertest.Parse(t, "\n  x")
+
+	diffs := ast.Diff(a, b, ast.CompareOptions{})
+	if len(diffs) != 1 {
+		t.Fatalf("expected a single difference. got=%v", diffs)
+	}
+	if diffs[0].String() != "Program.Statements[0].Token.Pos: 1:1 != 2:3" {
+		t.Errorf("wrong difference. got=%q", diffs[0].String())
+	}
+}
+
+func TestDiffNodeTypes(t *testing.T) {
+	a := &ast.ExpressionStatement{Expression: &ast.Identifier{Value: "x"}}
+	b := &ast.ExpressionStatement{Expression: &ast.Boolean{Value: true}}
+
+	diffs := ast.Diff(a, b, ast.CompareOptions{IgnoreTokens: true})
+	if len(diffs) != 1 {
+		t.Fatalf("expected a single difference. got=%v", diffs)
+	}
+
+	d := diffs[0]
+	if d.Path != "ExpressionStatement.Expression" ||
+		d.A != "*ast.Identifier(x)" || d.B != "*ast.Boolean()" {
+		t.Errorf("wrong difference. got=%q", d.String())
+	}
+}
+
+func TestDump(t *testing.T) {
+	program := parsertest.Parse(t, "let x = -1 + y;\nf(x)")
+
+	expected := `Program 1:1
+  Statements[0]: LetStatement 1:1
+    Name: Identifier Value="x" 1:5
+    Value: InfixExpression Operator="+" 1:9
+      Left: PrefixExpression Operator="-" 1:9
+        Right: IntegerLiteral Value=1 1:10
+      Right: Identifier Value="y" 1:14
+  Statements[1]: ExpressionStatement 2:1
+    Expression: CallExpression 2:1
+      Function: Identifier Value="f" 2:1
+      Arguments[0]: Identifier Value="x" 2:3
+`
+
+	if got := ast.Dump(program); got != expected {
+		t.Errorf("wrong dump.\nwant=\n%s\ngot=\n%s", expected, got)
+	}
+}
+
+func TestSexp(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = -1 + y;\nf(x)", "(let x (+ (- 1) y))\n(call f x)"},
+		{"let add = fn(x: int, y) -> int { return x + y; };", "(let add (fn (x:int y):int (block (return (+ x y)))))"},
+		{"if (a < b) { a } else { b }", "(if (< a b) (block a) (block b))"},
+		{"if (true) {}", "(if true (block))"},
+		{"try { throw e.x; } catch (e) { 1 } finally { 2 }", "(try (block (throw (. e x))) (catch e (block 1)) (finally (block 2)))"},
+		{"fn() {}()", "(call (fn () (block)))"},
+		{`import "lib/math" as m; export let x = m.pi;`, "(import \"lib/math\" m)\n(export (let x (. m pi)))"},
+		{`[1, {"a": xs[0]}, {}]`, `(array 1 (hash "a" (index xs 0)) (hash))`},
+	}
+
+	for _, tt := range tests {
+		if got := ast.Sexp(parsertest.Parse(t, tt.input)); got != tt.expected {
+			t.Errorf("wrong S-expression for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
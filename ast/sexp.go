@@ -0,0 +1,150 @@
+package ast
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+)
+
+// Sexp returns the tree rooted at node as an S-expression, with each operator or
+// construct written before its operands and the statements of a program on lines of
+// their own:
+//
+//	(let add (fn (x y) (block (+ x y))))
+//	(call add 1 (* 2 3))
+//
+// Annotated names are written as "name:type".
+func Sexp(node Node) string {
+	var out bytes.Buffer
+	writeSexp(&out, node)
+	return out.String()
+}
+
+func writeSexp(out *bytes.Buffer, node Node) {
+	// A missing child may be a nil pointer wrapped in the interface
+	if node == nil || reflect.ValueOf(node).IsNil() {
+		out.WriteString("nil")
+		return
+	}
+
+	list := func(head string, nodes ...Node) {
+		out.WriteString("(" + head)
+		for _, n := range nodes {
+			out.WriteString(" ")
+			writeSexp(out, n)
+		}
+		out.WriteString(")")
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for i, s := range n.Statements {
+			if i > 0 {
+				out.WriteString("\n")
+			}
+			writeSexp(out, s)
+		}
+
+	case *LetStatement:
+		list("let "+annotated(n.Name, n.Type), n.Value)
+
+	case *ReturnStatement:
+		list("return", n.ReturnValue)
+
+	case *ThrowStatement:
+		list("throw", n.Value)
+
+	case *ImportStatement:
+		list("import", n.Path, n.Name)
+
+	case *ExportStatement:
+		list("export", n.Statement)
+
+	case *ExpressionStatement:
+		writeSexp(out, n.Expression)
+
+	case *BlockStatement:
+		stmts := []Node{}
+		for _, s := range n.Statements {
+			stmts = append(stmts, s)
+		}
+		list("block", stmts...)
+
+	case *PrefixExpression:
+		list(n.Operator, n.Right)
+
+	case *InfixExpression:
+		list(n.Operator, n.Left, n.Right)
+
+	case *IfExpression:
+		if n.Alternative != nil {
+			list("if", n.Condition, n.Consequence, n.Alternative)
+		} else {
+			list("if", n.Condition, n.Consequence)
+		}
+
+	case *TryExpression:
+		out.WriteString("(try ")
+		writeSexp(out, n.Body)
+		if n.Catch != nil {
+			out.WriteString(" ")
+			list("catch "+n.Parameter.Value, n.Catch)
+		}
+		if n.Finally != nil {
+			out.WriteString(" ")
+			list("finally", n.Finally)
+		}
+		out.WriteString(")")
+
+	case *FunctionLiteral:
+		params := []string{}
+		for i, p := range n.Parameters {
+			params = append(params, annotated(p, n.ParameterType(i)))
+		}
+		head := "fn (" + strings.Join(params, " ") + ")"
+		if n.ReturnType != nil {
+			head += ":" + n.ReturnType.String()
+		}
+		list(head, n.Body)
+
+	case *CallExpression:
+		args := []Node{n.Function}
+		for _, a := range n.Arguments {
+			args = append(args, a)
+		}
+		list("call", args...)
+
+	case *MemberExpression:
+		out.WriteString("(. ")
+		writeSexp(out, n.Object)
+		out.WriteString(" " + n.Property.Value + ")")
+
+	case *IndexExpression:
+		list("index", n.Left, n.Index)
+
+	case *ArrayLiteral:
+		elements := []Node{}
+		for _, e := range n.Elements {
+			elements = append(elements, e)
+		}
+		list("array", elements...)
+
+	case *HashLiteral:
+		pairs := []Node{}
+		for i, key := range n.Keys {
+			pairs = append(pairs, key, n.Values[i])
+		}
+		list("hash", pairs...)
+
+	default:
+		out.WriteString(node.String())
+	}
+}
+
+// annotated returns name followed by its type annotation, if it has one.
+func annotated(name *Identifier, t TypeExpression) string {
+	if t == nil {
+		return name.Value
+	}
+	return name.Value + ":" + t.String()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"capuchin/capc"
+	"capuchin/compiler"
+	"capuchin/lexer"
+	"capuchin/object"
+	"capuchin/optimize"
+	"capuchin/parser"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// buildCommand compiles each of the named scripts to a .capc file next to it, or to
+// the file named by -o when there is a single script. It exits with 1 if a script
+// does not compile and 2 if one could not be read, parsed or written.
+func buildCommand(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	output := flags.String("o", "", "write the compiled program to `file` (one script only)")
+	optimized := flags.Bool("O", false, "optimize the program before compiling it")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: capuchin build [-O] [-o file] script.cap...")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 || (*output != "" && flags.NArg() > 1) {
+		flags.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		out := *output
+		if out == "" {
+			out = strings.TrimSuffix(path, filepath.Ext(path)) + capc.Extension
+		}
+
+		if code := build(path, out, *optimized); code > status {
+			status = code
+		}
+	}
+
+	return status
+}
+
+// build compiles the script at path to a .capc file at out, optimizing it first if
+// asked to, and returns the exit status for the script.
+func build(path, out string, optimized bool) int {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", path, e)
+		}
+		return 2
+	}
+
+	if optimized {
+		optimize.Program(program, object.BuiltinNames()...)
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	file := &capc.File{SourceHash: capc.Hash(src), Bytecode: comp.Bytecode()}
+	if err := capc.Encode(f, file); err != nil {
+		f.Close()
+		fmt.Fprintf(os.Stderr, "%s: %s\n", out, err)
+		return 2
+	}
+	if err := f.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	return 0
+}
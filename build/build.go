@@ -0,0 +1,342 @@
+// Package build provides a fluent API for constructing capuchin abstract syntax trees
+// from Go code, for example when generating programs:
+//
+//	build.Let("x", build.Infix(build.Int(1), "+", build.Ident("y")))
+//
+// Every node is given the same tokens the parser would produce, so the trees print
+// and format as valid source. Tokens built this way carry no source position.
+//
+// Invalid input, such as an unknown operator or a keyword used as an identifier, is
+// a programming error and causes a panic.
+package build
+
+import (
+	"capuchin/ast"
+	"capuchin/lexer"
+	"capuchin/parser"
+	"capuchin/token"
+	"fmt"
+	"strconv"
+)
+
+// Program returns a program made up of the supplied statements.
+func Program(stmts ...ast.Statement) *ast.Program {
+	return &ast.Program{Statements: append([]ast.Statement{}, stmts...)}
+}
+
+// Let returns a statement binding value to name, eg "let name = value;".
+func Let(name string, value ast.Expression) *ast.LetStatement {
+	return &ast.LetStatement{
+		Token: token.Token{Type: token.LET, Literal: "let"},
+		Name:  Ident(name),
+		Value: value,
+	}
+}
+
+// Return returns a statement returning value, eg "return value;".
+func Return(value ast.Expression) *ast.ReturnStatement {
+	return &ast.ReturnStatement{
+		Token:       token.Token{Type: token.RETURN, Literal: "return"},
+		ReturnValue: value,
+	}
+}
+
+// Throw returns a statement throwing value, eg "throw value;".
+func Throw(value ast.Expression) *ast.ThrowStatement {
+	return &ast.ThrowStatement{
+		Token: token.Token{Type: token.THROW, Literal: "throw"},
+		Value: value,
+	}
+}
+
+// Import returns a statement importing the module at path as name, eg
+// `import "path" as name;`.
+func Import(path, name string) *ast.ImportStatement {
+	return &ast.ImportStatement{
+		Token: token.Token{Type: token.IMPORT, Literal: "import"},
+		Path:  String(path),
+		Name:  Ident(name),
+	}
+}
+
+// Export returns let marked as exported, eg "export let name = value;".
+func Export(let *ast.LetStatement) *ast.ExportStatement {
+	return &ast.ExportStatement{
+		Token:     token.Token{Type: token.EXPORT, Literal: "export"},
+		Statement: let,
+	}
+}
+
+// Expr returns a statement made up of the single expression exp.
+func Expr(exp ast.Expression) *ast.ExpressionStatement {
+	return &ast.ExpressionStatement{Token: firstToken(exp), Expression: exp}
+}
+
+// Block returns a block containing the supplied statements.
+func Block(stmts ...ast.Statement) *ast.BlockStatement {
+	return &ast.BlockStatement{
+		Token:      token.Token{Type: token.LBRACE, Literal: "{"},
+		Statements: append([]ast.Statement{}, stmts...),
+	}
+}
+
+// Ident returns an identifier referring to name. It panics if name is not a valid
+// identifier or is a reserved keyword.
+func Ident(name string) *ast.Identifier {
+	if !validIdentifier(name) {
+		panic(fmt.Sprintf("build: %q is not a valid identifier", name))
+	}
+	if tok := token.LookupIdent(name); tok != token.IDENT {
+		panic(fmt.Sprintf("build: %q is a reserved keyword", name))
+	}
+
+	return &ast.Identifier{
+		Token: token.Token{Type: token.IDENT, Literal: name},
+		Value: name,
+	}
+}
+
+// Int returns an integer literal. As the language has no negative literals a
+// negative value is returned as the minus prefix applied to its magnitude.
+func Int(value int64) ast.Expression {
+	if value < 0 {
+		if value == -value {
+			panic(fmt.Sprintf("build: %d cannot be written as a literal", value))
+		}
+		return Prefix("-", Int(-value))
+	}
+
+	literal := strconv.FormatInt(value, 10)
+	return &ast.IntegerLiteral{
+		Token: token.Token{Type: token.INT, Literal: literal},
+		Value: value,
+	}
+}
+
+// String returns a string literal, with the characters which need it escaped.
+func String(value string) *ast.StringLiteral {
+	return &ast.StringLiteral{
+		Token: token.Token{Type: token.STRING, Literal: lexer.Quote(value)},
+		Value: value,
+	}
+}
+
+// Bool returns the literal true or false.
+func Bool(value bool) *ast.Boolean {
+	if value {
+		return &ast.Boolean{Token: token.Token{Type: token.TRUE, Literal: "true"}, Value: true}
+	}
+	return &ast.Boolean{Token: token.Token{Type: token.FALSE, Literal: "false"}, Value: false}
+}
+
+// Prefix returns the operator op applied to right, eg "-right". It panics if op is
+// not a prefix operator.
+func Prefix(op string, right ast.Expression) *ast.PrefixExpression {
+	tok := operator(op)
+	if tok.Type != token.BANG && tok.Type != token.MINUS {
+		panic(fmt.Sprintf("build: %q is not a prefix operator", op))
+	}
+
+	return &ast.PrefixExpression{Token: tok, Operator: op, Right: right}
+}
+
+// Infix returns the operator op applied to left and right, eg "left + right". It
+// panics if op is not an infix operator.
+func Infix(left ast.Expression, op string, right ast.Expression) *ast.InfixExpression {
+	tok := operator(op)
+	if tok.Type == token.BANG {
+		panic(fmt.Sprintf("build: %q is not an infix operator", op))
+	}
+
+	return &ast.InfixExpression{Token: tok, Left: left, Operator: op, Right: right}
+}
+
+// If returns an if expression without an else branch.
+func If(condition ast.Expression, consequence *ast.BlockStatement) *ast.IfExpression {
+	return &ast.IfExpression{
+		Token:       token.Token{Type: token.IF, Literal: "if"},
+		Condition:   condition,
+		Consequence: consequence,
+	}
+}
+
+// IfElse returns an if expression with both branches.
+func IfElse(condition ast.Expression, consequence, alternative *ast.BlockStatement) *ast.IfExpression {
+	exp := If(condition, consequence)
+	exp.Alternative = alternative
+	return exp
+}
+
+// TryCatch returns a try expression whose catch block binds the value caught to
+// param.
+func TryCatch(body *ast.BlockStatement, param string, catch *ast.BlockStatement) *ast.TryExpression {
+	return &ast.TryExpression{
+		Token:     token.Token{Type: token.TRY, Literal: "try"},
+		Body:      body,
+		Parameter: Ident(param),
+		Catch:     catch,
+	}
+}
+
+// TryFinally returns a try expression without a catch block.
+func TryFinally(body, finally *ast.BlockStatement) *ast.TryExpression {
+	return &ast.TryExpression{
+		Token:   token.Token{Type: token.TRY, Literal: "try"},
+		Body:    body,
+		Finally: finally,
+	}
+}
+
+// TryCatchFinally returns a try expression with both a catch and a finally block.
+func TryCatchFinally(body *ast.BlockStatement, param string, catch, finally *ast.BlockStatement) *ast.TryExpression {
+	exp := TryCatch(body, param, catch)
+	exp.Finally = finally
+	return exp
+}
+
+// Member returns the lookup of the property name in object, eg "object.name".
+func Member(object ast.Expression, name string) *ast.MemberExpression {
+	return &ast.MemberExpression{
+		Token:    token.Token{Type: token.DOT, Literal: "."},
+		Object:   object,
+		Property: Ident(name),
+	}
+}
+
+// Index returns the lookup of index in left, eg "left[index]".
+func Index(left, index ast.Expression) *ast.IndexExpression {
+	return &ast.IndexExpression{
+		Token: token.Token{Type: token.LBRACKET, Literal: "["},
+		Left:  left,
+		Index: index,
+	}
+}
+
+// Array returns an array literal of the supplied elements, eg "[1, 2]".
+func Array(elements ...ast.Expression) *ast.ArrayLiteral {
+	return &ast.ArrayLiteral{
+		Token:    token.Token{Type: token.LBRACKET, Literal: "["},
+		Elements: append([]ast.Expression{}, elements...),
+	}
+}
+
+// Hash returns a hash literal of the supplied keys and values, which alternate, so
+// that Hash(String("a"), Int(1)) builds {"a": 1}. It panics if a key has no value.
+func Hash(pairs ...ast.Expression) *ast.HashLiteral {
+	if len(pairs)%2 != 0 {
+		panic(fmt.Sprintf("build: hash key %s has no value", pairs[len(pairs)-1]))
+	}
+
+	hash := &ast.HashLiteral{
+		Token:  token.Token{Type: token.LBRACE, Literal: "{"},
+		Keys:   []ast.Expression{},
+		Values: []ast.Expression{},
+	}
+	for i := 0; i < len(pairs); i += 2 {
+		hash.Keys = append(hash.Keys, pairs[i])
+		hash.Values = append(hash.Values, pairs[i+1])
+	}
+	return hash
+}
+
+// Fn returns a function literal taking the named parameters, with body as the
+// statements of its body.
+func Fn(params []string, body ...ast.Statement) *ast.FunctionLiteral {
+	fn := &ast.FunctionLiteral{
+		Token:      token.Token{Type: token.FUNCTION, Literal: "fn"},
+		Parameters: []*ast.Identifier{},
+		Body:       Block(body...),
+	}
+
+	for _, p := range params {
+		fn.Parameters = append(fn.Parameters, Ident(p))
+	}
+
+	return fn
+}
+
+// Call returns the application of function to the supplied arguments.
+func Call(function ast.Expression, args ...ast.Expression) *ast.CallExpression {
+	return &ast.CallExpression{
+		Token:     token.Token{Type: token.LPAREN, Literal: "("},
+		Function:  function,
+		Arguments: append([]ast.Expression{}, args...),
+	}
+}
+
+// operator returns the token for op, panicking if op is not an operator.
+func operator(op string) token.Token {
+	tokType, ok := token.LookupOperator(op)
+	if !ok {
+		panic(fmt.Sprintf("build: %q is not an operator", op))
+	}
+	return token.Token{Type: tokType, Literal: op}
+}
+
+// firstToken returns the token the parser would find at the start of exp once it is
+// formatted, which is the token of an expression statement.
+func firstToken(exp ast.Expression) token.Token {
+	lparen := token.Token{Type: token.LPAREN, Literal: "("}
+
+	switch e := exp.(type) {
+	case *ast.InfixExpression:
+		if left, ok := e.Left.(*ast.InfixExpression); ok &&
+			parser.Precedence(left.Token.Type) < parser.Precedence(e.Token.Type) {
+			return lparen
+		}
+		return firstToken(e.Left)
+	case *ast.CallExpression:
+		switch e.Function.(type) {
+		case *ast.InfixExpression, *ast.PrefixExpression:
+			return lparen
+		}
+		return firstToken(e.Function)
+	case *ast.MemberExpression:
+		switch e.Object.(type) {
+		case *ast.InfixExpression, *ast.PrefixExpression:
+			return lparen
+		}
+		return firstToken(e.Object)
+	case *ast.IndexExpression:
+		switch e.Left.(type) {
+		case *ast.InfixExpression, *ast.PrefixExpression:
+			return lparen
+		}
+		return firstToken(e.Left)
+	case *ast.ArrayLiteral:
+		return e.Token
+	case *ast.HashLiteral:
+		return e.Token
+	case *ast.Identifier:
+		return e.Token
+	case *ast.IntegerLiteral:
+		return e.Token
+	case *ast.StringLiteral:
+		return e.Token
+	case *ast.Boolean:
+		return e.Token
+	case *ast.PrefixExpression:
+		return e.Token
+	case *ast.IfExpression:
+		return e.Token
+	case *ast.TryExpression:
+		return e.Token
+	case *ast.FunctionLiteral:
+		return e.Token
+	}
+	return token.Token{}
+}
+
+// validIdentifier reports whether name is made up only of the letters the lexer
+// accepts in identifiers.
+func validIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, ch := range name {
+		if !('a' <= ch && ch <= 'z') && !('A' <= ch && ch <= 'Z') && ch != '_' {
+			return false
+		}
+	}
+	return true
+}
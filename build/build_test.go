@@ -0,0 +1,144 @@
+package build
+
+import (
+	"capuchin/ast"
+	"capuchin/format"
+	"capuchin/lexer"
+	"capuchin/parser"
+	"testing"
+)
+
+func TestBuiltTreesMatchParsedTrees(t *testing.T) {
+	tests := []struct {
+		built  *ast.Program
+		source string
+	}{
+		{
+			Program(Let("x", Infix(Int(1), "+", Ident("y")))),
+			"let x = 1 + y;\n",
+		},
+		{
+			Program(Expr(Infix(Infix(Ident("a"), "+", Ident("b")), "*", Ident("c")))),
+			"(a + b) * c;\n",
+		},
+		{
+			Program(Expr(Infix(Ident("a"), "-", Infix(Ident("b"), "-", Ident("c"))))),
+			"a - (b - c);\n",
+		},
+		{
+			Program(Expr(Prefix("!", Prefix("-", Ident("a"))))),
+			"!-a;\n",
+		},
+		{
+			Program(Let("n", Int(-5))),
+			"let n = -5;\n",
+		},
+		{
+			Program(Return(Bool(false))),
+			"return false;\n",
+		},
+		{
+			Program(
+				Let("add", Fn([]string{"x", "y"}, Expr(Infix(Ident("x"), "+", Ident("y"))))),
+				Expr(Call(Ident("add"), Int(1), Infix(Int(2), "*", Int(3)))),
+			),
+			"let add = fn(x, y) {\n\tx + y;\n};\nadd(1, 2 * 3);\n",
+		},
+		{
+			Program(Expr(IfElse(Infix(Ident("x"), "<", Ident("y")),
+				Block(Expr(Ident("x"))), Block(Expr(Ident("y")))))),
+			"if (x < y) {\n\tx;\n} else {\n\ty;\n}\n",
+		},
+		{
+			Program(Expr(Call(Prefix("-", Ident("f")), Int(1)))),
+			"(-f)(1);\n",
+		},
+		{
+			Program(Expr(Call(Fn(nil), If(Bool(true), Block())))),
+			"fn() {}(if (true) {});\n",
+		},
+		{
+			Program(Expr(TryCatchFinally(Block(Throw(Member(Ident("a"), "b"))),
+				"e", Block(Expr(Member(Prefix("-", Ident("e")), "c"))), Block()))),
+			"try {\n\tthrow a.b;\n} catch (e) {\n\t(-e).c;\n} finally {}\n",
+		},
+		{
+			Program(Expr(Call(Ident("puts"), Infix(String("a\tb"), "+", String(""))))),
+			"puts(\"a\\tb\" + \"\");\n",
+		},
+		{
+			Program(Let("r", TryFinally(Block(Expr(Int(1))), Block(Expr(Int(2)))))),
+			"let r = try {\n\t1;\n} finally {\n\t2;\n};\n",
+		},
+		{
+			Program(Let("h", Hash(String("xs"), Array(Int(1), Array()), Int(2), Hash()))),
+			"let h = {\"xs\": [1, []], 2: {}};\n",
+		},
+		{
+			Program(Expr(Index(Index(Prefix("-", Ident("a")), Int(0)), Infix(Ident("i"), "+", Int(1))))),
+			"(-a)[0][i + 1];\n",
+		},
+		{
+			Program(Expr(Index(Array(Ident("a")), Int(0)))),
+			"[a][0];\n",
+		},
+		{
+			Program(Import("lib/say \"hi\"", "m"), Export(Let("x", Member(Ident("m"), "x")))),
+			"import \"lib/say \\\"hi\\\"\" as m;\nexport let x = m.x;\n",
+		},
+	}
+
+	for _, tt := range tests {
+		formatted := format.Program(tt.built)
+		if formatted != tt.source {
+			t.Errorf("formatted source wrong. want=%q, got=%q", tt.source, formatted)
+			continue
+		}
+
+		p := parser.New(lexer.New(formatted))
+		parsed := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Errorf("formatted source %q does not parse: %v", formatted, p.Errors())
+			continue
+		}
+
+		// Built tokens have no positions but should otherwise match the parser's
+		opts := ast.CompareOptions{IgnorePositions: true, All: true}
+		for _, d := range ast.Diff(tt.built, parsed, opts) {
+			t.Errorf("built tree for %q differs from parsed tree at %s", tt.source, d)
+		}
+
+		if tt.built.String() != parsed.String() {
+			t.Errorf("String() wrong. want=%q, got=%q", parsed.String(), tt.built.String())
+		}
+	}
+}
+
+func TestInvalidInputPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"unknown operator", func() { Infix(Int(1), "%", Int(2)) }},
+		{"prefix as infix", func() { Infix(Int(1), "!", Int(2)) }},
+		{"infix as prefix", func() { Prefix("*", Int(2)) }},
+		{"keyword identifier", func() { Ident("let") }},
+		{"invalid identifier", func() { Ident("x1") }},
+		{"empty identifier", func() { Ident("") }},
+		{"keyword parameter", func() { Fn([]string{"fn"}) }},
+		{"keyword property", func() { Member(Ident("a"), "try") }},
+		{"keyword import name", func() { Import("m", "as") }},
+		{"hash key without value", func() { Hash(String("a"), Int(1), String("b")) }},
+	}
+
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", tt.name)
+				}
+			}()
+			tt.fn()
+		}()
+	}
+}
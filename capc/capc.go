@@ -0,0 +1,239 @@
+// Package capc reads and writes compiled capuchin programs in the .capc object file
+// format, so that scripts which have not changed can be run without compiling them
+// again.
+//
+// A .capc file is laid out as follows, with every integer big endian:
+//
+//	magic        4 bytes   "CAPC"
+//	version      uint16    the format version, see Version
+//	source hash  32 bytes  SHA-256 of the source the program was compiled from
+//	globals      uint32 count, then each name as a string
+//	constants    uint32 count, then each constant as a tag byte and its payload
+//	main         the top level code, as a function body
+//	checksum     uint32    CRC-32 (IEEE) of everything before it
+//
+// A string is a uint32 length followed by its bytes. Integer constants (tag 1) hold
+// an int64, and string constants (tag 3) a string. Function constants (tag 2) hold
+// the function's name as a string, for stack traces, the number of locals and
+// parameters as uint32s, and the names of the locals as a uint32 count and strings,
+// for errors about locals used before their let. They end with a function body: the
+// instructions as a uint32 length and the bytes, then the debug line table as a
+// uint32 count of entries, each an instruction offset, line and column as uint32s.
+//
+// Decode checks everything it reads, including that every instruction is defined and
+// refers to constants, locals, free variables, jump targets and builtins which exist.
+// It follows every path through each function, checking that no instruction takes
+// more values from the stack than are there and that handlers are installed and
+// removed in turn, so that the virtual machine can trust a program loaded from disk
+// as much as a freshly compiled one.
+package capc
+
+import (
+	"bytes"
+	"capuchin/code"
+	"capuchin/compiler"
+	"capuchin/object"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Magic is the signature every .capc file starts with.
+const Magic = "CAPC"
+
+// Version is the version of the format written by Encode. Decode rejects files of
+// any other version, which must be rebuilt from source. Version 2 added function
+// names and the OpTailCall instruction, version 3 string constants and the
+// instructions of try, throw and member expressions, version 4 the instructions of
+// array and hash literals and index expressions, and version 5 the names of locals
+// and the instructions of cells.
+const Version = 5
+
+// Extension is the file name extension of compiled programs.
+const Extension = ".capc"
+
+// The tags identifying the type of each constant.
+const (
+	tagInteger  byte = 1
+	tagFunction byte = 2
+	tagString   byte = 3
+)
+
+// The errors returned by Decode, wrapped with details of the problem.
+var (
+	ErrFormat  = errors.New("not a capc file")
+	ErrVersion = errors.New("unsupported capc version")
+	ErrCorrupt = errors.New("corrupt capc file")
+)
+
+// File is a compiled program together with the hash of its source.
+type File struct {
+	// SourceHash identifies the source the program was compiled from, so a
+	// cached file can be checked against the script it was built from.
+	SourceHash [sha256.Size]byte
+
+	Bytecode *compiler.Bytecode
+}
+
+// Hash returns the hash of a program's source, as recorded in File.SourceHash.
+func Hash(source []byte) [sha256.Size]byte {
+	return sha256.Sum256(source)
+}
+
+// headerSize is the size of the magic, version and source hash.
+const headerSize = len(Magic) + 2 + sha256.Size
+
+// Encode writes f to w in the .capc format.
+func Encode(w io.Writer, f *File) error {
+	e := &encoder{}
+
+	e.buf.WriteString(Magic)
+	e.uint16(Version)
+	e.buf.Write(f.SourceHash[:])
+
+	e.uint32(len(f.Bytecode.Globals))
+	for _, name := range f.Bytecode.Globals {
+		e.string(name)
+	}
+
+	e.uint32(len(f.Bytecode.Constants))
+	for i, constant := range f.Bytecode.Constants {
+		switch constant := constant.(type) {
+		case *object.Integer:
+			e.buf.WriteByte(tagInteger)
+			e.uint64(uint64(constant.Value))
+		case *object.String:
+			e.buf.WriteByte(tagString)
+			e.string(constant.Value)
+		case *object.CompiledFunction:
+			e.buf.WriteByte(tagFunction)
+			e.string(constant.Name)
+			e.uint32(constant.NumLocals)
+			e.uint32(constant.NumParameters)
+			e.uint32(len(constant.Locals))
+			for _, name := range constant.Locals {
+				e.string(name)
+			}
+			e.body(constant.Instructions, constant.Lines)
+		default:
+			return fmt.Errorf("constant %d: cannot encode %s", i, constant.Type())
+		}
+	}
+
+	e.body(f.Bytecode.Instructions, f.Bytecode.Lines)
+
+	e.uint32(int(crc32.ChecksumIEEE(e.buf.Bytes())))
+
+	_, err := w.Write(e.buf.Bytes())
+	return err
+}
+
+// Decode reads a .capc file from r, returning an error wrapping ErrFormat,
+// ErrVersion or ErrCorrupt if it is not a valid file of the current version.
+func Decode(r io.Reader) (*File, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < len(Magic) || string(data[:len(Magic)]) != Magic {
+		return nil, ErrFormat
+	}
+	if len(data) < headerSize+4 {
+		return nil, fmt.Errorf("%w: truncated header", ErrCorrupt)
+	}
+	if v := binary.BigEndian.Uint16(data[len(Magic):]); v != Version {
+		return nil, fmt.Errorf("%w: file is version %d, want %d", ErrVersion, v, Version)
+	}
+
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+
+	d := &decoder{data: body, off: headerSize}
+	f := &File{Bytecode: &compiler.Bytecode{}}
+	copy(f.SourceHash[:], body[len(Magic)+2:headerSize])
+
+	numGlobals := d.count(4)
+	f.Bytecode.Globals = make([]string, numGlobals)
+	for i := range f.Bytecode.Globals {
+		f.Bytecode.Globals[i] = d.string()
+	}
+
+	numConstants := d.count(1)
+	f.Bytecode.Constants = make([]object.Object, 0, numConstants)
+	for i := 0; i < numConstants && d.err == nil; i++ {
+		switch tag := d.byte(); tag {
+		case tagInteger:
+			f.Bytecode.Constants = append(f.Bytecode.Constants,
+				&object.Integer{Value: int64(d.uint64())})
+		case tagString:
+			f.Bytecode.Constants = append(f.Bytecode.Constants,
+				&object.String{Value: d.string()})
+		case tagFunction:
+			fn := &object.CompiledFunction{Name: d.string()}
+			fn.NumLocals, fn.NumParameters = d.uint32(), d.uint32()
+			fn.Locals = make([]string, d.count(4))
+			for i := range fn.Locals {
+				fn.Locals[i] = d.string()
+			}
+			fn.Instructions, fn.Lines = d.body()
+			f.Bytecode.Constants = append(f.Bytecode.Constants, fn)
+		default:
+			d.fail("constant %d has unknown tag %d", i, tag)
+		}
+	}
+
+	f.Bytecode.Instructions, f.Bytecode.Lines = d.body()
+
+	if d.err == nil && d.off != len(d.data) {
+		d.fail("%d bytes of trailing data", len(d.data)-d.off)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	if err := validate(f.Bytecode); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) uint16(v int) {
+	e.buf.Write(binary.BigEndian.AppendUint16(nil, uint16(v)))
+}
+
+func (e *encoder) uint32(v int) {
+	e.buf.Write(binary.BigEndian.AppendUint32(nil, uint32(v)))
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.buf.Write(binary.BigEndian.AppendUint64(nil, v))
+}
+
+func (e *encoder) string(s string) {
+	e.uint32(len(s))
+	e.buf.WriteString(s)
+}
+
+// body writes a function body: its instructions and line table.
+func (e *encoder) body(ins code.Instructions, lines code.LineTable) {
+	e.uint32(len(ins))
+	e.buf.Write(ins)
+
+	e.uint32(len(lines))
+	for _, entry := range lines {
+		e.uint32(entry.Offset)
+		e.uint32(entry.Pos.Line)
+		e.uint32(entry.Pos.Column)
+	}
+}
@@ -0,0 +1,336 @@
+package capc
+
+import (
+	"bytes"
+	"capuchin/code"
+	"capuchin/compiler"
+	"capuchin/object"
+	"capuchin/parsertest"
+	"capuchin/vm"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+const source = `let fib = fn(n) {
+	if (n < 2) { n } else { fib(n - 1) + fib(n - 2) }
+};
+let adder = fn(a) { fn(b) { a + b } };
+let safe = fn(n) { try { 10 / n } catch (e) { e.line } };
+let pick = fn(xs) { xs[1]["v"] };
+adder(fib(10))(-5) + safe(0) + pick([1, {"v": 0}])`
+
+func TestRoundTrip(t *testing.T) {
+	bytecode := compile(t, source)
+	data := encode(t, &File{SourceHash: Hash([]byte(source)), Bytecode: bytecode})
+
+	f, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+
+	if f.SourceHash != Hash([]byte(source)) {
+		t.Errorf("source hash not preserved")
+	}
+	if !reflect.DeepEqual(f.Bytecode, bytecode) {
+		t.Errorf("bytecode not preserved.\nwant=%+v\ngot =%+v", bytecode, f.Bytecode)
+	}
+
+	machine := vm.New(f.Bytecode)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if got := machine.LastPoppedStackElem().Inspect(); got != "55" {
+		t.Errorf("wrong result from decoded program. want=55, got=%s", got)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid := encode(t, &File{Bytecode: compile(t, source)})
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+		message  string
+	}{
+		{"empty", nil, ErrFormat, "not a capc file"},
+		{"bad magic", append([]byte("CAPX"), valid[4:]...), ErrFormat, "not a capc file"},
+		{
+			"other version",
+			patch(valid, func(b []byte) { binary.BigEndian.PutUint16(b[4:], Version+1) }),
+			ErrVersion,
+			"unsupported capc version: file is version 6, want 5",
+		},
+		{"truncated header", valid[:10], ErrCorrupt, "corrupt capc file: truncated header"},
+		{
+			"flipped byte",
+			func() []byte { b := append([]byte{}, valid...); b[len(b)/2] ^= 0xff; return b }(),
+			ErrCorrupt,
+			"corrupt capc file: checksum mismatch",
+		},
+		{
+			"truncated body",
+			resum(valid[:len(valid)-10]),
+			ErrCorrupt,
+			"",
+		},
+		{
+			"trailing data",
+			resum(append(append([]byte{}, valid[:len(valid)-4]...), 0)),
+			ErrCorrupt,
+			"corrupt capc file: 1 bytes of trailing data",
+		},
+		{
+			"unknown opcode",
+			encodeRaw(t, &compiler.Bytecode{Instructions: code.Instructions{255}}),
+			ErrCorrupt,
+			"corrupt capc file: main: offset 0: opcode 255 undefined",
+		},
+		{
+			"missing constant",
+			encodeRaw(t, &compiler.Bytecode{Instructions: code.Make(code.OpConstant, 3)}),
+			ErrCorrupt,
+			"corrupt capc file: main: offset 0: constant 3 does not exist",
+		},
+		{
+			"truncated operand",
+			encodeRaw(t, &compiler.Bytecode{Instructions: code.Make(code.OpConstant, 0)[:2]}),
+			ErrCorrupt,
+			"corrupt capc file: main: offset 0: OpConstant is truncated",
+		},
+		{
+			"jump into an instruction",
+			encodeRaw(t, &compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpJump, 2)),
+			}),
+			ErrCorrupt,
+			"corrupt capc file: main: offset 1: jump to 2 is not an instruction",
+		},
+		{
+			"closure of an integer",
+			encodeRaw(t, &compiler.Bytecode{
+				Instructions: code.Make(code.OpClosure, 0, 0),
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+			}),
+			ErrCorrupt,
+			"corrupt capc file: main: offset 0: constant 0 is not a function",
+		},
+		{
+			"member named by an integer",
+			encodeRaw(t, &compiler.Bytecode{
+				Instructions: code.Make(code.OpMember, 0),
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+			}),
+			ErrCorrupt,
+			"corrupt capc file: main: offset 0: constant 0 is not a string",
+		},
+		{
+			"missing local",
+			encodeRaw(t, &compiler.Bytecode{
+				Constants: []object.Object{&object.CompiledFunction{
+					Instructions: code.Make(code.OpGetLocal, 1),
+					NumLocals:    1,
+				}},
+			}),
+			ErrCorrupt,
+			"corrupt capc file: constant 0: offset 0: local 1 does not exist",
+		},
+		{
+			"more local names than locals",
+			encodeRaw(t, &compiler.Bytecode{
+				Constants: []object.Object{&object.CompiledFunction{
+					Instructions: code.Make(code.OpReturn),
+					NumLocals:    1,
+					Locals:       []string{"a", "b"},
+				}},
+			}),
+			ErrCorrupt,
+			"corrupt capc file: constant 0: 2 local names but only 1 locals",
+		},
+		{
+			"free variable of the main program",
+			encodeRaw(t, &compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpGetFree, 3), code.Make(code.OpPop)),
+			}),
+			ErrCorrupt,
+			"corrupt capc file: main: offset 0: free variable 3 does not exist",
+		},
+		{
+			"free variable the closure does not capture",
+			encodeRaw(t, &compiler.Bytecode{
+				Instructions: concat(
+					code.Make(code.OpNull),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpPop),
+				),
+				Constants: []object.Object{&object.CompiledFunction{
+					Instructions: concat(code.Make(code.OpGetFree, 1), code.Make(code.OpReturnValue)),
+				}},
+			}),
+			ErrCorrupt,
+			"corrupt capc file: constant 0: offset 0: free variable 1 does not exist",
+		},
+		{
+			"pop from an empty stack",
+			encodeRaw(t, &compiler.Bytecode{Instructions: code.Make(code.OpPop)}),
+			ErrCorrupt,
+			"corrupt capc file: main: offset 0: OpPop needs 1 values on the stack but has 0",
+		},
+		{
+			"call without the callee",
+			encodeRaw(t, &compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpNull), code.Make(code.OpCall, 1)),
+			}),
+			ErrCorrupt,
+			"corrupt capc file: main: offset 1: OpCall needs 2 values on the stack but has 1",
+		},
+		{
+			"stack depth differing between paths",
+			encodeRaw(t, &compiler.Bytecode{
+				Instructions: concat(
+					code.Make(code.OpTrue),
+					code.Make(code.OpJumpNotTruthy, 5),
+					code.Make(code.OpTrue),
+					code.Make(code.OpNull),
+					code.Make(code.OpPop),
+				),
+			}),
+			ErrCorrupt,
+			"corrupt capc file: main: offset 5: reached with 0 values on the stack and with 1",
+		},
+		{
+			"catch outside a handler",
+			encodeRaw(t, &compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpCatch), code.Make(code.OpPop)),
+			}),
+			ErrCorrupt,
+			"corrupt capc file: main: offset 1: OpCatch without a caught error",
+		},
+		{
+			"end of a try which was never started",
+			encodeRaw(t, &compiler.Bytecode{Instructions: code.Make(code.OpEndTry)}),
+			ErrCorrupt,
+			"corrupt capc file: main: offset 0: OpEndTry without a handler",
+		},
+		{
+			"return from within a try",
+			encodeRaw(t, &compiler.Bytecode{
+				Constants: []object.Object{&object.CompiledFunction{
+					Instructions: concat(
+						code.Make(code.OpTry, 4),
+						code.Make(code.OpReturn),
+						code.Make(code.OpReturnValue),
+					),
+				}},
+			}),
+			ErrCorrupt,
+			"corrupt capc file: constant 0: offset 3: OpReturn leaves 1 handlers installed",
+		},
+		{
+			"function running off its end",
+			encodeRaw(t, &compiler.Bytecode{
+				Constants: []object.Object{&object.CompiledFunction{
+					Instructions: code.Make(code.OpNull),
+				}},
+			}),
+			ErrCorrupt,
+			"corrupt capc file: constant 0: offset 1: the function ends without returning",
+		},
+		{
+			"local read as a cell",
+			encodeRaw(t, &compiler.Bytecode{
+				Constants: []object.Object{&object.CompiledFunction{
+					Instructions: concat(code.Make(code.OpGetCell, 0), code.Make(code.OpReturnValue)),
+					NumLocals:    1,
+				}},
+			}),
+			ErrCorrupt,
+			"corrupt capc file: constant 0: offset 0: local 0 is not a cell",
+		},
+		{
+			"cell made after the start of the function",
+			encodeRaw(t, &compiler.Bytecode{
+				Constants: []object.Object{&object.CompiledFunction{
+					Instructions: concat(code.Make(code.OpNull), code.Make(code.OpPop),
+						code.Make(code.OpCell, 0), code.Make(code.OpReturn)),
+					NumLocals: 1,
+				}},
+			}),
+			ErrCorrupt,
+			"corrupt capc file: constant 0: offset 2: OpCell after the start of the function",
+		},
+		{
+			"jump back over the cells",
+			encodeRaw(t, &compiler.Bytecode{
+				Constants: []object.Object{&object.CompiledFunction{
+					Instructions: concat(code.Make(code.OpCell, 0), code.Make(code.OpJump, 0)),
+					NumLocals:    1,
+				}},
+			}),
+			ErrCorrupt,
+			"corrupt capc file: constant 0: offset 2: jump to 0 is into the cells of the locals",
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.data))
+		if err == nil {
+			t.Errorf("%s: expected an error, got none", tt.name)
+			continue
+		}
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%s: wrong error kind. want=%v, got=%v", tt.name, tt.expected, err)
+		}
+		if tt.message != "" && err.Error() != tt.message {
+			t.Errorf("%s: wrong message. want=%q, got=%q", tt.name, tt.message, err)
+		}
+	}
+}
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+
+	comp := compiler.New()
+	if err := comp.Compile(parsertest.Parse(t, input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
+
+func encode(t *testing.T, f *File) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, f); err != nil {
+		t.Fatalf("encode failed: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeRaw(t *testing.T, bytecode *compiler.Bytecode) []byte {
+	return encode(t, &File{Bytecode: bytecode})
+}
+
+// patch returns a copy of data changed by f, with its checksum updated.
+func patch(data []byte, f func([]byte)) []byte {
+	b := append([]byte{}, data...)
+	f(b)
+	return resum(b[:len(b)-4])
+}
+
+// resum returns body followed by its checksum, as if it had been written that way.
+func resum(body []byte) []byte {
+	b := append([]byte{}, body...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+}
+
+func concat(s ...[]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+	return out
+}
@@ -0,0 +1,93 @@
+package capc
+
+import (
+	"capuchin/code"
+	"capuchin/token"
+	"encoding/binary"
+	"fmt"
+)
+
+// decoder reads the fields of a .capc file in order. The first problem is kept in
+// err, after which every read returns a zero value, so callers can check once at the
+// end.
+type decoder struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: "+format, append([]interface{}{ErrCorrupt}, args...)...)
+	}
+}
+
+// next returns the next n bytes, or nil if there are not that many left.
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data)-d.off {
+		d.fail("unexpected end of file at offset %d", d.off)
+		return nil
+	}
+	b := d.data[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint32() int {
+	if b := d.next(4); b != nil {
+		return int(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// count reads the number of items in a list, each at least size bytes long. Counts
+// which could not fit in the rest of the file are rejected before anything is
+// allocated for them.
+func (d *decoder) count(size int) int {
+	n := d.uint32()
+	if n > (len(d.data)-d.off)/size {
+		d.fail("count %d at offset %d exceeds the file size", n, d.off-4)
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) string() string {
+	return string(d.next(d.uint32()))
+}
+
+// body reads a function body: its instructions and line table.
+func (d *decoder) body() (code.Instructions, code.LineTable) {
+	ins := code.Instructions(append([]byte{}, d.next(d.uint32())...))
+
+	n := d.count(12)
+	lines := make(code.LineTable, 0, n)
+	for i := 0; i < n; i++ {
+		entry := code.LineEntry{Offset: d.uint32()}
+		entry.Pos = token.Position{Line: d.uint32(), Column: d.uint32()}
+
+		if entry.Offset >= len(ins) || (i > 0 && entry.Offset <= lines[i-1].Offset) {
+			d.fail("line table entry %d has bad offset %d", i, entry.Offset)
+		}
+		lines = append(lines, entry)
+	}
+
+	return ins, lines
+}
@@ -0,0 +1,345 @@
+package capc
+
+import (
+	"capuchin/code"
+	"capuchin/compiler"
+	"capuchin/object"
+	"fmt"
+)
+
+// validate checks that every instruction of the program is well formed and refers
+// only to things which exist, and that whichever way the program goes it keeps the
+// stack and the handlers of each function in order, as the compiler's code does.
+func validate(bytecode *compiler.Bytecode) error {
+	main, err := decode(bytecode.Instructions)
+	if err != nil {
+		return fmt.Errorf("%w: main: %v", ErrCorrupt, err)
+	}
+
+	bodies := make(map[int]*body)
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		if fn.NumParameters > fn.NumLocals {
+			return fmt.Errorf("%w: constant %d: %d parameters but only %d locals",
+				ErrCorrupt, i, fn.NumParameters, fn.NumLocals)
+		}
+		if len(fn.Locals) > fn.NumLocals {
+			return fmt.Errorf("%w: constant %d: %d local names but only %d locals",
+				ErrCorrupt, i, len(fn.Locals), fn.NumLocals)
+		}
+
+		b, err := decode(fn.Instructions)
+		if err != nil {
+			return fmt.Errorf("%w: constant %d: %v", ErrCorrupt, i, err)
+		}
+		b.numLocals = fn.NumLocals
+		bodies[i] = b
+	}
+
+	// A function's free variables are those captured by the OpClosure creating it,
+	// or the fewest captured if there is more than one
+	closures := map[int]int{}
+	for _, b := range append([]*body{main}, values(bodies)...) {
+		for _, in := range b.instructions {
+			if in.op != code.OpClosure {
+				continue
+			}
+			if n, ok := closures[in.operands[0]]; !ok || in.operands[1] < n {
+				closures[in.operands[0]] = in.operands[1]
+			}
+		}
+	}
+
+	main.main = true
+	if err := main.check(bytecode.Constants); err != nil {
+		return fmt.Errorf("%w: main: %v", ErrCorrupt, err)
+	}
+	for i := range bytecode.Constants {
+		b, ok := bodies[i]
+		if !ok {
+			continue
+		}
+		b.numFree = closures[i]
+		if err := b.check(bytecode.Constants); err != nil {
+			return fmt.Errorf("%w: constant %d: %v", ErrCorrupt, i, err)
+		}
+	}
+
+	return nil
+}
+
+func values(bodies map[int]*body) []*body {
+	list := make([]*body, 0, len(bodies))
+	for _, b := range bodies {
+		list = append(list, b)
+	}
+	return list
+}
+
+// body is the decoded instructions of a function, or of the main program, and what
+// they may use.
+type body struct {
+	instructions []instruction
+	size         int // the length of the instructions in bytes
+
+	numLocals int
+	numFree   int
+	main      bool // the main program may end by running off its last instruction
+}
+
+// instruction is a decoded instruction and the offset it starts at.
+type instruction struct {
+	ip       int
+	op       code.Opcode
+	def      *code.Definition
+	operands []int
+	next     int // the offset of the instruction which follows
+}
+
+// decode splits ins into its instructions, checking that each is defined and has all
+// of its operands.
+func decode(ins code.Instructions) (*body, error) {
+	b := &body{size: len(ins)}
+
+	for ip := 0; ip < len(ins); {
+		def, err := code.Lookup(ins[ip])
+		if err != nil {
+			return nil, fmt.Errorf("offset %d: %v", ip, err)
+		}
+
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if ip+1+width > len(ins) {
+			return nil, fmt.Errorf("offset %d: %s is truncated", ip, def.Name)
+		}
+		operands, _ := code.ReadOperands(def, ins[ip+1:])
+
+		b.instructions = append(b.instructions, instruction{
+			ip: ip, op: code.Opcode(ins[ip]), def: def, operands: operands, next: ip + 1 + width,
+		})
+		ip += 1 + width
+	}
+
+	return b, nil
+}
+
+// check checks the operands of each instruction of b, then follows the paths through
+// b with flow.
+func (b *body) check(constants []object.Object) error {
+	starts := make(map[int]bool)
+	jumps := make(map[int]int)
+
+	// The locals kept in cells are moved into them by the OpCells b starts with
+	cells := make(map[int]bool)
+	prologue := 0
+
+	for _, in := range b.instructions {
+		ip, operands := in.ip, in.operands
+
+		switch in.op {
+		case code.OpConstant:
+			if operands[0] >= len(constants) {
+				return fmt.Errorf("offset %d: constant %d does not exist", ip, operands[0])
+			}
+		case code.OpClosure:
+			if operands[0] >= len(constants) {
+				return fmt.Errorf("offset %d: constant %d does not exist", ip, operands[0])
+			}
+			if _, ok := constants[operands[0]].(*object.CompiledFunction); !ok {
+				return fmt.Errorf("offset %d: constant %d is not a function", ip, operands[0])
+			}
+		case code.OpMember:
+			if operands[0] >= len(constants) {
+				return fmt.Errorf("offset %d: constant %d does not exist", ip, operands[0])
+			}
+			if _, ok := constants[operands[0]].(*object.String); !ok {
+				return fmt.Errorf("offset %d: constant %d is not a string", ip, operands[0])
+			}
+		case code.OpGetLocal, code.OpSetLocal, code.OpCell, code.OpGetCell, code.OpSetCell:
+			if operands[0] >= b.numLocals {
+				return fmt.Errorf("offset %d: local %d does not exist", ip, operands[0])
+			}
+		case code.OpGetFree, code.OpGetFreeCell:
+			if operands[0] >= b.numFree {
+				return fmt.Errorf("offset %d: free variable %d does not exist", ip, operands[0])
+			}
+		case code.OpGetBuiltin:
+			if operands[0] >= len(object.Builtins) {
+				return fmt.Errorf("offset %d: builtin %d does not exist", ip, operands[0])
+			}
+		case code.OpHash:
+			if operands[0]%2 != 0 {
+				return fmt.Errorf("offset %d: hash of %d keys and values", ip, operands[0])
+			}
+		case code.OpJump, code.OpJumpNotTruthy, code.OpTry:
+			jumps[ip] = operands[0]
+		}
+
+		switch {
+		case in.op == code.OpCell && ip == prologue:
+			cells[operands[0]] = true
+			prologue = in.next
+		case in.op == code.OpCell:
+			return fmt.Errorf("offset %d: OpCell after the start of the function", ip)
+		case (in.op == code.OpGetCell || in.op == code.OpSetCell) && !cells[operands[0]]:
+			return fmt.Errorf("offset %d: local %d is not a cell", ip, operands[0])
+		case in.op == code.OpSetLocal && cells[operands[0]]:
+			return fmt.Errorf("offset %d: local %d is a cell", ip, operands[0])
+		}
+
+		starts[in.ip] = true
+	}
+
+	for ip, target := range jumps {
+		if target != b.size && !starts[target] {
+			return fmt.Errorf("offset %d: jump to %d is not an instruction", ip, target)
+		}
+		if target < prologue {
+			return fmt.Errorf("offset %d: jump to %d is into the cells of the locals", ip, target)
+		}
+	}
+
+	return b.flow()
+}
+
+// state is what is known of the VM each time it reaches an instruction: the number of
+// values on the stack above the function's locals, the number of handlers the
+// function has installed, and whether the value on top is an error just caught.
+type state struct {
+	height   int
+	handlers int
+	caught   bool
+}
+
+// flow follows every path through b from its first instruction, checking that no
+// instruction takes more values from the stack than there are or removes a handler
+// the function did not install, that OpCatch only starts a handler, that the function
+// leaves no handlers behind when it returns, and that each instruction is always
+// reached with the same number of values and handlers.
+func (b *body) flow() error {
+	at := make(map[int]*instruction, len(b.instructions))
+	for i := range b.instructions {
+		at[b.instructions[i].ip] = &b.instructions[i]
+	}
+
+	states := map[int]state{0: {}}
+	work := []int{0}
+
+	reach := func(ip int, s state) error {
+		seen, ok := states[ip]
+		if !ok {
+			states[ip] = s
+			work = append(work, ip)
+			return nil
+		}
+		if seen.height != s.height {
+			return fmt.Errorf("offset %d: reached with %d values on the stack and with %d",
+				ip, seen.height, s.height)
+		}
+		if seen.handlers != s.handlers {
+			return fmt.Errorf("offset %d: reached with %d handlers installed and with %d",
+				ip, seen.handlers, s.handlers)
+		}
+		if seen.caught && !s.caught {
+			states[ip] = s
+			work = append(work, ip)
+		}
+		return nil
+	}
+
+	for len(work) > 0 {
+		ip := work[len(work)-1]
+		work = work[:len(work)-1]
+		s := states[ip]
+
+		in, ok := at[ip]
+		if !ok {
+			if !b.main {
+				return fmt.Errorf("offset %d: the function ends without returning", ip)
+			}
+			continue
+		}
+
+		pops, pushes := effect(in)
+		if s.height < pops {
+			return fmt.Errorf("offset %d: %s needs %d values on the stack but has %d",
+				ip, in.def.Name, pops, s.height)
+		}
+		next := state{height: s.height - pops + pushes, handlers: s.handlers}
+
+		var err error
+		switch in.op {
+		case code.OpReturn, code.OpReturnValue, code.OpTailCall:
+			if s.handlers > 0 {
+				return fmt.Errorf("offset %d: %s leaves %d handlers installed",
+					ip, in.def.Name, s.handlers)
+			}
+			if in.op == code.OpTailCall {
+				err = reach(in.next, next)
+			}
+		case code.OpThrow:
+		case code.OpCatch:
+			if !s.caught {
+				return fmt.Errorf("offset %d: OpCatch without a caught error", ip)
+			}
+			err = reach(in.next, next)
+		case code.OpJump:
+			err = reach(in.operands[0], next)
+		case code.OpJumpNotTruthy:
+			if err = reach(in.next, next); err == nil {
+				err = reach(in.operands[0], next)
+			}
+		case code.OpTry:
+			// The handler is removed as it catches, and the error pushed
+			caught := state{height: s.height + 1, handlers: s.handlers, caught: true}
+			next.handlers++
+			if err = reach(in.next, next); err == nil {
+				err = reach(in.operands[0], caught)
+			}
+		case code.OpEndTry:
+			if s.handlers == 0 {
+				return fmt.Errorf("offset %d: OpEndTry without a handler", ip)
+			}
+			next.handlers--
+			err = reach(in.next, next)
+		default:
+			err = reach(in.next, next)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// effect returns the number of values it takes from the stack and the number it
+// pushes.
+func effect(in *instruction) (pops, pushes int) {
+	switch in.op {
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpEqual, code.OpNotEqual,
+		code.OpGreaterThan, code.OpLessThan, code.OpIndex:
+		return 2, 1
+	case code.OpMinus, code.OpBang, code.OpCatch, code.OpMember:
+		return 1, 1
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetGlobal,
+		code.OpGetLocal, code.OpGetBuiltin, code.OpGetFree, code.OpCurrentClosure,
+		code.OpGetCell, code.OpGetFreeCell:
+		return 0, 1
+	case code.OpPop, code.OpJumpNotTruthy, code.OpSetGlobal, code.OpSetLocal,
+		code.OpSetCell, code.OpReturnValue, code.OpThrow:
+		return 1, 0
+	case code.OpCall, code.OpTailCall:
+		return in.operands[0] + 1, 1
+	case code.OpClosure:
+		return in.operands[1], 1
+	case code.OpArray, code.OpHash:
+		return in.operands[0], 1
+	}
+	return 0, 0
+}
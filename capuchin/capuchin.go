@@ -0,0 +1,235 @@
+// Package capuchin embeds the capuchin language in Go programs, as a scripting layer
+// for a host which provides the functions and values scripts work with:
+//
+//	in := capuchin.New()
+//	in.Register("double", func(n int64) int64 { return n * 2 })
+//	in.Set("limit", 10)
+//	result, err := in.Run("double(limit)")
+//
+// Values cross between Go and capuchin by reflection. Go integers, floats, strings,
+// bools, slices, maps with string keys and functions become the matching capuchin
+// values, and capuchin values come back as int64, float64, string, bool, []any,
+// map[string]any, Func or nil, or as any Go type they can be converted to. A value
+// which cannot be converted is reported with an error saying why.
+//
+// Scripts are run by the tree-walking evaluator, within the limits set by SetLimits.
+package capuchin
+
+import (
+	"capuchin/evaluator"
+	"capuchin/lexer"
+	"capuchin/limits"
+	"capuchin/object"
+	"capuchin/parser"
+	"capuchin/token"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Interpreter runs capuchin scripts. Its globals, including the functions registered
+// by the host, persist from one run to the next.
+type Interpreter struct {
+	env    *object.Environment
+	limits limits.Config
+
+	// caller is set while a Go function runs as a builtin, and calls the script
+	// functions it was passed as part of the program which called it. stopped is
+	// the abort which stopped the program during one of those calls, if any.
+	caller  object.Caller
+	stopped *object.Abort
+}
+
+// Func is a capuchin function value converted to Go. Calling it calls the function
+// with its arguments converted to capuchin values.
+type Func func(args ...any) (any, error)
+
+// ParseError reports the syntax errors in a script, which was not run.
+type ParseError struct {
+	Errors []string
+}
+
+func (e *ParseError) Error() string {
+	return "parse errors: " + strings.Join(e.Errors, "; ")
+}
+
+// RuntimeError is a runtime error raised by a script.
+type RuntimeError struct {
+	Message string
+	Pos     token.Position // Where in the script the error happened, if known
+
+	// Stack holds the calls in progress when the error happened, innermost first.
+	Stack []object.StackFrame
+}
+
+func (e *RuntimeError) Error() string {
+	return "runtime error: " + e.Message
+}
+
+// Traceback returns the message followed by the functions which were running when
+// the error happened, as object.Error.Traceback does.
+func (e *RuntimeError) Traceback() string {
+	return (&object.Error{Message: e.Message, Pos: e.Pos, Stack: e.Stack}).Traceback()
+}
+
+// New creates an Interpreter with no globals but the language's builtins.
+func New() *Interpreter {
+	return &Interpreter{env: object.NewEnvironment()}
+}
+
+// SetLimits bounds the resources used by each later Run or call. A run which goes
+// beyond the limits fails with the error from the limits package.
+func (in *Interpreter) SetLimits(config limits.Config) {
+	in.limits = config
+}
+
+// Register makes the Go function fn available to scripts as the builtin name. Its
+// arguments are converted to fn's parameter types when it is called, and it may
+// return one value, an error, or both; an error becomes a runtime error in the
+// script. An object.BuiltinFunction is registered as it is, without conversions.
+func (in *Interpreter) Register(name string, fn any) error {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return fmt.Errorf("cannot register %s: %T is not a function", name, fn)
+	}
+
+	builtin, err := in.builtin(name, v)
+	if err != nil {
+		return fmt.Errorf("cannot register %s: %w", name, err)
+	}
+	in.env.Set(name, builtin)
+	return nil
+}
+
+// Builtin converts the Go function fn to a builtin as Register does, for hosts which
+// run programs with the evaluator themselves and bind their builtins in an
+// object.Environment.
+func Builtin(name string, fn any) (*object.Builtin, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("cannot convert %s: %T is not a function", name, fn)
+	}
+
+	builtin, err := New().builtin(name, v)
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert %s: %w", name, err)
+	}
+	return builtin, nil
+}
+
+// Set binds the global name to value converted to capuchin.
+func (in *Interpreter) Set(name string, value any) error {
+	obj, err := in.toObject(value)
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", name, err)
+	}
+	in.env.Set(name, obj)
+	return nil
+}
+
+// Get returns the value of the global name converted to Go.
+func (in *Interpreter) Get(name string) (any, error) {
+	obj, ok := in.env.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("identifier not found: %s", name)
+	}
+
+	value, err := in.fromObject(obj)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get %s: %w", name, err)
+	}
+	return value, nil
+}
+
+// GetAs stores the value of the global name in the variable target points to,
+// converted to its type. A function value can be converted to any Go function type
+// returning at most one value and an error.
+func (in *Interpreter) GetAs(name string, target any) error {
+	ptr := reflect.ValueOf(target)
+	if ptr.Kind() != reflect.Pointer || ptr.IsNil() {
+		return fmt.Errorf("cannot get %s: target is %T, not a pointer", name, target)
+	}
+
+	obj, ok := in.env.Get(name)
+	if !ok {
+		return fmt.Errorf("identifier not found: %s", name)
+	}
+
+	v, err := in.fromObjectTo(obj, ptr.Elem().Type())
+	if err != nil {
+		return fmt.Errorf("cannot get %s: %w", name, err)
+	}
+	ptr.Elem().Set(v)
+	return nil
+}
+
+// Call calls the function bound to the global name with args and returns its result.
+func (in *Interpreter) Call(name string, args ...any) (any, error) {
+	fn, ok := in.env.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("identifier not found: %s", name)
+	}
+	return in.call(fn, args)
+}
+
+// Run runs source and returns the value of its last expression statement, or nil if
+// it has none.
+func (in *Interpreter) Run(source string) (any, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return nil, &ParseError{Errors: errs}
+	}
+
+	obj, err := in.result(evaluator.EvalWithLimits(program, in.env, in.limits))
+	if err != nil {
+		return nil, err
+	}
+	return in.fromObject(obj)
+}
+
+func (in *Interpreter) call(fn object.Object, args []any) (any, error) {
+	obj, err := in.callObject(fn, args)
+	if err != nil {
+		return nil, err
+	}
+	return in.fromObject(obj)
+}
+
+func (in *Interpreter) callObject(fn object.Object, args []any) (object.Object, error) {
+	objs := make([]object.Object, len(args))
+	for i, arg := range args {
+		obj, err := in.toObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		objs[i] = obj
+	}
+
+	if in.caller != nil {
+		return in.result(in.caller(fn, objs...))
+	}
+	return in.result(evaluator.ApplyWithLimits(fn, objs, in.limits))
+}
+
+// calling returns a copy of in for a Go function running as a builtin, which calls
+// script functions with call.
+func (in *Interpreter) calling(call object.Caller) *Interpreter {
+	return &Interpreter{env: in.env, limits: in.limits, caller: call}
+}
+
+// result turns a failed evaluation into a Go error. A program stopped while a Go
+// function running as a builtin was calling one of its functions is recorded, so
+// that the builtin stops the program in turn whatever the Go function returns.
+func (in *Interpreter) result(obj object.Object) (object.Object, error) {
+	switch obj := obj.(type) {
+	case *object.Error:
+		return nil, &RuntimeError{Message: obj.Message, Pos: obj.Pos, Stack: obj.Stack}
+	case *object.Abort:
+		if in.caller != nil {
+			in.stopped = obj
+		}
+		return nil, obj.Err
+	}
+	return obj, nil
+}
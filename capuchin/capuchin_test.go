@@ -0,0 +1,310 @@
+package capuchin
+
+import (
+	"capuchin/evaluator"
+	"capuchin/limits"
+	"capuchin/object"
+	"capuchin/parsertest"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	in := New()
+	must(t, in.Register("add", func(a, b int64) int64 { return a + b }))
+	must(t, in.Register("half", func(n float64) float64 { return n / 2 }))
+	must(t, in.Register("sum", func(ns ...int) int {
+		total := 0
+		for _, n := range ns {
+			total += n
+		}
+		return total
+	}))
+	must(t, in.Register("greet", func(name string) string { return "hello " + name }))
+	must(t, in.Register("length", func(xs []any) int { return len(xs) }))
+	must(t, in.Register("lookup", func(m map[string]int64, key string) (int64, error) {
+		v, ok := m[key]
+		if !ok {
+			return 0, fmt.Errorf("no key %q", key)
+		}
+		return v, nil
+	}))
+	must(t, in.Register("twice", func(f func(int64) int64, n int64) int64 { return f(f(n)) }))
+	must(t, in.Register("nothing", func() {}))
+	must(t, in.Register("name", func() string { return "capuchin" }))
+	must(t, in.Register("words", func() []string { return []string{"a", "b"} }))
+	must(t, in.Register("config", func() map[string]any { return map[string]any{"n": 1} }))
+	must(t, in.Set("key", "n"))
+
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"add(1, 2)", int64(3)},
+		{"half(3)", 1.5},
+		{"sum()", int64(0)},
+		{"sum(1, 2, 3)", int64(6)},
+		{"greet(name())", "hello capuchin"},
+		{"length(words())", int64(2)},
+		{"lookup(config(), key)", int64(1)},
+		{"twice(fn(x) { x * 3 }, 2)", int64(18)},
+		{"nothing()", nil},
+		{"add(2, 3) == 5", true},
+		{"words()", []any{"a", "b"}},
+		{"config()", map[string]any{"n": int64(1)}},
+	}
+
+	for _, tt := range tests {
+		got, err := in.Run(tt.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: wrong result. want=%#v, got=%#v", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestRegisterErrors(t *testing.T) {
+	in := New()
+	must(t, in.Register("add", func(a, b int64) int64 { return a + b }))
+	must(t, in.Register("small", func(n int8) int8 { return n }))
+	must(t, in.Register("fail", func() error { return errors.New("it broke") }))
+	must(t, in.Register("channel", func() chan int { return nil }))
+	must(t, in.Register("text", func(s string) string { return s }))
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"add(1)", "runtime error: wrong number of arguments: want=2, got=1"},
+		{"add(1, true)", "runtime error: argument 2 of add: cannot convert BOOLEAN to int64"},
+		{"small(1000)", "runtime error: argument 1 of small: cannot convert 1000 to int8: out of range"},
+		{"fail()", "runtime error: fail: it broke"},
+		{"channel()", "runtime error: result of channel: cannot convert chan int to a capuchin value"},
+		{"text(1)", "runtime error: argument 1 of text: cannot convert INTEGER to string"},
+		{"let", "parse errors: "},
+	}
+
+	for _, tt := range tests {
+		_, err := in.Run(tt.input)
+		if err == nil {
+			t.Errorf("%q: expected an error, got none", tt.input)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+
+	if err := in.Register("x", 5); err == nil || err.Error() != "cannot register x: int is not a function" {
+		t.Errorf("wrong error registering a non-function. got=%v", err)
+	}
+	if err := in.Register("x", func() (int, int) { return 0, 0 }); err == nil {
+		t.Errorf("expected an error registering a function with two results")
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	builtin, err := Builtin("add", func(a, b int64) int64 { return a + b })
+	must(t, err)
+
+	result := builtin.Fn(&object.Integer{Value: 1}, &object.Integer{Value: 2})
+	if i, ok := result.(*object.Integer); !ok || i.Value != 3 {
+		t.Errorf("wrong result. want=3, got=%s", result.Inspect())
+	}
+
+	result = builtin.Fn(&object.Integer{Value: 1}, evaluator.TRUE)
+	expected := "argument 2 of add: cannot convert BOOLEAN to int64"
+	if e, ok := result.(*object.Error); !ok || e.Message != expected {
+		t.Errorf("wrong error. want=%q, got=%s", expected, result.Inspect())
+	}
+
+	if _, err := Builtin("x", 5); err == nil || err.Error() != "cannot convert x: int is not a function" {
+		t.Errorf("wrong error converting a non-function. got=%v", err)
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	in := New()
+
+	values := []struct {
+		name     string
+		value    any
+		expected any
+	}{
+		{"i", 42, int64(42)},
+		{"u", uint8(7), int64(7)},
+		{"f", 2.5, 2.5},
+		{"s", "text", "text"},
+		{"b", true, true},
+		{"n", nil, nil},
+		{"a", []any{1, "two", []int{3}}, []any{int64(1), "two", []any{int64(3)}}},
+		{"h", map[string]any{"k": false}, map[string]any{"k": false}},
+	}
+
+	for _, tt := range values {
+		must(t, in.Set(tt.name, tt.value))
+		got, err := in.Get(tt.name)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: wrong value. want=%#v, got=%#v", tt.name, tt.expected, got)
+		}
+	}
+
+	must(t, in.Set("limit", 10))
+	if _, err := in.Run("let doubled = limit * 2;"); err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+	var doubled int
+	must(t, in.GetAs("doubled", &doubled))
+	if doubled != 20 {
+		t.Errorf("wrong global set by the script. want=20, got=%d", doubled)
+	}
+
+	errs := []struct {
+		err      error
+		expected string
+	}{
+		{in.Set("c", make(chan int)), "cannot set c: cannot convert chan int to a capuchin value"},
+		{in.Set("m", map[int]int{}), "cannot set m: cannot convert map[int]int to a capuchin value: keys must be strings"},
+		{in.Set("big", uint64(1<<63)), "cannot set big: cannot convert 9223372036854775808 to INTEGER: out of range"},
+		{in.GetAs("s", &doubled), "cannot get s: cannot convert STRING to int"},
+		{in.GetAs("s", doubled), "cannot get s: target is int, not a pointer"},
+		{in.GetAs("missing", &doubled), "identifier not found: missing"},
+		{func() error { _, err := in.Get("missing"); return err }(), "identifier not found: missing"},
+	}
+
+	for _, tt := range errs {
+		if tt.err == nil || tt.err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, tt.err)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	in := New()
+	if _, err := in.Run(`
+		let add = fn(a, b) { a + b };
+		let compose = fn(f, g) { fn(x) { f(g(x)) } };
+		let broken = fn() { 1 + true };
+	`); err != nil {
+		t.Fatalf("run failed: %s", err)
+	}
+
+	got, err := in.Call("add", 1, 2)
+	if err != nil || got != int64(3) {
+		t.Errorf("wrong result of add. want=3, got=%v (%v)", got, err)
+	}
+
+	var add func(int, int) int
+	must(t, in.GetAs("add", &add))
+	if got := add(20, 22); got != 42 {
+		t.Errorf("wrong result of converted add. want=42, got=%d", got)
+	}
+
+	var compose func(func(int64) int64, func(int64) int64) (Func, error)
+	must(t, in.GetAs("compose", &compose))
+	inc, err := compose(func(x int64) int64 { return x + 1 }, func(x int64) int64 { return x * 10 })
+	if err != nil {
+		t.Fatalf("compose failed: %s", err)
+	}
+	if got, err := inc(4); err != nil || got != int64(41) {
+		t.Errorf("wrong result of composed function. want=41, got=%v (%v)", got, err)
+	}
+
+	_, err = in.Call("broken")
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) || rerr.Message != "type mismatch: INTEGER + BOOLEAN" {
+		t.Errorf("wrong error from broken. got=%v", err)
+	}
+
+	if got, want := rerr.Traceback(), "type mismatch: INTEGER + BOOLEAN\n\tat broken (4:25)\n\tat <main>"; got != want {
+		t.Errorf("wrong traceback from broken.\nwant=%q\ngot =%q", want, got)
+	}
+
+	var broken func() (int, error)
+	must(t, in.GetAs("broken", &broken))
+	if _, err := broken(); err == nil {
+		t.Errorf("expected an error from converted broken")
+	}
+
+	if _, err := in.Call("add", 1); err == nil ||
+		err.Error() != "runtime error: wrong number of arguments: want=2, got=1" {
+		t.Errorf("wrong error for a call with too few arguments. got=%v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	in := New()
+	in.SetLimits(limits.Config{MaxSteps: 1000})
+
+	_, err := in.Run("let f = fn() { f() }; f()")
+	if !errors.Is(err, limits.ErrSteps) {
+		t.Errorf("wrong error. want=%v, got=%v", limits.ErrSteps, err)
+	}
+
+	if got, err := in.Run("1 + 1"); err != nil || got != int64(2) {
+		t.Errorf("limits not reset between runs. got=%v (%v)", got, err)
+	}
+}
+
+func TestCallbackLimits(t *testing.T) {
+	in := New()
+	in.SetLimits(limits.Config{MaxSteps: 100000, MaxDepth: 20})
+	must(t, in.Register("apply", func(f func() (any, error)) (any, error) { return f() }))
+	must(t, in.Register("ignore", func(f func() error) { f() }))
+
+	tests := []struct {
+		input    string
+		expected error
+	}{
+		// Calls made by a function passed to a Go function count towards the depth
+		{"let f = fn(n) { apply(fn() { f(n + 1) }) }; f(0)", limits.ErrDepth},
+		// and its steps towards those of the run, though each call is within them
+		{"let spin = fn(n) { if (n > 0) { spin(n - 1) } }; apply(fn() { spin(12000) })", nil},
+		{
+			"let spin = fn(n) { if (n > 0) { spin(n - 1) } }; apply(fn() { spin(12000) }); apply(fn() { spin(12000) })",
+			limits.ErrSteps,
+		},
+		{"apply(fn() { let loop = fn(n) { loop(n + 1) }; loop(0) })", limits.ErrSteps},
+		// A stopped program cannot catch being stopped, nor can the Go function
+		// ignore it
+		{"try { apply(fn() { let loop = fn() { loop() }; loop() }) } catch (e) { 1 }", limits.ErrSteps},
+		{"ignore(fn() { let loop = fn() { loop() }; loop() }); 1", limits.ErrSteps},
+	}
+
+	for _, tt := range tests {
+		_, err := in.Run(tt.input)
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%q: wrong error. want=%v, got=%v", tt.input, tt.expected, err)
+			continue
+		}
+	}
+
+	// The builtins made by Builtin share the limits of the program calling them
+	apply, err := Builtin("apply", func(f func() (any, error)) (any, error) { return f() })
+	must(t, err)
+	env := object.NewEnvironment()
+	env.Set("apply", apply)
+
+	program := parsertest.Parse(t, "let f = fn(n) { apply(fn() { f(n + 1) }) }; f(0)")
+	result := evaluator.EvalWithLimits(program, env, limits.Config{MaxDepth: 20})
+	if abort, ok := result.(*object.Abort); !ok || !errors.Is(abort.Err, limits.ErrDepth) {
+		t.Errorf("wrong result. want=%v, got=%s", limits.ErrDepth, result.Inspect())
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
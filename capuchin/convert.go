@@ -0,0 +1,398 @@
+package capuchin
+
+import (
+	"capuchin/evaluator"
+	"capuchin/object"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+var (
+	objectType = reflect.TypeOf((*object.Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+
+	builtinFunctionType = reflect.TypeOf(object.BuiltinFunction(nil))
+)
+
+// toObject converts a Go value to the capuchin value it stands for.
+func (in *Interpreter) toObject(v any) (object.Object, error) {
+	if obj, ok := v.(object.Object); ok {
+		return obj, nil
+	}
+	return in.valueToObject(reflect.ValueOf(v))
+}
+
+func (in *Interpreter) valueToObject(v reflect.Value) (object.Object, error) {
+	if !v.IsValid() {
+		return evaluator.NULL, nil
+	}
+	if v.Type().Implements(objectType) && v.CanInterface() {
+		if (v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer) && v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return v.Interface().(object.Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return evaluator.TRUE, nil
+		}
+		return evaluator.FALSE, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("cannot convert %d to INTEGER: out of range", v.Uint())
+		}
+		return &object.Integer{Value: int64(v.Uint())}, nil
+
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+
+	case reflect.Slice, reflect.Array:
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			e, err := in.valueToObject(v.Index(i))
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			elements[i] = e
+		}
+		return &object.Array{Elements: elements}, nil
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("cannot convert %s to a capuchin value: keys must be strings", v.Type())
+		}
+		pairs := make(map[object.HashKey]object.HashPair, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := &object.String{Value: iter.Key().String()}
+			value, err := in.valueToObject(iter.Value())
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", key.Value, err)
+			}
+			pairs[key.HashKey()] = object.HashPair{Key: key, Value: value}
+		}
+		return &object.Hash{Pairs: pairs}, nil
+
+	case reflect.Func:
+		if v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return in.builtin("function", v)
+
+	case reflect.Interface:
+		return in.valueToObject(v.Elem())
+	}
+
+	return nil, fmt.Errorf("cannot convert %s to a capuchin value", v.Type())
+}
+
+// fromObject converts obj to the Go value it stands for: an int64, float64, string,
+// bool, []any, map[string]any, Func or nil.
+func (in *Interpreter) fromObject(obj object.Object) (any, error) {
+	switch obj := obj.(type) {
+	case nil, *object.Null:
+		return nil, nil
+	case *object.Integer:
+		return obj.Value, nil
+	case *object.Float:
+		return obj.Value, nil
+	case *object.String:
+		return obj.Value, nil
+	case *object.Boolean:
+		return obj.Value, nil
+
+	case *object.Array:
+		elements := make([]any, len(obj.Elements))
+		for i, e := range obj.Elements {
+			v, err := in.fromObject(e)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			elements[i] = v
+		}
+		return elements, nil
+
+	case *object.Hash:
+		m := make(map[string]any, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			key, ok := pair.Key.(*object.String)
+			if !ok {
+				return nil, fmt.Errorf("cannot convert HASH with %s keys to map[string]any", pair.Key.Type())
+			}
+			v, err := in.fromObject(pair.Value)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", key.Value, err)
+			}
+			m[key.Value] = v
+		}
+		return m, nil
+
+	case *object.Function, *object.Builtin:
+		fn := obj
+		return Func(func(args ...any) (any, error) { return in.call(fn, args) }), nil
+	}
+
+	return nil, fmt.Errorf("cannot convert %s to a Go value", obj.Type())
+}
+
+// fromObjectTo converts obj to a Go value of type t.
+func (in *Interpreter) fromObjectTo(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	if t == objectType {
+		return reflect.ValueOf(&obj).Elem(), nil
+	}
+
+	if _, ok := obj.(*object.Null); ok {
+		switch t.Kind() {
+		case reflect.Interface, reflect.Pointer, reflect.Slice, reflect.Map, reflect.Func:
+			return reflect.Zero(t), nil
+		}
+	}
+
+	mismatch := func() (reflect.Value, error) {
+		return reflect.Value{}, fmt.Errorf("cannot convert %s to %s", obj.Type(), t)
+	}
+	v := reflect.New(t).Elem()
+
+	switch t.Kind() {
+	case reflect.Interface:
+		natural, err := in.fromObject(obj)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if natural == nil {
+			return v, nil
+		}
+		if !reflect.TypeOf(natural).AssignableTo(t) {
+			return mismatch()
+		}
+		v.Set(reflect.ValueOf(natural))
+
+	case reflect.Bool:
+		b, ok := obj.(*object.Boolean)
+		if !ok {
+			return mismatch()
+		}
+		v.SetBool(b.Value)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := obj.(*object.Integer)
+		if !ok {
+			return mismatch()
+		}
+		if v.OverflowInt(i.Value) {
+			return reflect.Value{}, fmt.Errorf("cannot convert %d to %s: out of range", i.Value, t)
+		}
+		v.SetInt(i.Value)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := obj.(*object.Integer)
+		if !ok {
+			return mismatch()
+		}
+		if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+			return reflect.Value{}, fmt.Errorf("cannot convert %d to %s: out of range", i.Value, t)
+		}
+		v.SetUint(uint64(i.Value))
+
+	case reflect.Float32, reflect.Float64:
+		switch n := obj.(type) {
+		case *object.Float:
+			v.SetFloat(n.Value)
+		case *object.Integer:
+			v.SetFloat(float64(n.Value))
+		default:
+			return mismatch()
+		}
+
+	case reflect.String:
+		s, ok := obj.(*object.String)
+		if !ok {
+			return mismatch()
+		}
+		v.SetString(s.Value)
+
+	case reflect.Slice:
+		a, ok := obj.(*object.Array)
+		if !ok {
+			return mismatch()
+		}
+		v.Set(reflect.MakeSlice(t, len(a.Elements), len(a.Elements)))
+		for i, e := range a.Elements {
+			ev, err := in.fromObjectTo(e, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("element %d: %w", i, err)
+			}
+			v.Index(i).Set(ev)
+		}
+
+	case reflect.Map:
+		h, ok := obj.(*object.Hash)
+		if !ok || t.Key().Kind() != reflect.String {
+			return mismatch()
+		}
+		v.Set(reflect.MakeMapWithSize(t, len(h.Pairs)))
+		for _, pair := range h.Pairs {
+			key, ok := pair.Key.(*object.String)
+			if !ok {
+				return reflect.Value{}, fmt.Errorf("cannot convert HASH with %s keys to %s", pair.Key.Type(), t)
+			}
+			ev, err := in.fromObjectTo(pair.Value, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("key %q: %w", key.Value, err)
+			}
+			v.SetMapIndex(reflect.ValueOf(key.Value).Convert(t.Key()), ev)
+		}
+
+	case reflect.Func:
+		switch obj.(type) {
+		case *object.Function, *object.Builtin:
+		default:
+			return mismatch()
+		}
+		if err := checkResults(t); err != nil {
+			return reflect.Value{}, err
+		}
+		v.Set(in.makeFunc(obj, t))
+
+	default:
+		return mismatch()
+	}
+
+	return v, nil
+}
+
+// checkResults reports whether a function of type t returns what a capuchin function
+// can be converted to: at most one value, optionally followed by an error.
+func checkResults(t reflect.Type) error {
+	n := t.NumOut()
+	if n > 0 && t.Out(n-1) == errorType {
+		n--
+	}
+	if n > 1 {
+		return fmt.Errorf("cannot convert a function to %s: too many results", t)
+	}
+	return nil
+}
+
+// makeFunc returns a Go function of type t which calls fn, converting its arguments
+// and result. If t has no error result, a failed call panics with the error.
+func (in *Interpreter) makeFunc(fn object.Object, t reflect.Type) reflect.Value {
+	returnsError := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+
+	return reflect.MakeFunc(t, func(params []reflect.Value) []reflect.Value {
+		args := make([]any, 0, len(params))
+		for i, p := range params {
+			if t.IsVariadic() && i == len(params)-1 {
+				for j := 0; j < p.Len(); j++ {
+					args = append(args, p.Index(j).Interface())
+				}
+				continue
+			}
+			args = append(args, p.Interface())
+		}
+
+		results := make([]reflect.Value, t.NumOut())
+		for i := range results {
+			results[i] = reflect.Zero(t.Out(i))
+		}
+
+		fail := func(err error) []reflect.Value {
+			if !returnsError {
+				panic(err)
+			}
+			results[len(results)-1] = reflect.ValueOf(&err).Elem()
+			return results
+		}
+
+		result, err := in.callObject(fn, args)
+		if err != nil {
+			return fail(err)
+		}
+		if len(results) > 0 && t.Out(0) != errorType {
+			v, err := in.fromObjectTo(result, t.Out(0))
+			if err != nil {
+				return fail(fmt.Errorf("result: %w", err))
+			}
+			results[0] = v
+		}
+		return results
+	})
+}
+
+// builtin wraps fn, a Go function, in a capuchin builtin called name. The arguments
+// are converted to fn's parameter types, and its result back to a capuchin value.
+// An error returned by fn becomes a runtime error.
+func (in *Interpreter) builtin(name string, fn reflect.Value) (*object.Builtin, error) {
+	t := fn.Type()
+	if t == builtinFunctionType || t.ConvertibleTo(builtinFunctionType) {
+		return &object.Builtin{Fn: fn.Convert(builtinFunctionType).Interface().(object.BuiltinFunction)}, nil
+	}
+	if err := checkResults(t); err != nil {
+		return nil, err
+	}
+
+	numIn := t.NumIn()
+	if t.IsVariadic() {
+		numIn--
+	}
+
+	// Script functions passed to fn run as part of the program calling it, or on
+	// their own when it is called as Fn, without a Caller
+	calling := func(call object.Caller, args ...object.Object) object.Object {
+		in := in.calling(call)
+
+		if len(args) < numIn || (!t.IsVariadic() && len(args) > numIn) {
+			return &object.Error{Message: fmt.Sprintf(
+				"wrong number of arguments: want=%d, got=%d", numIn, len(args))}
+		}
+
+		params := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var pt reflect.Type
+			if i < numIn {
+				pt = t.In(i)
+			} else {
+				pt = t.In(numIn).Elem()
+			}
+			p, err := in.fromObjectTo(arg, pt)
+			if err != nil {
+				return &object.Error{Message: fmt.Sprintf("argument %d of %s: %s", i+1, name, err)}
+			}
+			params[i] = p
+		}
+
+		results := fn.Call(params)
+		if in.stopped != nil {
+			return in.stopped
+		}
+		if n := len(results); n > 0 && t.Out(n-1) == errorType {
+			if err, _ := results[n-1].Interface().(error); err != nil {
+				return &object.Error{Message: fmt.Sprintf("%s: %s", name, err)}
+			}
+			results = results[:n-1]
+		}
+		if len(results) == 0 {
+			return nil
+		}
+
+		result, err := in.valueToObject(results[0])
+		if err != nil {
+			return &object.Error{Message: fmt.Sprintf("result of %s: %s", name, err)}
+		}
+		return result
+	}
+	return &object.Builtin{
+		Fn:      func(args ...object.Object) object.Object { return calling(nil, args...) },
+		Calling: calling,
+	}, nil
+}
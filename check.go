@@ -0,0 +1,66 @@
+package main
+
+import (
+	"capuchin/ast"
+	"capuchin/diagnostic"
+	"capuchin/resolver"
+	"capuchin/types"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// checkCommand resolves and type checks each of the named scripts without running
+// them. It exits with 1 if any errors were found and 2 if a script could not be read
+// or parsed.
+func checkCommand(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	showTypes := flags.Bool("types", false, "print the inferred type of each top level let")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: capuchin check [-types] script.cap...")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		program, err := parseFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+
+		diags := resolver.Resolve(program, scriptGlobals()...)
+		checker := types.NewChecker(scriptTypes())
+		_, typeDiags := checker.Check(program)
+		diags = append(diags, typeDiags...)
+		diagnostic.Sort(diags)
+
+		for _, d := range diags {
+			fmt.Printf("%s:%s\n", path, d)
+		}
+		if diagnostic.HasErrors(diags) && status == 0 {
+			status = 1
+		}
+
+		if *showTypes {
+			for _, stmt := range program.Statements {
+				if let, ok := stmt.(*ast.LetStatement); ok {
+					if scheme, ok := checker.Lookup(let.Name.Value); ok {
+						fmt.Printf("%s: %s\n", let.Name.Value, scheme)
+					}
+				}
+			}
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,280 @@
+// Package code defines the bytecode instruction set executed by the virtual machine.
+//
+// An instruction is a one byte opcode followed by its operands, each encoded big
+// endian in the width given by the opcode's Definition.
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Instructions is a sequence of encoded instructions.
+type Instructions []byte
+
+// String disassembles the instructions, one per line, prefixed by their offset.
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+			len(operands), operandCount)
+	}
+
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
+
+// Opcode identifies an instruction.
+type Opcode byte
+
+const (
+	// OpConstant pushes the constant with the index given by its operand.
+	OpConstant Opcode = iota
+
+	// Arithmetic and comparison pop two operands and push the result.
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpLessThan
+
+	// Prefix operators replace the top of the stack.
+	OpMinus
+	OpBang
+
+	OpTrue
+	OpFalse
+	OpNull
+
+	// OpPop discards the top of the stack.
+	OpPop
+
+	// Jumps move to the absolute offset given by their operand. OpJumpNotTruthy
+	// pops the condition and only jumps when it is not truthy.
+	OpJumpNotTruthy
+	OpJump
+
+	// Bindings are read and written by index: globals in the VM's global store,
+	// locals in the current frame, builtins and free variables of the closure. A
+	// free variable held in a cell is read through it.
+	OpGetGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetBuiltin
+	OpGetFree
+
+	// OpCall calls the function below its operand count of arguments.
+	OpCall
+
+	// OpReturnValue returns the top of the stack, OpReturn returns null.
+	OpReturnValue
+	OpReturn
+
+	// OpClosure wraps the function constant given by its first operand, capturing
+	// the number of free variables given by its second from the stack.
+	OpClosure
+
+	// OpCurrentClosure pushes the closure being executed, so functions can call
+	// themselves.
+	OpCurrentClosure
+
+	// OpTailCall is an OpCall whose result is returned straight away. A call to a
+	// closure replaces the current frame instead of pushing a new one.
+	OpTailCall
+
+	// OpTry installs a handler which, if a runtime error or a thrown value unwinds
+	// the program to it, restores the stack and frames to what they are now, pushes
+	// the error and jumps to the offset given by its operand. OpEndTry removes the
+	// most recently installed handler.
+	OpTry
+	OpEndTry
+
+	// OpThrow pops a value and throws it. A caught error is thrown again as it was.
+	OpThrow
+
+	// OpCatch replaces the caught error on top of the stack with the value a catch
+	// block binds for it.
+	OpCatch
+
+	// OpMember replaces the top of the stack with its property named by the string
+	// constant given by its operand.
+	OpMember
+
+	// OpArray replaces the number of values given by its operand with an array of
+	// them. OpHash does the same with a hash, taking the values as alternate keys
+	// and values, so its operand is twice the number of pairs.
+	OpArray
+	OpHash
+
+	// OpIndex pops an index and the array or hash below it, and pushes the element
+	// found there.
+	OpIndex
+
+	// OpCell replaces the local given by its operand with a cell holding its value,
+	// so that the closures capturing the local share it with the function. OpGetCell
+	// and OpSetCell read and write the value in such a cell, and OpGetFreeCell pushes
+	// the free variable given by its operand as it is, cell and all, for a closure to
+	// capture in turn.
+	OpCell
+	OpGetCell
+	OpSetCell
+	OpGetFreeCell
+)
+
+// Definition describes an opcode: its readable name and the width in bytes of each
+// of its operands.
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant:       {"OpConstant", []int{2}},
+	OpAdd:            {"OpAdd", []int{}},
+	OpSub:            {"OpSub", []int{}},
+	OpMul:            {"OpMul", []int{}},
+	OpDiv:            {"OpDiv", []int{}},
+	OpEqual:          {"OpEqual", []int{}},
+	OpNotEqual:       {"OpNotEqual", []int{}},
+	OpGreaterThan:    {"OpGreaterThan", []int{}},
+	OpLessThan:       {"OpLessThan", []int{}},
+	OpMinus:          {"OpMinus", []int{}},
+	OpBang:           {"OpBang", []int{}},
+	OpTrue:           {"OpTrue", []int{}},
+	OpFalse:          {"OpFalse", []int{}},
+	OpNull:           {"OpNull", []int{}},
+	OpPop:            {"OpPop", []int{}},
+	OpJumpNotTruthy:  {"OpJumpNotTruthy", []int{2}},
+	OpJump:           {"OpJump", []int{2}},
+	OpGetGlobal:      {"OpGetGlobal", []int{2}},
+	OpSetGlobal:      {"OpSetGlobal", []int{2}},
+	OpGetLocal:       {"OpGetLocal", []int{1}},
+	OpSetLocal:       {"OpSetLocal", []int{1}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpCall:           {"OpCall", []int{1}},
+	OpReturnValue:    {"OpReturnValue", []int{}},
+	OpReturn:         {"OpReturn", []int{}},
+	OpClosure:        {"OpClosure", []int{2, 1}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpTailCall:       {"OpTailCall", []int{1}},
+	OpTry:            {"OpTry", []int{2}},
+	OpEndTry:         {"OpEndTry", []int{}},
+	OpThrow:          {"OpThrow", []int{}},
+	OpCatch:          {"OpCatch", []int{}},
+	OpMember:         {"OpMember", []int{2}},
+	OpArray:          {"OpArray", []int{2}},
+	OpHash:           {"OpHash", []int{2}},
+	OpIndex:          {"OpIndex", []int{}},
+	OpCell:           {"OpCell", []int{1}},
+	OpGetCell:        {"OpGetCell", []int{1}},
+	OpSetCell:        {"OpSetCell", []int{1}},
+	OpGetFreeCell:    {"OpGetFreeCell", []int{1}},
+}
+
+// Lookup returns the definition of the opcode op.
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+// Make encodes the instruction op with the supplied operands. It returns an empty
+// slice if op is not defined.
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadOperands decodes the operands of an instruction described by def from ins,
+// returning them and the number of bytes read.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+
+		offset += width
+	}
+
+	return operands, offset
+}
+
+// ReadUint16 decodes a two byte operand.
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+// ReadUint8 decodes a one byte operand.
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
@@ -0,0 +1,49 @@
+package code
+
+import (
+	"capuchin/token"
+	"sort"
+)
+
+// LineEntry records that the instructions from Offset onwards, up to the next
+// entry, were compiled from the source at Pos.
+type LineEntry struct {
+	Offset int
+	Pos    token.Position
+}
+
+// LineTable maps instruction offsets back to source positions. Its entries are
+// ordered by offset.
+type LineTable []LineEntry
+
+// Add records that the instruction at offset was compiled from pos, returning the
+// updated table. Runs of instructions from the same position share one entry.
+func (lt LineTable) Add(offset int, pos token.Position) LineTable {
+	if n := len(lt); n > 0 {
+		if lt[n-1].Pos == pos {
+			return lt
+		}
+		if lt[n-1].Offset == offset {
+			lt[n-1].Pos = pos
+			return lt
+		}
+	}
+	return append(lt, LineEntry{Offset: offset, Pos: pos})
+}
+
+// Truncate drops the entries for offset and beyond, after the instructions there
+// have been removed.
+func (lt LineTable) Truncate(offset int) LineTable {
+	i := sort.Search(len(lt), func(i int) bool { return lt[i].Offset >= offset })
+	return lt[:i]
+}
+
+// Lookup returns the source position of the instruction at offset, or the zero
+// Position if it is not known.
+func (lt LineTable) Lookup(offset int) token.Position {
+	i := sort.Search(len(lt), func(i int) bool { return lt[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}
+	}
+	return lt[i-1].Pos
+}
@@ -0,0 +1,924 @@
+// Package compiler lowers a capuchin program to bytecode for the virtual machine.
+//
+// The compiler walks the syntax tree once, emitting instructions into the current
+// function and recording literals in a constant pool shared by the whole program.
+// Names are resolved through symbol tables: top level bindings become globals, the
+// bindings of a function become slots in its stack frame, and the bindings of the
+// enclosing functions which it uses become free variables of its closure.
+//
+// Every name let at the top level, or in a function, is given its slot before any of
+// the code is compiled, and each let of the same name binds that slot again, as the
+// evaluator's environments have one binding per name. So a function may call another
+// one defined further down the program, or further down the function enclosing it. A
+// local which a nested function uses is kept in a cell, which the closure captures
+// rather than the value, so that a later let of the name is seen by both.
+//
+// Calls in tail position, whose value is returned straight away, are compiled to
+// OpTailCall so that the VM can reuse the caller's frame for them, unless they are
+// within a try, whose handler must outlive them.
+//
+// A try installs a handler for its catch block and another for its finally block.
+// The finally block is compiled once for each way of leaving the try: falling off
+// its end, unwinding past it and each return within it.
+package compiler
+
+import (
+	"capuchin/ast"
+	"capuchin/code"
+	"capuchin/object"
+	"capuchin/token"
+	"fmt"
+)
+
+// Bytecode is the result of compiling a program.
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+
+	// Lines maps the offsets of Instructions back to the source positions.
+	Lines code.LineTable
+
+	// Globals holds the name of each global slot, for reporting the use of a
+	// global before its let has run.
+	Globals []string
+}
+
+// EmittedInstruction records an instruction emitted into a scope.
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// CompilationScope holds the instructions of the function being compiled.
+type CompilationScope struct {
+	instructions        code.Instructions
+	lines               code.LineTable
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+
+	// tries holds the trys of the function which the code being compiled is
+	// within, innermost last.
+	tries []tryRegion
+}
+
+// tryRegion is a try being compiled, for the returns within it to leave.
+type tryRegion struct {
+	handlers int                 // The handlers the VM has installed for it
+	finally  *ast.BlockStatement // nil if there is no finally block
+}
+
+// Compiler turns syntax trees into Bytecode.
+type Compiler struct {
+	constants   []object.Object
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	// pos is the source position of the node being compiled, which is recorded
+	// in the line table of each instruction emitted for it.
+	pos token.Position
+}
+
+// New creates a Compiler with a symbol table holding only the builtins.
+func New() *Compiler {
+	symbolTable := NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{{instructions: code.Instructions{}}},
+	}
+}
+
+// NewWithState creates a Compiler which carries on from the symbol table and
+// constants of an earlier compilation, as the REPL does between lines.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
+// SymbolTable returns the compiler's top level symbol table.
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
+}
+
+// Compile emits the bytecode for node. Compiling an identifier which is not bound
+// anywhere is an error.
+func (c *Compiler) Compile(node ast.Node) error {
+	defer c.at(node)()
+
+	switch node := node.(type) {
+	case *ast.Program:
+		c.declareGlobals(node.Statements)
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.LetStatement:
+		return c.compileLet(node)
+
+	case *ast.ExportStatement:
+		// A compiled program is never imported, so its exports are ordinary lets
+		return c.compileLet(node.Statement)
+
+	case *ast.ImportStatement:
+		return fmt.Errorf("import %q: modules are not supported by the compiler", node.Path.Value)
+
+	case *ast.ReturnStatement:
+		if err := c.compileTail(node.ReturnValue); err != nil {
+			return err
+		}
+		if err := c.leaveTries(); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.ThrowStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+
+	case *ast.InfixExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "+":
+			c.emit(code.OpAdd)
+		case "-":
+			c.emit(code.OpSub)
+		case "*":
+			c.emit(code.OpMul)
+		case "/":
+			c.emit(code.OpDiv)
+		case ">":
+			c.emit(code.OpGreaterThan)
+		case "<":
+			c.emit(code.OpLessThan)
+		case "==":
+			c.emit(code.OpEqual)
+		case "!=":
+			c.emit(code.OpNotEqual)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.IfExpression:
+		return c.compileIf(node, false)
+
+	case *ast.TryExpression:
+		return c.compileTry(node)
+
+	case *ast.MemberExpression:
+		if err := c.Compile(node.Object); err != nil {
+			return err
+		}
+		name := &object.String{Value: node.Property.Value}
+		c.emit(code.OpMember, c.addConstant(name))
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+
+	case *ast.ArrayLiteral:
+		for _, e := range node.Elements {
+			if err := c.Compile(e); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		// Keys and values are evaluated in the order they were written, as the
+		// evaluator does
+		for i, key := range node.Keys {
+			if err := c.Compile(key); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Values[i]); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Keys)*2)
+
+	case *ast.IntegerLiteral:
+		integer := &object.Integer{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(integer))
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			return fmt.Errorf("identifier not found: %s", node.Value)
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.FunctionLiteral:
+		return c.compileFunction(node, "")
+
+	case *ast.CallExpression:
+		return c.compileCall(node, code.OpCall)
+	}
+
+	return nil
+}
+
+// at makes node's position the one recorded for the instructions emitted, until the
+// returned function restores the previous one.
+func (c *Compiler) at(node ast.Node) func() {
+	outer := c.pos
+	if pos := position(node); pos.IsValid() {
+		c.pos = pos
+	}
+	return func() { c.pos = outer }
+}
+
+// compileTail compiles exp, whose value is about to be returned from the current
+// function. A call there, or at the end of either branch of an if there, becomes a
+// tail call, unless it is within a try.
+func (c *Compiler) compileTail(exp ast.Expression) error {
+	if len(c.scopes[c.scopeIndex].tries) > 0 {
+		return c.Compile(exp)
+	}
+
+	switch e := exp.(type) {
+	case *ast.CallExpression:
+		defer c.at(e)()
+		return c.compileCall(e, code.OpTailCall)
+	case *ast.IfExpression:
+		defer c.at(e)()
+		return c.compileIf(e, true)
+	}
+	return c.Compile(exp)
+}
+
+// compileCall emits the function and arguments of a call followed by op, which is
+// OpCall or OpTailCall.
+func (c *Compiler) compileCall(node *ast.CallExpression, op code.Opcode) error {
+	if err := c.Compile(node.Function); err != nil {
+		return err
+	}
+	for _, a := range node.Arguments {
+		if err := c.Compile(a); err != nil {
+			return err
+		}
+	}
+	c.emit(op, len(node.Arguments))
+	return nil
+}
+
+// Bytecode returns the program compiled so far.
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+		Lines:        c.scopes[c.scopeIndex].lines,
+		Globals:      c.globalTable().Names(),
+	}
+}
+
+// compileLet binds the value of a let statement. The value is compiled before a
+// local name is defined, so "let x = x + 1" reads the earlier x. A function literal
+// is compiled knowing the name it is bound to, so that it can call itself.
+func (c *Compiler) compileLet(node *ast.LetStatement) error {
+	var err error
+	if fn, ok := node.Value.(*ast.FunctionLiteral); ok {
+		err = c.compileFunction(fn, node.Name.Value)
+	} else {
+		err = c.Compile(node.Value)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.bind(node.Name)
+	return nil
+}
+
+// bind pops the value on top of the stack into the binding of name. A finally block
+// compiled more than once binds the same slots each time, since each name has only
+// the one.
+func (c *Compiler) bind(name *ast.Identifier) {
+	symbol := c.symbolTable.Bind(name.Value)
+	switch symbol.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, symbol.Index)
+	case CellScope:
+		c.emit(code.OpSetCell, symbol.Index)
+	default:
+		c.emit(code.OpSetLocal, symbol.Index)
+	}
+}
+
+// compileIf emits the condition, a conditional jump over the consequence and, so
+// that the if always leaves a value, either the alternative or a null. If the if is
+// in tail position, so are the values of its branches.
+func (c *Compiler) compileIf(node *ast.IfExpression, tail bool) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	// Emit an `OpJumpNotTruthy` with a bogus value, patched below
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.compileBranch(node.Consequence, tail); err != nil {
+		return err
+	}
+
+	// Emit an `OpJump` with a bogus value, patched below
+	jumpPos := c.emit(code.OpJump, 9999)
+
+	afterConsequencePos := len(c.currentInstructions())
+	c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
+
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBranch(node.Alternative, tail); err != nil {
+		return err
+	}
+
+	afterAlternativePos := len(c.currentInstructions())
+	c.changeOperand(jumpPos, afterAlternativePos)
+
+	return nil
+}
+
+// compileTry emits a try. The handlers for the finally and catch blocks are
+// installed in that order, so that an error in the catch block is handled by the
+// finally block. The catch block starts with the caught error on the stack, which
+// OpCatch turns into the value bound to the catch parameter, and the finally block
+// reached by unwinding ends by throwing the error again.
+func (c *Compiler) compileTry(node *ast.TryExpression) error {
+	region := tryRegion{finally: node.Finally}
+
+	var finallyPos, catchPos int
+	if node.Finally != nil {
+		finallyPos = c.emit(code.OpTry, 9999)
+		region.handlers++
+	}
+	if node.Catch != nil {
+		catchPos = c.emit(code.OpTry, 9999)
+		region.handlers++
+	}
+
+	scope := &c.scopes[c.scopeIndex]
+	scope.tries = append(scope.tries, region)
+	depth := len(scope.tries)
+
+	if err := c.compileBranch(node.Body, false); err != nil {
+		return err
+	}
+
+	if node.Catch != nil {
+		c.emit(code.OpEndTry)
+		jumpPos := c.emit(code.OpJump, 9999)
+
+		// Catching removes the catch handler
+		c.changeOperand(catchPos, len(c.currentInstructions()))
+		c.scopes[c.scopeIndex].tries[depth-1].handlers--
+
+		c.emit(code.OpCatch)
+		c.bind(node.Parameter)
+		if err := c.compileBranch(node.Catch, false); err != nil {
+			return err
+		}
+
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+	}
+
+	scope = &c.scopes[c.scopeIndex]
+	scope.tries = scope.tries[:depth-1]
+
+	if node.Finally != nil {
+		c.emit(code.OpEndTry)
+		if err := c.compileFinally(node.Finally); err != nil {
+			return err
+		}
+		jumpPos := c.emit(code.OpJump, 9999)
+
+		c.changeOperand(finallyPos, len(c.currentInstructions()))
+		if err := c.compileFinally(node.Finally); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+	}
+
+	return nil
+}
+
+// compileFinally emits a finally block, whose value is discarded.
+func (c *Compiler) compileFinally(block *ast.BlockStatement) error {
+	if err := c.compileBranch(block, false); err != nil {
+		return err
+	}
+	c.emit(code.OpPop)
+	return nil
+}
+
+// leaveTries emits what a return must do before it leaves the trys it is within:
+// remove their handlers and run their finally blocks, innermost first. The value
+// being returned stays on the stack below.
+func (c *Compiler) leaveTries() error {
+	tries := c.scopes[c.scopeIndex].tries
+	defer func() { c.scopes[c.scopeIndex].tries = tries }()
+
+	for i := len(tries) - 1; i >= 0; i-- {
+		for j := 0; j < tries[i].handlers; j++ {
+			c.emit(code.OpEndTry)
+		}
+		if tries[i].finally != nil {
+			// The finally block runs outside of its own try
+			c.scopes[c.scopeIndex].tries = tries[:i]
+			if err := c.compileFinally(tries[i].finally); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compileBranch compiles a branch of an if so that it leaves its value on the stack:
+// the value of its last expression statement, or null if it ends any other way.
+func (c *Compiler) compileBranch(block *ast.BlockStatement, tail bool) error {
+	for i, stmt := range block.Statements {
+		if es, ok := stmt.(*ast.ExpressionStatement); ok && i == len(block.Statements)-1 {
+			return c.compileValue(es, tail)
+		}
+		if err := c.Compile(stmt); err != nil {
+			return err
+		}
+	}
+
+	c.emit(code.OpNull)
+	return nil
+}
+
+// compileValue compiles the expression of stmt, leaving its value on the stack
+// rather than popping it.
+func (c *Compiler) compileValue(stmt *ast.ExpressionStatement, tail bool) error {
+	defer c.at(stmt)()
+	if tail {
+		return c.compileTail(stmt.Expression)
+	}
+	return c.Compile(stmt.Expression)
+}
+
+// compileFunction compiles fn in a new scope and emits the instruction creating its
+// closure. The name, if not empty, is what the function may call itself by.
+//
+// The locals of the function are declared before its body is compiled, and those
+// which the functions nested within it use are moved into cells as soon as it is
+// called.
+func (c *Compiler) compileFunction(fn *ast.FunctionLiteral, name string) error {
+	c.enterScope()
+
+	var body []ast.Statement
+	if fn.Body != nil {
+		body = fn.Body.Statements
+	}
+	c.symbolTable.captured = captured(body)
+
+	if name != "" {
+		c.symbolTable.DefineFunctionName(name)
+	}
+
+	for _, p := range fn.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+	for _, name := range declarations(body, nil) {
+		c.symbolTable.Declare(name)
+	}
+	for _, s := range c.symbolTable.Cells() {
+		c.emit(code.OpCell, s.Index)
+	}
+
+	if err := c.compileBody(body); err != nil {
+		c.leaveScope()
+		return err
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	locals := c.symbolTable.Names()
+	lines := c.scopes[c.scopeIndex].lines
+	instructions := c.leaveScope()
+
+	for _, s := range freeSymbols {
+		c.captureSymbol(s)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Name:          name,
+		Instructions:  instructions,
+		NumLocals:     numLocals,
+		NumParameters: len(fn.Parameters),
+		Locals:        locals,
+		Lines:         lines,
+	}
+
+	fnIndex := c.addConstant(compiledFn)
+	c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+
+	return nil
+}
+
+// compileBody compiles the statements of a function body. The value of a final
+// expression statement is returned, from tail position.
+func (c *Compiler) compileBody(stmts []ast.Statement) error {
+	for i, stmt := range stmts {
+		if es, ok := stmt.(*ast.ExpressionStatement); ok && i == len(stmts)-1 {
+			if err := c.compileValue(es, true); err != nil {
+				return err
+			}
+			defer c.at(es)()
+			c.emit(code.OpReturnValue)
+			return nil
+		}
+		if err := c.Compile(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// declareGlobals defines a global for every name let at the top level of stmts,
+// including within if branches, which share the top level scope.
+func (c *Compiler) declareGlobals(stmts []ast.Statement) {
+	for _, name := range declarations(stmts, nil) {
+		if symbol, ok := c.symbolTable.Lookup(name); !ok || symbol.Scope != GlobalScope {
+			c.symbolTable.Define(name)
+		}
+	}
+}
+
+// declarations appends the names let in stmts, outside of function literals, to
+// names in the order they appear.
+func declarations(stmts []ast.Statement, names []string) []string {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.LetStatement:
+			names = declarationsIn(s.Value, names)
+			names = append(names, s.Name.Value)
+		case *ast.ExportStatement:
+			names = declarationsIn(s.Statement.Value, names)
+			names = append(names, s.Statement.Name.Value)
+		case *ast.ReturnStatement:
+			names = declarationsIn(s.ReturnValue, names)
+		case *ast.ThrowStatement:
+			names = declarationsIn(s.Value, names)
+		case *ast.ExpressionStatement:
+			names = declarationsIn(s.Expression, names)
+		case *ast.BlockStatement:
+			names = declarations(s.Statements, names)
+		}
+	}
+	return names
+}
+
+func declarationsIn(exp ast.Expression, names []string) []string {
+	switch e := exp.(type) {
+	case *ast.PrefixExpression:
+		names = declarationsIn(e.Right, names)
+	case *ast.InfixExpression:
+		names = declarationsIn(e.Left, names)
+		names = declarationsIn(e.Right, names)
+	case *ast.IfExpression:
+		names = declarationsIn(e.Condition, names)
+		if e.Consequence != nil {
+			names = declarations(e.Consequence.Statements, names)
+		}
+		if e.Alternative != nil {
+			names = declarations(e.Alternative.Statements, names)
+		}
+	case *ast.TryExpression:
+		if e.Body != nil {
+			names = declarations(e.Body.Statements, names)
+		}
+		if e.Catch != nil {
+			names = append(names, e.Parameter.Value)
+			names = declarations(e.Catch.Statements, names)
+		}
+		if e.Finally != nil {
+			names = declarations(e.Finally.Statements, names)
+		}
+	case *ast.MemberExpression:
+		names = declarationsIn(e.Object, names)
+	case *ast.IndexExpression:
+		names = declarationsIn(e.Left, names)
+		names = declarationsIn(e.Index, names)
+	case *ast.ArrayLiteral:
+		for _, el := range e.Elements {
+			names = declarationsIn(el, names)
+		}
+	case *ast.HashLiteral:
+		for i, key := range e.Keys {
+			names = declarationsIn(key, names)
+			names = declarationsIn(e.Values[i], names)
+		}
+	case *ast.CallExpression:
+		names = declarationsIn(e.Function, names)
+		for _, arg := range e.Arguments {
+			names = declarationsIn(arg, names)
+		}
+	}
+	return names
+}
+
+// captured returns the names which the function literals within stmts use from
+// outside of themselves, and so may capture from the function whose body stmts is.
+func captured(stmts []ast.Statement) map[string]bool {
+	names := map[string]bool{}
+	for _, stmt := range stmts {
+		walk(stmt, func(fn *ast.FunctionLiteral, self string) {
+			for name := range freeNames(fn, self) {
+				names[name] = true
+			}
+		}, nil)
+	}
+	return names
+}
+
+// freeNames returns the names fn uses other than its parameters and self, the name
+// it is let bound to, which it calls itself by.
+func freeNames(fn *ast.FunctionLiteral, self string) map[string]bool {
+	names := map[string]bool{}
+	if fn.Body != nil {
+		walk(fn.Body, func(inner *ast.FunctionLiteral, self string) {
+			for name := range freeNames(inner, self) {
+				names[name] = true
+			}
+		}, func(ident *ast.Identifier) {
+			names[ident.Value] = true
+		})
+	}
+
+	delete(names, self)
+	for _, p := range fn.Parameters {
+		delete(names, p.Value)
+	}
+	return names
+}
+
+// walk calls use for each identifier node uses as a value and function for each
+// function literal within it, with the name it is let bound to, without looking
+// inside the function literals. use may be nil.
+func walk(node ast.Node, function func(*ast.FunctionLiteral, string), use func(*ast.Identifier)) {
+	each := func(nodes ...ast.Node) {
+		for _, n := range nodes {
+			walk(n, function, use)
+		}
+	}
+
+	switch n := node.(type) {
+	case *ast.Identifier:
+		if use != nil {
+			use(n)
+		}
+	case *ast.FunctionLiteral:
+		function(n, "")
+	case *ast.LetStatement:
+		if fn, ok := n.Value.(*ast.FunctionLiteral); ok {
+			function(fn, n.Name.Value)
+		} else {
+			each(n.Value)
+		}
+	case *ast.ExportStatement:
+		each(n.Statement)
+	case *ast.ReturnStatement:
+		each(n.ReturnValue)
+	case *ast.ThrowStatement:
+		each(n.Value)
+	case *ast.ExpressionStatement:
+		each(n.Expression)
+	case *ast.BlockStatement:
+		if n != nil {
+			for _, stmt := range n.Statements {
+				each(stmt)
+			}
+		}
+	case *ast.PrefixExpression:
+		each(n.Right)
+	case *ast.InfixExpression:
+		each(n.Left, n.Right)
+	case *ast.IfExpression:
+		each(n.Condition, n.Consequence, n.Alternative)
+	case *ast.TryExpression:
+		each(n.Body, n.Catch, n.Finally)
+	case *ast.MemberExpression:
+		each(n.Object)
+	case *ast.IndexExpression:
+		each(n.Left, n.Index)
+	case *ast.ArrayLiteral:
+		for _, e := range n.Elements {
+			each(e)
+		}
+	case *ast.HashLiteral:
+		for i, key := range n.Keys {
+			each(key, n.Values[i])
+		}
+	case *ast.CallExpression:
+		each(n.Function)
+		for _, arg := range n.Arguments {
+			each(arg)
+		}
+	}
+}
+
+// position returns the source position the instructions for node are attributed
+// to: the operator of an infix, member or index expression, where its runtime errors
+// arise, and the first token of anything else. Programs and blocks take the position
+// of the statements within them.
+func position(node ast.Node) token.Position {
+	switch node := node.(type) {
+	case *ast.Program, *ast.BlockStatement:
+		return token.Position{}
+	case *ast.InfixExpression:
+		return node.Token.Pos
+	case *ast.MemberExpression:
+		return node.Token.Pos
+	case *ast.IndexExpression:
+		return node.Token.Pos
+	}
+	return ast.Pos(node)
+}
+
+func (c *Compiler) globalTable() *SymbolTable {
+	s := c.symbolTable
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case CellScope:
+		c.emit(code.OpGetCell, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// captureSymbol pushes the variable of s for a closure to capture: a local or free
+// variable kept in a cell is pushed as the cell, not its value.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case CellScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFreeCell, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+// emit appends an instruction to the current scope and returns its position.
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	if c.pos.IsValid() {
+		scope := &c.scopes[c.scopeIndex]
+		scope.lines = scope.lines.Add(pos, c.pos)
+	}
+
+	c.setLastInstruction(op, pos)
+
+	return pos
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	updatedInstructions := append(c.currentInstructions(), ins...)
+
+	c.scopes[c.scopeIndex].instructions = updatedInstructions
+
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	newInstruction := code.Make(op, operand)
+
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{instructions: code.Instructions{}}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
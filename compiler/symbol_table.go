@@ -0,0 +1,227 @@
+package compiler
+
+import "sort"
+
+// SymbolScope says where the value of a symbol is kept at run time.
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	CellScope     SymbolScope = "CELL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+)
+
+// Symbol is a name bound by the program, with the index of its value in its scope.
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// SymbolTable maps the names visible in one function, or at the top level, to
+// symbols. Tables for functions are enclosed by the table of the code defining them.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store          map[string]Symbol
+	numDefinitions int
+
+	// pending holds the locals declared for the lets further on in the function,
+	// whose slots are allocated but which are not bound yet.
+	pending map[string]Symbol
+
+	// captured holds the names which the functions nested within this one use.
+	// Locals by those names are kept in cells, for the closures to share.
+	captured map[string]bool
+
+	// FreeSymbols holds the symbols of the enclosing functions which this
+	// function uses, in the order the closure captures them.
+	FreeSymbols []Symbol
+}
+
+// NewSymbolTable creates an empty top level symbol table.
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: make(map[string]Symbol), pending: make(map[string]Symbol)}
+}
+
+// NewEnclosedSymbolTable creates an empty symbol table for a function defined in
+// outer.
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// Define binds name to the next free slot, global at the top level and local inside
+// a function.
+func (s *SymbolTable) Define(name string) Symbol {
+	symbol := s.allocate(name)
+	s.store[name] = symbol
+	return symbol
+}
+
+// Declare allocates a local slot for name, which a let further on in the function
+// binds, unless the function has one by that name already. The name is not bound
+// until Bind is called for it.
+func (s *SymbolTable) Declare(name string) Symbol {
+	if symbol, ok := s.local(name); ok {
+		return symbol
+	}
+	symbol := s.allocate(name)
+	s.pending[name] = symbol
+	return symbol
+}
+
+// Bind binds name as a let does and returns its symbol. A name which s already has a
+// global or local slot for, or has declared, keeps it, so that the closures which
+// captured it see the new value.
+func (s *SymbolTable) Bind(name string) Symbol {
+	if symbol, ok := s.store[name]; ok && symbol.Scope == GlobalScope {
+		return symbol
+	}
+	if symbol, ok := s.local(name); ok {
+		delete(s.pending, name)
+		s.store[name] = symbol
+		return symbol
+	}
+	return s.Define(name)
+}
+
+// DefineBuiltin binds name to the builtin with the supplied index.
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineFunctionName binds name to the function being compiled, so that it can call
+// itself without capturing its own closure.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// Resolve looks name up in s and the tables enclosing it. As in the resolver, a name
+// bound in any of the tables wins over a local only declared so far, which wins over
+// a builtin, so a use ahead of a let refers to the name the let will hide if there is
+// one. Locals of an enclosing function become free symbols of s.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	for _, find := range []func(*SymbolTable, string) (Symbol, bool){bound, declared, builtin} {
+		if symbol, ok := s.resolve(name, find); ok {
+			return symbol, true
+		}
+	}
+	return Symbol{}, false
+}
+
+func (s *SymbolTable) resolve(name string, find func(*SymbolTable, string) (Symbol, bool)) (Symbol, bool) {
+	if symbol, ok := find(s, name); ok {
+		return symbol, true
+	}
+	if s.Outer == nil {
+		return Symbol{}, false
+	}
+
+	symbol, ok := s.Outer.resolve(name, find)
+	if !ok || symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
+		return symbol, ok
+	}
+	return s.defineFree(symbol), true
+}
+
+func bound(s *SymbolTable, name string) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	return symbol, ok && symbol.Scope != BuiltinScope
+}
+
+func declared(s *SymbolTable, name string) (Symbol, bool) {
+	symbol, ok := s.pending[name]
+	return symbol, ok
+}
+
+func builtin(s *SymbolTable, name string) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	return symbol, ok && symbol.Scope == BuiltinScope
+}
+
+// Lookup returns the symbol bound to name in s itself, ignoring enclosing tables.
+func (s *SymbolTable) Lookup(name string) (Symbol, bool) {
+	obj, ok := s.store[name]
+	return obj, ok
+}
+
+// Names returns the names of the slots defined in s, indexed by slot. Slots whose
+// name has since been rebound to another slot are left empty.
+func (s *SymbolTable) Names() []string {
+	names := make([]string, s.numDefinitions)
+	for _, symbol := range s.slots() {
+		names[symbol.Index] = symbol.Name
+	}
+	return names
+}
+
+// Cells returns the symbols of the locals of s which are kept in cells, in the order
+// of their slots.
+func (s *SymbolTable) Cells() []Symbol {
+	cells := []Symbol{}
+	for _, symbol := range s.slots() {
+		if symbol.Scope == CellScope {
+			cells = append(cells, symbol)
+		}
+	}
+	sort.Slice(cells, func(i, j int) bool { return cells[i].Index < cells[j].Index })
+	return cells
+}
+
+// slots returns the symbols of the global or local slots of s, declared or bound.
+func (s *SymbolTable) slots() []Symbol {
+	symbols := []Symbol{}
+	for _, table := range []map[string]Symbol{s.store, s.pending} {
+		for _, symbol := range table {
+			switch symbol.Scope {
+			case GlobalScope, LocalScope, CellScope:
+				symbols = append(symbols, symbol)
+			}
+		}
+	}
+	return symbols
+}
+
+// local returns the local slot s has for name, bound or declared.
+func (s *SymbolTable) local(name string) (Symbol, bool) {
+	if symbol, ok := s.store[name]; ok && (symbol.Scope == LocalScope || symbol.Scope == CellScope) {
+		return symbol, true
+	}
+	symbol, ok := s.pending[name]
+	return symbol, ok
+}
+
+// allocate returns a symbol for name in the next free slot.
+func (s *SymbolTable) allocate(name string) Symbol {
+	symbol := Symbol{Name: name, Index: s.numDefinitions}
+	switch {
+	case s.Outer == nil:
+		symbol.Scope = GlobalScope
+	case s.captured[name]:
+		symbol.Scope = CellScope
+	default:
+		symbol.Scope = LocalScope
+	}
+
+	s.numDefinitions++
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1}
+	symbol.Scope = FreeScope
+
+	s.store[original.Name] = symbol
+	return symbol
+}
@@ -0,0 +1,122 @@
+package compiler
+
+import "testing"
+
+func TestDefineAndResolve(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a")
+	global.DefineBuiltin(0, "puts")
+
+	local := NewEnclosedSymbolTable(global)
+	b := local.Define("b")
+
+	nested := NewEnclosedSymbolTable(local)
+	nested.Define("c")
+
+	tests := []struct {
+		table    *SymbolTable
+		name     string
+		expected Symbol
+	}{
+		{global, "a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{local, "a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{local, "b", Symbol{Name: "b", Scope: LocalScope, Index: 0}},
+		{nested, "puts", Symbol{Name: "puts", Scope: BuiltinScope, Index: 0}},
+		{nested, "b", Symbol{Name: "b", Scope: FreeScope, Index: 0}},
+		{nested, "c", Symbol{Name: "c", Scope: LocalScope, Index: 0}},
+	}
+
+	for _, tt := range tests {
+		result, ok := tt.table.Resolve(tt.name)
+		if !ok {
+			t.Errorf("name %s not resolvable", tt.name)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", tt.name, tt.expected, result)
+		}
+	}
+
+	if a.Index != 0 || b.Index != 0 {
+		t.Errorf("wrong indexes. got=%d and %d", a.Index, b.Index)
+	}
+
+	if len(nested.FreeSymbols) != 1 || nested.FreeSymbols[0] != b {
+		t.Errorf("wrong free symbols. got=%+v", nested.FreeSymbols)
+	}
+
+	if _, ok := nested.Resolve("d"); ok {
+		t.Errorf("name d resolved, but was expected not to")
+	}
+}
+
+func TestDeclareAndBind(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("x")
+
+	local := NewEnclosedSymbolTable(global)
+	local.captured = map[string]bool{"y": true}
+	x := local.Declare("x")
+	y := local.Declare("y")
+
+	if again := local.Declare("x"); again != x {
+		t.Errorf("x declared twice. first=%+v, second=%+v", x, again)
+	}
+
+	// A bound name wins over a declared one, whichever table each is in
+	expected := Symbol{Name: "x", Scope: GlobalScope, Index: 0}
+	if result, ok := local.Resolve("x"); !ok || result != expected {
+		t.Errorf("expected x to resolve to %+v, got=%+v", expected, result)
+	}
+
+	expected = Symbol{Name: "y", Scope: CellScope, Index: 1}
+	if y != expected {
+		t.Errorf("expected y to be declared as %+v, got=%+v", expected, y)
+	}
+
+	nested := NewEnclosedSymbolTable(local)
+	expected = Symbol{Name: "y", Scope: FreeScope, Index: 0}
+	if result, ok := nested.Resolve("y"); !ok || result != expected {
+		t.Errorf("expected y to resolve to %+v, got=%+v", expected, result)
+	}
+	if len(nested.FreeSymbols) != 1 || nested.FreeSymbols[0] != y {
+		t.Errorf("wrong free symbols. got=%+v", nested.FreeSymbols)
+	}
+
+	if bound := local.Bind("x"); bound != x {
+		t.Errorf("expected x to be bound to %+v, got=%+v", x, bound)
+	}
+	if result, ok := local.Resolve("x"); !ok || result != x {
+		t.Errorf("expected x to resolve to %+v once bound, got=%+v", x, result)
+	}
+
+	names := local.Names()
+	if len(names) != 2 || names[0] != "x" || names[1] != "y" {
+		t.Errorf("wrong names. got=%q", names)
+	}
+	if cells := local.Cells(); len(cells) != 1 || cells[0] != y {
+		t.Errorf("wrong cells. got=%+v", cells)
+	}
+}
+
+func TestDefineFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineFunctionName("a")
+
+	expected := Symbol{Name: "a", Scope: FunctionScope, Index: 0}
+	if result, ok := global.Resolve("a"); !ok || result != expected {
+		t.Errorf("expected a to resolve to %+v, got=%+v", expected, result)
+	}
+}
+
+func TestNames(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "puts")
+	global.Define("a")
+	global.Define("b")
+
+	names := global.Names()
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("wrong names. got=%q", names)
+	}
+}
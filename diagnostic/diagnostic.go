@@ -0,0 +1,67 @@
+// Package diagnostic provides the positioned messages reported by the passes that
+// analyse a capuchin program before it runs.
+package diagnostic
+
+import (
+	"capuchin/token"
+	"fmt"
+	"sort"
+)
+
+// Severity indicates how serious a diagnostic is.
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Diagnostic is a single message about a location in the source code.
+type Diagnostic struct {
+	Pos      token.Position
+	End      token.Position // Optional end of the source span the message covers
+	Severity Severity
+	Code     string // A short identifier for the kind of problem, eg "undefined"
+	Message  string
+}
+
+// String returns the diagnostic in the "line:column: severity: message (code)" form.
+// When the diagnostic covers a span its position is written "line:column-line:column".
+func (d Diagnostic) String() string {
+	pos := d.Pos.String()
+	if d.End.IsValid() && d.End != d.Pos {
+		pos += "-" + d.End.String()
+	}
+	return fmt.Sprintf("%s: %s: %s (%s)", pos, d.Severity, d.Message, d.Code)
+}
+
+// Sort orders diagnostics by their position in the source code.
+func Sort(diags []Diagnostic) {
+	sort.SliceStable(diags, func(i, j int) bool {
+		a, b := diags[i].Pos, diags[j].Pos
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
+
+// HasErrors reports whether any of the diagnostics has the Error severity.
+func HasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
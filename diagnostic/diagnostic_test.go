@@ -0,0 +1,44 @@
+package diagnostic
+
+import (
+	"capuchin/token"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	d := Diagnostic{
+		Pos:      token.Position{Line: 3, Column: 7},
+		Severity: Warning,
+		Code:     "unused",
+		Message:  "x is never used",
+	}
+
+	if d.String() != "3:7: warning: x is never used (unused)" {
+		t.Errorf("d.String() wrong. got=%q", d.String())
+	}
+
+	d.End = token.Position{Line: 3, Column: 8}
+	if d.String() != "3:7-3:8: warning: x is never used (unused)" {
+		t.Errorf("d.String() with span wrong. got=%q", d.String())
+	}
+}
+
+func TestSort(t *testing.T) {
+	diags := []Diagnostic{
+		{Pos: token.Position{Line: 2, Column: 1}, Message: "c"},
+		{Pos: token.Position{Line: 1, Column: 9}, Message: "b"},
+		{Pos: token.Position{Line: 1, Column: 2}, Message: "a"},
+	}
+
+	Sort(diags)
+
+	for i, expected := range []string{"a", "b", "c"} {
+		if diags[i].Message != expected {
+			t.Errorf("diags[%d] wrong. want=%q, got=%q", i, expected, diags[i].Message)
+		}
+	}
+
+	if HasErrors(diags[:0]) || !HasErrors(diags) {
+		t.Errorf("HasErrors wrong")
+	}
+}
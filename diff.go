@@ -0,0 +1,94 @@
+package main
+
+import (
+	"capuchin/ast"
+	"capuchin/lexer"
+	"capuchin/parser"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// diffCommand compares the syntax trees of two scripts, ignoring layout, and prints
+// the path to each mismatching subtree. Like diff(1) it exits with 0 when the scripts
+// are equal, 1 when they differ and 2 when they could not be compared.
+func diffCommand(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	all := flags.Bool("all", false, "report every difference instead of the first")
+	positions := flags.Bool("positions", false, "also compare source positions")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: capuchin diff [-all] [-positions] a.cap b.cap")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	a, err := parseFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	b, err := parseFile(flags.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	opts := ast.CompareOptions{IgnorePositions: !*positions, All: *all}
+	diffs := ast.Diff(a, b, opts)
+	for _, d := range diffs {
+		fmt.Println(d)
+	}
+
+	if len(diffs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// parseFile reads and parses the script at path, returning the parser errors as a
+// single error.
+func parseFile(path string) (*ast.Program, error) {
+	src, err := readSource(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseSource(path, src)
+}
+
+// parseSource parses src, the script at path, returning the parser errors as a single
+// error.
+func parseSource(path, src string) (*ast.Program, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+
+	if errs := p.Errors(); len(errs) > 0 {
+		msg := fmt.Sprintf("%s: %d parse errors:", path, len(errs))
+		for _, e := range errs {
+			msg += "\n\t" + e
+		}
+		return nil, fmt.Errorf("%s", msg)
+	}
+
+	return program, nil
+}
+
+// readSource returns the contents of the script at path, which is the standard input
+// if path is "-".
+func readSource(path string) (string, error) {
+	var src []byte
+	var err error
+	if path == "-" {
+		src, err = io.ReadAll(os.Stdin)
+	} else {
+		src, err = os.ReadFile(path)
+	}
+	return string(src), err
+}
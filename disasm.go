@@ -0,0 +1,107 @@
+package main
+
+import (
+	"capuchin/capc"
+	"capuchin/compiler"
+	"capuchin/disasm"
+	"capuchin/lexer"
+	"capuchin/parser"
+	"capuchin/repl"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// disasmCommand prints the bytecode of each named script, compiling .cap scripts and
+// loading .capc files. With no files it reads lines from the terminal and prints the
+// bytecode of each. It exits with 1 if a script does not compile and 2 if a file
+// could not be read.
+func disasmCommand(args []string) int {
+	flags := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: capuchin disasm [script.cap | program.capc]...")
+		fmt.Fprintln(flags.Output(), "With no files, disassembles each line typed at the prompt.")
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		repl.StartDisasm(os.Stdin, os.Stdout)
+		return 0
+	}
+
+	status := 0
+	for i, path := range flags.Args() {
+		if flags.NArg() > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s:\n", path)
+		}
+
+		bytecode, source, code := loadBytecode(path)
+		if code != 0 {
+			if code > status {
+				status = code
+			}
+			continue
+		}
+
+		disasm.Fprint(os.Stdout, bytecode, disasm.Options{Source: source})
+	}
+
+	return status
+}
+
+// loadBytecode returns the bytecode of the script or .capc file at path, along with
+// its source if that is available. A .capc file's source is the script of the same
+// name beside it, if that is still the source it was built from.
+func loadBytecode(path string) (*compiler.Bytecode, string, int) {
+	if filepath.Ext(path) == capc.Extension {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return nil, "", 2
+		}
+		defer f.Close()
+
+		file, err := capc.Decode(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			return nil, "", 2
+		}
+
+		source := ""
+		script := strings.TrimSuffix(path, capc.Extension) + ".cap"
+		if src, err := os.ReadFile(script); err == nil && capc.Hash(src) == file.SourceHash {
+			source = string(src)
+		}
+		return file.Bytecode, source, 0
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, "", 2
+	}
+
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", path, e)
+		}
+		return nil, "", 2
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return nil, "", 1
+	}
+
+	return comp.Bytecode(), string(src), 0
+}
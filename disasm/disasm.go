@@ -0,0 +1,153 @@
+// Package disasm prints compiled capuchin programs in a readable form.
+//
+// Each instruction is shown with its offset, the source position it was compiled
+// from, its decoded operands and, where an operand refers to something, a comment
+// naming it: the value of a constant, the name of a global, builtin or property, or
+// the function a closure is made from. When the source is available its lines are shown
+// above the instructions compiled from them.
+package disasm
+
+import (
+	"bufio"
+	"capuchin/code"
+	"capuchin/compiler"
+	"capuchin/object"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Options control what Fprint shows.
+type Options struct {
+	// Source is the program text the bytecode was compiled from. If it is empty,
+	// only positions are shown.
+	Source string
+
+	// FirstConstant is the index of the first constant to show. The REPL sets it
+	// so that each line only shows the constants it added.
+	FirstConstant int
+}
+
+// Fprint writes the disassembly of bytecode to w: the main program, the constant pool
+// and then the body of each function in it.
+func Fprint(w io.Writer, bytecode *compiler.Bytecode, opts Options) error {
+	p := &printer{
+		out:      bufio.NewWriter(w),
+		bytecode: bytecode,
+	}
+	if opts.Source != "" {
+		p.source = strings.Split(opts.Source, "\n")
+	}
+
+	fmt.Fprintln(p.out, "main:")
+	p.instructions(bytecode.Instructions, bytecode.Lines)
+
+	first := opts.FirstConstant
+	if first > len(bytecode.Constants) {
+		first = len(bytecode.Constants)
+	}
+	constants := bytecode.Constants[first:]
+	if len(constants) > 0 {
+		fmt.Fprintln(p.out, "constants:")
+		for i, c := range constants {
+			fmt.Fprintf(p.out, "  %4d  %s\n", first+i, p.describe(c))
+		}
+	}
+
+	for i, c := range constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			index := first + i
+			fmt.Fprintf(p.out, "fn#%d: %d parameters, %d locals\n", index, fn.NumParameters, fn.NumLocals)
+			p.instructions(fn.Instructions, fn.Lines)
+		}
+	}
+
+	return p.out.Flush()
+}
+
+type printer struct {
+	out      *bufio.Writer
+	bytecode *compiler.Bytecode
+	source   []string
+}
+
+// instructions prints one function body. A source line is printed whenever the
+// instructions move on to a line which has not just been shown.
+func (p *printer) instructions(ins code.Instructions, lines code.LineTable) {
+	shown := 0
+
+	for ip := 0; ip < len(ins); {
+		pos := lines.Lookup(ip)
+		if pos.Line != shown && pos.Line > 0 && pos.Line <= len(p.source) {
+			fmt.Fprintf(p.out, "%4d | %s\n", pos.Line, strings.TrimRight(p.source[pos.Line-1], " \t\r"))
+			shown = pos.Line
+		}
+
+		def, err := code.Lookup(ins[ip])
+		if err != nil {
+			fmt.Fprintf(p.out, "       %04d  ERROR: %s\n", ip, err)
+			ip++
+			continue
+		}
+
+		operands, read := code.ReadOperands(def, ins[ip+1:])
+
+		position := "-"
+		if pos.IsValid() {
+			position = pos.String()
+		}
+
+		text := def.Name
+		for _, o := range operands {
+			text += fmt.Sprintf(" %d", o)
+		}
+
+		line := fmt.Sprintf("       %04d  %-7s %-22s", ip, position, text)
+		if comment := p.comment(code.Opcode(ins[ip]), operands); comment != "" {
+			line += " ; " + comment
+		}
+		fmt.Fprintln(p.out, strings.TrimRight(line, " "))
+
+		ip += 1 + read
+	}
+}
+
+// comment names what the operands of an instruction refer to.
+func (p *printer) comment(op code.Opcode, operands []int) string {
+	switch op {
+	case code.OpConstant:
+		if operands[0] < len(p.bytecode.Constants) {
+			return p.describe(p.bytecode.Constants[operands[0]])
+		}
+	case code.OpMember:
+		if operands[0] < len(p.bytecode.Constants) {
+			return "." + p.bytecode.Constants[operands[0]].Inspect()
+		}
+	case code.OpGetGlobal, code.OpSetGlobal:
+		if operands[0] < len(p.bytecode.Globals) {
+			return p.bytecode.Globals[operands[0]]
+		}
+	case code.OpGetBuiltin:
+		if operands[0] < len(object.Builtins) {
+			return object.Builtins[operands[0]].Name
+		}
+	case code.OpClosure:
+		return fmt.Sprintf("fn#%d, %d free", operands[0], operands[1])
+	}
+	return ""
+}
+
+// describe returns a short description of a constant.
+func (p *printer) describe(obj object.Object) string {
+	switch obj := obj.(type) {
+	case *object.CompiledFunction:
+		for i, c := range p.bytecode.Constants {
+			if c == obj {
+				return fmt.Sprintf("fn#%d", i)
+			}
+		}
+		return "fn"
+	default:
+		return fmt.Sprintf("%s %s", obj.Type(), obj.Inspect())
+	}
+}
@@ -0,0 +1,97 @@
+package disasm
+
+import (
+	"bytes"
+	"capuchin/compiler"
+	"capuchin/parsertest"
+	"testing"
+)
+
+func TestFprint(t *testing.T) {
+	input := `let a = 1;
+let f = fn(x) {
+  puts(x + a)
+};
+f(2)`
+
+	expected := `main:
+   1 | let a = 1;
+       0000  1:9     OpConstant 0           ; INTEGER 1
+       0003  1:1     OpSetGlobal 0          ; a
+   2 | let f = fn(x) {
+       0006  2:1     OpClosure 1 0          ; fn#1, 0 free
+       0010  2:1     OpSetGlobal 1          ; f
+   5 | f(2)
+       0013  5:1     OpGetGlobal 1          ; f
+       0016  5:3     OpConstant 2           ; INTEGER 2
+       0019  5:1     OpCall 1
+       0021  5:1     OpPop
+constants:
+     0  INTEGER 1
+     1  fn#1
+     2  INTEGER 2
+fn#1: 1 parameters, 1 locals
+   3 |   puts(x + a)
+       0000  3:3     OpGetBuiltin 0         ; puts
+       0002  3:8     OpGetLocal 0
+       0004  3:12    OpGetGlobal 0          ; a
+       0007  3:10    OpAdd
+       0008  3:3     OpTailCall 1
+       0010  3:3     OpReturnValue
+`
+
+	var out bytes.Buffer
+	if err := Fprint(&out, compile(t, input), Options{Source: input}); err != nil {
+		t.Fatalf("Fprint failed: %s", err)
+	}
+
+	if out.String() != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestFprintWithoutSource(t *testing.T) {
+	expected := `main:
+       0000  1:1     OpTrue
+       0001  1:1     OpPop
+`
+
+	var out bytes.Buffer
+	if err := Fprint(&out, compile(t, "true"), Options{}); err != nil {
+		t.Fatalf("Fprint failed: %s", err)
+	}
+
+	if out.String() != expected {
+		t.Errorf("wrong disassembly.\nwant=%q\ngot =%q", expected, out.String())
+	}
+}
+
+func TestFirstConstant(t *testing.T) {
+	expected := `main:
+       0000  1:1     OpConstant 0           ; INTEGER 1
+       0003  1:5     OpConstant 1           ; INTEGER 2
+       0006  1:3     OpAdd
+       0007  1:1     OpPop
+constants:
+     1  INTEGER 2
+`
+
+	var out bytes.Buffer
+	if err := Fprint(&out, compile(t, "1 + 2"), Options{FirstConstant: 1}); err != nil {
+		t.Fatalf("Fprint failed: %s", err)
+	}
+
+	if out.String() != expected {
+		t.Errorf("wrong disassembly.\nwant=%q\ngot =%q", expected, out.String())
+	}
+}
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+
+	comp := compiler.New()
+	if err := comp.Compile(parsertest.Parse(t, input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
@@ -0,0 +1,690 @@
+// Package evaluator runs capuchin programs by walking their abstract syntax tree.
+//
+// It is the reference implementation of the language: the bytecode compiler and
+// virtual machine are tested against it and must produce the same results and the
+// same runtime errors.
+//
+// Calls in tail position, whose value is returned straight away by the function
+// making them, do not grow the Go stack. They are handed back to applyFunction as a
+// tailCall, which it makes in a loop once the current call is done, so recursion
+// through tail calls runs in constant stack however deep it goes.
+//
+// Runtime errors record where they happened and the calls in progress at the time,
+// except those replaced by tail calls, for their traceback. They unwind the program,
+// as values thrown by a throw statement do, until they are caught by a try. Calls
+// within a try are never tail calls, so that the try is still there when they fail.
+//
+// EvalWithLimits runs untrusted programs within the limits of a limits.Config,
+// counting every node evaluated as a step.
+package evaluator
+
+import (
+	"capuchin/ast"
+	"capuchin/limits"
+	"capuchin/object"
+	"capuchin/token"
+	"fmt"
+)
+
+// The values which only ever need one instance.
+var (
+	NULL  = &object.Null{}
+	TRUE  = &object.Boolean{Value: true}
+	FALSE = &object.Boolean{Value: false}
+)
+
+// Eval evaluates node in env and returns its value. Runtime errors are returned as
+// *object.Error values. Statements which have no value, such as let, return nil.
+func Eval(node ast.Node, env *object.Environment) object.Object {
+	return (&interpreter{}).eval(node, env)
+}
+
+// EvalWithLimits evaluates node in env like Eval, but stops as soon as the evaluation
+// goes beyond the limits of config, or its context is done, returning an
+// *object.Abort holding the reason.
+func EvalWithLimits(node ast.Node, env *object.Environment, config limits.Config) object.Object {
+	return (&interpreter{meter: limits.NewMeter(config)}).eval(node, env)
+}
+
+// Apply calls fn with args as a call in a program would, for callers outside the
+// program such as a host embedding capuchin. fn may be any function value.
+func Apply(fn object.Object, args []object.Object) object.Object {
+	return (&interpreter{}).applyFunction(fn, args, token.Position{})
+}
+
+// ApplyWithLimits calls fn with args like Apply, within the limits of config.
+func ApplyWithLimits(fn object.Object, args []object.Object, config limits.Config) object.Object {
+	return (&interpreter{meter: limits.NewMeter(config)}).applyFunction(fn, args, token.Position{})
+}
+
+// interpreter holds the state of one evaluation.
+type interpreter struct {
+	meter *limits.Meter // nil when there are no limits
+	depth int           // function calls in progress
+
+	// tries counts the try bodies, and the catch blocks with a finally, which the
+	// current function call is within.
+	tries int
+
+	loader *Loader // nil when imports are not available
+	file   string  // the file being evaluated, which imports are relative to
+}
+
+func (in *interpreter) eval(node ast.Node, env *object.Environment) object.Object {
+	if err := in.meter.Step(); err != nil {
+		return &object.Abort{Err: err}
+	}
+
+	result := in.evalNode(node, env)
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = position(node)
+	}
+	return result
+}
+
+func (in *interpreter) evalNode(node ast.Node, env *object.Environment) object.Object {
+	switch node := node.(type) {
+
+	// Statements
+	case *ast.Program:
+		return in.evalProgram(node, env)
+
+	case *ast.ExpressionStatement:
+		return in.eval(node.Expression, env)
+
+	case *ast.BlockStatement:
+		return in.evalBlockStatement(node, env, false)
+
+	case *ast.ReturnStatement:
+		val := in.evalTail(node.ReturnValue, env)
+		if isError(val) {
+			return val
+		}
+		in.meter.Alloc(limits.ObjectSize)
+		return &object.ReturnValue{Value: val}
+
+	case *ast.ThrowStatement:
+		val := in.eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		in.meter.Alloc(limits.ObjectSize)
+		return &object.Error{Message: "uncaught exception: " + val.Inspect(), Value: val}
+
+	case *ast.LetStatement:
+		val := in.eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		if fn, ok := val.(*object.Function); ok {
+			if _, ok := node.Value.(*ast.FunctionLiteral); ok {
+				fn.Name = node.Name.Value
+			}
+		}
+		in.meter.Alloc(limits.SlotSize)
+		env.Set(node.Name.Value, val)
+
+	case *ast.ImportStatement:
+		module := in.importModule(node)
+		if isError(module) {
+			return module
+		}
+		in.meter.Alloc(limits.SlotSize)
+		env.Set(node.Name.Value, module)
+
+	case *ast.ExportStatement:
+		return in.eval(node.Statement, env)
+
+	// Expressions
+	case *ast.IntegerLiteral:
+		in.meter.Alloc(limits.ObjectSize)
+		return &object.Integer{Value: node.Value}
+
+	case *ast.StringLiteral:
+		in.meter.Alloc(limits.ObjectSize + int64(len(node.Value)))
+		return &object.String{Value: node.Value}
+
+	case *ast.Boolean:
+		return nativeBoolToBooleanObject(node.Value)
+
+	case *ast.PrefixExpression:
+		right := in.eval(node.Right, env)
+		if isError(right) {
+			return right
+		}
+		in.meter.Alloc(limits.ObjectSize)
+		return evalPrefixExpression(node.Operator, right)
+
+	case *ast.InfixExpression:
+		left := in.eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		right := in.eval(node.Right, env)
+		if isError(right) {
+			return right
+		}
+		in.meter.Alloc(limits.ObjectSize)
+		result := evalInfixExpression(node.Operator, left, right)
+		if str, ok := result.(*object.String); ok {
+			in.meter.Alloc(int64(len(str.Value)))
+		}
+		return result
+
+	case *ast.IfExpression:
+		return in.evalIfExpression(node, env, false)
+
+	case *ast.TryExpression:
+		return in.evalTryExpression(node, env)
+
+	case *ast.MemberExpression:
+		obj := in.eval(node.Object, env)
+		if isError(obj) {
+			return obj
+		}
+		return evalMemberExpression(obj, node.Property.Value)
+
+	case *ast.IndexExpression:
+		left := in.eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := in.eval(node.Index, env)
+		if isError(index) {
+			return index
+		}
+		return evalIndexExpression(left, index)
+
+	case *ast.ArrayLiteral:
+		elements := in.evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isError(elements[0]) {
+			return elements[0]
+		}
+		in.meter.Alloc(limits.ObjectSize + limits.SlotSize*int64(len(elements)))
+		return &object.Array{Elements: append([]object.Object{}, elements...)}
+
+	case *ast.HashLiteral:
+		return in.evalHashLiteral(node, env)
+
+	case *ast.Identifier:
+		return evalIdentifier(node, env)
+
+	case *ast.FunctionLiteral:
+		in.meter.Alloc(limits.ObjectSize)
+		return &object.Function{Parameters: node.Parameters, Body: node.Body, Env: env}
+
+	case *ast.CallExpression:
+		return in.evalCallExpression(node, env, false)
+	}
+
+	return nil
+}
+
+// tailCall is a call in tail position which is still to be made. It never escapes
+// from the function call, or the program, it was returned from.
+type tailCall struct {
+	fn   object.Object
+	args []object.Object
+	pos  token.Position
+}
+
+func (tc *tailCall) Type() object.ObjectType { return "TAIL_CALL" }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
+// evalTail evaluates node, whose value is the result of the enclosing function. A
+// call there is returned as a tailCall rather than made, unless it is within a try.
+func (in *interpreter) evalTail(node ast.Node, env *object.Environment) object.Object {
+	if in.tries > 0 {
+		return in.eval(node, env)
+	}
+
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		return in.evalBlockStatement(node, env, true)
+	case *ast.ExpressionStatement:
+		return in.evalTail(node.Expression, env)
+	case *ast.IfExpression:
+		return in.evalIfExpression(node, env, true)
+	case *ast.CallExpression:
+		return in.evalCallExpression(node, env, true)
+	}
+	return in.eval(node, env)
+}
+
+func (in *interpreter) evalProgram(program *ast.Program, env *object.Environment) object.Object {
+	var result object.Object
+
+	for _, statement := range program.Statements {
+		result = in.eval(statement, env)
+
+		switch result := result.(type) {
+		case *object.ReturnValue:
+			if call, ok := result.Value.(*tailCall); ok {
+				return in.applyFunction(call.fn, call.args, call.pos)
+			}
+			return result.Value
+		case *object.Error, *object.Abort:
+			return result
+		}
+	}
+
+	return result
+}
+
+// evalBlockStatement evaluates the statements of a block, stopping early at a return
+// or an error, which are passed up unchanged. A block whose last statement has no
+// value evaluates to null. If the block is in tail position, so is its last statement.
+func (in *interpreter) evalBlockStatement(block *ast.BlockStatement, env *object.Environment, tail bool) object.Object {
+	var result object.Object
+
+	for i, statement := range block.Statements {
+		if tail && i == len(block.Statements)-1 {
+			result = in.evalTail(statement, env)
+		} else {
+			result = in.eval(statement, env)
+		}
+
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ || rt == object.ABORT_OBJ {
+				return result
+			}
+		}
+	}
+
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return TRUE
+	}
+	return FALSE
+}
+
+func evalPrefixExpression(operator string, right object.Object) object.Object {
+	switch operator {
+	case "!":
+		return nativeBoolToBooleanObject(!isTruthy(right))
+	case "-":
+		if right.Type() != object.INTEGER_OBJ {
+			return newError("unknown operator: -%s", right.Type())
+		}
+		return &object.Integer{Value: -right.(*object.Integer).Value}
+	default:
+		return newError("unknown operator: %s%s", operator, right.Type())
+	}
+}
+
+func evalInfixExpression(operator string, left, right object.Object) object.Object {
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		return evalIntegerInfixExpression(operator, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return evalStringInfixExpression(operator, left, right)
+	case operator == "==":
+		return nativeBoolToBooleanObject(left == right)
+	case operator == "!=":
+		return nativeBoolToBooleanObject(left != right)
+	case left.Type() != right.Type():
+		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := left.(*object.Integer).Value
+	rightVal := right.(*object.Integer).Value
+
+	switch operator {
+	case "+":
+		return &object.Integer{Value: leftVal + rightVal}
+	case "-":
+		return &object.Integer{Value: leftVal - rightVal}
+	case "*":
+		return &object.Integer{Value: leftVal * rightVal}
+	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Integer{Value: leftVal / rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// evalStringInfixExpression joins two strings with + and compares them by value with
+// == and !=.
+func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// evalIfExpression evaluates an if. If it is in tail position, so are its branches.
+func (in *interpreter) evalIfExpression(ie *ast.IfExpression, env *object.Environment, tail bool) object.Object {
+	condition := in.eval(ie.Condition, env)
+	if isError(condition) {
+		return condition
+	}
+
+	if isTruthy(condition) {
+		return in.evalBlockStatement(ie.Consequence, env, tail)
+	} else if ie.Alternative != nil {
+		return in.evalBlockStatement(ie.Alternative, env, tail)
+	}
+	return NULL
+}
+
+// evalTryExpression evaluates the body of a try. If it fails with an error, rather
+// than an abort, the error is bound to the catch parameter and the catch block is
+// evaluated instead. The finally block is evaluated last, whatever happened before,
+// and its value is discarded unless it returns or fails itself.
+func (in *interpreter) evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	in.tries++
+	result := in.evalBlockStatement(te.Body, env, false)
+	in.tries--
+
+	if err, ok := result.(*object.Error); ok && te.Catch != nil {
+		in.meter.Alloc(limits.SlotSize)
+		env.Set(te.Parameter.Value, err.Caught())
+
+		if te.Finally != nil {
+			in.tries++
+		}
+		result = in.evalBlockStatement(te.Catch, env, false)
+		if te.Finally != nil {
+			in.tries--
+		}
+	}
+
+	if te.Finally == nil || result.Type() == object.ABORT_OBJ {
+		return result
+	}
+
+	finally := in.evalBlockStatement(te.Finally, env, false)
+	if rt := finally.Type(); rt == object.RETURN_VALUE_OBJ || isError(finally) {
+		return finally
+	}
+	return result
+}
+
+// evalMemberExpression looks up the property name of obj. Only hashes and modules
+// have properties: those of a hash are its string keys, and a missing one is null,
+// while those of a module are its exports, and a missing one is an error.
+func evalMemberExpression(obj object.Object, name string) object.Object {
+	if module, ok := obj.(*object.Module); ok {
+		if val, ok := module.Exports[name]; ok {
+			return val
+		}
+		return newError("module %s does not export %s", module.Path, name)
+	}
+
+	hash, ok := obj.(*object.Hash)
+	if !ok {
+		return newError("no property %s on %s", name, obj.Type())
+	}
+
+	pair, ok := hash.Pairs[(&object.String{Value: name}).HashKey()]
+	if !ok {
+		return NULL
+	}
+	return pair.Value
+}
+
+// evalIndexExpression looks up the element of an array, or the value of a hash,
+// found at index. An index outside the array, or a key missing from the hash, gives
+// null.
+func evalIndexExpression(left, index object.Object) object.Object {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		elements := left.(*object.Array).Elements
+		i := index.(*object.Integer).Value
+		if i < 0 || i >= int64(len(elements)) {
+			return NULL
+		}
+		return elements[i]
+
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		pair, ok := left.(*object.Hash).Pairs[key.HashKey()]
+		if !ok {
+			return NULL
+		}
+		return pair.Value
+
+	default:
+		return newError("index operator not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
+// evalHashLiteral evaluates the keys and values of a hash from left to right. A key
+// written twice keeps the last value given for it.
+func (in *interpreter) evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+	pairs := make(map[object.HashKey]object.HashPair, len(node.Keys))
+
+	for i, keyNode := range node.Keys {
+		key := in.eval(keyNode, env)
+		if isError(key) {
+			return key
+		}
+		hashable, ok := key.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", key.Type())
+		}
+
+		value := in.eval(node.Values[i], env)
+		if isError(value) {
+			return value
+		}
+
+		pairs[hashable.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	in.meter.Alloc(limits.ObjectSize + 2*limits.SlotSize*int64(len(pairs)))
+	return &object.Hash{Pairs: pairs}
+}
+
+// evalCallExpression evaluates the function and the arguments of a call, then makes
+// the call, or returns it as a tailCall if it is in tail position.
+func (in *interpreter) evalCallExpression(node *ast.CallExpression, env *object.Environment, tail bool) object.Object {
+	function := in.eval(node.Function, env)
+	if isError(function) {
+		return function
+	}
+	args := in.evalExpressions(node.Arguments, env)
+	if len(args) == 1 && isError(args[0]) {
+		return args[0]
+	}
+
+	in.meter.Alloc(limits.SlotSize * int64(len(args)))
+	if tail {
+		in.meter.Alloc(limits.ObjectSize)
+		return &tailCall{fn: function, args: args, pos: position(node)}
+	}
+	return in.applyFunction(function, args, position(node))
+}
+
+func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+	if val, ok := env.Get(node.Value); ok {
+		return val
+	}
+
+	if builtin := object.GetBuiltinByName(node.Value); builtin != nil {
+		return builtin
+	}
+
+	return newError("identifier not found: %s", node.Value)
+}
+
+// evalExpressions evaluates exps from left to right. If one fails, the error is
+// returned as the only element.
+func (in *interpreter) evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+	var result []object.Object
+
+	for _, e := range exps {
+		evaluated := in.eval(e, env)
+		if isError(evaluated) {
+			return []object.Object{evaluated}
+		}
+		result = append(result, evaluated)
+	}
+
+	return result
+}
+
+// applyFunction calls fn with args from a call at pos. The body is evaluated in tail
+// position, and when it ends in a tail call, that call is made next in place of this
+// one, so an error in it is reported as happening in this call.
+func (in *interpreter) applyFunction(fn object.Object, args []object.Object, pos token.Position) object.Object {
+	in.depth++
+	defer func() { in.depth-- }()
+	if err := in.meter.Call(in.depth); err != nil {
+		return &object.Abort{Err: err}
+	}
+
+	// The tries of the caller do not stop the callee's tail calls
+	tries := in.tries
+	in.tries = 0
+	defer func() { in.tries = tries }()
+
+	site := pos                   // The position of the call being made
+	var caller *object.StackFrame // The function making it, once it is a tail call
+
+	for {
+		switch f := fn.(type) {
+		case *object.Function:
+			if len(args) != len(f.Parameters) {
+				return located(newError("wrong number of arguments: want=%d, got=%d",
+					len(f.Parameters), len(args)), site, caller)
+			}
+			extendedEnv := in.extendFunctionEnv(f, args)
+			evaluated := unwrapReturnValue(in.evalTail(f.Body, extendedEnv))
+
+			frame := &object.StackFrame{Function: object.FunctionName(f.Name), Pos: pos}
+			if call, ok := evaluated.(*tailCall); ok {
+				fn, args, site, caller = call.fn, call.args, call.pos, frame
+				continue
+			}
+			if err, ok := evaluated.(*object.Error); ok {
+				err.Stack = append(err.Stack, *frame)
+			}
+			return evaluated
+
+		case *object.Builtin:
+			result := f.Call(in.call, args...)
+			if err, ok := result.(*object.Error); ok {
+				return located(err, site, caller)
+			}
+			if result != nil {
+				return result
+			}
+			return NULL
+
+		default:
+			return located(newError("not a function: %s", fn.Type()), site, caller)
+		}
+	}
+}
+
+// call is the object.Caller given to builtins, which calls fn as a call made by the
+// builtin, sharing the limits and the call depth of the program.
+func (in *interpreter) call(fn object.Object, args ...object.Object) object.Object {
+	return in.applyFunction(fn, args, token.Position{})
+}
+
+// located records that err happened in a call at pos, made by caller unless that is
+// nil.
+func located(err *object.Error, pos token.Position, caller *object.StackFrame) *object.Error {
+	if !err.Pos.IsValid() {
+		err.Pos = pos
+	}
+	if caller != nil {
+		err.Stack = append(err.Stack, *caller)
+	}
+	return err
+}
+
+// position returns the position an error in node is reported at, the same as the
+// compiler records for its instructions: that of the operator for an infix, member or
+// index expression, otherwise where the node starts.
+func position(node ast.Node) token.Position {
+	switch node := node.(type) {
+	case *ast.Program, *ast.BlockStatement:
+		return token.Position{}
+	case *ast.InfixExpression:
+		return node.Token.Pos
+	case *ast.MemberExpression:
+		return node.Token.Pos
+	case *ast.IndexExpression:
+		return node.Token.Pos
+	}
+	return ast.Pos(node)
+}
+
+func (in *interpreter) extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
+	in.meter.Alloc(limits.ObjectSize + limits.SlotSize*int64(len(args)))
+	env := object.NewEnclosedEnvironment(fn.Env)
+
+	for i, param := range fn.Parameters {
+		env.Set(param.Value, args[i])
+	}
+
+	return env
+}
+
+func unwrapReturnValue(obj object.Object) object.Object {
+	if returnValue, ok := obj.(*object.ReturnValue); ok {
+		return returnValue.Value
+	}
+	return obj
+}
+
+// isTruthy reports whether obj counts as true in a condition: everything but null
+// and false does.
+func isTruthy(obj object.Object) bool {
+	switch obj {
+	case NULL, FALSE:
+		return false
+	default:
+		return true
+	}
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
+
+// isError reports whether obj stops the evaluation: an error or an abort.
+func isError(obj object.Object) bool {
+	if obj == nil {
+		return false
+	}
+	rt := obj.Type()
+	return rt == object.ERROR_OBJ || rt == object.ABORT_OBJ
+}
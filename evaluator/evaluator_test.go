@@ -0,0 +1,477 @@
+package evaluator
+
+import (
+	"capuchin/lexer"
+	"capuchin/limits"
+	"capuchin/object"
+	"capuchin/parser"
+	"context"
+	"errors"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+func TestEvalIntegerExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"5", 5},
+		{"-10", -10},
+		{"5 + 5 + 5 + 5 - 10", 10},
+		{"2 * 2 * 2 * 2 * 2", 32},
+		{"-50 + 100 + -50", 0},
+		{"20 + 2 * -10", 0},
+		{"50 / 2 * 2 + 10", 60},
+		{"3 * (3 * 3) + 10", 37},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestEvalBooleanExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"true", true},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 == 1", true},
+		{"1 != 1", false},
+		{"true == true", true},
+		{"true != false", true},
+		{"(1 < 2) == true", true},
+		{"!5", false},
+		{"!!true", true},
+		{"1 == true", false},
+	}
+
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestIfElseExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"if (true) { 10 }", 10},
+		{"if (false) { 10 }", nil},
+		{"if (1) { 10 }", 10},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (true) {}", nil},
+		{"if (true) { let a = 1; }", nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if integer, ok := tt.expected.(int); ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else if evaluated != NULL {
+			t.Errorf("object is not NULL. got=%T (%+v)", evaluated, evaluated)
+		}
+	}
+}
+
+func TestReturnStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"return 10; 9;", 10},
+		{"9; return 2 * 5; 9;", 10},
+		{"if (10 > 1) { if (10 > 1) { return 10; } return 1; }", 10},
+		{"let f = fn(x) { return x; x + 10; }; f(10);", 10},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestErrorHandling(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"5 + true;", "type mismatch: INTEGER + BOOLEAN"},
+		{"5 + true; 5;", "type mismatch: INTEGER + BOOLEAN"},
+		{"-true", "unknown operator: -BOOLEAN"},
+		{"true + false;", "unknown operator: BOOLEAN + BOOLEAN"},
+		{"if (10 > 1) { return true + false; }", "unknown operator: BOOLEAN + BOOLEAN"},
+		{"foobar", "identifier not found: foobar"},
+		{"10 / (5 - 5)", "division by zero"},
+		{"5(1)", "not a function: INTEGER"},
+		{"fn(x) { x }(1, 2)", "wrong number of arguments: want=1, got=2"},
+		{`"Hello" - "World"`, "unknown operator: STRING - STRING"},
+		{`{"name": "Monkey"}[fn(x) { x }];`, "unusable as hash key: FUNCTION"},
+		{`{[1]: 2}`, "unusable as hash key: ARRAY"},
+		{`[1, 2]["a"]`, "index operator not supported: ARRAY[STRING]"},
+		{`5[0]`, "index operator not supported: INTEGER[INTEGER]"},
+		{`[1, -true]`, "unknown operator: -BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { 1 } catch (e) { 2 }", "1"},
+		{"try { throw 5; 1 } catch (e) { e + 1 }", "6"},
+		{"try { 1 + true } catch (e) { e.message }", "type mismatch: INTEGER + BOOLEAN"},
+		{"try {\n  1 + true\n} catch (e) { e.line * 100 + e.column }", "205"},
+		{"try { 1 + true } catch (e) { e.missing }", "null"},
+		{
+			"let f = fn() { -true };\nlet g = fn() { 1 + f() };\ntry { g() } catch (e) { e.stack }",
+			"[{column: 20, function: f, line: 2}, {column: 7, function: g, line: 3}]",
+		},
+		{"let f = fn(n) { if (n == 0) { throw 42 } else { f(n - 1) } }; try { f(10) } catch (e) { e }", "42"},
+		{"let f = fn() { try { throw 3 } catch (e) { e } }; f() + 1", "4"},
+		{"try { try { throw 1 } catch (e) { throw e + 1 } } catch (e) { e * 10 }", "20"},
+		{"try { try { throw 1 } finally { let a = 2; } } catch (e) { e + a }", "3"},
+		{"try { let a = 1; throw 2; } catch (e) { let b = e; } finally { let c = 3; }; a * 100 + b * 10 + c", "123"},
+		{"try { 1 } finally { 2 }", "1"},
+		{"try { let a = 1; } catch (e) { 2 }", "null"},
+		{"let f = fn() { try { return 1; } finally { return 2; } }; f()", "2"},
+		{"let f = fn() { try { 1 + true } finally { return 5; } }; f()", "5"},
+		{"let f = fn() { try { return 1; } catch (e) { 2 }; 3 }; f()", "1"},
+		{"let f = fn() { try { return g(); } catch (e) { 0 } }; let g = fn() { throw 1 }; f()", "0"},
+		{"let f = fn() { try { throw 1 } catch (e) { return g(); } finally { 0 } }; let g = fn() { throw 2 }; try { f() } catch (e) { e }", "2"},
+		{"return try { 1 } finally { 2 }", "1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if isError(evaluated) {
+			t.Errorf("%q: unexpected error: %s", tt.input, evaluated.Inspect())
+			continue
+		}
+		if got := evaluated.Inspect(); got != tt.expected {
+			t.Errorf("%q: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestUncaughtExceptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"throw 5", "uncaught exception: 5\n\tat <main> (1:1)"},
+		{
+			"let f = fn() { throw true };\nlet g = fn() { 1 + f() };\ng()",
+			"uncaught exception: true\n\tat f (1:16)\n\tat g (2:20)\n\tat <main> (3:1)",
+		},
+		{
+			"let f = fn() { 1 + true };\ntry { f() } finally { 0 }",
+			"type mismatch: INTEGER + BOOLEAN\n\tat f (1:18)\n\tat <main> (2:7)",
+		},
+		{"try { throw 1 } catch (e) { e + true }", "type mismatch: INTEGER + BOOLEAN\n\tat <main> (1:31)"},
+		{"try { 1 } finally { -true }", "unknown operator: -BOOLEAN\n\tat <main> (1:21)"},
+		{"5.x", "no property x on INTEGER\n\tat <main> (1:2)"},
+		{"let xs = [1];\nxs[true]", "index operator not supported: ARRAY[BOOLEAN]\n\tat <main> (2:3)"},
+		{"let h = {\"a\": 1,\n  [2]: 3};", "unusable as hash key: ARRAY\n\tat <main> (1:9)"},
+	}
+
+	for _, tt := range tests {
+		errObj, ok := testEval(tt.input).(*object.Error)
+		if !ok {
+			t.Errorf("%q: no error returned", tt.input)
+			continue
+		}
+		if got := errObj.Traceback(); got != tt.expected {
+			t.Errorf("%q: wrong traceback.\nwant=%q\ngot =%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"Hello World!"`, "Hello World!"},
+		{`"Hello" + " " + "World!"`, "Hello World!"},
+		{`let greet = fn(name) { "Hello, " + name }; greet("you")`, "Hello, you"},
+		{`"a" + "b" == "ab"`, true},
+		{`"a" != "a"`, false},
+		{`"a" == 1`, false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("%q: object is not String. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("%q: String has wrong value. want=%q, got=%q", tt.input, expected, str.Value)
+			}
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestArraysAndHashes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[1, 2 * 2, 3 + 3]", "[1, 4, 6]"},
+		{"[]", "[]"},
+		{"[1, 2, 3][0]", "1"},
+		{"[1, 2, 3][1 + 1]", "3"},
+		{"let i = 0; [1][i]", "1"},
+		{"let xs = [1, 2, 3]; xs[0] + xs[1] + xs[2]", "6"},
+		{"let xs = [1, 2, 3]; let i = xs[0]; xs[i]", "2"},
+		{"[1, 2, 3][3]", "null"},
+		{"[1, 2, 3][-1]", "null"},
+		{"[[1, 2], [3]][0][1]", "2"},
+		{"let f = fn(x) { [x, x * 2] }; f(2)[1]", "4"},
+		{`{"one": 10 - 9, "two": 1 + 1, "th" + "ree": 6 / 2, 4: 4, true: 5, false: 6}`,
+			"{4: 4, false: 6, one: 1, three: 3, true: 5, two: 2}"},
+		{"{}", "{}"},
+		{`{"a": 1, "a": 2}["a"]`, "2"},
+		{`{"foo": 5}["foo"]`, "5"},
+		{`{"foo": 5}["bar"]`, "null"},
+		{`let key = "foo"; {"foo": 5}[key]`, "5"},
+		{`{}["foo"]`, "null"},
+		{`{5: 5}[5]`, "5"},
+		{`{true: 5}[true]`, "5"},
+		{`{"xs": [1, 2]}.xs[1]`, "2"},
+		{`[{"name": "a"}][0].name`, "a"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if isError(evaluated) {
+			t.Errorf("%q: unexpected error: %s", tt.input, evaluated.Inspect())
+			continue
+		}
+		if got := evaluated.Inspect(); got != tt.expected {
+			t.Errorf("%q: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestLetStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let a = 5; a;", 5},
+		{"let a = 5 * 5; a;", 25},
+		{"let a = 5; let b = a; let c = a + b + 5; c;", 15},
+		{"let a = 1; let a = a + 1; a;", 2},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestFunctionApplication(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let identity = fn(x) { x; }; identity(5);", 5},
+		{"let double = fn(x) { x * 2; }; double(5);", 10},
+		{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
+		{"fn(x) { x; }(5)", 5},
+		{"let newAdder = fn(x) { fn(y) { x + y } }; newAdder(2)(3);", 5},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(5);", 120},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	// Without tail calls, the countdown would need far more stack than this.
+	defer debug.SetMaxStack(debug.SetMaxStack(64 << 20))
+
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let countdown = fn(n) { if (n == 0) { 0 } else { countdown(n - 1) } }; countdown(1000000)", 0},
+		{"let countdown = fn(n) { if (n == 0) { return 0; }; return countdown(n - 1); }; countdown(1000000)", 0},
+		{"let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } }; sum(1000, 0)", 500500},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(10)", 3628800},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestTraceback(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + true", "type mismatch: INTEGER + BOOLEAN\n\tat <main> (1:3)"},
+		{
+			"let inner = fn(x) { x / 0 };\nlet outer = fn() {\n  1 + inner(2)\n};\nouter()",
+			"division by zero\n\tat inner (1:23)\n\tat outer (3:7)\n\tat <main> (5:1)",
+		},
+		{
+			"let f = fn() { 1 * fn() { -true }() };\nf()",
+			"unknown operator: -BOOLEAN\n\tat <anonymous> (1:27)\n\tat f (1:20)\n\tat <main> (2:1)",
+		},
+		{
+			// The call to the anonymous function replaced f's call
+			"let f = fn() { fn() { -true }() };\nf()",
+			"unknown operator: -BOOLEAN\n\tat <anonymous> (1:23)\n\tat <main> (2:1)",
+		},
+		{
+			"let g = fn() { 1 + x };\nlet f = fn() { g() };\nf()",
+			"identifier not found: x\n\tat g (1:20)\n\tat <main> (3:1)",
+		},
+		{
+			"let f = fn(a) { a };\nlet g = fn() { 1 + f() };\ng()",
+			"wrong number of arguments: want=1, got=0\n\tat g (2:20)\n\tat <main> (3:1)",
+		},
+	}
+
+	for _, tt := range tests {
+		errObj, ok := testEval(tt.input).(*object.Error)
+		if !ok {
+			t.Errorf("%q: no error returned", tt.input)
+			continue
+		}
+		if got := errObj.Traceback(); got != tt.expected {
+			t.Errorf("%q: wrong traceback.\nwant=%q\ngot =%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		config   limits.Config
+		expected error
+	}{
+		{"let f = fn() { f() }; f()", limits.Config{MaxSteps: 10000}, limits.ErrSteps},
+		{"let f = fn() { 1 + f() }; f()", limits.Config{MaxDepth: 100}, limits.ErrDepth},
+		{"let f = fn(n) { f(n + 1) }; f(0)", limits.Config{MaxMemory: 1 << 20}, limits.ErrMemory},
+		{"1 + 2", limits.Config{Context: cancelled}, context.Canceled},
+		{"let f = fn() { try { f() } catch (e) { 0 } }; f()", limits.Config{MaxDepth: 100}, limits.ErrDepth},
+		{"let f = fn() { try { f() } finally { 0 } }; f()", limits.Config{MaxSteps: 1000}, limits.ErrSteps},
+		{"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)",
+			limits.Config{MaxSteps: 1000000, MaxDepth: 20, MaxMemory: 1 << 24}, nil},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := EvalWithLimits(program, object.NewEnvironment(), tt.config)
+
+		if tt.expected == nil {
+			if isError(evaluated) {
+				t.Errorf("%q: unexpected error within limits: %s", tt.input, evaluated.Inspect())
+			}
+			continue
+		}
+
+		abort, ok := evaluated.(*object.Abort)
+		if !ok {
+			t.Errorf("%q: no abort. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if !errors.Is(abort.Err, tt.expected) {
+			t.Errorf("%q: wrong error. want=%v, got=%v", tt.input, tt.expected, abort.Err)
+		}
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	program := parser.New(lexer.New("let f = fn() { f() }; f()")).ParseProgram()
+
+	start := time.Now()
+	evaluated := EvalWithLimits(program, object.NewEnvironment(), limits.Config{Context: ctx})
+
+	abort, ok := evaluated.(*object.Abort)
+	if !ok || !errors.Is(abort.Err, context.DeadlineExceeded) {
+		t.Errorf("not stopped by its deadline. got=%T (%+v)", evaluated, evaluated)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("program stopped %s after it started, long after its deadline", elapsed)
+	}
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	if evaluated := testEval("puts()"); evaluated != NULL {
+		t.Errorf("puts did not return NULL. got=%T (%+v)", evaluated, evaluated)
+	}
+}
+
+func testEval(input string) object.Object {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	env := object.NewEnvironment()
+
+	return Eval(program, env)
+}
+
+func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
+	result, ok := obj.(*object.Integer)
+	if !ok {
+		t.Errorf("object is not Integer. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%d, want=%d", result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
+func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
+	result, ok := obj.(*object.Boolean)
+	if !ok {
+		t.Errorf("object is not Boolean. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%t, want=%t", result.Value, expected)
+		return false
+	}
+
+	return true
+}
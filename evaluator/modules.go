@@ -0,0 +1,175 @@
+package evaluator
+
+import (
+	"capuchin/ast"
+	"capuchin/lexer"
+	"capuchin/object"
+	"capuchin/parser"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SearchPathEnv names the environment variable listing the directories, separated as
+// in PATH, which the command line tools add to the search path of their Loader.
+const SearchPathEnv = "CAPUCHIN_PATH"
+
+// ModuleExt is the extension of the files holding capuchin code, which may be left
+// out of the paths given to import.
+const ModuleExt = ".cap"
+
+// Loader finds, evaluates and keeps the modules imported by the programs it
+// evaluates. The path of an import is looked up relative to the directory of the
+// importing file, then in each directory of SearchPath in turn. Each module is
+// evaluated only once, by the first program importing it, and every later import
+// of the same file gets the same module.
+type Loader struct {
+	SearchPath []string
+
+	// Globals is the environment enclosing the top level of every module, holding
+	// the values an embedder provides to them. It may be nil.
+	Globals *object.Environment
+
+	modules map[string]*object.Module // by absolute path
+	loading []string                  // the files being evaluated, outermost first
+}
+
+// NewLoader creates a Loader looking up imports in the directories of searchPath
+// once it has tried the importing file's own.
+func NewLoader(searchPath ...string) *Loader {
+	return &Loader{SearchPath: searchPath, modules: map[string]*object.Module{}}
+}
+
+// Eval evaluates program, read from file, in env like the package's Eval, loading
+// the modules it imports with l. file may be empty for a program which was not read
+// from a file, whose imports are then relative to the current directory.
+func (l *Loader) Eval(program *ast.Program, env *object.Environment, file string) object.Object {
+	if file != "" {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return newError("%s", err)
+		}
+		l.loading = append(l.loading, abs)
+		defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+	}
+	return (&interpreter{loader: l, file: file}).eval(program, env)
+}
+
+// importModule loads the module imported by node for the file in is evaluating.
+func (in *interpreter) importModule(node *ast.ImportStatement) object.Object {
+	if in.loader == nil {
+		return newError("cannot import %q: no module loader", node.Path.Value)
+	}
+	return in.loader.load(in, node)
+}
+
+func (l *Loader) load(in *interpreter, node *ast.ImportStatement) object.Object {
+	path, err := l.resolve(node.Path.Value, in.file)
+	if err != nil {
+		return err
+	}
+	abs, absErr := filepath.Abs(path)
+	if absErr != nil {
+		return newError("%s", absErr)
+	}
+
+	if module, ok := l.modules[abs]; ok {
+		return module
+	}
+	for i, loading := range l.loading {
+		if loading == abs {
+			return newError("import cycle: %s", l.cycle(l.loading[i:], abs))
+		}
+	}
+
+	src, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return newError("%s", readErr)
+	}
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return newError("%s: %s", path, errs[0])
+	}
+
+	l.loading = append(l.loading, abs)
+	defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+
+	env := object.NewEnvironment()
+	if l.Globals != nil {
+		env = object.NewEnclosedEnvironment(l.Globals)
+	}
+
+	// The module shares the limits and the call depth of the program importing it
+	module := &interpreter{meter: in.meter, depth: in.depth, loader: l, file: path}
+	switch result := module.eval(program, env).(type) {
+	case *object.Error:
+		result.Stack = append(result.Stack, object.StackFrame{
+			Function: "<module " + path + ">",
+			Pos:      node.Token.Pos,
+		})
+		return result
+	case *object.Abort:
+		return result
+	}
+
+	exports := map[string]object.Object{}
+	for _, stmt := range program.Statements {
+		if export, ok := stmt.(*ast.ExportStatement); ok {
+			name := export.Statement.Name.Value
+			exports[name], _ = env.Get(name)
+		}
+	}
+
+	l.modules[abs] = &object.Module{Path: path, Exports: exports}
+	return l.modules[abs]
+}
+
+// resolve returns the file imported as path by the file from.
+func (l *Loader) resolve(path, from string) (string, *object.Error) {
+	if filepath.Ext(path) != ModuleExt {
+		path += ModuleExt
+	}
+	if filepath.IsAbs(path) {
+		if isFile(path) {
+			return path, nil
+		}
+		return "", newError("cannot find module %q", path)
+	}
+
+	dirs := append([]string{filepath.Dir(from)}, l.SearchPath...)
+	for _, dir := range dirs {
+		if candidate := filepath.Join(dir, path); isFile(candidate) {
+			return candidate, nil
+		}
+	}
+	return "", newError("cannot find module %q in %s", path, strings.Join(dirs, ", "))
+}
+
+// cycle describes the imports from the first of files, each imported by the one
+// before, back to the file at abs.
+func (l *Loader) cycle(files []string, abs string) string {
+	names := []string{}
+	for _, file := range files {
+		names = append(names, relative(file))
+	}
+	return strings.Join(append(names, relative(abs)), " -> ")
+}
+
+// relative returns the absolute path abs relative to the current directory if it is
+// below it.
+func relative(abs string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return abs
+	}
+	if rel, err := filepath.Rel(wd, abs); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return abs
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,150 @@
+package evaluator
+
+import (
+	"capuchin/lexer"
+	"capuchin/object"
+	"capuchin/parser"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImports(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"lib/math.cap": `
+			let twice = fn(x) { x * 2 };
+			export let add = fn(a, b) { a + b };
+			export let four = twice(2);`,
+		"lib/counted.cap": `count(); export let one = 1;`,
+		"lib/a.cap":       `import "counted" as c; export let one = c.one;`,
+		"lib/b.cap":       `import "counted.cap" as c; export let two = c.one + 1;`,
+		"vendor/extra.cap": `
+			import "math" as m;
+			export let six = m.add(m.four, 2);`,
+	})
+
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`import "lib/math" as m; m.add(1, 2)`, 3},
+		{`import "lib/math.cap" as math; math.four`, 4},
+		// Modules in the search path are found from any file
+		{`import "extra" as e; e.six`, 6},
+		{`import "lib/a" as a; import "lib/b" as b; a.one + b.two`, 3},
+		{`let f = fn() { import "lib/math" as m; m.add }; f()(2, 5)`, 7},
+	}
+
+	for _, tt := range tests {
+		count := 0
+		loader := NewLoader(filepath.Join(dir, "lib"), filepath.Join(dir, "vendor"))
+		loader.Globals = object.NewEnvironment()
+		loader.Globals.Set("count", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+			count++
+			return nil
+		}})
+
+		evaluated := testLoad(t, loader, dir, tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+
+		// A module imported twice is evaluated once
+		if count > 1 {
+			t.Errorf("%q: module evaluated %d times", tt.input, count)
+		}
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"a.cap":      `import "b" as b; export let x = 1;`,
+		"b.cap":      `import "a" as a; export let y = 2;`,
+		"self.cap":   `import "self" as s;`,
+		"broken.cap": `let = 1;`,
+		"fails.cap":  "export let x = 1;\nlet f = fn() { 1 + true };\nf();",
+		"math.cap":   `export let add = fn(a, b) { a + b };`,
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`import "a" as a;`,
+			"import cycle: " + strings.Join([]string{
+				filepath.Join(dir, "a.cap"), filepath.Join(dir, "b.cap"), filepath.Join(dir, "a.cap"),
+			}, " -> "),
+		},
+		{
+			`import "self" as s;`,
+			"import cycle: " + filepath.Join(dir, "self.cap") + " -> " + filepath.Join(dir, "self.cap"),
+		},
+		{
+			`import "missing" as m;`,
+			`cannot find module "missing.cap" in ` + dir,
+		},
+		{
+			`import "broken" as b;`,
+			filepath.Join(dir, "broken.cap") + ": 1:5: expected next token to be IDENT, but got = instead.",
+		},
+		{
+			`import "math" as m; m.sub(2, 1)`,
+			"module " + filepath.Join(dir, "math.cap") + " does not export sub",
+		},
+		{
+			`let m = 1;` + "\n" + `import "fails" as f;`,
+			"type mismatch: INTEGER + BOOLEAN\n\tat f (2:18)\n\tat <module " +
+				filepath.Join(dir, "fails.cap") + "> (3:1)\n\tat <main> (2:1)",
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := testLoad(t, NewLoader(), dir, tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%q: no error returned. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if got := errObj.Traceback(); !strings.HasPrefix(got, tt.expected) {
+			t.Errorf("%q: wrong error.\nwant=%q\ngot =%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestImportWithoutLoader(t *testing.T) {
+	errObj, ok := testEval(`import "math" as m;`).(*object.Error)
+	if !ok {
+		t.Fatalf("no error returned")
+	}
+	expected := `cannot import "math": no module loader`
+	if errObj.Message != expected {
+		t.Errorf("wrong error. want=%q, got=%q", expected, errObj.Message)
+	}
+}
+
+// writeModules writes the files of modules, by their paths relative to a temporary
+// directory, and returns the directory.
+func writeModules(t *testing.T, modules map[string]string) string {
+	dir := t.TempDir()
+	for name, src := range modules {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// testLoad evaluates input with loader as if it were the file main.cap in dir.
+func testLoad(t *testing.T, loader *Loader, dir, input string) object.Object {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("%q: parser errors: %v", input, p.Errors())
+	}
+	return loader.Eval(program, object.NewEnvironment(), filepath.Join(dir, "main.cap"))
+}
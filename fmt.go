@@ -0,0 +1,67 @@
+package main
+
+import (
+	"capuchin/format"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// fmtCommand prints each of the named scripts in the standard layout, or with -w
+// rewrites the scripts in place. A shebang line is kept as it is. It exits with 2 if
+// a script could not be read, parsed or written.
+func fmtCommand(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	write := flags.Bool("w", false, "rewrite the scripts instead of printing them")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: capuchin fmt [-w] script.cap...")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		src, err := readSource(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+		program, err := parseSource(path, src)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+
+		formatted := shebang(src) + format.Program(program)
+		if !*write || path == "-" {
+			fmt.Print(formatted)
+			continue
+		}
+		if err := os.WriteFile(path, []byte(formatted), 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+		}
+	}
+
+	return status
+}
+
+// shebang returns the shebang line src starts with, with its newline, or "" if it has
+// none.
+func shebang(src string) string {
+	if !strings.HasPrefix(src, "#!") {
+		return ""
+	}
+	line, _, _ := strings.Cut(src, "\n")
+	return line + "\n"
+}
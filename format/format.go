@@ -0,0 +1,308 @@
+// Package format prints an abstract syntax tree as canonical capuchin source code.
+//
+// Unlike the String methods of the ast package, which fully parenthesise expressions
+// for debugging, the output of this package is valid source that parses back to the
+// same tree. Parentheses are only added where operator precedence requires them.
+package format
+
+import (
+	"bytes"
+	"capuchin/ast"
+	"capuchin/lexer"
+	"capuchin/parser"
+	"capuchin/token"
+	"strconv"
+	"strings"
+)
+
+// indent is the string used for each level of block nesting.
+const indent = "\t"
+
+// Program returns the formatted source of the supplied program, with one statement
+// per line and a trailing newline.
+func Program(program *ast.Program) string {
+	p := &printer{}
+	p.statements(program.Statements)
+	return p.out.String()
+}
+
+// Node returns the formatted source of any node. Statements are printed without a
+// trailing newline.
+func Node(node ast.Node) string {
+	p := &printer{}
+
+	switch n := node.(type) {
+	case *ast.Program:
+		p.statements(n.Statements)
+	case ast.Statement:
+		p.statement(n)
+	case ast.Expression:
+		p.expression(n, parser.LOWEST)
+	case ast.TypeExpression:
+		p.write(n.String())
+	}
+
+	return p.out.String()
+}
+
+// printer accumulates formatted source, keeping track of the nesting depth.
+type printer struct {
+	out   bytes.Buffer
+	depth int
+}
+
+func (p *printer) write(s string) {
+	p.out.WriteString(s)
+}
+
+func (p *printer) newline() {
+	p.write("\n")
+	p.write(strings.Repeat(indent, p.depth))
+}
+
+// statements prints each statement on its own line.
+func (p *printer) statements(stmts []ast.Statement) {
+	for i, s := range stmts {
+		p.statement(s)
+		p.separate(stmts, i)
+		p.write("\n")
+	}
+}
+
+// separate ends the if or try expression statement stmts[i] with a semicolon when
+// the statement after it starts with a token which would otherwise continue it, as
+// the "-" of "-a", the "(" of "(a)" or the "[" of "[a]" would subtract from, call or
+// index the expression.
+func (p *printer) separate(stmts []ast.Statement, i int) {
+	if !endsWithBlock(stmts[i]) || i+1 == len(stmts) {
+		return
+	}
+	if strings.IndexAny(Node(stmts[i+1]), "-([") == 0 {
+		p.write(";")
+	}
+}
+
+// endsWithBlock reports whether stmt is an if or try expression statement, which
+// ends with a block and reads better without a semicolon.
+func endsWithBlock(stmt ast.Statement) bool {
+	s, ok := stmt.(*ast.ExpressionStatement)
+	if !ok {
+		return false
+	}
+	switch s.Expression.(type) {
+	case *ast.IfExpression, *ast.TryExpression:
+		return true
+	}
+	return false
+}
+
+func (p *printer) statement(stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		p.write("let ")
+		p.write(s.Name.Value)
+		if s.Type != nil {
+			p.write(": ")
+			p.write(s.Type.String())
+		}
+		p.write(" = ")
+		p.expression(s.Value, parser.LOWEST)
+		p.write(";")
+
+	case *ast.ImportStatement:
+		p.write("import ")
+		p.write(s.Path.String())
+		p.write(" as ")
+		p.write(s.Name.Value)
+		p.write(";")
+
+	case *ast.ExportStatement:
+		p.write("export ")
+		p.statement(s.Statement)
+
+	case *ast.ReturnStatement:
+		p.write("return")
+		if s.ReturnValue != nil {
+			p.write(" ")
+			p.expression(s.ReturnValue, parser.LOWEST)
+		}
+		p.write(";")
+
+	case *ast.ThrowStatement:
+		p.write("throw ")
+		p.expression(s.Value, parser.LOWEST)
+		p.write(";")
+
+	case *ast.ExpressionStatement:
+		p.expression(s.Expression, parser.LOWEST)
+		if !endsWithBlock(s) {
+			p.write(";")
+		}
+
+	case *ast.BlockStatement:
+		p.block(s)
+	}
+}
+
+// block prints the braces and indented statements of a block.
+func (p *printer) block(block *ast.BlockStatement) {
+	if block == nil || len(block.Statements) == 0 {
+		p.write("{}")
+		return
+	}
+
+	p.write("{")
+	p.depth++
+	for i, s := range block.Statements {
+		p.newline()
+		p.statement(s)
+		p.separate(block.Statements, i)
+	}
+	p.depth--
+	p.newline()
+	p.write("}")
+}
+
+// expression prints exp, wrapping it in parentheses if it binds more loosely than the
+// precedence required by its context.
+func (p *printer) expression(exp ast.Expression, context int) {
+	if precedence(exp) < context {
+		p.write("(")
+		p.expression(exp, parser.LOWEST)
+		p.write(")")
+		return
+	}
+
+	switch e := exp.(type) {
+	case *ast.Identifier:
+		p.write(e.Value)
+
+	case *ast.IntegerLiteral:
+		p.write(strconv.FormatInt(e.Value, 10))
+
+	case *ast.StringLiteral:
+		p.write(lexer.Quote(e.Value))
+
+	case *ast.Boolean:
+		if e.Value {
+			p.write("true")
+		} else {
+			p.write("false")
+		}
+
+	case *ast.PrefixExpression:
+		p.write(e.Operator)
+		p.expression(e.Right, parser.PREFIX)
+
+	case *ast.InfixExpression:
+		// Operators are left associative, so a right operand of the same
+		// precedence needs parentheses to keep its grouping.
+		prec := precedence(e)
+		p.expression(e.Left, prec)
+		p.write(" " + e.Operator + " ")
+		p.expression(e.Right, prec+1)
+
+	case *ast.IfExpression:
+		p.write("if (")
+		p.expression(e.Condition, parser.LOWEST)
+		p.write(") ")
+		p.block(e.Consequence)
+		if e.Alternative != nil {
+			p.write(" else ")
+			p.block(e.Alternative)
+		}
+
+	case *ast.TryExpression:
+		p.write("try ")
+		p.block(e.Body)
+		if e.Catch != nil {
+			p.write(" catch (" + e.Parameter.Value + ") ")
+			p.block(e.Catch)
+		}
+		if e.Finally != nil {
+			p.write(" finally ")
+			p.block(e.Finally)
+		}
+
+	case *ast.MemberExpression:
+		p.expression(e.Object, parser.CALL)
+		p.write("." + e.Property.Value)
+
+	case *ast.IndexExpression:
+		p.expression(e.Left, parser.CALL)
+		p.write("[")
+		p.expression(e.Index, parser.LOWEST)
+		p.write("]")
+
+	case *ast.ArrayLiteral:
+		p.write("[")
+		p.list(e.Elements)
+		p.write("]")
+
+	case *ast.HashLiteral:
+		p.write("{")
+		for i, key := range e.Keys {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expression(key, parser.LOWEST)
+			p.write(": ")
+			p.expression(e.Values[i], parser.LOWEST)
+		}
+		p.write("}")
+
+	case *ast.FunctionLiteral:
+		p.write("fn(")
+		for i, param := range e.Parameters {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.write(param.Value)
+			if t := e.ParameterType(i); t != nil {
+				p.write(": ")
+				p.write(t.String())
+			}
+		}
+		p.write(") ")
+		if e.ReturnType != nil {
+			p.write("-> ")
+			p.write(e.ReturnType.String())
+			p.write(" ")
+		}
+		p.block(e.Body)
+
+	case *ast.CallExpression:
+		p.expression(e.Function, parser.CALL)
+		p.write("(")
+		p.list(e.Arguments)
+		p.write(")")
+	}
+}
+
+// list prints exps separated by commas.
+func (p *printer) list(exps []ast.Expression) {
+	for i, exp := range exps {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expression(exp, parser.LOWEST)
+	}
+}
+
+// precedence returns how tightly exp binds when it is printed without parentheses.
+func precedence(exp ast.Expression) int {
+	switch e := exp.(type) {
+	case *ast.InfixExpression:
+		op, _ := token.LookupOperator(e.Operator)
+		return parser.Precedence(op)
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.IntegerLiteral:
+		// Negative values only come from hand built trees and print with a
+		// leading minus, so they group like a prefix expression.
+		if e.Value < 0 {
+			return parser.PREFIX
+		}
+	}
+	return parser.CALL + 1
+}
@@ -0,0 +1,93 @@
+package format
+
+import (
+	"capuchin/ast"
+	"capuchin/parsertest"
+	"testing"
+)
+
+func TestProgram(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=5", "let x = 5;\n"},
+		{"return (x);", "return x;\n"},
+		{"-a * b", "-a * b;\n"},
+		{"-(a * b)", "-(a * b);\n"},
+		{"a + b + c", "a + b + c;\n"},
+		{"a + (b + c)", "a + (b + c);\n"},
+		{"(a + b) * c", "(a + b) * c;\n"},
+		{"3 + 4 * 5 == 3 * 1 + 4 * 5", "3 + 4 * 5 == 3 * 1 + 4 * 5;\n"},
+		{"(5 > 4) == (3 < 4)", "5 > 4 == 3 < 4;\n"},
+		{"add(a, b, 1, 2 * 3, (4 + 5))", "add(a, b, 1, 2 * 3, 4 + 5);\n"},
+		{"if (x < y) { x } else { y }", "if (x < y) {\n\tx;\n} else {\n\ty;\n}\n"},
+		{"fn() {}", "fn() {};\n"},
+		// A statement which would continue an if or try before it keeps them apart
+		{"if (x) { 1 };\n-a;", "if (x) {\n\t1;\n};\n-a;\n"},
+		{"try { a } catch (e) { b }; (c + d) * 2", "try {\n\ta;\n} catch (e) {\n\tb;\n};\n(c + d) * 2;\n"},
+		{"fn() { if (x) { 1 }; -a }", "fn() {\n\tif (x) {\n\t\t1;\n\t};\n\t-a;\n};\n"},
+		{"if (x) { 1 }; [a][0]", "if (x) {\n\t1;\n};\n[a][0];\n"},
+		{"if (x) { 1 }; !a; if (y) { 2 }", "if (x) {\n\t1;\n}\n!a;\nif (y) {\n\t2;\n}\n"},
+		{
+			"let f = fn(x) { if (x) { return fn(y) { y } } };",
+			"let f = fn(x) {\n\tif (x) {\n\t\treturn fn(y) {\n\t\t\ty;\n\t\t};\n\t}\n};\n",
+		},
+		{"fn(x) { x }(5)", "fn(x) {\n\tx;\n}(5);\n"},
+		{"let x:int=5", "let x: int = 5;\n"},
+		{
+			"let f = fn(a:int, b, c : [ {string:int} ])->fn(int)->bool { a }",
+			"let f = fn(a: int, b, c: [{string: int}]) -> fn(int) -> bool {\n\ta;\n};\n",
+		},
+		{
+			"try{ throw(1) }catch(e){e.message}finally{}",
+			"try {\n\tthrow 1;\n} catch (e) {\n\te.message;\n} finally {}\n",
+		},
+		{"let x = try { a } finally { b };", "let x = try {\n\ta;\n} finally {\n\tb;\n};\n"},
+		{"(f(x).y).z + -(e.line)", "f(x).y.z + -e.line;\n"},
+		{`puts("say \"hi\"\n" + ("x" + "y"))`, "puts(\"say \\\"hi\\\"\\n\" + (\"x\" + \"y\"));\n"},
+		{"[ 1,(2+3) ,[]][0]", "[1, 2 + 3, []][0];\n"},
+		{`let h={"a" :1,2:[x]}`, "let h = {\"a\": 1, 2: [x]};\n"},
+		{"{}", "{};\n"},
+		{"(-xs)[0] + (a + b)[i * 2] + f(x)[1].y", "(-xs)[0] + (a + b)[i * 2] + f(x)[1].y;\n"},
+		{
+			`import  "lib/math"as m export let two=m.add(1,1)`,
+			"import \"lib/math\" as m;\nexport let two = m.add(1, 1);\n",
+		},
+	}
+
+	for _, tt := range tests {
+		program := parsertest.Parse(t, tt.input)
+
+		actual := Program(program)
+		if actual != tt.expected {
+			t.Errorf("format wrong for %q. want=%q, got=%q", tt.input, tt.expected, actual)
+			continue
+		}
+
+		// Formatting must not change the meaning of the program. Tokens are
+		// ignored as dropping parentheses changes the first token of a statement.
+		reparsed := parsertest.Parse(t, actual)
+		opts := ast.CompareOptions{IgnoreTokens: true}
+		for _, d := range ast.Diff(program, reparsed, opts) {
+			t.Errorf("formatting %q changed the tree at %s", tt.input, d)
+		}
+
+		// And formatting formatted source is a no-op
+		if again := Program(reparsed); again != actual {
+			t.Errorf("format not idempotent. want=%q, got=%q", actual, again)
+		}
+	}
+}
+
+func TestNode(t *testing.T) {
+	program := parsertest.Parse(t, "let x = (1 + 2) * 3;")
+	stmt := program.Statements[0].(*ast.LetStatement)
+
+	if got := Node(stmt); got != "let x = (1 + 2) * 3;" {
+		t.Errorf("Node(stmt) wrong. got=%q", got)
+	}
+	if got := Node(stmt.Value); got != "(1 + 2) * 3" {
+		t.Errorf("Node(expression) wrong. got=%q", got)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"capuchin/highlight"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// highlightCommand writes each of the named scripts, or the standard input if none
+// are named, with its syntax coloured for a terminal or as HTML. It exits with 2 if
+// a script could not be read.
+func highlightCommand(args []string) int {
+	flags := flag.NewFlagSet("highlight", flag.ContinueOnError)
+	asHTML := flags.Bool("html", false, "write HTML instead of ANSI escape sequences")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: capuchin highlight [-html] [script.cap...]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	write := highlight.ANSI
+	if *asHTML {
+		write = highlight.HTML
+	}
+
+	if flags.NArg() == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		write(os.Stdout, string(src), highlight.DefaultTheme)
+		return 0
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+		write(os.Stdout, string(src), highlight.DefaultTheme)
+	}
+
+	return status
+}
@@ -0,0 +1,253 @@
+// Package highlight colours capuchin source code for display, using the lexer to
+// classify its text.
+//
+// The source is split into spans, each the text of a token or of the space between
+// tokens. A Theme gives the style of each type of token, and ANSI and HTML write the
+// source in those styles for a terminal or a web page. Text the lexer skips which is
+// not white space, such as the shebang line of a script, is given the type COMMENT,
+// and characters the lexer does not recognise are ILLEGAL tokens, which the default
+// theme marks as errors. The source is always written out exactly as it was, only
+// with styles added.
+package highlight
+
+import (
+	"capuchin/lexer"
+	"capuchin/token"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The types of the text between tokens.
+const (
+	WHITESPACE token.TokenType = "WHITESPACE"
+	COMMENT    token.TokenType = "COMMENT"
+)
+
+// Span is a piece of source text with the type of token it is.
+type Span struct {
+	Type token.TokenType
+	Text string
+}
+
+// Spans splits source into spans covering all of it, in order. Adjacent spans of the
+// same type are merged.
+func Spans(source string) []Span {
+	// The offset of the start of each line, to find tokens by their position
+	starts := []int{0}
+	for i := 0; i < len(source); i++ {
+		if source[i] == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+
+	s := &splitter{source: source}
+	l := lexer.New(source)
+	for {
+		tok := l.NextToken()
+		start := min(starts[tok.Pos.Line-1]+tok.Pos.Column-1, len(source))
+		s.gap(start)
+		if tok.Type == token.EOF {
+			break
+		}
+
+		// An illegal character is a single byte, though its literal may be
+		// longer when the byte is part of a multibyte character.
+		end := start + len(tok.Literal)
+		if tok.Type == token.ILLEGAL && !strings.HasPrefix(tok.Literal, `"`) {
+			end = start + 1
+		}
+		s.add(tok.Type, end)
+	}
+	s.gap(len(source))
+
+	return s.spans
+}
+
+// splitter builds the spans of source from the start up to offset.
+type splitter struct {
+	source string
+	offset int
+	spans  []Span
+}
+
+// add adds the span of type t from the offset up to end.
+func (s *splitter) add(t token.TokenType, end int) {
+	if end <= s.offset {
+		return
+	}
+
+	text := s.source[s.offset:end]
+	s.offset = end
+	if n := len(s.spans); n > 0 && s.spans[n-1].Type == t {
+		s.spans[n-1].Text += text
+		return
+	}
+	s.spans = append(s.spans, Span{Type: t, Text: text})
+}
+
+// gap adds the spans of the text the lexer skipped, up to end. On each line, the
+// text from the first to the last character which is not white space is a comment.
+func (s *splitter) gap(end int) {
+	for s.offset < end {
+		i := s.offset
+		for i < end && isSpace(s.source[i]) {
+			i++
+		}
+		s.add(WHITESPACE, i)
+
+		j := i
+		for j < end && s.source[j] != '\n' {
+			j++
+		}
+		for j > i && isSpace(s.source[j-1]) {
+			j--
+		}
+		s.add(COMMENT, j)
+	}
+}
+
+// isSpace reports whether ch is white space to the lexer.
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+}
+
+// Style is the way text is shown. Colours are written as "#rrggbb", and an empty
+// colour leaves the text's colour as it is.
+type Style struct {
+	Color      string
+	Background string
+	Bold       bool
+	Italic     bool
+	Underline  bool
+}
+
+// Theme gives the style of each type of token. Types it has no style for are
+// written plainly.
+type Theme map[token.TokenType]Style
+
+// DefaultTheme is a theme for dark backgrounds.
+var DefaultTheme = defaultTheme()
+
+func defaultTheme() Theme {
+	keyword := Style{Color: "#c678dd", Bold: true}
+	constant := Style{Color: "#d19a66"}
+	text := Style{Color: "#98c379"}
+	operator := Style{Color: "#56b6c2"}
+
+	theme := Theme{
+		token.INT:     constant,
+		token.STRING:  text,
+		token.TRUE:    constant,
+		token.FALSE:   constant,
+		token.ILLEGAL: {Color: "#ffffff", Background: "#e06c75", Underline: true},
+		COMMENT:       {Color: "#7f848e", Italic: true},
+	}
+	for _, word := range token.Keywords() {
+		if t := token.LookupIdent(word); theme[t] == (Style{}) {
+			theme[t] = keyword
+		}
+	}
+	for _, t := range []token.TokenType{
+		token.ASSIGN, token.PLUS, token.MINUS, token.BANG, token.ASTERISK, token.SLASH,
+		token.LT, token.GT, token.EQ, token.NOT_EQ, token.ARROW, token.DOT, token.COLON,
+	} {
+		theme[t] = operator
+	}
+	return theme
+}
+
+// ANSI writes source to w with the styles of theme as ANSI escape sequences, for a
+// terminal.
+func ANSI(w io.Writer, source string, theme Theme) error {
+	var out strings.Builder
+	for _, span := range Spans(source) {
+		codes := theme[span.Type].ansi()
+		if codes == "" {
+			out.WriteString(span.Text)
+			continue
+		}
+		out.WriteString("\x1b[" + codes + "m" + span.Text + "\x1b[0m")
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// HTML writes source to w as a pre element, with the tokens which have a style in
+// theme wrapped in styled span elements.
+func HTML(w io.Writer, source string, theme Theme) error {
+	var out strings.Builder
+	out.WriteString(`<pre class="capuchin">`)
+	for _, span := range Spans(source) {
+		text := html.EscapeString(span.Text)
+		css := theme[span.Type].css()
+		if css == "" {
+			out.WriteString(text)
+			continue
+		}
+		out.WriteString(`<span style="` + css + `">` + text + `</span>`)
+	}
+	out.WriteString("</pre>\n")
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// ansi returns the parameters of the escape sequence setting s, or "" if s is the
+// plain style.
+func (s Style) ansi() string {
+	codes := []string{}
+	if s.Bold {
+		codes = append(codes, "1")
+	}
+	if s.Italic {
+		codes = append(codes, "3")
+	}
+	if s.Underline {
+		codes = append(codes, "4")
+	}
+	if r, g, b, ok := rgb(s.Color); ok {
+		codes = append(codes, fmt.Sprintf("38;2;%d;%d;%d", r, g, b))
+	}
+	if r, g, b, ok := rgb(s.Background); ok {
+		codes = append(codes, fmt.Sprintf("48;2;%d;%d;%d", r, g, b))
+	}
+	return strings.Join(codes, ";")
+}
+
+// css returns the declarations of s as a CSS style, or "" if s is the plain style.
+func (s Style) css() string {
+	decls := []string{}
+	if _, _, _, ok := rgb(s.Color); ok {
+		decls = append(decls, "color:"+s.Color)
+	}
+	if _, _, _, ok := rgb(s.Background); ok {
+		decls = append(decls, "background-color:"+s.Background)
+	}
+	if s.Bold {
+		decls = append(decls, "font-weight:bold")
+	}
+	if s.Italic {
+		decls = append(decls, "font-style:italic")
+	}
+	if s.Underline {
+		decls = append(decls, "text-decoration:underline")
+	}
+	return strings.Join(decls, ";")
+}
+
+// rgb returns the components of a colour written as "#rrggbb". It reports false if
+// color is empty or not written that way.
+func rgb(color string) (r, g, b uint8, ok bool) {
+	if len(color) != 7 || color[0] != '#' {
+		return 0, 0, 0, false
+	}
+	n, err := strconv.ParseUint(color[1:], 16, 32)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	return uint8(n >> 16), uint8(n >> 8), uint8(n), true
+}
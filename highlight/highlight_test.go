@@ -0,0 +1,124 @@
+package highlight
+
+import (
+	"bytes"
+	"capuchin/token"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSpans(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []Span
+	}{
+		{"let x = 5;", []Span{
+			{token.LET, "let"}, {WHITESPACE, " "}, {token.IDENT, "x"}, {WHITESPACE, " "},
+			{token.ASSIGN, "="}, {WHITESPACE, " "}, {token.INT, "5"}, {token.SEMICOLON, ";"},
+		}},
+		{"\n\tf(a != b)\n", []Span{
+			{WHITESPACE, "\n\t"}, {token.IDENT, "f"}, {token.LPAREN, "("}, {token.IDENT, "a"},
+			{WHITESPACE, " "}, {token.NOT_EQ, "!="}, {WHITESPACE, " "}, {token.IDENT, "b"},
+			{token.RPAREN, ")"}, {WHITESPACE, "\n"},
+		}},
+		// Brackets of the same type are one span
+		{"f(g())", []Span{
+			{token.IDENT, "f"}, {token.LPAREN, "("}, {token.IDENT, "g"}, {token.LPAREN, "("},
+			{token.RPAREN, "))"},
+		}},
+		// Illegal characters keep their bytes, however many there are
+		{"a @é$ b", []Span{
+			{token.IDENT, "a"}, {WHITESPACE, " "}, {token.ILLEGAL, "@é$"}, {WHITESPACE, " "},
+			{token.IDENT, "b"},
+		}},
+		// The shebang line is skipped by the lexer, so it is a comment
+		{"#! /usr/bin/env capuchin  \nx", []Span{
+			{COMMENT, "#! /usr/bin/env capuchin"}, {WHITESPACE, "  \n"}, {token.IDENT, "x"},
+		}},
+		// An unterminated string is illegal up to the end of the line
+		{"\"a\\\"b\" \"c é\n", []Span{
+			{token.STRING, "\"a\\\"b\""}, {WHITESPACE, " "}, {token.ILLEGAL, "\"c é"}, {WHITESPACE, "\n"},
+		}},
+		{"", nil},
+		{"  ", []Span{{WHITESPACE, "  "}}},
+	}
+
+	for _, tt := range tests {
+		spans := Spans(tt.input)
+		if !reflect.DeepEqual(spans, tt.expected) {
+			t.Errorf("wrong spans for %q.\nwant=%q\ngot =%q", tt.input, tt.expected, spans)
+		}
+	}
+}
+
+func TestSpansCoverSource(t *testing.T) {
+	inputs := []string{
+		"let add = fn(x: int, y) -> int { x + y };\r\nadd(1, 2)",
+		"try { throw e.x; } catch (e) {}\n\n",
+		"€ £ ¥ ~ ` \" ' |",
+		"#!/usr/bin/env capuchin\r\nputs(1)",
+	}
+
+	for _, input := range inputs {
+		var out strings.Builder
+		for _, span := range Spans(input) {
+			out.WriteString(span.Text)
+		}
+		if out.String() != input {
+			t.Errorf("spans do not cover the source. want=%q, got=%q", input, out.String())
+		}
+	}
+}
+
+func TestANSI(t *testing.T) {
+	theme := Theme{
+		token.LET:     {Bold: true},
+		token.INT:     {Color: "#ff8000"},
+		token.ILLEGAL: {Background: "#ff0000", Underline: true},
+	}
+
+	var out bytes.Buffer
+	if err := ANSI(&out, "let x = 10 @", theme); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "\x1b[1mlet\x1b[0m x = \x1b[38;2;255;128;0m10\x1b[0m \x1b[4;48;2;255;0;0m@\x1b[0m"
+	if out.String() != expected {
+		t.Errorf("wrong output. want=%q, got=%q", expected, out.String())
+	}
+}
+
+func TestHTML(t *testing.T) {
+	theme := Theme{
+		token.IF: {Color: "#0000ff", Bold: true},
+		token.LT: {Italic: true},
+	}
+
+	var out bytes.Buffer
+	if err := HTML(&out, "if (a < b) { \"&\" }", theme); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<pre class="capuchin"><span style="color:#0000ff;font-weight:bold">if</span> (a ` +
+		`<span style="font-style:italic">&lt;</span> b) { &#34;&amp;&#34; }</pre>` + "\n"
+	if out.String() != expected {
+		t.Errorf("wrong output.\nwant=%q\ngot =%q", expected, out.String())
+	}
+}
+
+func TestDefaultTheme(t *testing.T) {
+	for _, word := range token.Keywords() {
+		if _, ok := DefaultTheme[token.LookupIdent(word)]; !ok {
+			t.Errorf("keyword %s has no style", word)
+		}
+	}
+	for _, tt := range []token.TokenType{token.ILLEGAL, COMMENT, token.INT, token.PLUS} {
+		if _, ok := DefaultTheme[tt]; !ok {
+			t.Errorf("%s has no style", tt)
+		}
+	}
+	if _, ok := DefaultTheme[token.IDENT]; ok {
+		t.Errorf("identifiers are styled")
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"capuchin/lexer"
+	"capuchin/token"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// lexCommand prints the tokens of each of the named scripts, one per line with its
+// position, type and literal. It exits with 1 if a script has illegal characters and
+// 2 if one could not be read.
+func lexCommand(args []string) int {
+	flags := flag.NewFlagSet("lex", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: capuchin lex script.cap...")
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		src, err := readSource(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+
+		l := lexer.New(src)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			fmt.Printf("%s:%s\t%s\t%q\n", path, tok.Pos, tok.Type, tok.Literal)
+			if tok.Type == token.ILLEGAL && status == 0 {
+				status = 1
+			}
+		}
+	}
+
+	return status
+}
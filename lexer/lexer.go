@@ -19,12 +19,24 @@ type Lexer struct {
 
 	// ch holds the current char being processed
 	ch byte
+
+	// line and column hold the source position of the current char.
+	line   int
+	column int
 }
 
-// New creates an Lexer instance using the supplied input string.
+// New creates an Lexer instance using the supplied input string. A shebang line at
+// the start of the input, such as "#!/usr/bin/env capuchin", is skipped, so that
+// scripts can be run directly; the tokens after it keep their line numbers.
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar() // Set the initial values within the Lexer
+
+	if l.ch == '#' && l.peekChar() == '!' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+	}
 	return l
 }
 
@@ -33,6 +45,14 @@ func New(input string) *Lexer {
 // reached then the ch variable will be set to 0 (zero).
 func (l *Lexer) readChar() {
 
+	// Track the position of the char we are about to move on to
+	if l.ch == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -52,6 +72,9 @@ func (l *Lexer) NextToken() token.Token {
 	// Advance the lexer past any whitespace characters
 	l.skipWhitespace()
 
+	// Every token is stamped with the position of its first char
+	pos := token.Position{Line: l.line, Column: l.column}
+
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -77,7 +100,22 @@ func (l *Lexer) NextToken() token.Token {
 	case '+':
 		tok = newToken(token.PLUS, l.ch)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '>' {
+			ch := l.ch
+			l.readChar()
+			literal := string(ch) + string(l.ch)
+			tok = token.Token{Type: token.ARROW, Literal: literal}
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
+	case ':':
+		tok = newToken(token.COLON, l.ch)
+	case '.':
+		tok = newToken(token.DOT, l.ch)
+	case '[':
+		tok = newToken(token.LBRACKET, l.ch)
+	case ']':
+		tok = newToken(token.RBRACKET, l.ch)
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -93,6 +131,14 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.LT, l.ch)
 	case '>':
 		tok = newToken(token.GT, l.ch)
+	case '"':
+		tok.Type, tok.Literal = l.readString()
+		if tok.Type == token.ILLEGAL {
+			// The string ends at the end of its line, which is left for the
+			// next token
+			tok.Pos = pos
+			return tok
+		}
 	case '{':
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
@@ -106,10 +152,12 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Pos = pos
 			return tok
 		} else if isDigit(l.ch) {
 			tok.Type = token.INT
 			tok.Literal = l.readNumber()
+			tok.Pos = pos
 			return tok
 		} else {
 			// If it is not a switch or letter then it is not valid
@@ -117,6 +165,8 @@ func (l *Lexer) NextToken() token.Token {
 		}
 	}
 
+	tok.Pos = pos
+
 	// Advance the lexer to the next character of the input string
 	l.readChar()
 	return tok
@@ -140,6 +190,24 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
+// readString reads a string literal, from its opening quote to its closing quote,
+// returning the STRING token's type and literal, which is the string as it is written
+// in the source. A string left open at the end of its line is returned as ILLEGAL.
+func (l *Lexer) readString() (token.TokenType, string) {
+	position := l.position
+	for {
+		l.readChar()
+		if l.ch == '\\' {
+			l.readChar()
+		} else if l.ch == '"' {
+			return token.STRING, l.input[position : l.position+1]
+		}
+		if l.ch == '\n' || l.ch == 0 {
+			return token.ILLEGAL, l.input[position:l.position]
+		}
+	}
+}
+
 // readNumber will iterate through the characters of the lexer's input string
 // until it reaches a character that is not a digit (assessed via the isDigit
 // function. It will then return the sub-string from the initial starting
@@ -188,7 +256,7 @@ func (l *Lexer) skipWhitespace() {
 // advancing the internal index postions.
 func (l *Lexer) peekChar() byte {
 
-	if l.position >= len(l.input) {
+	if l.readPosition >= len(l.input) {
 		return 0
 	}
 
@@ -126,3 +126,234 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTypeAnnotationTokens(t *testing.T) {
+	input := `let f: fn([int]) -> bool = fn(a: {string: int}) -> bool { a -1 };`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "f"},
+		{token.COLON, ":"},
+		{token.FUNCTION, "fn"},
+		{token.LPAREN, "("},
+		{token.LBRACKET, "["},
+		{token.IDENT, "int"},
+		{token.RBRACKET, "]"},
+		{token.RPAREN, ")"},
+		{token.ARROW, "->"},
+		{token.IDENT, "bool"},
+		{token.ASSIGN, "="},
+		{token.FUNCTION, "fn"},
+		{token.LPAREN, "("},
+		{token.IDENT, "a"},
+		{token.COLON, ":"},
+		{token.LBRACE, "{"},
+		{token.IDENT, "string"},
+		{token.COLON, ":"},
+		{token.IDENT, "int"},
+		{token.RBRACE, "}"},
+		{token.RPAREN, ")"},
+		{token.ARROW, "->"},
+		{token.IDENT, "bool"},
+		{token.LBRACE, "{"},
+		{token.IDENT, "a"},
+		{token.MINUS, "-"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.SEMICOLON, ";"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. Expected %q %q, got %q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestExceptionTokens(t *testing.T) {
+	input := `try { throw 1; } catch (e) { e.message } finally { 2 }`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.TRY, "try"},
+		{token.LBRACE, "{"},
+		{token.THROW, "throw"},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.RBRACE, "}"},
+		{token.CATCH, "catch"},
+		{token.LPAREN, "("},
+		{token.IDENT, "e"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.IDENT, "e"},
+		{token.DOT, "."},
+		{token.IDENT, "message"},
+		{token.RBRACE, "}"},
+		{token.FINALLY, "finally"},
+		{token.LBRACE, "{"},
+		{token.INT, "2"},
+		{token.RBRACE, "}"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. Expected %q %q, got %q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n\tx == 10;\n"
+
+	tests := []struct {
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{"let", 1, 1},
+		{"x", 1, 5},
+		{"=", 1, 7},
+		{"5", 1, 9},
+		{";", 1, 10},
+		{"x", 2, 2},
+		{"==", 2, 4},
+		{"10", 2, 7},
+		{";", 2, 9},
+		{"", 3, 1},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal was wrong. Expected %q, got %q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - Position was wrong. Expected %d:%d, got %s",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos)
+		}
+	}
+}
+
+func TestShebang(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{"#!/usr/bin/env capuchin\nlet x = 1;", token.LET, "let", 2, 1},
+		{"#!/usr/bin/env capuchin", token.EOF, "", 1, 24},
+		{"#!\n\n  x", token.IDENT, "x", 3, 3},
+		// Only the first line may be a shebang
+		{" #!x", token.ILLEGAL, "#", 1, 2},
+		{"#x", token.ILLEGAL, "#", 1, 1},
+	}
+
+	for i, tt := range tests {
+		tok := New(tt.input).NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. Expected %q %q, got %q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - Position was wrong. Expected %d:%d, got %s",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	input := `import "lib/math" as m; "a \"b\" \\" "" "open
+x`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{token.IMPORT, "import", 1, 1},
+		{token.STRING, `"lib/math"`, 1, 8},
+		{token.AS, "as", 1, 19},
+		{token.IDENT, "m", 1, 22},
+		{token.SEMICOLON, ";", 1, 23},
+		{token.STRING, `"a \"b\" \\"`, 1, 25},
+		{token.STRING, `""`, 1, 38},
+		{token.ILLEGAL, `"open`, 1, 41},
+		{token.IDENT, "x", 2, 1},
+		{token.EOF, "", 2, 2},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. Expected %q %q, got %q %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - Position was wrong. Expected %d:%d, got %s",
+				i, tt.expectedLine, tt.expectedColumn, tok.Pos)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		literal  string
+		expected string
+	}{
+		{`""`, ""},
+		{`"hello"`, "hello"},
+		{`"a\tb\nc\r"`, "a\tb\nc\r"},
+		{`"say \"hi\" \\o/"`, `say "hi" \o/`},
+		{`"héllo"`, "héllo"},
+	}
+
+	for _, tt := range tests {
+		value, err := Unquote(tt.literal)
+		if err != nil {
+			t.Errorf("Unquote(%s) failed: %v", tt.literal, err)
+			continue
+		}
+		if value != tt.expected {
+			t.Errorf("Unquote(%s) wrong. want=%q, got=%q", tt.literal, tt.expected, value)
+		}
+		if quoted := Quote(value); quoted != tt.literal {
+			t.Errorf("Quote(%q) wrong. want=%s, got=%s", value, tt.literal, quoted)
+		}
+	}
+
+	for _, literal := range []string{`"\q"`, `"a`, `x`, `"\"`} {
+		if _, err := Unquote(literal); err == nil {
+			t.Errorf("Unquote(%s) did not fail", literal)
+		}
+	}
+}
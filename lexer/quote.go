@@ -0,0 +1,66 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// escapes maps the character after a backslash in a string literal to the character
+// it stands for.
+var escapes = map[byte]byte{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'"':  '"',
+	'\\': '\\',
+}
+
+// Unquote returns the value of the string literal lit, as the literal of a STRING
+// token: the text between its quotes, with its escape sequences replaced by the
+// characters they stand for.
+func Unquote(lit string) (string, error) {
+	if len(lit) < 2 || lit[0] != '"' || lit[len(lit)-1] != '"' {
+		return "", fmt.Errorf("%s is not a string literal", lit)
+	}
+
+	var out strings.Builder
+	for i := 1; i < len(lit)-1; i++ {
+		ch := lit[i]
+		if ch != '\\' {
+			out.WriteByte(ch)
+			continue
+		}
+
+		i++
+		escaped, ok := escapes[lit[i]]
+		if !ok || i == len(lit)-1 {
+			return "", fmt.Errorf("unknown escape sequence \\%c in %s", lit[i], lit)
+		}
+		out.WriteByte(escaped)
+	}
+	return out.String(), nil
+}
+
+// Quote returns a string literal whose value is s, the reverse of Unquote.
+func Quote(s string) string {
+	var out strings.Builder
+	out.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\n':
+			out.WriteString(`\n`)
+		case '\t':
+			out.WriteString(`\t`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '"':
+			out.WriteString(`\"`)
+		case '\\':
+			out.WriteString(`\\`)
+		default:
+			out.WriteByte(s[i])
+		}
+	}
+	out.WriteByte('"')
+	return out.String()
+}
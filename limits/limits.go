@@ -0,0 +1,125 @@
+// Package limits bounds the resources a capuchin program may use while it runs, so
+// that programs which cannot be trusted to finish can be run safely.
+//
+// Both the evaluator and the virtual machine accept a Config. They count their steps
+// and estimate their allocations with a Meter, and stop the program with an error
+// as soon as it goes beyond a limit or the Config's context is done. Steps and
+// allocations are counted differently by the two engines, so the same program may
+// stop at a different point in each.
+package limits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// The errors a program is stopped with when it goes beyond a limit. A program whose
+// context is done is stopped with an error wrapping ErrCancelled and the context's
+// own error.
+var (
+	ErrSteps     = errors.New("step limit exceeded")
+	ErrDepth     = errors.New("call depth limit exceeded")
+	ErrMemory    = errors.New("memory limit exceeded")
+	ErrCancelled = errors.New("execution cancelled")
+)
+
+// Stopped reports whether err is one of the errors a program is stopped with.
+func Stopped(err error) bool {
+	return errors.Is(err, ErrSteps) || errors.Is(err, ErrDepth) ||
+		errors.Is(err, ErrMemory) || errors.Is(err, ErrCancelled)
+}
+
+// Approximate sizes, in bytes, of what the engines allocate.
+const (
+	ObjectSize = 32 // a value, such as an integer or a function, or a call frame
+	SlotSize   = 16 // an element of a slice of values, or a binding in an environment
+)
+
+// checkInterval is how many steps are taken between checks of the context, which
+// are much slower than counting.
+const checkInterval = 1024
+
+// Config holds the limits on a run. A zero limit is no limit.
+type Config struct {
+	MaxSteps  int64 // instructions run by the VM or nodes evaluated by the evaluator
+	MaxDepth  int   // function calls in progress, not counting tail calls
+	MaxMemory int64 // bytes allocated in total, as estimated by the engine
+
+	// Context, if not nil, stops the program when it is done.
+	Context context.Context
+}
+
+// Meter keeps track of one run's use of resources. The methods of a nil Meter do
+// nothing, and never fail, so an engine without limits need not check for one.
+type Meter struct {
+	config    Config
+	steps     int64
+	allocated int64
+	done      <-chan struct{}
+}
+
+// NewMeter creates a Meter enforcing config.
+func NewMeter(config Config) *Meter {
+	m := &Meter{config: config}
+	if config.Context != nil {
+		m.done = config.Context.Done()
+	}
+	return m
+}
+
+// Step counts a step of the program. It fails if the program has now taken too many
+// steps, or allocated too much, or if its context is done.
+func (m *Meter) Step() error {
+	if m == nil {
+		return nil
+	}
+	m.steps++
+
+	if m.config.MaxSteps > 0 && m.steps > m.config.MaxSteps {
+		return ErrSteps
+	}
+	if m.config.MaxMemory > 0 && m.allocated > m.config.MaxMemory {
+		return ErrMemory
+	}
+	if m.done != nil && m.steps%checkInterval == 1 {
+		select {
+		case <-m.done:
+			return fmt.Errorf("%w: %w", ErrCancelled, m.config.Context.Err())
+		default:
+		}
+	}
+	return nil
+}
+
+// Alloc counts n bytes as allocated. Going beyond the limit is reported by the next
+// Step, so that allocating never fails.
+func (m *Meter) Alloc(n int64) {
+	if m != nil {
+		m.allocated += n
+	}
+}
+
+// Call checks depth, the number of calls in progress once a new one is made.
+func (m *Meter) Call(depth int) error {
+	if m != nil && m.config.MaxDepth > 0 && depth > m.config.MaxDepth {
+		return ErrDepth
+	}
+	return nil
+}
+
+// Steps returns the number of steps counted so far.
+func (m *Meter) Steps() int64 {
+	if m == nil {
+		return 0
+	}
+	return m.steps
+}
+
+// Allocated returns the number of bytes counted as allocated so far.
+func (m *Meter) Allocated() int64 {
+	if m == nil {
+		return 0
+	}
+	return m.allocated
+}
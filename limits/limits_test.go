@@ -0,0 +1,71 @@
+package limits
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMeter(t *testing.T) {
+	m := NewMeter(Config{MaxSteps: 3, MaxDepth: 2, MaxMemory: 100})
+
+	for i := 0; i < 3; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("step %d failed: %s", i+1, err)
+		}
+	}
+	if err := m.Step(); err != ErrSteps {
+		t.Errorf("wrong error after too many steps. want=%v, got=%v", ErrSteps, err)
+	}
+
+	if err := m.Call(2); err != nil {
+		t.Errorf("call at the maximum depth failed: %s", err)
+	}
+	if err := m.Call(3); err != ErrDepth {
+		t.Errorf("wrong error for a deep call. want=%v, got=%v", ErrDepth, err)
+	}
+
+	m = NewMeter(Config{MaxMemory: 100})
+	m.Alloc(100)
+	if err := m.Step(); err != nil {
+		t.Errorf("step at the memory limit failed: %s", err)
+	}
+	m.Alloc(1)
+	if err := m.Step(); err != ErrMemory {
+		t.Errorf("wrong error after allocating too much. want=%v, got=%v", ErrMemory, err)
+	}
+	if m.Steps() != 2 || m.Allocated() != 101 {
+		t.Errorf("wrong counts. want=2 steps, 101 bytes, got=%d steps, %d bytes", m.Steps(), m.Allocated())
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := NewMeter(Config{Context: ctx})
+
+	for i := 0; i < 2*checkInterval; i++ {
+		if err := m.Step(); err != nil {
+			t.Fatalf("step %d failed before cancellation: %s", i+1, err)
+		}
+	}
+
+	cancel()
+	var err error
+	for i := 0; i < checkInterval && err == nil; i++ {
+		err = m.Step()
+	}
+	if !errors.Is(err, ErrCancelled) || !errors.Is(err, context.Canceled) {
+		t.Errorf("wrong error after cancellation. got=%v", err)
+	}
+}
+
+func TestNilMeter(t *testing.T) {
+	var m *Meter
+	m.Alloc(1 << 40)
+	if err := m.Step(); err != nil {
+		t.Errorf("nil meter failed a step: %s", err)
+	}
+	if err := m.Call(1 << 20); err != nil {
+		t.Errorf("nil meter failed a call: %s", err)
+	}
+}
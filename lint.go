@@ -0,0 +1,87 @@
+package main
+
+import (
+	"capuchin/format"
+	"capuchin/lint"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// lintCommand reports lint issues in each of the named scripts, optionally rewriting
+// them with the safe fixes applied. A shebang line is kept as it is. It exits with 1
+// if any issues remain and 2 if a script could not be linted.
+func lintCommand(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	configPath := flags.String("config", "",
+		"rules configuration file (default "+lint.DefaultConfigFile+" if present)")
+	fix := flags.Bool("fix", false, "apply safe fixes and rewrite the scripts")
+	flags.Usage = func() {
+		out := flags.Output()
+		fmt.Fprintln(out, "usage: capuchin lint [-config file] [-fix] script.cap...")
+		flags.PrintDefaults()
+		fmt.Fprintln(out, "rules:")
+		for _, rule := range lint.Rules() {
+			fmt.Fprintf(out, "  %-20s %s\n", rule, lint.Describe(rule))
+		}
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	config := lint.Config{}
+	if *configPath == "" {
+		if _, err := os.Stat(lint.DefaultConfigFile); err == nil {
+			*configPath = lint.DefaultConfigFile
+		}
+	}
+	if *configPath != "" {
+		var err error
+		if config, err = lint.LoadConfig(*configPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		src, err := readSource(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+		program, err := parseSource(path, src)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+
+		issues := lint.Run(program, config, scriptGlobals()...)
+
+		if *fix && lint.ApplyFixes(issues) > 0 {
+			if err := os.WriteFile(path, []byte(shebang(src)+format.Program(program)), 0o644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				status = 2
+				continue
+			}
+			// Report what is left once the fixes are in place
+			issues = lint.Run(program, config, scriptGlobals()...)
+		}
+
+		for _, issue := range issues {
+			fmt.Printf("%s:%s\n", path, issue)
+		}
+		if len(issues) > 0 && status == 0 {
+			status = 1
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,54 @@
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// DefaultConfigFile is the name of the configuration file the lint command looks for
+// when none is given.
+const DefaultConfigFile = ".capuchin-lint.json"
+
+// Config selects which rules the linter runs. It is read from a JSON file such as:
+//
+//	{"rules": {"shadowed": false, "empty-block": true}}
+//
+// Rules missing from the file are enabled.
+type Config struct {
+	Rules map[string]bool `json:"rules"`
+}
+
+// Enabled reports whether the named rule should run.
+func (c Config) Enabled(rule string) bool {
+	enabled, ok := c.Rules[rule]
+	return !ok || enabled
+}
+
+// LoadConfig reads the configuration file at path, rejecting rules that do not exist.
+func LoadConfig(path string) (Config, error) {
+	var config Config
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("%s: %v", path, err)
+	}
+
+	unknown := []string{}
+	for rule := range config.Rules {
+		if _, ok := rules[rule]; !ok {
+			unknown = append(unknown, rule)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return config, fmt.Errorf("%s: unknown lint rules %v", path, unknown)
+	}
+
+	return config, nil
+}
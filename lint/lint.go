@@ -0,0 +1,450 @@
+// Package lint checks capuchin programs for code which is legal but probably wrong,
+// such as unused bindings or statements that can never run.
+//
+// Each rule can be switched on or off through a Config. Problems are reported as
+// warnings in the same positioned format as the other analysis passes, and where a
+// rewrite is known to keep the program's meaning the issue carries a Fix.
+package lint
+
+import (
+	"capuchin/ast"
+	"capuchin/diagnostic"
+	"capuchin/resolver"
+	"capuchin/token"
+	"fmt"
+	"sort"
+)
+
+// The names of the lint rules, as used in configuration files and diagnostic codes.
+const (
+	UnusedLet         = "unused-let"
+	Shadowed          = "shadowed"
+	Unreachable       = "unreachable"
+	ConstantCondition = "constant-condition"
+	SelfComparison    = "self-comparison"
+	EmptyBlock        = "empty-block"
+	UnusedImport      = "unused-import"
+)
+
+// rules maps each rule to a short description of what it finds.
+var rules = map[string]string{
+	UnusedLet:         "let bindings which are never used",
+	Shadowed:          "bindings which hide a binding of an enclosing scope",
+	Unreachable:       "statements following a return or throw",
+	ConstantCondition: "if expressions whose condition is a constant",
+	SelfComparison:    "comparisons of an expression with itself",
+	EmptyBlock:        "if and else branches with no statements",
+	UnusedImport:      "imported modules which are never used",
+}
+
+// Rules returns the names of all the lint rules, sorted.
+func Rules() []string {
+	names := []string{}
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Describe returns a short description of the named rule.
+func Describe(rule string) string {
+	return rules[rule]
+}
+
+// Issue is a problem found by a lint rule. The diagnostic's Code is the rule name.
+type Issue struct {
+	diagnostic.Diagnostic
+
+	// Fix rewrites the program to resolve the issue. It is nil if there is no
+	// rewrite which is known to be safe.
+	Fix *Fix
+}
+
+// Fix is an automatic rewrite of the program's syntax tree.
+type Fix struct {
+	Description string
+	apply       func()
+}
+
+// ApplyFixes rewrites the program the issues were found in, applying every available
+// fix. It returns the number of fixes applied.
+func ApplyFixes(issues []Issue) int {
+	applied := 0
+	for _, issue := range issues {
+		if issue.Fix != nil {
+			issue.Fix.apply()
+			applied++
+		}
+	}
+	return applied
+}
+
+// Run checks program against the rules enabled in config. The builtins are the names
+// predeclared by the runtime, which bindings may shadow. Issues are returned ordered
+// by position.
+func Run(program *ast.Program, config Config, builtins ...string) []Issue {
+	l := &linter{
+		config: config,
+		uses:   make(map[*ast.Identifier]int),
+	}
+
+	// The resolver links every use of a name to its declaration, which is what the
+	// unused binding rule counts. Its own diagnostics are left to the checker.
+	resolver.Resolve(program, builtins...)
+
+	l.openScope()
+	for _, name := range builtins {
+		l.scopes[0][name] = true
+	}
+	l.openScope()
+	l.statements(&program.Statements)
+
+	if l.config.Enabled(UnusedLet) {
+		l.unusedLets()
+	}
+	if l.config.Enabled(UnusedImport) {
+		l.unusedImports()
+	}
+
+	sort.SliceStable(l.issues, func(i, j int) bool {
+		a, b := l.issues[i].Pos, l.issues[j].Pos
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	return l.issues
+}
+
+// linter walks a program once, applying every enabled rule.
+type linter struct {
+	config Config
+	issues []Issue
+
+	// scopes holds the names declared so far in each enclosing function, the
+	// outermost being the builtins.
+	scopes []map[string]bool
+
+	// lets records each let statement with the list it belongs to, and uses counts
+	// the references to each declaration.
+	lets []letSite
+	uses map[*ast.Identifier]int
+
+	// imports records each import statement, whose name is counted in uses too.
+	imports []*ast.ImportStatement
+}
+
+// letSite is a let statement and the statement list holding it.
+type letSite struct {
+	stmt *ast.LetStatement
+	list *[]ast.Statement
+}
+
+func (l *linter) report(rule string, node ast.Node, fix *Fix, format string, args ...interface{}) {
+	if !l.config.Enabled(rule) {
+		return
+	}
+
+	l.issues = append(l.issues, Issue{
+		Diagnostic: diagnostic.Diagnostic{
+			Pos:      ast.Pos(node),
+			Severity: diagnostic.Warning,
+			Code:     rule,
+			Message:  fmt.Sprintf(format, args...),
+		},
+		Fix: fix,
+	})
+}
+
+func (l *linter) openScope() {
+	l.scopes = append(l.scopes, make(map[string]bool))
+}
+
+func (l *linter) closeScope() {
+	l.scopes = l.scopes[:len(l.scopes)-1]
+}
+
+// declare adds ident to the current scope, reporting it if it hides a name from an
+// enclosing scope.
+func (l *linter) declare(ident *ast.Identifier) {
+	current := len(l.scopes) - 1
+	if !l.scopes[current][ident.Value] {
+		for i := current - 1; i >= 0; i-- {
+			if l.scopes[i][ident.Value] {
+				kind := "an outer binding"
+				if i == 0 {
+					kind = "a builtin"
+				}
+				l.report(Shadowed, ident, nil, "%s shadows %s", ident.Value, kind)
+				break
+			}
+		}
+	}
+	l.scopes[current][ident.Value] = true
+}
+
+// statements lints a list of statements. The list is passed by pointer so fixes can
+// remove statements from it.
+func (l *linter) statements(list *[]ast.Statement) {
+	stmts := *list
+	for i, stmt := range stmts {
+		l.statement(stmt, list)
+
+		if ends(stmt) && i+1 < len(stmts) {
+			// Keep hold of the return itself, as earlier fixes may have
+			// changed the list's indices by the time this one runs.
+			ret := stmt
+			fix := &Fix{
+				Description: "remove the unreachable statements",
+				apply: func() {
+					for j, s := range *list {
+						if s == ret {
+							*list = (*list)[:j+1]
+							return
+						}
+					}
+				},
+			}
+			l.report(Unreachable, stmts[i+1], fix, "unreachable statement after %s", stmt.TokenLiteral())
+
+			// The rest of the list is still walked so that names used
+			// there do not look unused.
+			for _, rest := range stmts[i+1:] {
+				l.statement(rest, list)
+			}
+			return
+		}
+	}
+}
+
+func (l *linter) statement(stmt ast.Statement, list *[]ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		l.expression(s.Value, func(e ast.Expression) { s.Value = e })
+		l.declare(s.Name)
+		l.lets = append(l.lets, letSite{stmt: s, list: list})
+	case *ast.ExportStatement:
+		// An exported binding is used by the programs importing it
+		l.expression(s.Statement.Value, func(e ast.Expression) { s.Statement.Value = e })
+		l.declare(s.Statement.Name)
+	case *ast.ImportStatement:
+		l.declare(s.Name)
+		l.imports = append(l.imports, s)
+	case *ast.ReturnStatement:
+		l.expression(s.ReturnValue, func(e ast.Expression) { s.ReturnValue = e })
+	case *ast.ThrowStatement:
+		l.expression(s.Value, func(e ast.Expression) { s.Value = e })
+	case *ast.ExpressionStatement:
+		l.expression(s.Expression, func(e ast.Expression) { s.Expression = e })
+	case *ast.BlockStatement:
+		l.statements(&s.Statements)
+	}
+}
+
+// expression lints exp, where replace stores a new expression in exp's place.
+func (l *linter) expression(exp ast.Expression, replace func(ast.Expression)) {
+	switch e := exp.(type) {
+	case *ast.Identifier:
+		if e.Binding != nil && e.Binding.Decl != nil && e.Binding.Decl != e {
+			l.uses[e.Binding.Decl]++
+		}
+
+	case *ast.PrefixExpression:
+		l.expression(e.Right, func(r ast.Expression) { e.Right = r })
+
+	case *ast.InfixExpression:
+		l.expression(e.Left, func(r ast.Expression) { e.Left = r })
+		l.expression(e.Right, func(r ast.Expression) { e.Right = r })
+		l.selfComparison(e, replace)
+
+	case *ast.IfExpression:
+		if constant(e.Condition) {
+			l.report(ConstantCondition, e.Condition, nil,
+				"condition %s is always the same", e.Condition.String())
+		}
+		l.expression(e.Condition, func(r ast.Expression) { e.Condition = r })
+		l.emptyBlocks(e)
+		if e.Consequence != nil {
+			l.statements(&e.Consequence.Statements)
+		}
+		if e.Alternative != nil {
+			l.statements(&e.Alternative.Statements)
+		}
+
+	case *ast.TryExpression:
+		if e.Body != nil {
+			l.statements(&e.Body.Statements)
+		}
+		if e.Catch != nil {
+			l.declare(e.Parameter)
+			l.statements(&e.Catch.Statements)
+		}
+		if e.Finally != nil {
+			l.statements(&e.Finally.Statements)
+		}
+
+	case *ast.MemberExpression:
+		l.expression(e.Object, func(r ast.Expression) { e.Object = r })
+
+	case *ast.IndexExpression:
+		l.expression(e.Left, func(r ast.Expression) { e.Left = r })
+		l.expression(e.Index, func(r ast.Expression) { e.Index = r })
+
+	case *ast.ArrayLiteral:
+		for i := range e.Elements {
+			i := i
+			l.expression(e.Elements[i], func(r ast.Expression) { e.Elements[i] = r })
+		}
+
+	case *ast.HashLiteral:
+		for i := range e.Keys {
+			i := i
+			l.expression(e.Keys[i], func(r ast.Expression) { e.Keys[i] = r })
+			l.expression(e.Values[i], func(r ast.Expression) { e.Values[i] = r })
+		}
+
+	case *ast.FunctionLiteral:
+		l.openScope()
+		for _, param := range e.Parameters {
+			l.declare(param)
+		}
+		if e.Body != nil {
+			l.statements(&e.Body.Statements)
+		}
+		l.closeScope()
+
+	case *ast.CallExpression:
+		l.expression(e.Function, func(r ast.Expression) { e.Function = r })
+		for i := range e.Arguments {
+			i := i
+			l.expression(e.Arguments[i], func(r ast.Expression) { e.Arguments[i] = r })
+		}
+	}
+}
+
+// selfComparison reports comparisons such as "x == x". Equality of an identifier or
+// literal with itself has a known result, which the fix substitutes.
+func (l *linter) selfComparison(e *ast.InfixExpression, replace func(ast.Expression)) {
+	switch e.Operator {
+	case "==", "!=", "<", ">":
+	default:
+		return
+	}
+
+	if !pure(e.Left) || !ast.Equal(e.Left, e.Right, ast.CompareOptions{IgnorePositions: true}) {
+		return
+	}
+
+	var fix *Fix
+	if e.Operator == "==" || e.Operator == "!=" {
+		if _, ok := e.Left.(*ast.FunctionLiteral); !ok {
+			result := e.Operator == "=="
+			fix = &Fix{
+				Description: fmt.Sprintf("replace with %t", result),
+				apply:       func() { replace(boolean(e, result)) },
+			}
+		}
+	}
+
+	l.report(SelfComparison, e, fix, "%s compares %s with itself",
+		e.String(), e.Left.String())
+}
+
+// emptyBlocks reports the empty branches of an if expression. An empty else branch
+// gives the same null as a missing one, so the fix removes it.
+func (l *linter) emptyBlocks(e *ast.IfExpression) {
+	if e.Consequence != nil && len(e.Consequence.Statements) == 0 {
+		l.report(EmptyBlock, e.Consequence, nil, "empty if branch")
+	}
+
+	if e.Alternative != nil && len(e.Alternative.Statements) == 0 {
+		fix := &Fix{
+			Description: "remove the empty else branch",
+			apply:       func() { e.Alternative = nil },
+		}
+		l.report(EmptyBlock, e.Alternative, fix, "empty else branch")
+	}
+}
+
+// unusedLets reports the let bindings which are never referred to. Names starting
+// with an underscore are deliberately unused and are skipped.
+func (l *linter) unusedLets() {
+	for _, site := range l.lets {
+		stmt, list := site.stmt, site.list
+		name := stmt.Name.Value
+		if l.uses[stmt.Name] > 0 || name[0] == '_' {
+			continue
+		}
+
+		var fix *Fix
+		if pure(stmt.Value) {
+			fix = &Fix{
+				Description: "remove the let statement",
+				apply: func() {
+					for i, s := range *list {
+						if s == stmt {
+							*list = append((*list)[:i:i], (*list)[i+1:]...)
+							return
+						}
+					}
+				},
+			}
+		}
+
+		l.report(UnusedLet, stmt.Name, fix, "%s is never used", name)
+	}
+}
+
+// unusedImports reports the imported modules whose name is never referred to. There
+// is no fix, since importing a module runs it.
+func (l *linter) unusedImports() {
+	for _, stmt := range l.imports {
+		if name := stmt.Name.Value; l.uses[stmt.Name] == 0 && name[0] != '_' {
+			l.report(UnusedImport, stmt.Name, nil, "import %s is never used", name)
+		}
+	}
+}
+
+// ends reports whether stmt always leaves the statement list it is in, so that the
+// statements following it can never run.
+func ends(stmt ast.Statement) bool {
+	switch stmt.(type) {
+	case *ast.ReturnStatement, *ast.ThrowStatement:
+		return true
+	}
+	return false
+}
+
+// constant reports whether exp is made only of literals, so always has one value.
+func constant(exp ast.Expression) bool {
+	switch e := exp.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean:
+		return true
+	case *ast.PrefixExpression:
+		return constant(e.Right)
+	case *ast.InfixExpression:
+		return constant(e.Left) && constant(e.Right)
+	}
+	return false
+}
+
+// pure reports whether evaluating exp can have no effect other than producing its
+// value, so that it can safely be removed or duplicated.
+func pure(exp ast.Expression) bool {
+	switch exp.(type) {
+	case *ast.Identifier, *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean, *ast.FunctionLiteral:
+		return true
+	}
+	return false
+}
+
+// boolean returns the literal for value, positioned where exp was.
+func boolean(exp *ast.InfixExpression, value bool) *ast.Boolean {
+	tok := token.Token{Type: token.FALSE, Literal: "false", Pos: ast.Pos(exp)}
+	if value {
+		tok.Type, tok.Literal = token.TRUE, "true"
+	}
+	return &ast.Boolean{Token: tok, Value: value}
+}
@@ -0,0 +1,157 @@
+package lint
+
+import (
+	"capuchin/format"
+	"capuchin/parsertest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let x = 1; puts(x);", nil},
+		{"let x = 1;", []string{"1:5: warning: x is never used (unused-let)"}},
+		{"let _x = 1;", nil},
+		{"let f = fn(x) { x }; f(1);", nil},
+		{
+			"let x = 1; let f = fn(x) { x }; f(x);",
+			[]string{"1:23: warning: x shadows an outer binding (shadowed)"},
+		},
+		{"let x = 1; let x = x + 1; puts(x);", nil},
+		{"let puts = 1; puts;", []string{"1:5: warning: puts shadows a builtin (shadowed)"}},
+		{
+			"let f = fn() { return 1; puts(2); }; f();",
+			[]string{"1:26: warning: unreachable statement after return (unreachable)"},
+		},
+		{
+			"let f = fn() { throw 1; puts(2); }; f();",
+			[]string{"1:25: warning: unreachable statement after throw (unreachable)"},
+		},
+		{"try { puts(1) } catch (e) { puts(e.message) }", nil},
+		{
+			"let e = 1; let f = fn() { try { puts(e) } catch (e) { puts(e) } }; f();",
+			[]string{"1:50: warning: e shadows an outer binding (shadowed)"},
+		},
+		{
+			"if (true) { puts(1) }",
+			[]string{"1:5: warning: condition true is always the same (constant-condition)"},
+		},
+		{
+			"if (1 < 2) { puts(1) }",
+			[]string{"1:5: warning: condition (1 < 2) is always the same (constant-condition)"},
+		},
+		{
+			"let x = 1; puts(x == x);",
+			[]string{"1:17: warning: (x == x) compares x with itself (self-comparison)"},
+		},
+		{"let x = 1; let y = 2; puts(x == y);", nil},
+		{`import "math" as m; export let x = m.pi;`, nil},
+		{`import "math" as m; import "io" as _io;`, []string{"1:18: warning: import m is never used (unused-import)"}},
+		{
+			`let m = 1; let f = fn() { import "math" as m; m }; f();`,
+			[]string{"1:5: warning: m is never used (unused-let)", "1:44: warning: m shadows an outer binding (shadowed)"},
+		},
+		{"puts(puts(1) == puts(1));", nil},
+		{`let i = 0; let k = "k"; let h = {k: [i]}; puts(h[k][i]);`, nil},
+		{
+			"let x = 1; puts([x == x]);",
+			[]string{"1:18: warning: (x == x) compares x with itself (self-comparison)"},
+		},
+		{
+			"let x = 1; if (x) {} else { puts(x) }",
+			[]string{"1:19: warning: empty if branch (empty-block)"},
+		},
+		{
+			"let x = 1; if (x) { puts(x) } else {}",
+			[]string{"1:36: warning: empty else branch (empty-block)"},
+		},
+	}
+
+	for _, tt := range tests {
+		program := parsertest.Parse(t, tt.input)
+		issues := Run(program, Config{}, "puts")
+
+		if len(issues) != len(tt.expected) {
+			t.Errorf("wrong number of issues for %q. want=%d, got=%d (%v)",
+				tt.input, len(tt.expected), len(issues), issues)
+			continue
+		}
+		for i, issue := range issues {
+			if issue.String() != tt.expected[i] {
+				t.Errorf("issue %d wrong for %q. want=%q, got=%q",
+					i, tt.input, tt.expected[i], issue.String())
+			}
+		}
+	}
+}
+
+func TestDisabledRules(t *testing.T) {
+	input := "let x = 1; if (true) { return x; x; }"
+	config := Config{Rules: map[string]bool{
+		ConstantCondition: false,
+		Unreachable:       true,
+	}}
+
+	issues := Run(parsertest.Parse(t, input), config)
+
+	if len(issues) != 1 || issues[0].Code != Unreachable {
+		t.Errorf("only the unreachable rule should report. got=%v", issues)
+	}
+}
+
+func TestFixes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1; let y = 2; puts(y);", "let y = 2;\nputs(y);\n"},
+		// Removing a call could lose its effect, so it is kept
+		{"let x = puts(1);", "let x = puts(1);\n"},
+		{
+			"let f = fn() { return 1; puts(2); puts(3); }; f();",
+			"let f = fn() {\n\treturn 1;\n};\nf();\n",
+		},
+		{"let x = 1; puts(x == x, x != x);", "let x = 1;\nputs(true, false);\n"},
+		{"let x = 1; puts(x < x);", "let x = 1;\nputs(x < x);\n"},
+		{
+			"let x = 1; if (x) { puts(x) } else {}",
+			"let x = 1;\nif (x) {\n\tputs(x);\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		program := parsertest.Parse(t, tt.input)
+		ApplyFixes(Run(program, Config{}, "puts"))
+
+		if actual := format.Program(program); actual != tt.expected {
+			t.Errorf("fixed program wrong for %q. want=%q, got=%q",
+				tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	good := filepath.Join(dir, "good.json")
+	os.WriteFile(good, []byte(`{"rules": {"shadowed": false}}`), 0o644)
+
+	config, err := LoadConfig(good)
+	if err != nil {
+		t.Fatalf("LoadConfig returned error: %v", err)
+	}
+	if config.Enabled(Shadowed) || !config.Enabled(UnusedLet) {
+		t.Errorf("config has wrong rules enabled. got=%+v", config)
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	os.WriteFile(bad, []byte(`{"rules": {"no-such-rule": true}}`), 0o644)
+
+	if _, err := LoadConfig(bad); err == nil {
+		t.Errorf("LoadConfig should reject unknown rules")
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLintFix(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1; let y = 2; puts(y);", "let y = 2;\nputs(y);\n"},
+		{
+			"#!/usr/bin/env capuchin\nlet x = 1; let y = 2; puts(y);",
+			"#!/usr/bin/env capuchin\nlet y = 2;\nputs(y);\n",
+		},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "script.cap")
+		if err := os.WriteFile(path, []byte(tt.input), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if status := lintCommand([]string{"-fix", path}); status != 0 {
+			t.Errorf("%q: wrong status. want=0, got=%d", tt.input, status)
+		}
+
+		fixed, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(fixed) != tt.expected {
+			t.Errorf("%q: wrong script. want=%q, got=%q", tt.input, tt.expected, fixed)
+		}
+	}
+}
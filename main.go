@@ -1,20 +1,82 @@
 package main
 
 import (
-	"capuchin/repl"
 	"fmt"
+	"io"
 	"os"
-	"os/user"
+	"sort"
 )
 
+// command is a subcommand of capuchin. Its run function is given the arguments after
+// the subcommand's name and returns the exit status.
+type command struct {
+	run     func(args []string) int
+	summary string
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"run":       {runCommand, "run a script"},
+		"repl":      {replCommand, "start an interactive session (the default)"},
+		"lex":       {lexCommand, "print the tokens of scripts"},
+		"parse":     {parseCommand, "print the syntax trees of scripts"},
+		"fmt":       {fmtCommand, "format scripts"},
+		"check":     {checkCommand, "resolve and type check scripts"},
+		"lint":      {lintCommand, "report lint issues in scripts"},
+		"test":      {testCommand, "run the tests in _test.cap files"},
+		"build":     {buildCommand, "compile scripts to bytecode"},
+		"disasm":    {disasmCommand, "print the bytecode of scripts"},
+		"optimize":  {optimizeCommand, "print scripts as they are after optimization"},
+		"diff":      {diffCommand, "compare the syntax trees of two scripts"},
+		"highlight": {highlightCommand, "print scripts with their syntax coloured"},
+		"help": {func([]string) int {
+			usage(os.Stdout)
+			return 0
+		}, "print this help"},
+	}
+}
+
 func main() {
+	if len(os.Args) < 2 {
+		os.Exit(replCommand(nil))
+	}
 
-	user, err := user.Current()
+	cmd, ok := commands[os.Args[1]]
+	if !ok && isFile(os.Args[1]) {
+		// A script named on its own is run, as it is from a shebang line
+		os.Exit(runCommand(os.Args[1:]))
+	}
+	if !ok {
+		fmt.Fprintf(os.Stderr, "capuchin: unknown command %q\n", os.Args[1])
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	os.Exit(cmd.run(os.Args[2:]))
+}
 
-	if err != nil {
-		panic(err)
+// isFile reports whether path names a file which is not a directory.
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// usage writes the list of commands to w.
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: capuchin <command> [arguments]")
+	fmt.Fprintln(w, "       capuchin script.cap [arguments]")
+	fmt.Fprintln(w, "commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].summary)
 	}
 
-	fmt.Printf("Welcome %s, this is the Capuchin REPL:\n", user.Username)
-	repl.Start(os.Stdin, os.Stdout)
+	fmt.Fprintln(w, "Scripts named - are read from the standard input. Commands exit with 0 on")
+	fmt.Fprintln(w, "success, 1 when a script fails and 2 when one cannot be read or parsed.")
 }
@@ -0,0 +1,42 @@
+package object
+
+import (
+	"fmt"
+)
+
+// Builtins holds the functions available to every program, in the order the
+// compiler numbers them.
+var Builtins = []struct {
+	Name    string
+	Builtin *Builtin
+}{
+	{
+		"puts",
+		&Builtin{Fn: func(args ...Object) Object {
+			for _, arg := range args {
+				fmt.Println(arg.Inspect())
+			}
+			return nil
+		}},
+	},
+}
+
+// GetBuiltinByName returns the builtin with the supplied name, or nil if there is
+// none.
+func GetBuiltinByName(name string) *Builtin {
+	for _, def := range Builtins {
+		if def.Name == name {
+			return def.Builtin
+		}
+	}
+	return nil
+}
+
+// BuiltinNames returns the names of the builtins.
+func BuiltinNames() []string {
+	names := []string{}
+	for _, def := range Builtins {
+		names = append(names, def.Name)
+	}
+	return names
+}
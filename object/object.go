@@ -0,0 +1,392 @@
+// Package object defines the values capuchin programs compute with, shared by the
+// tree-walking evaluator and the bytecode virtual machine.
+package object
+
+import (
+	"bytes"
+	"capuchin/ast"
+	"capuchin/code"
+	"capuchin/token"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ObjectType identifies the kind of an Object.
+type ObjectType string
+
+const (
+	INTEGER_OBJ = "INTEGER"
+	BOOLEAN_OBJ = "BOOLEAN"
+	NULL_OBJ    = "NULL"
+	FLOAT_OBJ   = "FLOAT"
+	STRING_OBJ  = "STRING"
+	ARRAY_OBJ   = "ARRAY"
+	HASH_OBJ    = "HASH"
+
+	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	ERROR_OBJ        = "ERROR"
+	ABORT_OBJ        = "ABORT"
+
+	FUNCTION_OBJ          = "FUNCTION"
+	BUILTIN_OBJ           = "BUILTIN"
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CLOSURE_OBJ           = "CLOSURE"
+	CELL_OBJ              = "CELL"
+
+	MODULE_OBJ = "MODULE"
+)
+
+// Object is the interface implemented by every value.
+type Object interface {
+	Type() ObjectType
+	Inspect() string
+}
+
+// Integer is a signed 64 bit integer value.
+type Integer struct {
+	Value int64
+}
+
+func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
+func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
+
+// Boolean is the value true or false.
+type Boolean struct {
+	Value bool
+}
+
+func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
+func (b *Boolean) Inspect() string  { return fmt.Sprintf("%t", b.Value) }
+
+// Float is a 64 bit floating point value.
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+func (f *Float) Inspect() string  { return strconv.FormatFloat(f.Value, 'g', -1, 64) }
+
+// String is an immutable string of UTF-8 text.
+type String struct {
+	Value string
+}
+
+func (s *String) Type() ObjectType { return STRING_OBJ }
+func (s *String) Inspect() string  { return s.Value }
+
+// Array is an ordered list of values.
+type Array struct {
+	Elements []Object
+}
+
+func (a *Array) Type() ObjectType { return ARRAY_OBJ }
+func (a *Array) Inspect() string {
+	elements := []string{}
+	for _, e := range a.Elements {
+		elements = append(elements, e.Inspect())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// HashKey identifies the key of a hash pair. Keys which are equal values have the
+// same HashKey.
+type HashKey struct {
+	Type  ObjectType
+	Value uint64
+}
+
+// Hashable is implemented by the values which can be used as hash keys.
+type Hashable interface {
+	HashKey() HashKey
+}
+
+func (i *Integer) HashKey() HashKey {
+	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
+}
+
+func (b *Boolean) HashKey() HashKey {
+	var value uint64
+	if b.Value {
+		value = 1
+	}
+	return HashKey{Type: b.Type(), Value: value}
+}
+
+func (s *String) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(s.Value))
+	return HashKey{Type: s.Type(), Value: h.Sum64()}
+}
+
+// HashPair is a key of a hash together with its value.
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+// Hash maps keys to values.
+type Hash struct {
+	Pairs map[HashKey]HashPair
+}
+
+func (h *Hash) Type() ObjectType { return HASH_OBJ }
+
+// Inspect lists the pairs of the hash ordered by key, so that equal hashes look the
+// same.
+func (h *Hash) Inspect() string {
+	pairs := []string{}
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair.Key.Inspect()+": "+pair.Value.Inspect())
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// Null is the absence of a value, such as the result of an if without an else
+// whose condition does not hold.
+type Null struct{}
+
+func (n *Null) Type() ObjectType { return NULL_OBJ }
+func (n *Null) Inspect() string  { return "null" }
+
+// ReturnValue wraps the value of a return statement while the evaluator unwinds to
+// the enclosing function call.
+type ReturnValue struct {
+	Value Object
+}
+
+func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
+func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
+
+// Error is a runtime error, or a value thrown by the program, which unwinds the
+// program to the nearest enclosing try, or stops it if there is none.
+type Error struct {
+	Message string
+	Pos     token.Position // Where the error happened, if known
+
+	// Stack holds the calls in progress when the error happened, innermost first.
+	// Once the error has been caught it only holds the calls it unwound.
+	Stack []StackFrame
+
+	// Value is the value thrown by a throw statement, nil for a runtime error.
+	Value Object
+}
+
+func (e *Error) Type() ObjectType { return ERROR_OBJ }
+func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+
+// Error returns the message, so that the virtual machine can report an Error as a Go
+// error.
+func (e *Error) Error() string { return e.Message }
+
+// Traceback returns the message followed by the functions which were running when
+// the error happened, innermost first, each with the position it had reached:
+//
+//	type mismatch: INTEGER + BOOLEAN
+//		at inner (2:11)
+//		at outer (5:3)
+//		at <main> (7:1)
+func (e *Error) Traceback() string {
+	var out strings.Builder
+	out.WriteString(e.Message)
+
+	pos := e.Pos
+	for _, frame := range e.Stack {
+		writeFrame(&out, frame.Function, pos)
+		pos = frame.Pos
+	}
+	writeFrame(&out, "<main>", pos)
+
+	return out.String()
+}
+
+// Caught returns the value a catch block binds for the error: the thrown value, or
+// for a runtime error a hash describing it, such as
+//
+//	{column: 11, line: 2, message: type mismatch: INTEGER + BOOLEAN, stack: [...]}
+//
+// whose stack holds a hash of the function, line and column of each call unwound.
+func (e *Error) Caught() Object {
+	if e.Value != nil {
+		return e.Value
+	}
+
+	stack := &Array{Elements: []Object{}}
+	for _, frame := range e.Stack {
+		stack.Elements = append(stack.Elements, newHash(
+			"function", &String{Value: frame.Function},
+			"line", &Integer{Value: int64(frame.Pos.Line)},
+			"column", &Integer{Value: int64(frame.Pos.Column)},
+		))
+	}
+
+	return newHash(
+		"message", &String{Value: e.Message},
+		"line", &Integer{Value: int64(e.Pos.Line)},
+		"column", &Integer{Value: int64(e.Pos.Column)},
+		"stack", stack,
+	)
+}
+
+// newHash creates a hash from alternating string keys and values.
+func newHash(pairs ...interface{}) *Hash {
+	h := &Hash{Pairs: make(map[HashKey]HashPair, len(pairs)/2)}
+	for i := 0; i < len(pairs); i += 2 {
+		key := &String{Value: pairs[i].(string)}
+		h.Pairs[key.HashKey()] = HashPair{Key: key, Value: pairs[i+1].(Object)}
+	}
+	return h
+}
+
+func writeFrame(out *strings.Builder, function string, pos token.Position) {
+	out.WriteString("\n\tat " + function)
+	if pos.IsValid() {
+		out.WriteString(" (" + pos.String() + ")")
+	}
+}
+
+// StackFrame is a call in progress: the function called, by its name or as
+// "<anonymous>", and the position of the call.
+type StackFrame struct {
+	Function string
+	Pos      token.Position
+}
+
+// Anonymous is the name of functions which were not bound to a name by a let.
+const Anonymous = "<anonymous>"
+
+// FunctionName returns the name a function is known by in stack traces.
+func FunctionName(name string) string {
+	if name == "" {
+		return Anonymous
+	}
+	return name
+}
+
+// Abort stops the evaluation of the program from outside it, when the program goes
+// beyond its resource limits or its context is done. Unlike an Error, it does not
+// come from the program itself.
+type Abort struct {
+	Err error
+}
+
+func (a *Abort) Type() ObjectType { return ABORT_OBJ }
+func (a *Abort) Inspect() string  { return "ABORT: " + a.Err.Error() }
+
+// Function is a function value created by the evaluator, closing over the
+// environment it was defined in.
+type Function struct {
+	Name       string // The name the function was let bound to, if any
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
+func (f *Function) Inspect() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range f.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("fn(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") {\n")
+	out.WriteString(f.Body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+
+// BuiltinFunction is the Go implementation of a builtin. It returns nil when it has
+// no value to return.
+type BuiltinFunction func(args ...Object) Object
+
+// Caller calls the function value fn with args for a builtin, as part of the program
+// which called the builtin.
+type Caller func(fn Object, args ...Object) Object
+
+// CallingFunction is the Go implementation of a builtin which calls the functions it
+// is passed, with call.
+type CallingFunction func(call Caller, args ...Object) Object
+
+// Builtin is a function implemented in Go. Calling, if it is set, takes the place of
+// Fn when the builtin is called by a program.
+type Builtin struct {
+	Fn      BuiltinFunction
+	Calling CallingFunction
+}
+
+// Call calls b with args. The functions b calls are called with call, so that they
+// run within the limits of the program calling b and count as calls it has in
+// progress.
+func (b *Builtin) Call(call Caller, args ...Object) Object {
+	if b.Calling != nil {
+		return b.Calling(call, args...)
+	}
+	return b.Fn(args...)
+}
+
+func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
+func (b *Builtin) Inspect() string  { return "builtin function" }
+
+// CompiledFunction is the bytecode of a function produced by the compiler.
+type CompiledFunction struct {
+	Name          string // The name the function was let bound to, if any
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+
+	// Locals holds the name of each local slot, for reporting the use of a local
+	// before its let has run.
+	Locals []string
+
+	// Lines maps the offsets of Instructions back to the source positions.
+	Lines code.LineTable
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Closure is a compiled function together with the free variables it captured when
+// it was created by the virtual machine. A local of an enclosing function is captured
+// as the Cell holding it, rather than as its value.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// Cell holds a local variable which closures have captured, so that the function
+// defining it and the closures all see the value its latest let gave it. Value is nil
+// until the first let has run.
+type Cell struct {
+	Name  string
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string {
+	return fmt.Sprintf("Cell[%p]", c)
+}
+
+// Module is a file of capuchin code loaded by an import statement, which gives the
+// importing program the values bound by its export statements.
+type Module struct {
+	Path    string // The file the module was loaded from
+	Exports map[string]Object
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string  { return "<module " + m.Path + ">" }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"capuchin/ast"
+	"capuchin/format"
+	"capuchin/object"
+	"capuchin/optimize"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// optimizeCommand prints each of the named scripts as it is after optimization. With
+// -dump it prints an outline of the syntax tree before and after instead. It exits
+// with 2 if a script could not be read or parsed.
+func optimizeCommand(args []string) int {
+	flags := flag.NewFlagSet("optimize", flag.ContinueOnError)
+	dump := flags.Bool("dump", false, "print the tree before and after optimizing")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: capuchin optimize [-dump] script.cap...")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		program, err := parseFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+
+		if !*dump {
+			optimize.Program(program, object.BuiltinNames()...)
+			fmt.Print(format.Program(program))
+			continue
+		}
+
+		fmt.Printf("%s: before\n%s", path, ast.Dump(program))
+		optimize.Program(program, object.BuiltinNames()...)
+		fmt.Printf("%s: after\n%s", path, ast.Dump(program))
+	}
+
+	return status
+}
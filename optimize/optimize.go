@@ -0,0 +1,396 @@
+// Package optimize rewrites capuchin programs into simpler programs which compute the
+// same results.
+//
+// The optimizer works on the syntax tree, before the program is compiled or run:
+//
+//   - prefix and infix expressions whose operands are literals are folded into their
+//     value, unless evaluating them would fail, so that the error still happens
+//   - if expressions whose condition is a literal are replaced by the branch taken
+//   - statements after a return or throw are removed
+//   - uses of a name let bound once to a literal are replaced by the literal
+//
+// Folding is done by the evaluator itself, so a folded value is always the value the
+// program would have computed.
+package optimize
+
+import (
+	"capuchin/ast"
+	"capuchin/build"
+	"capuchin/evaluator"
+	"capuchin/object"
+	"capuchin/resolver"
+	"capuchin/token"
+	"math"
+)
+
+// Program optimizes program in place. The builtins are the names predeclared by the
+// runtime, as for the resolver, which the optimizer runs first.
+func Program(program *ast.Program, builtins ...string) {
+	resolver.Resolve(program, builtins...)
+
+	o := &optimizer{constants: make(map[*ast.Identifier]ast.Expression)}
+	o.openScope(program.Statements, nil)
+	program.Statements = o.statements(program.Statements, true)
+	o.closeScope()
+}
+
+type optimizer struct {
+	// scopes holds, for the program and each enclosing function, how many times
+	// each name is declared in it by a let or as a parameter.
+	scopes []map[string]int
+
+	// constants maps the declarations which can be inlined to their literal value.
+	constants map[*ast.Identifier]ast.Expression
+}
+
+func (o *optimizer) openScope(body []ast.Statement, params []*ast.Identifier) {
+	decls := make(map[string]int)
+	for _, p := range params {
+		decls[p.Value]++
+	}
+	countLets(body, decls)
+	o.scopes = append(o.scopes, decls)
+}
+
+func (o *optimizer) closeScope() {
+	o.scopes = o.scopes[:len(o.scopes)-1]
+}
+
+// statements optimizes a list of statements. The list is the whole body of the
+// program or of a function when direct is set, and an if branch otherwise.
+func (o *optimizer) statements(stmts []ast.Statement, direct bool) []ast.Statement {
+	out := []ast.Statement{}
+
+	for i, stmt := range stmts {
+		last := i == len(stmts)-1
+
+		switch s := stmt.(type) {
+		case *ast.LetStatement:
+			o.let(s, direct)
+
+		case *ast.ExportStatement:
+			o.let(s.Statement, direct)
+
+		case *ast.ReturnStatement:
+			s.ReturnValue = o.expression(s.ReturnValue)
+			return append(out, s)
+
+		case *ast.ThrowStatement:
+			s.Value = o.expression(s.Value)
+			return append(out, s)
+
+		case *ast.ExpressionStatement:
+			s.Expression = o.expression(s.Expression)
+			if branch, ok := o.spliceable(s.Expression, last); ok {
+				out = append(out, branch...)
+				if len(branch) > 0 {
+					switch branch[len(branch)-1].(type) {
+					case *ast.ReturnStatement, *ast.ThrowStatement:
+						return out
+					}
+				}
+				continue
+			}
+
+		case *ast.BlockStatement:
+			s.Statements = o.statements(s.Statements, false)
+		}
+
+		out = append(out, stmt)
+	}
+
+	return out
+}
+
+// let optimizes the value of a let statement, recording it as a constant if it is a
+// literal bound to a name declared only once.
+func (o *optimizer) let(s *ast.LetStatement, direct bool) {
+	s.Value = o.expression(s.Value)
+	// A let inside a branch may not run, so only lets directly in the body are
+	// known to bind their name whenever it is used.
+	if direct && literal(s.Value) && o.scopes[len(o.scopes)-1][s.Name.Value] == 1 {
+		o.constants[s.Name] = s.Value
+	}
+}
+
+// spliceable reports whether exp, the expression of a statement, is an if whose
+// branch is known and can take the place of the statement, returning the branch's
+// statements. Branches share their enclosing scope, so this is safe when the
+// statement's value is unused or is the value of the branch's last statement.
+func (o *optimizer) spliceable(exp ast.Expression, last bool) ([]ast.Statement, bool) {
+	ie, ok := exp.(*ast.IfExpression)
+	if !ok {
+		return nil, false
+	}
+	cond, ok := truthiness(ie.Condition)
+	if !ok {
+		return nil, false
+	}
+
+	branch := ie.Alternative
+	if cond {
+		branch = ie.Consequence
+	}
+	if branch == nil || len(branch.Statements) == 0 {
+		return nil, !last
+	}
+
+	if last {
+		switch branch.Statements[len(branch.Statements)-1].(type) {
+		case *ast.ExpressionStatement, *ast.ReturnStatement, *ast.ThrowStatement:
+		default:
+			return nil, false
+		}
+	}
+	return branch.Statements, true
+}
+
+// expression optimizes exp and returns the expression to replace it with.
+func (o *optimizer) expression(exp ast.Expression) ast.Expression {
+	switch e := exp.(type) {
+	case *ast.Identifier:
+		// A use ahead of the let has a binding of its own and is left alone,
+		// as it would fail or see a different value.
+		if b := e.Binding; b != nil && b.Decl != nil && b.Decl.Binding == b {
+			if value, ok := o.constants[b.Decl]; ok {
+				return place(clone(value), e.Token.Pos)
+			}
+		}
+
+	case *ast.PrefixExpression:
+		e.Right = o.expression(e.Right)
+		if literal(e.Right) {
+			return fold(e)
+		}
+
+	case *ast.InfixExpression:
+		e.Left = o.expression(e.Left)
+		e.Right = o.expression(e.Right)
+		if literal(e.Left) && literal(e.Right) {
+			return fold(e)
+		}
+
+	case *ast.IfExpression:
+		e.Condition = o.expression(e.Condition)
+		if e.Consequence != nil {
+			e.Consequence.Statements = o.statements(e.Consequence.Statements, false)
+		}
+		if e.Alternative != nil {
+			e.Alternative.Statements = o.statements(e.Alternative.Statements, false)
+		}
+
+		if cond, ok := truthiness(e.Condition); ok {
+			branch := e.Alternative
+			if cond {
+				branch = e.Consequence
+			}
+			if value := single(branch); value != nil {
+				return value
+			}
+		}
+
+	case *ast.TryExpression:
+		for _, block := range []*ast.BlockStatement{e.Body, e.Catch, e.Finally} {
+			if block != nil {
+				block.Statements = o.statements(block.Statements, false)
+			}
+		}
+
+	case *ast.MemberExpression:
+		e.Object = o.expression(e.Object)
+
+	case *ast.IndexExpression:
+		e.Left = o.expression(e.Left)
+		e.Index = o.expression(e.Index)
+
+	case *ast.ArrayLiteral:
+		for i, el := range e.Elements {
+			e.Elements[i] = o.expression(el)
+		}
+
+	case *ast.HashLiteral:
+		for i, key := range e.Keys {
+			e.Keys[i] = o.expression(key)
+			e.Values[i] = o.expression(e.Values[i])
+		}
+
+	case *ast.FunctionLiteral:
+		if e.Body != nil {
+			o.openScope(e.Body.Statements, e.Parameters)
+			e.Body.Statements = o.statements(e.Body.Statements, true)
+			o.closeScope()
+		}
+
+	case *ast.CallExpression:
+		e.Function = o.expression(e.Function)
+		for i, arg := range e.Arguments {
+			e.Arguments[i] = o.expression(arg)
+		}
+	}
+
+	return exp
+}
+
+// fold evaluates an operator applied to literals, returning the literal result or
+// exp itself if the evaluation fails.
+func fold(exp ast.Expression) ast.Expression {
+	var value ast.Expression
+
+	switch result := evaluator.Eval(exp, object.NewEnvironment()).(type) {
+	case *object.Integer:
+		if result.Value == math.MinInt64 {
+			// There is no literal for it, as 9223372036854775808 overflows
+			return exp
+		}
+		value = build.Int(result.Value)
+	case *object.Boolean:
+		value = build.Bool(result.Value)
+	case *object.String:
+		value = build.String(result.Value)
+	default:
+		return exp
+	}
+
+	return place(value, ast.Pos(exp))
+}
+
+// literal reports whether exp is written as a literal: an integer, which may be
+// negative, a string or a boolean.
+func literal(exp ast.Expression) bool {
+	switch e := exp.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean:
+		return true
+	case *ast.PrefixExpression:
+		_, ok := e.Right.(*ast.IntegerLiteral)
+		return ok && e.Operator == "-"
+	}
+	return false
+}
+
+// truthiness returns whether the condition exp holds, if it is a literal.
+func truthiness(exp ast.Expression) (bool, bool) {
+	if b, ok := exp.(*ast.Boolean); ok {
+		return b.Value, true
+	}
+	return true, literal(exp)
+}
+
+// single returns the expression of a block consisting of one expression statement,
+// or nil.
+func single(block *ast.BlockStatement) ast.Expression {
+	if block == nil || len(block.Statements) != 1 {
+		return nil
+	}
+	if es, ok := block.Statements[0].(*ast.ExpressionStatement); ok {
+		return es.Expression
+	}
+	return nil
+}
+
+// clone copies a literal, so that each use has a node of its own.
+func clone(exp ast.Expression) ast.Expression {
+	switch e := exp.(type) {
+	case *ast.IntegerLiteral:
+		c := *e
+		return &c
+	case *ast.StringLiteral:
+		c := *e
+		return &c
+	case *ast.Boolean:
+		c := *e
+		return &c
+	case *ast.PrefixExpression:
+		c := *e
+		c.Right = clone(e.Right)
+		return &c
+	}
+	return exp
+}
+
+// place positions a literal built from scratch at pos, where the code it replaces
+// started.
+func place(exp ast.Expression, pos token.Position) ast.Expression {
+	switch e := exp.(type) {
+	case *ast.IntegerLiteral:
+		e.Token.Pos = pos
+	case *ast.StringLiteral:
+		e.Token.Pos = pos
+	case *ast.Boolean:
+		e.Token.Pos = pos
+	case *ast.PrefixExpression:
+		e.Token.Pos = pos
+		place(e.Right, token.Position{Line: pos.Line, Column: pos.Column + len(e.Operator)})
+	}
+	return exp
+}
+
+// countLets counts the let and import declarations of each name in stmts into
+// decls. Blocks share their enclosing scope, functions do not.
+func countLets(stmts []ast.Statement, decls map[string]int) {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.LetStatement:
+			decls[s.Name.Value]++
+			countLetsIn(s.Value, decls)
+		case *ast.ExportStatement:
+			countLets([]ast.Statement{s.Statement}, decls)
+		case *ast.ImportStatement:
+			decls[s.Name.Value]++
+		case *ast.ReturnStatement:
+			countLetsIn(s.ReturnValue, decls)
+		case *ast.ThrowStatement:
+			countLetsIn(s.Value, decls)
+		case *ast.ExpressionStatement:
+			countLetsIn(s.Expression, decls)
+		case *ast.BlockStatement:
+			countLets(s.Statements, decls)
+		}
+	}
+}
+
+func countLetsIn(exp ast.Expression, decls map[string]int) {
+	switch e := exp.(type) {
+	case *ast.PrefixExpression:
+		countLetsIn(e.Right, decls)
+	case *ast.InfixExpression:
+		countLetsIn(e.Left, decls)
+		countLetsIn(e.Right, decls)
+	case *ast.IfExpression:
+		countLetsIn(e.Condition, decls)
+		if e.Consequence != nil {
+			countLets(e.Consequence.Statements, decls)
+		}
+		if e.Alternative != nil {
+			countLets(e.Alternative.Statements, decls)
+		}
+	case *ast.TryExpression:
+		// The catch parameter is bound in the enclosing scope, like a let
+		if e.Parameter != nil {
+			decls[e.Parameter.Value]++
+		}
+		for _, block := range []*ast.BlockStatement{e.Body, e.Catch, e.Finally} {
+			if block != nil {
+				countLets(block.Statements, decls)
+			}
+		}
+	case *ast.MemberExpression:
+		countLetsIn(e.Object, decls)
+	case *ast.IndexExpression:
+		countLetsIn(e.Left, decls)
+		countLetsIn(e.Index, decls)
+	case *ast.ArrayLiteral:
+		for _, el := range e.Elements {
+			countLetsIn(el, decls)
+		}
+	case *ast.HashLiteral:
+		for i, key := range e.Keys {
+			countLetsIn(key, decls)
+			countLetsIn(e.Values[i], decls)
+		}
+	case *ast.CallExpression:
+		countLetsIn(e.Function, decls)
+		for _, arg := range e.Arguments {
+			countLetsIn(arg, decls)
+		}
+	}
+}
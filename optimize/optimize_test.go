@@ -0,0 +1,151 @@
+package optimize
+
+import (
+	"capuchin/ast"
+	"capuchin/evaluator"
+	"capuchin/format"
+	"capuchin/object"
+	"capuchin/parsertest"
+	"testing"
+)
+
+func TestOptimizations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// Folding
+		{"3 + 4 * 5 == 3 * 1 + 4 * 5", "true;\n"},
+		{"1 + 2 * x", "1 + 2 * x;\n"},
+		{"x * (2 + 3)", "x * 5;\n"},
+		{"2 - 7", "-5;\n"},
+		{"-(-3)", "3;\n"},
+		{"!true == false", "true;\n"},
+		{"9223372036854775807 + 1", "9223372036854775807 + 1;\n"},
+		{`"a" + "\tb" == "a\tb"`, "true;\n"},
+		{`let s = "a" + "b"; s + s`, "let s = \"ab\";\n\"abab\";\n"},
+		{`"a" - "b"`, "\"a\" - \"b\";\n"},
+		{"1 / 0", "1 / 0;\n"},
+		{"1 + true", "1 + true;\n"},
+		{`[1 + 1, {"a" + "b": 2 * 3}][0]`, "[2, {\"ab\": 6}][0];\n"},
+
+		// Conditions
+		{"let a = if (1 < 2) { 10 } else { 20 };", "let a = 10;\n"},
+		{"if (false) { x } else { y }", "y;\n"},
+		{"if (true) { let b = 2; b }", "let b = 2;\nb;\n"},
+		{"if (false) { x }; 1", "1;\n"},
+		{"if (false) { x }", "if (false) {\n\tx;\n}\n"},
+		{"if (true) { let c = 1; }", "if (true) {\n\tlet c = 1;\n}\n"},
+		{"if (x) { 1 + 1 } else { 2 * 3 }", "if (x) {\n\t2;\n} else {\n\t6;\n}\n"},
+
+		// Dead code
+		{"fn() { return 1; 2; 3 }", "fn() {\n\treturn 1;\n};\n"},
+		{"return 1; let a = 2;", "return 1;\n"},
+		{"fn() { if (true) { return 1; }; 2 }", "fn() {\n\treturn 1;\n};\n"},
+		{"throw 1 + 1; 2", "throw 2;\n"},
+		{"if (true) { throw 1; }; 2", "throw 1;\n"},
+		{"try { return 1; 2 } catch (e) { 2 * 3 }", "try {\n\treturn 1;\n} catch (e) {\n\t6;\n}\n"},
+
+		// Inlining
+		{"let x = 2 + 3; x * x", "let x = 5;\n25;\n"},
+		{"let x = 1; let f = fn(y) { x + y }; f", "let x = 1;\nlet f = fn(y) {\n\t1 + y;\n};\nf;\n"},
+		{"let x = 1; let x = 2; x", "let x = 1;\nlet x = 2;\nx;\n"},
+		{"let f = fn() { x }; let x = 1;", "let f = fn() {\n\tx;\n};\nlet x = 1;\n"},
+		{"if (c) { let x = 1; }; x", "if (c) {\n\tlet x = 1;\n}\nx;\n"},
+		{"let f = fn(x) { let x = 1; x }", "let f = fn(x) {\n\tlet x = 1;\n\tx;\n};\n"},
+		{"let e = 1; try { 2 } catch (e) { 3 }; e", "let e = 1;\ntry {\n\t2;\n} catch (e) {\n\t3;\n}\ne;\n"},
+		{"export let x = 2 * 3; x", "export let x = 6;\n6;\n"},
+		{"let i = 1; [i][i - 1]", "let i = 1;\n[1][0];\n"},
+		{`let m = 1; import "m" as m; m`, "let m = 1;\nimport \"m\" as m;\nm;\n"},
+	}
+
+	for _, tt := range tests {
+		program := parsertest.Parse(t, tt.input)
+		Program(program)
+
+		if got := format.Program(program); got != tt.expected {
+			t.Errorf("wrong optimization of %q.\nwant=%q\ngot =%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestPositionsAreKept(t *testing.T) {
+	program := parsertest.Parse(t, "let x = 1;\nfoo(2 * 3, x)")
+	Program(program)
+
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	if got := ast.Pos(call.Arguments[0]).String(); got != "2:5" {
+		t.Errorf("folded value has wrong position. want=2:5, got=%s", got)
+	}
+	if got := ast.Pos(call.Arguments[1]).String(); got != "2:12" {
+		t.Errorf("inlined value has wrong position. want=2:12, got=%s", got)
+	}
+}
+
+func TestInlinedStringPositions(t *testing.T) {
+	program := parsertest.Parse(t, "let s = \"ab\";\nlet a = s;\nlet b = s;")
+	Program(program)
+
+	want := []string{"1:9", "2:9", "3:9"}
+	if len(program.Statements) != len(want) {
+		t.Fatalf("wrong number of statements. want=%d, got=%d", len(want), len(program.Statements))
+	}
+	for i, stmt := range program.Statements {
+		let := stmt.(*ast.LetStatement)
+		if _, ok := let.Value.(*ast.StringLiteral); !ok {
+			t.Fatalf("let %s was not inlined. got=%T", let.Name.Value, let.Value)
+		}
+		if got := ast.Pos(let.Value).String(); got != want[i] {
+			t.Errorf("let %s has wrong position. want=%s, got=%s", let.Name.Value, want[i], got)
+		}
+	}
+}
+
+// TestSemanticsPreserved evaluates each program before and after optimization, which
+// must give the same value or the same error.
+func TestSemanticsPreserved(t *testing.T) {
+	programs := []string{
+		"3 + 4 * 5 == 3 * 1 + 4 * 5",
+		"let a = 5; let b = a * 2; a + b",
+		"let a = 1; let a = a + 1; a",
+		"let x = 2; let f = fn(y) { x * y }; f(21)",
+		"let f = fn() { g }; let r = f; let g = 3; r()",
+		"let f = fn(n) { if (n < 2) { return n; }; f(n - 1) + f(n - 2) }; f(10)",
+		"if (true) { let b = 2; b } ; b + 1",
+		"if (false) { 1 }",
+		"if (1) { 2 } else { 3 }",
+		"let f = fn() { if (true) { return 1; }; 2 }; f()",
+		"let f = fn() { return 1; undefined }; f()",
+		"1 / 0",
+		"5 + true",
+		"-true",
+		"let x = 9223372036854775807; x + 1",
+		"let x = 0; 10 / x",
+		"let f = fn(x) { let y = x; let x = 7; y + x }; f(1)",
+		"let c = fn() { if (false) { let z = 1; }; z }; c()",
+		"return 1 + 1; 3",
+		"let f = fn() { try { throw 1 + 1; 3 } catch (e) { e * 2 } }; f()",
+		"let x = 1; let r = try { throw 2; } catch (x) { x }; x + r",
+		"if (true) { throw 4 * 5; }; 6",
+		`let xs = [1, 2 * 3]; xs[1] + {"k": xs}["k"][0]`,
+		"let i = 2; [1, 2][i - 3]",
+		`if (true) { {"a": 1 + 1} }["a"]`,
+	}
+
+	for _, input := range programs {
+		expected := evaluator.Eval(parsertest.Parse(t, input), object.NewEnvironment())
+
+		program := parsertest.Parse(t, input)
+		Program(program, object.BuiltinNames()...)
+		got := evaluator.Eval(program, object.NewEnvironment())
+
+		if expected == nil || got == nil {
+			t.Errorf("%q: no value. before=%v, after=%v", input, expected, got)
+			continue
+		}
+		if got.Type() != expected.Type() || got.Inspect() != expected.Inspect() {
+			t.Errorf("%q: optimization changed the result. before=%s, after=%s (%s)",
+				input, expected.Inspect(), got.Inspect(), format.Program(program))
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"capuchin/ast"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// parseCommand prints the syntax tree of each of the named scripts as S-expressions,
+// or with -dump as an outline of the nodes with their positions. It exits with 2 if
+// a script could not be read or parsed.
+func parseCommand(args []string) int {
+	flags := flag.NewFlagSet("parse", flag.ContinueOnError)
+	dump := flags.Bool("dump", false, "print an outline of the tree with positions")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: capuchin parse [-dump] script.cap...")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		program, err := parseFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+
+		if *dump {
+			fmt.Print(ast.Dump(program))
+		} else {
+			fmt.Println(ast.Sexp(program))
+		}
+	}
+
+	return status
+}
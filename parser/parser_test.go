@@ -108,6 +108,28 @@ func TestIdentifierExpression(t *testing.T) {
 	}
 }
 
+func TestStringLiteralExpression(t *testing.T) {
+	input := `"hello \"world\"";`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.StringLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.StringLiteral. got=%T", stmt.Expression)
+	}
+	if literal.Value != `hello "world"` {
+		t.Errorf("literal.Value not %q. got=%q", `hello "world"`, literal.Value)
+	}
+	if literal.TokenLiteral() != `"hello \"world\""` {
+		t.Errorf("literal.TokenLiteral not %s. got=%s", `"hello \"world\""`,
+			literal.TokenLiteral())
+	}
+}
+
 func TestIntegerLiteralExpression(t *testing.T) {
 	input := "5;"
 
@@ -341,6 +363,26 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a + b + c * d / f + g)",
 			"add((((a + b) + ((c * d) / f)) + g))",
 		},
+		{
+			"-e.line + f(x).column",
+			"((-e.line) + f(x).column)",
+		},
+		{
+			"e.stack.first(1)",
+			"e.stack.first(1)",
+		},
+		{
+			"a * [1, 2, 3, 4][b * c] * d",
+			"((a * ([1, 2, 3, 4][(b * c)])) * d)",
+		},
+		{
+			"add(a * b[2], b[1], 2 * [1, 2][1])",
+			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
+		},
+		{
+			"-xs[0] + h.list[1](x)",
+			"((-(xs[0])) + (h.list[1])(x))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -670,6 +712,367 @@ func TestCallExpressionParameterParsing(t *testing.T) {
 	}
 }
 
+func TestParsingArrayLiterals(t *testing.T) {
+	input := "[1, 2 * 2, 3 + 3]"
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+	array, ok := stmt.Expression.(*ast.ArrayLiteral)
+	if !ok {
+		t.Fatalf("exp not ast.ArrayLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(array.Elements) != 3 {
+		t.Fatalf("len(array.Elements) not 3. got=%d", len(array.Elements))
+	}
+
+	testIntegerLiteral(t, array.Elements[0], 1)
+	testInfixExpression(t, array.Elements[1], 2, "*", 2)
+	testInfixExpression(t, array.Elements[2], 3, "+", 3)
+}
+
+func TestParsingIndexExpressions(t *testing.T) {
+	input := "myArray[1 + 1]"
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+	indexExp, ok := stmt.Expression.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.IndexExpression. got=%T", stmt.Expression)
+	}
+
+	if !testIdentifier(t, indexExp.Left, "myArray") {
+		return
+	}
+	testInfixExpression(t, indexExp.Index, 1, "+", 1)
+}
+
+func TestParsingHashLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"{}", "{}"},
+		{`{"one": 1, "two": 2, "three": 3}`, `{"one": 1, "two": 2, "three": 3}`},
+		{`{true: 1, 2: "two"}`, `{true: 1, 2: "two"}`},
+		{`{"one": 0 + 1, "two": 10 - 8}`, `{"one": (0 + 1), "two": (10 - 8)}`},
+		{`{"xs": [1, {}], "f": fn(x) { x }}`, `{"xs": [1, {}], "f": fn(x) x}`},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+				program.Statements[0])
+		}
+		hash, ok := stmt.Expression.(*ast.HashLiteral)
+		if !ok {
+			t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
+		}
+		if len(hash.Keys) != len(hash.Values) {
+			t.Fatalf("hash has %d keys but %d values", len(hash.Keys), len(hash.Values))
+		}
+		if got := hash.String(); got != tt.expected {
+			t.Errorf("wrong hash. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestCollectionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[1, 2", "1:6: expected next token to be ], but got EOF instead."},
+		{"xs[1", "1:5: expected next token to be ], but got EOF instead."},
+		{`{"a" 1}`, "1:6: expected next token to be :, but got INT instead."},
+		{`{"a": 1 "b": 2}`, "1:9: expected next token to be ,, but got STRING instead."},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errs := p.Errors()
+		if len(errs) == 0 {
+			t.Errorf("%q: expected parser errors", tt.input)
+			continue
+		}
+		if errs[0] != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected, errs[0])
+		}
+	}
+}
+
+func TestTypeAnnotationParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: int = 5;", "let x: int = 5;"},
+		{"let xs: [int] = y;", "let xs: [int] = y;"},
+		{"let h: {string: [bool]} = y;", "let h: {string: [bool]} = y;"},
+		{"let f: fn(int, int) -> bool = g;", "let f: fn(int, int) -> bool = g;"},
+		{"let f: fn() -> fn(int) -> int = g;", "let f: fn() -> fn(int) -> int = g;"},
+		{"fn(a: int, b: string) -> bool { a }", "fn(a: int, b: string) -> bool a"},
+		{"fn(a, b: int) { a }", "fn(a, b: int) a"},
+		{"fn(a) -> {int: int} { a }", "fn(a) -> {int: int} a"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	program := New(lexer.New("fn(a, b: int, c) { a }")).ParseProgram()
+	function := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+
+	if len(function.ParameterTypes) != 3 {
+		t.Fatalf("function.ParameterTypes wrong length. want=3, got=%d",
+			len(function.ParameterTypes))
+	}
+	if function.ParameterType(0) != nil || function.ParameterType(2) != nil {
+		t.Errorf("unannotated parameters should have nil types. got=%v",
+			function.ParameterTypes)
+	}
+	if named, ok := function.ParameterType(1).(*ast.NamedType); !ok || named.Name != "int" {
+		t.Errorf("parameter b should be annotated with int. got=%v", function.ParameterType(1))
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	input := `try { throw x; } catch (e) { e.message } finally { y }`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	exp, ok := stmt.Expression.(*ast.TryExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.TryExpression. got=%T", stmt.Expression)
+	}
+
+	if len(exp.Body.Statements) != 1 {
+		t.Fatalf("body is not 1 statements. got=%d", len(exp.Body.Statements))
+	}
+	throw, ok := exp.Body.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("Body.Statements[0] is not ast.ThrowStatement. got=%T", exp.Body.Statements[0])
+	}
+	if !testIdentifier(t, throw.Value, "x") {
+		return
+	}
+
+	if !testIdentifier(t, exp.Parameter, "e") {
+		return
+	}
+	if got := exp.Catch.String(); got != "e.message" {
+		t.Errorf("wrong catch block. want=%q, got=%q", "e.message", got)
+	}
+	member, ok := exp.Catch.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MemberExpression)
+	if !ok {
+		t.Fatalf("catch expression is not ast.MemberExpression. got=%T",
+			exp.Catch.Statements[0].(*ast.ExpressionStatement).Expression)
+	}
+	if !testIdentifier(t, member.Object, "e") || member.Property.Value != "message" {
+		t.Errorf("wrong member expression. got=%s", member)
+	}
+
+	if got := exp.Finally.String(); got != "y" {
+		t.Errorf("wrong finally block. want=%q, got=%q", "y", got)
+	}
+}
+
+func TestTryExpressionClauses(t *testing.T) {
+	tests := []struct {
+		input        string
+		catch        bool
+		finally      bool
+		expectedText string
+	}{
+		{"try { a } catch (e) { b }", true, false, "try acatch(e) b"},
+		{"try { a } finally { c }", false, true, "try afinally c"},
+		{"let x = try { a } catch (e) { b } finally { c };", true, true,
+			"let x = try acatch(e) bfinally c;"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.expectedText {
+			t.Errorf("%q: wrong program. want=%q, got=%q", tt.input, tt.expectedText, got)
+		}
+	}
+}
+
+func TestExceptionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { a }", "1:10: expected next token to be CATCH or FINALLY, but got EOF instead."},
+		{"try { a } catch { b }", "1:17: expected next token to be (, but got { instead."},
+		{"try { a } catch (1) { b }", "1:18: expected next token to be IDENT, but got INT instead."},
+		{"try a", "1:5: expected next token to be {, but got IDENT instead."},
+		{"e.1", "1:3: expected next token to be IDENT, but got INT instead."},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errs := p.Errors()
+		if len(errs) == 0 {
+			t.Errorf("%q: expected parser errors", tt.input)
+			continue
+		}
+		if errs[0] != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected, errs[0])
+		}
+	}
+}
+
+func TestModuleStatements(t *testing.T) {
+	input := `import "lib/math" as m;
+export let add = fn(a, b) { m.sum(a, b) };`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d",
+			len(program.Statements))
+	}
+
+	imp, ok := program.Statements[0].(*ast.ImportStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ImportStatement. got=%T",
+			program.Statements[0])
+	}
+	if imp.Path.Value != "lib/math" {
+		t.Errorf("wrong import path. want=%q, got=%q", "lib/math", imp.Path.Value)
+	}
+	if !testIdentifier(t, imp.Name, "m") {
+		return
+	}
+
+	exp, ok := program.Statements[1].(*ast.ExportStatement)
+	if !ok {
+		t.Fatalf("program.Statements[1] is not ast.ExportStatement. got=%T",
+			program.Statements[1])
+	}
+	if !testLetStatement(t, exp.Statement, "add") {
+		return
+	}
+
+	expected := `import "lib/math" as m;export let add = fn(a, b) m.sum(a, b);`
+	if got := program.String(); got != expected {
+		t.Errorf("wrong program. want=%q, got=%q", expected, got)
+	}
+}
+
+func TestModuleErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"import m;", "1:8: expected next token to be STRING, but got IDENT instead."},
+		{`import "m";`, "1:11: expected next token to be AS, but got ; instead."},
+		{`import "m" as 1;`, "1:15: expected next token to be IDENT, but got INT instead."},
+		{`import "\q" as m;`, `1:8: unknown escape sequence \q in "\q"`},
+		{"export x;", "1:8: expected next token to be LET, but got IDENT instead."},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errs := p.Errors()
+		if len(errs) == 0 {
+			t.Errorf("%q: expected parser errors", tt.input)
+			continue
+		}
+		if errs[0] != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected, errs[0])
+		}
+	}
+}
+
+func TestTypeAnnotationErrors(t *testing.T) {
+	tests := []string{
+		"let x: = 5;",
+		"let x: [int = 5;",
+		"let x: {int} = 5;",
+		"let f: fn(int) = g;",
+		"fn(a:) { a }",
+		"fn(a) -> { a }",
+	}
+
+	for _, input := range tests {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q", input)
+		}
+	}
+}
+
+func TestParsingIgnoresLayout(t *testing.T) {
+	compact := "let add = fn(x, y) { return x + y; }; add(1, 2 * 3);"
+	spread := `let add = fn(x, y) {
+		return (x + y);
+	};
+
+	add(1, (2 * 3))`
+
+	a := New(lexer.New(compact)).ParseProgram()
+	b := New(lexer.New(spread)).ParseProgram()
+
+	opts := ast.CompareOptions{IgnorePositions: true, All: true}
+	for _, d := range ast.Diff(a, b, opts) {
+		t.Errorf("programs differ at %s", d)
+	}
+}
+
 func testLetStatement(t *testing.T, s ast.Statement, name string) bool {
 	if s.TokenLiteral() != "let" {
 		t.Errorf("s.TokenLiteral not 'let'. got=%q", s.TokenLiteral())
@@ -0,0 +1,22 @@
+// Package parsertest provides the helper shared by the tests of the packages which
+// work on parsed programs, such as the formatter, the resolver and the linter.
+package parsertest
+
+import (
+	"capuchin/ast"
+	"capuchin/lexer"
+	"capuchin/parser"
+	"testing"
+)
+
+// Parse parses input, stopping the test t if it has syntax errors.
+func Parse(t testing.TB, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("parser errors for %q: %v", input, errs)
+	}
+	return program
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"capuchin/repl"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+)
+
+// replCommand starts an interactive session on the terminal, first greeting the user
+// unless -q is given.
+func replCommand(args []string) int {
+	flags := flag.NewFlagSet("repl", flag.ContinueOnError)
+	quiet := flags.Bool("q", false, "do not print the welcome banner")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: capuchin repl [-q]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if !*quiet {
+		fmt.Println(banner())
+	}
+	repl.Start(os.Stdin, os.Stdout)
+	return 0
+}
+
+// banner returns the greeting shown when the REPL starts, naming the user if they
+// can be found.
+func banner() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		name = u.Username
+	}
+
+	if name == "" {
+		return "Welcome, this is the Capuchin REPL:"
+	}
+	return fmt.Sprintf("Welcome %s, this is the Capuchin REPL:", name)
+}
@@ -0,0 +1,152 @@
+package repl
+
+import (
+	"capuchin/ast"
+	"capuchin/object"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// command is a REPL command, run by entering its name after a colon.
+type command struct {
+	name  string
+	usage string // The command's argument, if it takes one
+	help  string
+	run   func(s *session, arg string)
+}
+
+// commands lists the REPL's commands in the order :help shows them. It is filled in
+// by init, as :help refers to it.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"eval", "", "run each entry and show its value (the default)",
+			func(s *session, arg string) { s.setMode(evalMode) }},
+		{"tokens", "", "toggle showing the tokens of each entry instead of running it",
+			func(s *session, arg string) { s.setMode(tokensMode) }},
+		{"ast", "", "toggle showing the syntax tree of each entry instead of running it",
+			func(s *session, arg string) { s.setMode(astMode) }},
+		{"bytecode", "", "toggle showing the bytecode of each entry instead of running it",
+			func(s *session, arg string) { s.setMode(bytecodeMode) }},
+		{"env", "", "list the bindings made in the session",
+			(*session).listBindings},
+		{"load", "<file>", "run a file in the session",
+			(*session).load},
+		{"reset", "", "forget every binding made in the session",
+			func(s *session, arg string) {
+				s.reset()
+				fmt.Fprintln(s.output, "session reset")
+			}},
+		{"type", "<expression>", "show the type inferred for an expression",
+			(*session).showType},
+		{"time", "", "toggle showing how long each entry takes",
+			func(s *session, arg string) {
+				s.timing = !s.timing
+				fmt.Fprintf(s.output, "timing: %t\n", s.timing)
+			}},
+		{"help", "", "list the commands",
+			(*session).help},
+	}
+}
+
+// isCommand reports whether a line of input is a command rather than an entry.
+func isCommand(line string) bool {
+	return strings.HasPrefix(line, ":")
+}
+
+// command runs the command line.
+func (s *session) command(line string) {
+	name, arg, _ := strings.Cut(strings.TrimPrefix(line, ":"), " ")
+	arg = strings.TrimSpace(arg)
+
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if c.usage != "" && arg == "" {
+			fmt.Fprintf(s.output, "usage: :%s %s\n", c.name, c.usage)
+			return
+		}
+		c.run(s, arg)
+		return
+	}
+
+	fmt.Fprintf(s.output, "unknown command :%s, :help lists the commands\n", name)
+}
+
+// setMode switches the session to m, or back to evaluating entries if it is already
+// in m.
+func (s *session) setMode(m mode) {
+	if s.mode == m {
+		m = evalMode
+	}
+	s.mode = m
+	fmt.Fprintf(s.output, "mode: %s\n", modeNames[m])
+}
+
+// listBindings writes each binding made in the session with its value.
+func (s *session) listBindings(arg string) {
+	builtins := map[string]bool{}
+	for _, name := range object.BuiltinNames() {
+		builtins[name] = true
+	}
+
+	for _, name := range s.env.Names() {
+		if builtins[name] {
+			continue
+		}
+		value, _ := s.env.Get(name)
+		fmt.Fprintf(s.output, "%s = %s\n", name, value.Inspect())
+	}
+}
+
+// load runs the file at path in the session, whatever its mode.
+func (s *session) load(path string) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(s.output, "cannot load %s: %s\n", path, err)
+		return
+	}
+	s.evaluate(string(source), path)
+}
+
+// showType writes the type inferred for the expression source, given the bindings
+// made so far.
+func (s *session) showType(source string) {
+	program := s.parse(source)
+	if program == nil {
+		return
+	}
+
+	var exp ast.Expression
+	if len(program.Statements) == 1 {
+		if es, ok := program.Statements[0].(*ast.ExpressionStatement); ok {
+			exp = es.Expression
+		}
+	}
+	if exp == nil {
+		fmt.Fprintln(s.output, "usage: :type <expression>")
+		return
+	}
+
+	info, diags := s.checker.Check(program)
+	for _, d := range diags {
+		fmt.Fprintf(s.output, "\t%s\n", d)
+	}
+	if len(diags) == 0 {
+		fmt.Fprintln(s.output, info.TypeOf(exp))
+	}
+}
+
+// help writes the list of commands.
+func (s *session) help(arg string) {
+	for _, c := range commands {
+		name := ":" + c.name
+		if c.usage != "" {
+			name += " " + c.usage
+		}
+		fmt.Fprintf(s.output, "  %-20s %s\n", name, c.help)
+	}
+}
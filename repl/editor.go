@@ -0,0 +1,455 @@
+package repl
+
+import (
+	"bufio"
+	"capuchin/highlight"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// maxHistory is the number of entries kept in the history.
+const maxHistory = 1000
+
+// editor reads lines from a terminal, letting the user edit them as they type:
+//
+//   - left and right, or Ctrl-B and Ctrl-F, move the cursor, and Home and End, or
+//     Ctrl-A and Ctrl-E, move it to the start and end of the line
+//   - Backspace and Delete delete a character, Ctrl-W the word before the cursor,
+//     Ctrl-U everything before it and Ctrl-K everything after it
+//   - up and down, or Ctrl-P and Ctrl-N, step through the history, and Ctrl-R
+//     searches it
+//   - Tab completes the name before the cursor
+//   - Ctrl-C abandons the line, and Ctrl-D on an empty line ends the input
+//
+// The line is coloured as it is typed, unless the NO_COLOR environment variable is
+// set.
+//
+// Entries are added to the history whole, so an entry typed over several lines is
+// recalled as one line, which can be edited like any other. Newlines separate
+// tokens no differently from spaces, so the entry still means the same.
+type editor struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	// raw puts the terminal in raw mode, so that keys are read as they are
+	// pressed, returning a function restoring it. It is nil if no change is needed.
+	raw func() (restore func(), err error)
+
+	// complete returns the completions of a name, or of a command if the prefix
+	// starts with a colon.
+	complete func(prefix string) []string
+
+	// highlight returns the line with the escape sequences colouring it, or is nil
+	// to show lines as they are.
+	highlight func(line string) string
+
+	history     []string
+	historyFile string // The file entries are appended to, if any
+
+	// The line being edited
+	prompt string
+	buf    []rune
+	cursor int
+
+	// The entry of the history shown, which is len(history) for the new line, and
+	// the new line as it was before stepping into the history.
+	position int
+	draft    []rune
+}
+
+// newEditor returns an editor reading from the terminal in, whose history is kept
+// in historyFile if it is not empty.
+func newEditor(in *os.File, out io.Writer, historyFile string, complete func(string) []string) *editor {
+	e := &editor{
+		in:          bufio.NewReader(in),
+		out:         out,
+		raw:         func() (func(), error) { return makeRaw(int(in.Fd())) },
+		complete:    complete,
+		historyFile: historyFile,
+	}
+	if os.Getenv("NO_COLOR") == "" {
+		e.highlight = func(line string) string {
+			var out strings.Builder
+			highlight.ANSI(&out, line, highlight.DefaultTheme)
+			return out.String()
+		}
+	}
+	e.loadHistory()
+	return e
+}
+
+// historyFile returns the file the REPL keeps its history in: $CAPUCHIN_HISTORY, or
+// .capuchin_history in the user's home directory. It returns "" if there is neither.
+func historyFile() string {
+	if path := os.Getenv("CAPUCHIN_HISTORY"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".capuchin_history")
+}
+
+// ctrl returns the character sent by pressing Ctrl with the letter key.
+func ctrl(key byte) rune {
+	return rune(key & 0x1f)
+}
+
+func (e *editor) readLine(prompt string) (string, error) {
+	if e.raw != nil {
+		restore, err := e.raw()
+		if err != nil {
+			return "", err
+		}
+		defer restore()
+	}
+
+	e.prompt, e.buf, e.cursor = prompt, nil, 0
+	e.position, e.draft = len(e.history), nil
+	e.refresh()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			return e.accept(), nil
+		case ctrl('C'):
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupt
+		case ctrl('D'):
+			if len(e.buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			e.delete(e.cursor, e.cursor+1)
+		case ctrl('A'):
+			e.cursor = 0
+		case ctrl('E'):
+			e.cursor = len(e.buf)
+		case ctrl('B'):
+			e.move(-1)
+		case ctrl('F'):
+			e.move(1)
+		case ctrl('P'):
+			e.recall(e.position - 1)
+		case ctrl('N'):
+			e.recall(e.position + 1)
+		case ctrl('H'), 127:
+			e.delete(e.cursor-1, e.cursor)
+		case ctrl('W'):
+			e.delete(e.wordStart(), e.cursor)
+		case ctrl('U'):
+			e.delete(0, e.cursor)
+		case ctrl('K'):
+			e.delete(e.cursor, len(e.buf))
+		case ctrl('R'):
+			if e.search() {
+				return e.accept(), nil
+			}
+		case '\t':
+			e.completeWord()
+		case 27:
+			e.escape(e.readEscape())
+		default:
+			if unicode.IsPrint(r) {
+				e.insert([]rune{r})
+			}
+		}
+
+		e.refresh()
+	}
+}
+
+// accept ends the line being edited, returning it.
+func (e *editor) accept() string {
+	e.cursor = len(e.buf)
+	e.refresh()
+	fmt.Fprint(e.out, "\r\n")
+	return string(e.buf)
+}
+
+// readEscape reads the rest of an escape sequence, such as "[A" for the up key.
+func (e *editor) readEscape() string {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return ""
+	}
+
+	seq := []rune{r}
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return ""
+		}
+		seq = append(seq, r)
+		// Parameters are digits separated by semicolons, up to a final letter or ~
+		if !('0' <= r && r <= '9') && r != ';' {
+			return string(seq)
+		}
+	}
+}
+
+// escape carries out the key sent as the escape sequence seq.
+func (e *editor) escape(seq string) {
+	switch seq {
+	case "[A", "OA":
+		e.recall(e.position - 1)
+	case "[B", "OB":
+		e.recall(e.position + 1)
+	case "[C", "OC":
+		e.move(1)
+	case "[D", "OD":
+		e.move(-1)
+	case "[H", "OH", "[1~", "[7~":
+		e.cursor = 0
+	case "[F", "OF", "[4~", "[8~":
+		e.cursor = len(e.buf)
+	case "[3~":
+		e.delete(e.cursor, e.cursor+1)
+	}
+}
+
+// refresh redraws the line, leaving the terminal's cursor at the editor's.
+func (e *editor) refresh() {
+	line := string(e.buf)
+	if e.highlight != nil {
+		line = e.highlight(line)
+	}
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", e.prompt, line)
+	if back := len(e.buf) - e.cursor; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+func (e *editor) move(n int) {
+	if c := e.cursor + n; c >= 0 && c <= len(e.buf) {
+		e.cursor = c
+	}
+}
+
+func (e *editor) insert(text []rune) {
+	buf := append([]rune{}, e.buf[:e.cursor]...)
+	buf = append(buf, text...)
+	e.buf = append(buf, e.buf[e.cursor:]...)
+	e.cursor += len(text)
+}
+
+// delete removes the characters from start up to end, where they are on the line.
+func (e *editor) delete(start, end int) {
+	start, end = max(start, 0), min(end, len(e.buf))
+	if start >= end {
+		return
+	}
+	e.buf = append(e.buf[:start], e.buf[end:]...)
+	if e.cursor > end {
+		e.cursor -= end - start
+	} else if e.cursor > start {
+		e.cursor = start
+	}
+}
+
+// wordStart returns where the word before the cursor starts, skipping the spaces
+// between them.
+func (e *editor) wordStart() int {
+	i := e.cursor
+	for i > 0 && unicode.IsSpace(e.buf[i-1]) {
+		i--
+	}
+	for i > 0 && !unicode.IsSpace(e.buf[i-1]) {
+		i--
+	}
+	return i
+}
+
+// recall shows the i-th entry of the history in place of the line.
+func (e *editor) recall(i int) {
+	if i < 0 || i > len(e.history) || i == e.position {
+		return
+	}
+	if e.position == len(e.history) {
+		e.draft = e.buf
+	}
+
+	e.position = i
+	if i == len(e.history) {
+		e.buf = e.draft
+	} else {
+		e.buf = []rune(e.history[i])
+	}
+	e.cursor = len(e.buf)
+}
+
+// search searches the history backwards for the text typed, showing the latest
+// entry containing it. Ctrl-R steps to the entry before. Enter takes the entry and
+// reports true so that it is run, Ctrl-G or Ctrl-C give up the search, and any
+// other key takes the entry for editing.
+func (e *editor) search() bool {
+	var query []rune
+	match := len(e.history)
+
+	// find returns the latest entry before from containing the query.
+	find := func(from int) int {
+		for i := min(from, len(e.history)) - 1; i >= 0; i-- {
+			if strings.Contains(e.history[i], string(query)) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	for {
+		found := ""
+		if match >= 0 && match < len(e.history) {
+			found = e.history[match]
+		}
+		fmt.Fprintf(e.out, "\r(search)`%s': %s\x1b[K", string(query), found)
+
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return false
+		}
+
+		switch {
+		case r == ctrl('G') || r == ctrl('C'):
+			return false
+		case r == ctrl('R'):
+			if m := find(match); m >= 0 {
+				match = m
+			}
+			continue
+		case r == ctrl('H') || r == 127:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				match = find(len(e.history))
+			}
+			continue
+		case unicode.IsPrint(r):
+			query = append(query, r)
+			match = find(match + 1)
+			continue
+		}
+
+		if found != "" {
+			e.buf = []rune(found)
+			e.cursor = len(e.buf)
+			e.position = match
+		}
+		if r == 27 {
+			e.readEscape()
+		}
+		return r == '\r' || r == '\n'
+	}
+}
+
+// completeWord completes the name before the cursor. If several names could follow
+// it, it is extended as far as they agree, and if they already differ they are
+// listed.
+func (e *editor) completeWord() {
+	if e.complete == nil {
+		return
+	}
+
+	start := e.cursor
+	for start > 0 && isNameRune(e.buf[start-1]) {
+		start--
+	}
+	if start == 1 && e.buf[0] == ':' {
+		start = 0
+	}
+	prefix := string(e.buf[start:e.cursor])
+	if prefix == "" {
+		return
+	}
+
+	matches := e.complete(prefix)
+	if len(matches) == 0 {
+		return
+	}
+
+	common := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, common) {
+			common = common[:len(common)-1]
+		}
+	}
+
+	if len(common) > len(prefix) {
+		e.insert([]rune(common[len(prefix):]))
+	} else if len(matches) > 1 {
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(matches, "  "))
+	}
+}
+
+// isNameRune reports whether r may appear in an identifier.
+func isNameRune(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || r == '_'
+}
+
+// remember adds entry to the history, and to the history file, unless it is empty
+// or repeats the entry before.
+func (e *editor) remember(entry string) {
+	entry = strings.TrimSpace(strings.ReplaceAll(entry, "\n", " "))
+	if entry == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == entry) {
+		return
+	}
+
+	e.history = append(e.history, entry)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+
+	if e.historyFile == "" {
+		return
+	}
+	// The history is a convenience, so a file which cannot be written is ignored
+	f, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, entry)
+}
+
+// loadHistory reads the latest entries of the history file.
+func (e *editor) loadHistory() {
+	if e.historyFile == "" {
+		return
+	}
+	data, err := os.ReadFile(e.historyFile)
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// completions returns the names starting with prefix, sorted and without repeats.
+func completions(prefix string, names []string) []string {
+	seen := map[string]bool{}
+	matches := []string{}
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) && !seen[name] {
+			seen[name] = true
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
@@ -0,0 +1,205 @@
+package repl
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testEditor returns an editor reading the keys in input, with the history given.
+func testEditor(input string, history ...string) (*editor, *bytes.Buffer) {
+	var out bytes.Buffer
+	e := &editor{
+		in:  bufio.NewReader(strings.NewReader(input)),
+		out: &out,
+		complete: func(prefix string) []string {
+			return completions(prefix, []string{"let", "len", "puts", "length", ":type", ":tokens"})
+		},
+		history: history,
+	}
+	return e, &out
+}
+
+func TestEditor(t *testing.T) {
+	history := []string{"let a = 1;", "puts(a)", "let b = 2;"}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"abc\r", "abc"},
+		{"abc\n", "abc"},
+		{"abc\x1b[D\x1b[DX\r", "aXbc"},
+		{"abc\x02\x02\x06X\r", "abXc"},
+		{"abc\x01X\x05Y\r", "XabcY"},
+		{"abc\x1b[HX\x1b[FY\r", "XabcY"},
+		{"abc\x1b[D\x1b[C\x1b[CX\r", "abcX"},
+		{"abc\x7f\r", "ab"},
+		{"abc\x08\x08\r", "a"},
+		{"\x7fa\r", "a"},
+		{"abc\x1b[D\x1b[3~\r", "ab"},
+		{"abc\x01\x04\r", "bc"},
+		{"let x = 1\x17\x17\r", "let x "},
+		{"abc\x1b[D\x15\r", "c"},
+		{"abc\x1b[D\x0b\r", "ab"},
+		{"é\x7fü\r", "ü"},
+
+		// History
+		{"\x1b[A\r", "let b = 2;"},
+		{"\x1b[A\x1b[A\r", "puts(a)"},
+		{"\x1b[A\x1b[A\x1b[A\x1b[A\r", "let a = 1;"},
+		{"\x1b[A\x1b[A\x1b[B\r", "let b = 2;"},
+		{"x\x1b[A\x1b[B\r", "x"},
+		{"\x10\x10\x0e\r", "let b = 2;"},
+		{"\x1bOA!\r", "let b = 2;!"},
+		{"\x12let\r", "let b = 2;"},
+		{"\x12let\x12\r", "let a = 1;"},
+		{"\x12let\x12\x12\r", "let a = 1;"},
+		{"\x12put\x1b[C!\r", "puts(a)!"},
+		{"\x12z\x7fa = \r", "let a = 1;"},
+		{"x\x12let\x07\r", "x"},
+		{"x\x12nothing\x01\r", "x"},
+
+		// Completion
+		{"pu\t(1)\r", "puts(1)"},
+		{"x = le\t\r", "x = le"},
+		{"x = leng\t\r", "x = length"},
+		{":ty\t\r", ":type"},
+		{":to\t\r", ":tokens"},
+		{":t\t\r", ":t"},
+		{"z\t\r", "z"},
+	}
+
+	for _, tt := range tests {
+		e, _ := testEditor(tt.input, history...)
+		line, err := e.readLine(">>")
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.input, err)
+			continue
+		}
+		if line != tt.expected {
+			t.Errorf("wrong line for %q. want=%q, got=%q", tt.input, tt.expected, line)
+		}
+	}
+}
+
+func TestEditorEnds(t *testing.T) {
+	e, out := testEditor("abc\x03\x04")
+
+	if _, err := e.readLine(">>"); err != errInterrupt {
+		t.Errorf("Ctrl-C wrong. want=%v, got=%v", errInterrupt, err)
+	}
+	if _, err := e.readLine(">>"); err != io.EOF {
+		t.Errorf("Ctrl-D wrong. want=%v, got=%v", io.EOF, err)
+	}
+	if !strings.Contains(out.String(), "^C\r\n") {
+		t.Errorf("interrupt not shown. got=%q", out.String())
+	}
+}
+
+func TestEditorListsCompletions(t *testing.T) {
+	e, out := testEditor("le\t\r")
+	e.readLine(">>")
+
+	if !strings.Contains(out.String(), "\r\nlen  length  let\r\n") {
+		t.Errorf("completions not listed. got=%q", out.String())
+	}
+}
+
+func TestEditorRedraws(t *testing.T) {
+	e, out := testEditor("ab\x1b[D\r")
+	e.readLine(">>")
+
+	expected := "\r>>\x1b[K" + "\r>>a\x1b[K" + "\r>>ab\x1b[K" + "\r>>ab\x1b[K\x1b[1D" + "\r>>ab\x1b[K\r\n"
+	if out.String() != expected {
+		t.Errorf("wrong output. want=%q, got=%q", expected, out.String())
+	}
+}
+
+func TestEditorHighlights(t *testing.T) {
+	e, out := testEditor("let\r")
+	e.highlight = func(line string) string { return strings.ToUpper(line) }
+	e.readLine(">>")
+
+	if !strings.HasSuffix(out.String(), "\r>>LET\x1b[K\r\n") {
+		t.Errorf("line not highlighted. got=%q", out.String())
+	}
+}
+
+func TestReaderAbandonsEntry(t *testing.T) {
+	e, _ := testEditor("let f = fn(x) {\r\x03x\r")
+	r := &reader{lines: e, output: io.Discard}
+
+	if entry, ok := r.read(); !ok || entry != "x" {
+		t.Errorf("wrong entry. want=%q, got=%q", "x", entry)
+	}
+}
+
+func TestHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(path, []byte("let a = 1;\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	e, _ := testEditor("")
+	e.historyFile = path
+	e.loadHistory()
+	e.remember("let f = fn(x) {\n  x\n};")
+	e.remember("let f = fn(x) {\n  x\n};")
+	e.remember("  ")
+
+	expected := []string{"let a = 1;", "let f = fn(x) {   x };"}
+	if !reflect.DeepEqual(e.history, expected) {
+		t.Errorf("wrong history. want=%q, got=%q", expected, e.history)
+	}
+
+	// A new session sees the entries of the one before
+	next, _ := testEditor("")
+	next.historyFile = path
+	next.loadHistory()
+	if !reflect.DeepEqual(next.history, expected) {
+		t.Errorf("wrong history loaded. want=%q, got=%q", expected, next.history)
+	}
+}
+
+func TestSessionCompletions(t *testing.T) {
+	var output bytes.Buffer
+	s := newSession(&output)
+	s.evaluate("let letter = 1; let total = 2;", "")
+
+	tests := []struct {
+		prefix   string
+		expected []string
+	}{
+		{"le", []string{"len", "let", "letter"}},
+		{"pu", []string{"push", "puts"}},
+		{"t", []string{"throw", "total", "trim", "true", "try"}},
+		{"spl", []string{"split"}},
+		{":t", []string{":time", ":tokens", ":type"}},
+		{"q", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := s.complete(tt.prefix); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("wrong completions of %q. want=%q, got=%q", tt.prefix, tt.expected, got)
+		}
+	}
+}
+
+func TestNonTerminalInput(t *testing.T) {
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	s := newSession(io.Discard)
+	if _, ok := s.lineReader(f).(*scannerLines); !ok {
+		t.Errorf("input which is not a terminal is not read a line at a time")
+	}
+}
@@ -0,0 +1,133 @@
+package repl
+
+import (
+	"bufio"
+	"capuchin/lexer"
+	"capuchin/token"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// lineReader reads lines of input, after showing a prompt.
+type lineReader interface {
+	// readLine returns the next line, without its newline. It returns io.EOF
+	// once the input is exhausted and errInterrupt if the user abandoned the line.
+	readLine(prompt string) (string, error)
+
+	// remember records an entry read, for the history of an editor.
+	remember(entry string)
+}
+
+// errInterrupt is returned by readLine when the user interrupts the line being
+// entered.
+var errInterrupt = errors.New("interrupted")
+
+// scannerLines reads lines from input which is not a terminal, such as a file or a
+// pipe, writing the prompts to output.
+type scannerLines struct {
+	scanner *bufio.Scanner
+	output  io.Writer
+}
+
+func (l *scannerLines) readLine(prompt string) (string, error) {
+	fmt.Fprint(l.output, prompt)
+	if !l.scanner.Scan() {
+		if err := l.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return l.scanner.Text(), nil
+}
+
+func (l *scannerLines) remember(entry string) {}
+
+// reader reads the entries of a session, prompting for each line.
+type reader struct {
+	lines  lineReader
+	output io.Writer
+}
+
+// read returns the next entry, reading lines until they make up a complete one. It
+// returns false once the input is exhausted. An entry left incomplete at the end of
+// the input is returned as it is, so that its errors are reported. An incomplete
+// entry is abandoned by an empty line or an interrupt.
+func (r *reader) read() (string, bool) {
+	var pending []string
+
+	for {
+		prompt := PROMPT
+		if len(pending) > 0 {
+			prompt = CONTINUATION
+		}
+
+		line, err := r.lines.readLine(prompt)
+		if err == errInterrupt {
+			pending = nil
+			continue
+		}
+		if err != nil {
+			if len(pending) > 0 {
+				fmt.Fprintln(r.output)
+				return r.entry(pending), true
+			}
+			return "", false
+		}
+
+		// Commands take a single line
+		if len(pending) == 0 && isCommand(strings.TrimSpace(line)) {
+			return r.entry([]string{strings.TrimSpace(line)}), true
+		}
+		if len(pending) > 0 && strings.TrimSpace(line) == "" {
+			pending = nil
+			continue
+		}
+
+		pending = append(pending, line)
+		if source := strings.Join(pending, "\n"); !incomplete(source) {
+			return r.entry(pending), true
+		}
+	}
+}
+
+// entry returns the entry made up of lines, recording it in the history.
+func (r *reader) entry(lines []string) string {
+	source := strings.Join(lines, "\n")
+	r.lines.remember(source)
+	return source
+}
+
+// incomplete reports whether source needs more input to make a program: whether it
+// leaves a bracket open or ends with an operator or other token which must be
+// followed by something. A bracket closed too many times is left for the parser to
+// report. So is a string left open: strings end on the line they start on, so more
+// lines could never close it.
+func incomplete(source string) bool {
+	l := lexer.New(source)
+	depth := 0
+	last := token.Token{Type: token.EOF}
+
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			depth--
+		}
+		last = tok
+	}
+
+	if depth > 0 {
+		return true
+	}
+
+	switch last.Type {
+	case token.ASSIGN, token.PLUS, token.MINUS, token.BANG, token.ASTERISK,
+		token.SLASH, token.LT, token.GT, token.EQ, token.NOT_EQ, token.COMMA,
+		token.COLON, token.ARROW, token.DOT, token.ELSE:
+		return depth == 0
+	}
+	return false
+}
@@ -2,36 +2,261 @@ package repl
 
 import (
 	"bufio"
+	"capuchin/ast"
+	"capuchin/compiler"
+	"capuchin/disasm"
+	"capuchin/evaluator"
 	"capuchin/lexer"
+	"capuchin/object"
+	"capuchin/parser"
+	"capuchin/stdlib"
 	"capuchin/token"
+	"capuchin/types"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"time"
 )
 
 // PROMPT is the value that is shown at the beginning of each REPL line
 const PROMPT = ">>"
 
-// Start will read in the input source until it encounters a new line character
-// and then pass the that string to the Lexer. It will then print out each
-// token produced by the Lexer.
-func Start(input io.Reader, ouput io.Writer) {
+// CONTINUATION is shown instead of PROMPT on the lines which continue an incomplete
+// entry.
+const CONTINUATION = ".."
 
-	scanner := bufio.NewScanner(input)
+// Start reads input an entry at a time, evaluating each entry as a program and
+// writing its value to output. The entries share one environment, so that a name
+// bound in one can be used in the next. Parse errors and runtime errors are written
+// to output along with their positions, and the session carries on.
+//
+// An entry is a line, or several if the first leaves a bracket open or ends with an
+// operator, as in
+//
+//	>>let add = fn(x, y) {
+//	..  x + y
+//	..};
+//
+// An empty line abandons an entry which is still incomplete.
+//
+// A line starting with a colon is a command to the REPL rather than an entry. The
+// commands switch between running entries and showing their tokens, syntax tree or
+// bytecode, and inspect the session; :help lists them.
+//
+// When input and output are a terminal, lines are read with an editor which keeps a
+// history, saved in the file named by $CAPUCHIN_HISTORY or ~/.capuchin_history, and
+// completes keywords, builtins, the functions of the standard library and the names
+// bound in the session. Otherwise input is read a line at a time as it comes.
+//
+// Entries may import modules, which are looked up relative to the current directory
+// and then in the directories listed in $CAPUCHIN_PATH.
+//
+// Everything the session writes, including the output of puts, goes to output.
+func Start(input io.Reader, output io.Writer) {
+	newSession(output).run(input)
+}
 
-	for {
+// StartDisasm reads entries from input as Start does but, instead of running them,
+// compiles each one and writes its bytecode to output. Bindings carry over from entry
+// to entry, as they would when running, and only the constants each entry adds are
+// listed.
+func StartDisasm(input io.Reader, output io.Writer) {
+	s := newSession(output)
+	s.mode = bytecodeMode
+	s.run(input)
+}
+
+// mode is what a session does with its entries.
+type mode int
+
+const (
+	evalMode mode = iota
+	tokensMode
+	astMode
+	bytecodeMode
+)
+
+var modeNames = map[mode]string{
+	evalMode:     "eval",
+	tokensMode:   "tokens",
+	astMode:      "ast",
+	bytecodeMode: "bytecode",
+}
+
+// session is the state of a REPL, which lasts from one entry to the next.
+type session struct {
+	output io.Writer
+	mode   mode
+	timing bool // Whether to show how long each entry takes
+
+	env    *object.Environment
+	loader *evaluator.Loader // loads the modules imported by entries
+
+	// checker holds the types of the bindings made by the entries run, so that
+	// :type can show the types of expressions using them.
+	checker *types.Checker
+
+	// symbols and constants are the compiler's state in bytecode mode.
+	symbols   *compiler.SymbolTable
+	constants []object.Object
+}
+
+func newSession(output io.Writer) *session {
+	s := &session{output: output}
+	s.reset()
+	return s
+}
+
+// reset forgets every binding made in the session.
+func (s *session) reset() {
+	puts := &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		for _, arg := range args {
+			fmt.Fprintln(s.output, arg.Inspect())
+		}
+		return nil
+	}}
+	// Modules are loaded afresh after a reset, picking up any changes to them. The
+	// session's own bindings are kept apart from the globals it shares with them, so
+	// that :env lists only the former.
+	s.loader = evaluator.NewLoader(filepath.SplitList(os.Getenv(evaluator.SearchPathEnv))...)
+	s.loader.Globals = object.NewEnvironment()
+	s.loader.Globals.Set("puts", puts)
+	if err := stdlib.Define(s.loader.Globals); err != nil {
+		panic(err)
+	}
+	s.env = object.NewEnclosedEnvironment(s.loader.Globals)
+
+	s.checker = types.NewChecker(stdlib.Types(types.Builtins()))
+
+	s.symbols = compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		s.symbols.DefineBuiltin(i, v.Name)
+	}
+	s.constants = []object.Object{}
+}
 
-		fmt.Printf(PROMPT)
-		scanned := scanner.Scan()
+// run reads and handles the entries and commands in input until it is exhausted.
+func (s *session) run(input io.Reader) {
+	r := &reader{lines: s.lineReader(input), output: s.output}
 
-		if !scanned {
+	for {
+		source, ok := r.read()
+		if !ok {
 			return
 		}
 
-		line := scanner.Text()
-		l := lexer.New(line)
+		if isCommand(source) {
+			s.command(source)
+			continue
+		}
+
+		start := time.Now()
+		s.entry(source)
+		if s.timing {
+			fmt.Fprintf(s.output, "time: %s\n", time.Since(start))
+		}
+	}
+}
+
+// lineReader returns the reader of the lines of input: an editor if the session is
+// on a terminal.
+func (s *session) lineReader(input io.Reader) lineReader {
+	in, ok := input.(*os.File)
+	out, ok2 := s.output.(*os.File)
+	if ok && ok2 && isTerminal(int(in.Fd())) && isTerminal(int(out.Fd())) {
+		return newEditor(in, out, historyFile(), s.complete)
+	}
+	return &scannerLines{scanner: bufio.NewScanner(input), output: s.output}
+}
+
+// complete returns the completions of prefix: the commands starting with it if it
+// starts with a colon, and otherwise the keywords, builtins and bound names.
+func (s *session) complete(prefix string) []string {
+	if isCommand(prefix) {
+		names := []string{}
+		for _, c := range commands {
+			names = append(names, ":"+c.name)
+		}
+		return completions(prefix, names)
+	}
+
+	names := append(token.Keywords(), object.BuiltinNames()...)
+	names = append(names, stdlib.Names()...)
+	return completions(prefix, append(names, s.env.Names()...))
+}
 
+// entry handles source as the session's mode says.
+func (s *session) entry(source string) {
+	switch s.mode {
+	case tokensMode:
+		l := lexer.New(source)
 		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
-			fmt.Printf("%+v\n", tok)
+			fmt.Fprintf(s.output, "%+v\n", tok)
+		}
+
+	case astMode:
+		if program := s.parse(source); program != nil {
+			fmt.Fprintln(s.output, ast.Sexp(program))
+		}
+
+	case bytecodeMode:
+		s.compile(source)
+
+	default:
+		s.evaluate(source, "")
+	}
+}
+
+// parse parses source, writing its errors to output and returning nil if it has any.
+func (s *session) parse(source string) *ast.Program {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintf(s.output, "\t%s\n", e)
 		}
+		return nil
+	}
+	return program
+}
+
+// evaluate runs source, read from file if it is not empty, in the session's
+// environment, writing its value or errors to output.
+func (s *session) evaluate(source, file string) {
+	program := s.parse(source)
+	if program == nil {
+		return
+	}
+
+	// Type errors are not reported, as they need not stop the program running,
+	// but the types of the bindings it makes are kept for :type.
+	s.checker.Check(program)
+
+	switch result := s.loader.Eval(program, s.env, file).(type) {
+	case nil:
+	case *object.Error:
+		fmt.Fprintf(s.output, "ERROR: %s\n", result.Traceback())
+	default:
+		fmt.Fprintln(s.output, result.Inspect())
+	}
+}
+
+// compile compiles source, writing its bytecode to output.
+func (s *session) compile(source string) {
+	program := s.parse(source)
+	if program == nil {
+		return
 	}
+
+	first := len(s.constants)
+	comp := compiler.NewWithState(s.symbols, s.constants)
+	if err := comp.Compile(program); err != nil {
+		fmt.Fprintf(s.output, "compile error: %s\n", err)
+		return
+	}
+
+	bytecode := comp.Bytecode()
+	s.constants = bytecode.Constants
+	disasm.Fprint(s.output, bytecode, disasm.Options{Source: source, FirstConstant: first})
 }
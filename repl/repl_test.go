@@ -0,0 +1,200 @@
+package repl
+
+import (
+	"bytes"
+	"capuchin/compiler"
+	"capuchin/disasm"
+	"capuchin/lexer"
+	"capuchin/parser"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5 + 5\n", ">>10\n>>"},
+		{"let x = 5;\nx * 2\n", ">>>>10\n>>"},
+		{"let add = fn(a, b) { a + b };\nadd(1, 2)\n", ">>>>3\n>>"},
+		{"if (false) { 1 }\n", ">>null\n>>"},
+		{"puts(1, true)\n", ">>1\ntrue\nnull\n>>"},
+		{"", ">>"},
+
+		// Errors are reported and the session carries on
+		{
+			"let = 1;\n2\n",
+			">>\t1:5: expected next token to be IDENT, but got = instead.\n" +
+				"\t1:5: no prefix parse function for = found.\n>>2\n>>",
+		},
+		{"1 + true\n3\n", ">>ERROR: type mismatch: INTEGER + BOOLEAN\n\tat <main> (1:3)\n>>3\n>>"},
+		{
+			"let f = fn(x) { -x };\nf(true)\n",
+			">>>>ERROR: unknown operator: -BOOLEAN\n\tat f (1:17)\n\tat <main> (1:1)\n>>",
+		},
+		{"throw 7;\n", ">>ERROR: uncaught exception: 7\n\tat <main> (1:1)\n>>"},
+
+		// Entries spanning several lines
+		{"let add = fn(x, y) {\n  x + y\n};\nadd(1, 2)\n", ">>....>>3\n>>"},
+		{"puts(1,\n2)\n", ">>..1\n2\nnull\n>>"},
+		{"1 +\n\n2\n", ">>..>>2\n>>"},
+		{"if (true) { 1 } else\n{ 2 }\n", ">>..1\n>>"},
+		{"fn(x) {\n  x + true\n}(1)\n", ">>....ERROR: type mismatch: INTEGER + BOOLEAN\n\tat <anonymous> (2:5)\n\tat <main> (1:1)\n>>"},
+		{"let f = fn() {\n", ">>..\n\t1:15: expected }, but got EOF instead.\n>>"},
+		{"1)\n", ">>\t1:2: no prefix parse function for ) found.\n>>"},
+	}
+
+	for _, tt := range tests {
+		var output bytes.Buffer
+		Start(strings.NewReader(tt.input), &output)
+
+		if got := output.String(); got != tt.expected {
+			t.Errorf("wrong output for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			":tokens\nlet x\n:tokens\n",
+			">>mode: tokens\n>>{Type:LET Literal:let Pos:1:1}\n{Type:IDENT Literal:x Pos:1:5}\n>>mode: eval\n>>",
+		},
+		{":ast\n-a * f(b)\nlet x = \n1;\n", ">>mode: ast\n>>(* (- a) (call f b))\n>>..(let x 1)\n>>"},
+		{":ast\n:eval\n1 + 1\n", ">>mode: ast\n>>mode: eval\n>>2\n>>"},
+		{":bytecode\n1\n", ">>mode: bytecode\n>>" + disassemble(t, "1") + ">>"},
+		{"let a = 1;\nlet f = fn(x) { x };\n:env\n", ">>>>>>a = 1\nf = fn(x) {\nx\n}\n>>"},
+		{"let a = 1;\n:reset\na\n", ">>>>session reset\n>>ERROR: identifier not found: a\n\tat <main> (1:1)\n>>"},
+		{"let id = fn(x) { x };\n:type id(5) < 2\n", ">>>>bool\n>>"},
+		{":type fn(x) { x }\n", ">>fn(a) -> a\n>>"},
+		{":type split(\"a b\", \" \")\n", ">>[string]\n>>"},
+		{"upper(\"hi\")\n", ">>HI\n>>"},
+		{":type 1 + true\n", ">>\t1:5-1:9: error: true has type bool, expected int (type)\n>>"},
+		{":type let x = 1;\n", ">>usage: :type <expression>\n>>"},
+		{":type\n", ">>usage: :type <expression>\n>>"},
+		{":load\n", ">>usage: :load <file>\n>>"},
+		{":load missing.cap\n", ">>cannot load missing.cap: open missing.cap: no such file or directory\n>>"},
+		{":frobnicate\n", ">>unknown command :frobnicate, :help lists the commands\n>>"},
+	}
+
+	for _, tt := range tests {
+		var output bytes.Buffer
+		Start(strings.NewReader(tt.input), &output)
+
+		if got := output.String(); got != tt.expected {
+			t.Errorf("wrong output for %q.\nwant=%q\ngot =%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lib.cap")
+	source := "let double = fn(x) {\n  x * 2\n};\nlet four = double(2);\n"
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	Start(strings.NewReader(":load "+path+"\ndouble(four)\n"), &output)
+
+	if expected, got := ">>>>8\n>>", output.String(); got != expected {
+		t.Errorf("wrong output. want=%q, got=%q", expected, got)
+	}
+}
+
+func TestImport(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"util.cap": "puts(0);\nexport let double = fn(x) { x * 2 };\n",
+		// Imports in a loaded file are relative to it
+		"lib.cap": "import \"util\" as u;\nlet four = u.double(2);\n",
+	}
+	for name, source := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The module is only run once however many times it is imported
+	input := ":load " + filepath.Join(dir, "lib.cap") + "\n" +
+		"import \"" + filepath.Join(dir, "util") + "\" as util;\nutil.double(four)\n"
+
+	var output bytes.Buffer
+	Start(strings.NewReader(input), &output)
+
+	if expected, got := ">>0\n>>>>8\n>>", output.String(); got != expected {
+		t.Errorf("wrong output. want=%q, got=%q", expected, got)
+	}
+}
+
+func TestTime(t *testing.T) {
+	var output bytes.Buffer
+	Start(strings.NewReader(":time\n1\n:time\n2\n"), &output)
+
+	pattern := `^>>timing: true\n>>1\ntime: [0-9.]+[nµm]?s\n>>timing: false\n>>2\n>>$`
+	if !regexp.MustCompile(pattern).MatchString(output.String()) {
+		t.Errorf("wrong output. want=%q, got=%q", pattern, output.String())
+	}
+}
+
+func TestHelp(t *testing.T) {
+	var output bytes.Buffer
+	Start(strings.NewReader(":help\n"), &output)
+
+	for _, c := range commands {
+		if !strings.Contains(output.String(), ":"+c.name) || !strings.Contains(output.String(), c.help) {
+			t.Errorf(":help does not describe :%s. got=%q", c.name, output.String())
+		}
+	}
+}
+
+// disassemble returns the disassembly of source, as bytecode mode shows it.
+func disassemble(t *testing.T, source string) string {
+	t.Helper()
+
+	comp := compiler.New()
+	if err := comp.Compile(parser.New(lexer.New(source)).ParseProgram()); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	disasm.Fprint(&out, comp.Bytecode(), disasm.Options{Source: source})
+	return out.String()
+}
+
+func TestIncomplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"let x = 1;", false},
+		{"let x =", true},
+		{"fn(x) {", true},
+		{"fn(x) { x }", false},
+		{"add(1,", true},
+		{"add(1, (2", true},
+		{"a[1", true},
+		{"1 + 2 *", true},
+		{"x.", true},
+		{"if (x) { 1 } else", true},
+		{"1 }", false},
+		{"} +", false},
+		{`"abc`, false},
+		{`let s = "{`, false},
+		{`"{" + "(" + "["`, false},
+		{`puts("{"`, true},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := incomplete(tt.input); got != tt.expected {
+			t.Errorf("incomplete(%q) wrong. want=%t, got=%t", tt.input, tt.expected, got)
+		}
+	}
+}
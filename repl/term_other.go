@@ -0,0 +1,15 @@
+//go:build !linux
+
+package repl
+
+import "errors"
+
+// isTerminal reports whether fd refers to a terminal. Line editing is only
+// supported on Linux, so elsewhere input is always read a line at a time.
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw mode is not supported on this platform")
+}
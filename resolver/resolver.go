@@ -0,0 +1,329 @@
+// Package resolver provides the semantic analysis pass which works out what every
+// identifier in a capuchin program refers to before any code runs.
+//
+// The resolver builds a symbol table of let bindings, imports, catch parameters,
+// function parameters and builtins, scoped the same way the evaluator scopes its
+// environments: the top level of the program and each function body form a scope,
+// blocks do not. Each ast.Identifier is annotated with the ast.Binding it resolves
+// to, and problems are reported as diagnostics.
+package resolver
+
+import (
+	"capuchin/ast"
+	"capuchin/diagnostic"
+	"fmt"
+)
+
+// The codes of the diagnostics reported by the resolver.
+const (
+	Undefined           = "undefined"
+	DuplicateParameter  = "duplicate-parameter"
+	UseBeforeDefinition = "use-before-definition"
+	NestedExport        = "nested-export"
+)
+
+// scope holds the bindings of the program's top level or of a function body.
+type scope struct {
+	outer *scope
+	depth int
+
+	// bindings holds the names which have been defined so far.
+	bindings map[string]*ast.Binding
+
+	// pending holds the names declared somewhere in the scope, mapped to their
+	// first declaration, so that uses ahead of the let can be told apart from
+	// names that do not exist at all.
+	pending map[string]*ast.Identifier
+}
+
+// Resolver annotates the identifiers of a program with their bindings.
+type Resolver struct {
+	builtins map[string]*ast.Binding
+	scope    *scope
+	blocks   int // the blocks the current statement is within in its scope
+	diags    []diagnostic.Diagnostic
+}
+
+// New creates a Resolver which treats the supplied names as builtins, visible from
+// anywhere in the program.
+func New(builtins ...string) *Resolver {
+	r := &Resolver{builtins: make(map[string]*ast.Binding)}
+	for _, name := range builtins {
+		r.builtins[name] = &ast.Binding{Depth: 0}
+	}
+	return r
+}
+
+// Resolve is a convenience function which resolves program with a new Resolver.
+func Resolve(program *ast.Program, builtins ...string) []diagnostic.Diagnostic {
+	return New(builtins...).Resolve(program)
+}
+
+// Resolve annotates every identifier in the program and returns the diagnostics for
+// the problems found, ordered by position.
+func (r *Resolver) Resolve(program *ast.Program) []diagnostic.Diagnostic {
+	r.diags = nil
+
+	r.openScope(program.Statements)
+	r.statements(program.Statements)
+	r.closeScope()
+
+	diagnostic.Sort(r.diags)
+	return r.diags
+}
+
+func (r *Resolver) openScope(body []ast.Statement) {
+	s := &scope{
+		outer:    r.scope,
+		depth:    1,
+		bindings: make(map[string]*ast.Binding),
+		pending:  make(map[string]*ast.Identifier),
+	}
+	if r.scope != nil {
+		s.depth = r.scope.depth + 1
+	}
+
+	declarations(body, s.pending)
+	r.scope = s
+}
+
+func (r *Resolver) closeScope() {
+	r.scope = r.scope.outer
+}
+
+func (r *Resolver) errorf(ident *ast.Identifier, code string, format string, args ...interface{}) {
+	r.diags = append(r.diags, diagnostic.Diagnostic{
+		Pos:      ident.Token.Pos,
+		Severity: diagnostic.Error,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// define binds ident in the current scope, replacing any previous binding of the
+// same name.
+func (r *Resolver) define(ident *ast.Identifier) {
+	b := &ast.Binding{Decl: ident, Depth: r.scope.depth}
+	r.scope.bindings[ident.Value] = b
+	ident.Binding = b
+}
+
+// lookup resolves a use of an identifier. Names already defined in any enclosing
+// scope win, matching the evaluator's environment lookup. A name only declared later
+// is fine from inside a nested function, which cannot run before the declaration,
+// but is an error in the scope doing the declaring.
+func (r *Resolver) lookup(ident *ast.Identifier) {
+	for s := r.scope; s != nil; s = s.outer {
+		if b, ok := s.bindings[ident.Value]; ok {
+			ident.Binding = b
+			return
+		}
+	}
+
+	for s := r.scope; s != nil; s = s.outer {
+		if decl, ok := s.pending[ident.Value]; ok {
+			ident.Binding = &ast.Binding{Decl: decl, Depth: s.depth}
+			if s == r.scope {
+				r.errorf(ident, UseBeforeDefinition,
+					"%s used before its definition at %s", ident.Value, decl.Token.Pos)
+			}
+			return
+		}
+	}
+
+	if b, ok := r.builtins[ident.Value]; ok {
+		ident.Binding = b
+		return
+	}
+
+	ident.Binding = nil
+	r.errorf(ident, Undefined, "undefined identifier %s", ident.Value)
+}
+
+func (r *Resolver) statements(stmts []ast.Statement) {
+	for _, s := range stmts {
+		r.statement(s)
+	}
+}
+
+func (r *Resolver) statement(stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		// The value is resolved first, so "let x = x + 1" refers to an earlier x
+		r.expression(s.Value)
+		r.define(s.Name)
+	case *ast.ExportStatement:
+		// Only the top level of a module is exported
+		if r.scope.depth > 1 || r.blocks > 0 {
+			r.errorf(s.Statement.Name, NestedExport,
+				"export of %s is not at the top level", s.Statement.Name.Value)
+		}
+		r.statement(s.Statement)
+	case *ast.ImportStatement:
+		r.define(s.Name)
+	case *ast.ReturnStatement:
+		r.expression(s.ReturnValue)
+	case *ast.ThrowStatement:
+		r.expression(s.Value)
+	case *ast.ExpressionStatement:
+		r.expression(s.Expression)
+	case *ast.BlockStatement:
+		r.block(s)
+	}
+}
+
+func (r *Resolver) block(block *ast.BlockStatement) {
+	if block != nil {
+		r.blocks++
+		r.statements(block.Statements)
+		r.blocks--
+	}
+}
+
+func (r *Resolver) expression(exp ast.Expression) {
+	switch e := exp.(type) {
+	case *ast.Identifier:
+		r.lookup(e)
+	case *ast.PrefixExpression:
+		r.expression(e.Right)
+	case *ast.InfixExpression:
+		r.expression(e.Left)
+		r.expression(e.Right)
+	case *ast.IfExpression:
+		r.expression(e.Condition)
+		r.block(e.Consequence)
+		r.block(e.Alternative)
+	case *ast.TryExpression:
+		// The catch parameter is bound in the enclosing scope, like a let
+		r.block(e.Body)
+		if e.Catch != nil {
+			r.define(e.Parameter)
+			r.block(e.Catch)
+		}
+		r.block(e.Finally)
+	case *ast.MemberExpression:
+		r.expression(e.Object)
+	case *ast.IndexExpression:
+		r.expression(e.Left)
+		r.expression(e.Index)
+	case *ast.ArrayLiteral:
+		for _, el := range e.Elements {
+			r.expression(el)
+		}
+	case *ast.HashLiteral:
+		for i, key := range e.Keys {
+			r.expression(key)
+			r.expression(e.Values[i])
+		}
+	case *ast.CallExpression:
+		r.expression(e.Function)
+		for _, arg := range e.Arguments {
+			r.expression(arg)
+		}
+	case *ast.FunctionLiteral:
+		r.function(e)
+	}
+}
+
+func (r *Resolver) function(fn *ast.FunctionLiteral) {
+	var body []ast.Statement
+	if fn.Body != nil {
+		body = fn.Body.Statements
+	}
+
+	r.openScope(body)
+	defer r.closeScope()
+
+	blocks := r.blocks
+	r.blocks = 0
+	defer func() { r.blocks = blocks }()
+
+	for _, param := range fn.Parameters {
+		if prev, ok := r.scope.bindings[param.Value]; ok {
+			r.errorf(param, DuplicateParameter,
+				"duplicate parameter %s, first declared at %s", param.Value, prev.Decl.Token.Pos)
+		}
+		r.define(param)
+	}
+
+	r.statements(body)
+}
+
+// declarations records the first let declaration of each name in stmts into decls. It
+// looks inside blocks, which share their enclosing scope, but not inside functions.
+func declarations(stmts []ast.Statement, decls map[string]*ast.Identifier) {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.LetStatement:
+			declarationsIn(s.Value, decls)
+			if _, ok := decls[s.Name.Value]; !ok {
+				decls[s.Name.Value] = s.Name
+			}
+		case *ast.ExportStatement:
+			declarations([]ast.Statement{s.Statement}, decls)
+		case *ast.ImportStatement:
+			if _, ok := decls[s.Name.Value]; !ok {
+				decls[s.Name.Value] = s.Name
+			}
+		case *ast.ReturnStatement:
+			declarationsIn(s.ReturnValue, decls)
+		case *ast.ThrowStatement:
+			declarationsIn(s.Value, decls)
+		case *ast.ExpressionStatement:
+			declarationsIn(s.Expression, decls)
+		case *ast.BlockStatement:
+			declarations(s.Statements, decls)
+		}
+	}
+}
+
+// declarationsIn records the let declarations made by the blocks within exp.
+func declarationsIn(exp ast.Expression, decls map[string]*ast.Identifier) {
+	switch e := exp.(type) {
+	case *ast.PrefixExpression:
+		declarationsIn(e.Right, decls)
+	case *ast.InfixExpression:
+		declarationsIn(e.Left, decls)
+		declarationsIn(e.Right, decls)
+	case *ast.IfExpression:
+		declarationsIn(e.Condition, decls)
+		if e.Consequence != nil {
+			declarations(e.Consequence.Statements, decls)
+		}
+		if e.Alternative != nil {
+			declarations(e.Alternative.Statements, decls)
+		}
+	case *ast.TryExpression:
+		if e.Body != nil {
+			declarations(e.Body.Statements, decls)
+		}
+		if e.Catch != nil {
+			if _, ok := decls[e.Parameter.Value]; !ok {
+				decls[e.Parameter.Value] = e.Parameter
+			}
+			declarations(e.Catch.Statements, decls)
+		}
+		if e.Finally != nil {
+			declarations(e.Finally.Statements, decls)
+		}
+	case *ast.MemberExpression:
+		declarationsIn(e.Object, decls)
+	case *ast.IndexExpression:
+		declarationsIn(e.Left, decls)
+		declarationsIn(e.Index, decls)
+	case *ast.ArrayLiteral:
+		for _, el := range e.Elements {
+			declarationsIn(el, decls)
+		}
+	case *ast.HashLiteral:
+		for i, key := range e.Keys {
+			declarationsIn(key, decls)
+			declarationsIn(e.Values[i], decls)
+		}
+	case *ast.CallExpression:
+		declarationsIn(e.Function, decls)
+		for _, arg := range e.Arguments {
+			declarationsIn(arg, decls)
+		}
+	}
+}
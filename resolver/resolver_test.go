@@ -0,0 +1,157 @@
+package resolver
+
+import (
+	"capuchin/ast"
+	"capuchin/parsertest"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let x = 5; let y = x + 1;", nil},
+		{"let x = foobar;", []string{"1:9: error: undefined identifier foobar (undefined)"}},
+		{"puts(1);", nil},
+		{"let f = fn(x, y) { x + y; }; f(1, 2);", nil},
+		{
+			"let f = fn(x, y, x) { x };",
+			[]string{"1:18: error: duplicate parameter x, first declared at 1:12 (duplicate-parameter)"},
+		},
+		{
+			"let y = x;\nlet x = 1;",
+			[]string{"1:9: error: x used before its definition at 2:5 (use-before-definition)"},
+		},
+		{"let x = x + 1;", []string{"1:9: error: x used before its definition at 1:5 (use-before-definition)"}},
+		{"let x = 1; let x = x + 1;", nil},
+		// Functions only run once called, so they may refer to later bindings
+		{"let f = fn() { g() }; let g = fn() { 1 }; f();", nil},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } };", nil},
+		// Parameters are not visible outside of their function
+		{"let f = fn(a) { a }; a;", []string{"1:22: error: undefined identifier a (undefined)"}},
+		// Blocks share their function's scope
+		{"if (true) { let z = 1; } z;", nil},
+		{
+			"let f = fn() { let a = b; let b = 1; };",
+			[]string{"1:24: error: b used before its definition at 1:31 (use-before-definition)"},
+		},
+		// Catch parameters are bound like lets, and properties are not identifiers
+		{"try { throw 1; } catch (e) { e.message; }; e;", nil},
+		{"throw e; try { 1 } catch (e) { e }", []string{"1:7: error: e used before its definition at 1:27 (use-before-definition)"}},
+		{"try { x } finally { y }", []string{
+			"1:7: error: undefined identifier x (undefined)",
+			"1:21: error: undefined identifier y (undefined)",
+		}},
+		// An outer binding is found before the inner one is defined
+		{"let x = 1; let f = fn() { let y = x; let x = 2; };", nil},
+		// Imports bind their name, and exports are lets
+		{`m.f(x); import "m" as m; export let x = m;`, []string{
+			"1:1: error: m used before its definition at 1:23 (use-before-definition)",
+			"1:5: error: x used before its definition at 1:37 (use-before-definition)",
+		}},
+		{
+			"if (true) { export let x = 1; } let f = fn() { export let y = x; };",
+			[]string{
+				"1:24: error: export of x is not at the top level (nested-export)",
+				"1:59: error: export of y is not at the top level (nested-export)",
+			},
+		},
+		{
+			"a(b, c);",
+			[]string{
+				"1:1: error: undefined identifier a (undefined)",
+				"1:3: error: undefined identifier b (undefined)",
+				"1:6: error: undefined identifier c (undefined)",
+			},
+		},
+		{
+			"[a, {b: c}][d];",
+			[]string{
+				"1:2: error: undefined identifier a (undefined)",
+				"1:6: error: undefined identifier b (undefined)",
+				"1:9: error: undefined identifier c (undefined)",
+				"1:13: error: undefined identifier d (undefined)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		program := parsertest.Parse(t, tt.input)
+		diags := Resolve(program, "puts")
+
+		if len(diags) != len(tt.expected) {
+			t.Errorf("wrong number of diagnostics for %q. want=%d, got=%d (%v)",
+				tt.input, len(tt.expected), len(diags), diags)
+			continue
+		}
+		for i, d := range diags {
+			if d.String() != tt.expected[i] {
+				t.Errorf("diagnostic %d wrong for %q. want=%q, got=%q",
+					i, tt.input, tt.expected[i], d.String())
+			}
+		}
+	}
+}
+
+func TestBindings(t *testing.T) {
+	input := `let x = 1;
+let f = fn(y) {
+	let g = fn() { x + y };
+	puts(g);
+};`
+
+	program := parsertest.Parse(t, input)
+	if diags := Resolve(program, "puts"); len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+
+	letX := program.Statements[0].(*ast.LetStatement)
+	letF := program.Statements[1].(*ast.LetStatement)
+	f := letF.Value.(*ast.FunctionLiteral)
+	letG := f.Body.Statements[0].(*ast.LetStatement)
+	g := letG.Value.(*ast.FunctionLiteral)
+	sum := g.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.InfixExpression)
+	call := f.Body.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+
+	tests := []struct {
+		name          string
+		ident         ast.Expression
+		expectedDecl  *ast.Identifier
+		expectedDepth int
+	}{
+		{"let x", letX.Name, letX.Name, 1},
+		{"parameter y", f.Parameters[0], f.Parameters[0], 2},
+		{"let g", letG.Name, letG.Name, 2},
+		{"x in g", sum.Left, letX.Name, 1},
+		{"y in g", sum.Right, f.Parameters[0], 2},
+		{"puts", call.Function, nil, 0},
+		{"g argument", call.Arguments[0], letG.Name, 2},
+	}
+
+	for _, tt := range tests {
+		ident := tt.ident.(*ast.Identifier)
+		if ident.Binding == nil {
+			t.Errorf("%s: identifier was not resolved", tt.name)
+			continue
+		}
+		if ident.Binding.Decl != tt.expectedDecl {
+			t.Errorf("%s: wrong declaration. want=%p, got=%p",
+				tt.name, tt.expectedDecl, ident.Binding.Decl)
+		}
+		if ident.Binding.Depth != tt.expectedDepth {
+			t.Errorf("%s: wrong depth. want=%d, got=%d",
+				tt.name, tt.expectedDepth, ident.Binding.Depth)
+		}
+	}
+}
+
+func TestUndefinedIdentifierHasNoBinding(t *testing.T) {
+	program := parsertest.Parse(t, "foobar;")
+	Resolve(program)
+
+	ident := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.Identifier)
+	if ident.Binding != nil {
+		t.Errorf("undefined identifier should not have a binding. got=%+v", ident.Binding)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"capuchin/capc"
+	"capuchin/evaluator"
+	"capuchin/object"
+	"capuchin/stdlib"
+	"capuchin/types"
+	"capuchin/vm"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runCommand runs a script with the evaluator. The program is given the arguments
+// after the script's path as the array args and the environment variables as the
+// hash env, and can end itself by calling exit with a status. The standard library
+// is predeclared too, and the modules it imports are given the same names.
+//
+// The exit status is the one passed to exit, or else given by the script's value: an
+// integer is the status itself, false is 1, and other values are 0. A status must be
+// from 0 to 255, what a process can exit with. If the script fails with a runtime
+// error, or ends with a status out of range, the error is printed and the status is
+// 1, and if it could not be read or parsed the status is 2.
+//
+// A program compiled by build, named by its .capc file, is run with the virtual
+// machine instead; see runCompiled.
+func runCommand(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	searchPath := flags.String("path", "", "directories to look for imported modules in, separated as in PATH")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: capuchin run [-path dirs] script.cap [arguments]\n       capuchin run program.capc")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	path := flags.Arg(0)
+	if filepath.Ext(path) == capc.Extension {
+		return runCompiled(path)
+	}
+
+	program, err := parseFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	globals := object.NewEnvironment()
+	globals.Set("args", stringArray(flags.Args()[1:]))
+	globals.Set("env", environment())
+	globals.Set("exit", &object.Builtin{Fn: exit})
+	if err := stdlib.Define(globals); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	loader := newLoader(*searchPath, globals)
+	switch result := loader.Eval(program, object.NewEnclosedEnvironment(globals), path).(type) {
+	case *object.Error:
+		fmt.Fprintf(os.Stderr, "%s: runtime error: %s\n", path, result.Traceback())
+		return 1
+	case *object.Abort:
+		if status, ok := result.Err.(exitStatus); ok {
+			return int(status)
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, result.Err)
+		return 1
+	default:
+		return resultStatus(path, result)
+	}
+}
+
+// runCompiled runs the program compiled to the .capc file at path with the virtual
+// machine, exiting as runCommand does. build compiles scripts with only the builtins
+// predeclared, so the program has no args, env, exit or standard library.
+func runCompiled(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer f.Close()
+
+	file, err := capc.Decode(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 2
+	}
+
+	machine := vm.New(file.Bytecode)
+	if err := machine.Run(); err != nil {
+		if rerr, ok := err.(*object.Error); ok {
+			fmt.Fprintf(os.Stderr, "%s: runtime error: %s\n", path, rerr.Traceback())
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		}
+		return 1
+	}
+	return resultStatus(path, machine.Result())
+}
+
+// resultStatus returns the exit status given by result, the value of the script at
+// path, printing the error if it is out of range.
+func resultStatus(path string, result object.Object) int {
+	switch result := result.(type) {
+	case *object.Integer:
+		if err := checkStatus(result.Value); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			return 1
+		}
+		return int(result.Value)
+	case *object.Boolean:
+		if !result.Value {
+			return 1
+		}
+	}
+	return 0
+}
+
+// exitStatus is the reason a script stops when it calls exit.
+type exitStatus int
+
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
+// exit is the builtin which ends a script with the status it is given, or 0. It
+// stops the evaluation as a limit does, so the script cannot catch it.
+func exit(args ...object.Object) object.Object {
+	if len(args) == 0 {
+		return &object.Abort{Err: exitStatus(0)}
+	}
+	if len(args) > 1 {
+		return &object.Error{Message: fmt.Sprintf("wrong number of arguments: want=1, got=%d", len(args))}
+	}
+
+	status, ok := args[0].(*object.Integer)
+	if !ok {
+		return &object.Error{Message: "argument to exit must be INTEGER, got " + string(args[0].Type())}
+	}
+	if err := checkStatus(status.Value); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+	return &object.Abort{Err: exitStatus(status.Value)}
+}
+
+// checkStatus reports an error if status is not one a process can exit with, which
+// the operating system would otherwise wrap around, making 256 a success.
+func checkStatus(status int64) error {
+	if status < 0 || status > 255 {
+		return fmt.Errorf("exit status %d out of range [0, 255]", status)
+	}
+	return nil
+}
+
+// environment returns the process's environment variables as a hash of strings.
+func environment() *object.Hash {
+	h := &object.Hash{Pairs: map[object.HashKey]object.HashPair{}}
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		key := &object.String{Value: name}
+		h.Pairs[key.HashKey()] = object.HashPair{Key: key, Value: &object.String{Value: value}}
+	}
+	return h
+}
+
+// newLoader returns the loader for the modules imported by scripts, which looks for
+// them in the directories of searchPath and then in those of $CAPUCHIN_PATH. The
+// modules are run in an environment enclosed by globals.
+func newLoader(searchPath string, globals *object.Environment) *evaluator.Loader {
+	dirs := filepath.SplitList(searchPath)
+	dirs = append(dirs, filepath.SplitList(os.Getenv(evaluator.SearchPathEnv))...)
+
+	loader := evaluator.NewLoader(dirs...)
+	loader.Globals = globals
+	return loader
+}
+
+// scriptGlobals returns the names predeclared for scripts run by runCommand, for
+// resolving them.
+func scriptGlobals() []string {
+	names := append(object.BuiltinNames(), "args", "env", "exit")
+	return append(names, stdlib.Names()...)
+}
+
+// scriptTypes returns the types of the names predeclared for scripts run by
+// runCommand, for type checking them.
+func scriptTypes() map[string]types.Type {
+	globals := stdlib.Types(types.Builtins())
+	globals["args"] = &types.Array{Element: types.String}
+	globals["env"] = &types.Hash{Key: types.String, Value: types.String}
+	// exit never returns, so its result may be used as any type, and its status is
+	// optional
+	globals["exit"] = &types.Function{
+		Params:   []types.Type{types.Int},
+		Return:   types.NewVariable(),
+		Variadic: true,
+	}
+	return globals
+}
+
+// stringArray returns an array holding strs.
+func stringArray(strs []string) *object.Array {
+	arr := &object.Array{Elements: []object.Object{}}
+	for _, s := range strs {
+		arr.Elements = append(arr.Elements, &object.String{Value: s})
+	}
+	return arr
+}
@@ -0,0 +1,427 @@
+package stdlib
+
+import (
+	"capuchin/object"
+	"capuchin/types"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// The collection functions work on arrays and hashes, and len, contains and slice on
+// strings as well. None of them changes the values it is given: push, pop, delete and
+// merge return new arrays and hashes, leaving the old ones as they were.
+//
+// keys, values and entries list the pairs of a hash ordered by key, integers and
+// strings each in their natural order, so that a script sees the same order each
+// time it runs.
+func init() {
+	a, b := types.NewVariable(), types.NewVariable()
+	as, h := &types.Array{Element: a}, &types.Hash{Key: a, Value: b}
+
+	add(map[string]function{
+		"len":      {length, fnType(types.Int, types.Any)},
+		"first":    {first, fnType(a, as)},
+		"last":     {last, fnType(a, as)},
+		"rest":     {rest, fnType(as, as)},
+		"push":     {push, fnType(as, as, a)},
+		"pop":      {pop, fnType(as, as)},
+		"concat":   {concat, &types.Function{Params: []types.Type{as, as}, Return: as, Variadic: true}},
+		"slice":    {slice, fnType(a, a, types.Int, types.Int)},
+		"reverse":  {reverse, fnType(as, as)},
+		"sort":     {sortArray, fnType(as, as, fnType(types.Bool, a, a))},
+		"contains": {contains, fnType(types.Bool, a, b)},
+		"keys":     {keys, fnType(as, h)},
+		"values":   {values, fnType(&types.Array{Element: b}, h)},
+		"entries":  {entries, fnType(&types.Array{Element: &types.Array{Element: types.Any}}, h)},
+		"has":      {has, fnType(types.Bool, h, a)},
+		"delete":   {remove, fnType(h, h, a)},
+		"merge":    {merge, &types.Function{Params: []types.Type{h, h}, Return: h, Variadic: true}},
+	})
+}
+
+// length returns the number of characters in a string, elements in an array or
+// pairs in a hash.
+func length(obj object.Object) (int, error) {
+	switch obj := obj.(type) {
+	case *object.String:
+		return utf8.RuneCountInString(obj.Value), nil
+	case *object.Array:
+		return len(obj.Elements), nil
+	case *object.Hash:
+		return len(obj.Pairs), nil
+	}
+	return 0, typeError(1, obj, object.STRING_OBJ, object.ARRAY_OBJ, object.HASH_OBJ)
+}
+
+// first returns the first element of an array.
+func first(obj object.Object) (object.Object, error) {
+	elements, err := nonEmpty(obj)
+	if err != nil {
+		return nil, err
+	}
+	return elements[0], nil
+}
+
+// last returns the last element of an array.
+func last(obj object.Object) (object.Object, error) {
+	elements, err := nonEmpty(obj)
+	if err != nil {
+		return nil, err
+	}
+	return elements[len(elements)-1], nil
+}
+
+// rest returns the elements of an array after the first. The rest of an empty array
+// is empty.
+func rest(obj object.Object) (*object.Array, error) {
+	elements, err := array(1, obj)
+	if err != nil {
+		return nil, err
+	}
+	if len(elements) == 0 {
+		return newArray(nil), nil
+	}
+	return newArray(elements[1:]), nil
+}
+
+// push returns an array with the elements of obj followed by element.
+func push(obj, element object.Object) (*object.Array, error) {
+	elements, err := array(1, obj)
+	if err != nil {
+		return nil, err
+	}
+	return newArray(elements, element), nil
+}
+
+// pop returns an array with the elements of obj but the last, which last gives.
+func pop(obj object.Object) (*object.Array, error) {
+	elements, err := nonEmpty(obj)
+	if err != nil {
+		return nil, err
+	}
+	return newArray(elements[:len(elements)-1]), nil
+}
+
+// concat returns an array with the elements of each of its arguments in turn.
+func concat(obj object.Object, more ...object.Object) (*object.Array, error) {
+	result := []object.Object{}
+	for i, obj := range append([]object.Object{obj}, more...) {
+		elements, err := array(i+1, obj)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, elements...)
+	}
+	return &object.Array{Elements: result}, nil
+}
+
+// slice returns the characters of a string or the elements of an array from start up
+// to but not including end.
+func slice(obj object.Object, start, end int) (object.Object, error) {
+	switch obj := obj.(type) {
+	case *object.String:
+		runes := []rune(obj.Value)
+		if err := checkBounds(start, end, len(runes)); err != nil {
+			return nil, err
+		}
+		return &object.String{Value: string(runes[start:end])}, nil
+	case *object.Array:
+		if err := checkBounds(start, end, len(obj.Elements)); err != nil {
+			return nil, err
+		}
+		return newArray(obj.Elements[start:end]), nil
+	}
+	return nil, typeError(1, obj, object.STRING_OBJ, object.ARRAY_OBJ)
+}
+
+// checkBounds reports an error unless start and end are bounds of a slice of a
+// string or array of length n.
+func checkBounds(start, end, n int) error {
+	if start < 0 || end < start || end > n {
+		return fmt.Errorf("bounds [%d:%d] out of range for length %d", start, end, n)
+	}
+	return nil
+}
+
+// reverse returns the elements of an array in reverse order.
+func reverse(obj object.Object) (*object.Array, error) {
+	elements, err := array(1, obj)
+	if err != nil {
+		return nil, err
+	}
+	reversed := make([]object.Object, len(elements))
+	for i, e := range elements {
+		reversed[len(elements)-1-i] = e
+	}
+	return &object.Array{Elements: reversed}, nil
+}
+
+// sortArray returns the elements of an array sorted by less, which reports whether
+// its first argument goes before its second. Elements which go in neither order keep
+// the order they had. The sort stops at the first error less returns.
+func sortArray(obj object.Object, less func(a, b object.Object) (bool, error)) (*object.Array, error) {
+	elements, err := array(1, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := newArray(elements)
+	var failed error
+	sort.SliceStable(sorted.Elements, func(i, j int) bool {
+		if failed != nil {
+			return false
+		}
+		before, err := less(sorted.Elements[i], sorted.Elements[j])
+		failed = err
+		return before
+	})
+	if failed != nil {
+		return nil, failed
+	}
+	return sorted, nil
+}
+
+// contains reports whether a string contains the string sub, or an array an element
+// equal to sub.
+func contains(obj, sub object.Object) (bool, error) {
+	switch obj := obj.(type) {
+	case *object.String:
+		s, ok := sub.(*object.String)
+		if !ok {
+			return false, typeError(2, sub, object.STRING_OBJ)
+		}
+		return strings.Contains(obj.Value, s.Value), nil
+	case *object.Array:
+		for _, e := range obj.Elements {
+			if equal(e, sub) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, typeError(1, obj, object.STRING_OBJ, object.ARRAY_OBJ)
+}
+
+// keys returns the keys of a hash.
+func keys(obj object.Object) (*object.Array, error) {
+	pairs, err := sortedPairs(obj)
+	if err != nil {
+		return nil, err
+	}
+	elements := make([]object.Object, len(pairs))
+	for i, pair := range pairs {
+		elements[i] = pair.Key
+	}
+	return &object.Array{Elements: elements}, nil
+}
+
+// values returns the values of a hash, in the order of their keys.
+func values(obj object.Object) (*object.Array, error) {
+	pairs, err := sortedPairs(obj)
+	if err != nil {
+		return nil, err
+	}
+	elements := make([]object.Object, len(pairs))
+	for i, pair := range pairs {
+		elements[i] = pair.Value
+	}
+	return &object.Array{Elements: elements}, nil
+}
+
+// entries returns the pairs of a hash, each as an array of its key and value.
+func entries(obj object.Object) (*object.Array, error) {
+	pairs, err := sortedPairs(obj)
+	if err != nil {
+		return nil, err
+	}
+	elements := make([]object.Object, len(pairs))
+	for i, pair := range pairs {
+		elements[i] = &object.Array{Elements: []object.Object{pair.Key, pair.Value}}
+	}
+	return &object.Array{Elements: elements}, nil
+}
+
+// has reports whether a hash has the key key.
+func has(obj, key object.Object) (bool, error) {
+	h, err := hash(1, obj)
+	if err != nil {
+		return false, err
+	}
+	k, err := hashKey(key)
+	if err != nil {
+		return false, err
+	}
+	_, ok := h.Pairs[k]
+	return ok, nil
+}
+
+// remove returns a hash with the pairs of obj but the one with the key key, if
+// there is one. It is the library's delete.
+func remove(obj, key object.Object) (*object.Hash, error) {
+	h, err := hash(1, obj)
+	if err != nil {
+		return nil, err
+	}
+	k, err := hashKey(key)
+	if err != nil {
+		return nil, err
+	}
+	result := newHash(h)
+	delete(result.Pairs, k)
+	return result, nil
+}
+
+// merge returns a hash with the pairs of each of its arguments. A key in more than
+// one of them has its value from the last.
+func merge(obj object.Object, more ...object.Object) (*object.Hash, error) {
+	result := &object.Hash{Pairs: map[object.HashKey]object.HashPair{}}
+	for i, obj := range append([]object.Object{obj}, more...) {
+		h, err := hash(i+1, obj)
+		if err != nil {
+			return nil, err
+		}
+		for k, pair := range h.Pairs {
+			result.Pairs[k] = pair
+		}
+	}
+	return result, nil
+}
+
+// array returns the elements of obj, argument n of a function, which must be an
+// array.
+func array(n int, obj object.Object) ([]object.Object, error) {
+	a, ok := obj.(*object.Array)
+	if !ok {
+		return nil, typeError(n, obj, object.ARRAY_OBJ)
+	}
+	return a.Elements, nil
+}
+
+// nonEmpty returns the elements of obj, the first argument of a function, which must
+// be an array with at least one.
+func nonEmpty(obj object.Object) ([]object.Object, error) {
+	elements, err := array(1, obj)
+	if err != nil {
+		return nil, err
+	}
+	if len(elements) == 0 {
+		return nil, errors.New("empty array")
+	}
+	return elements, nil
+}
+
+// hash returns obj, argument n of a function, which must be a hash.
+func hash(n int, obj object.Object) (*object.Hash, error) {
+	h, ok := obj.(*object.Hash)
+	if !ok {
+		return nil, typeError(n, obj, object.HASH_OBJ)
+	}
+	return h, nil
+}
+
+// hashKey returns the HashKey of key, which must be usable as one.
+func hashKey(key object.Object) (object.HashKey, error) {
+	hashable, ok := key.(object.Hashable)
+	if !ok {
+		return object.HashKey{}, fmt.Errorf("unusable as hash key: %s", key.Type())
+	}
+	return hashable.HashKey(), nil
+}
+
+// sortedPairs returns the pairs of obj, the first argument of a function, which must
+// be a hash, ordered by key.
+func sortedPairs(obj object.Object) ([]object.HashPair, error) {
+	h, err := hash(1, obj)
+	if err != nil {
+		return nil, err
+	}
+	pairs := make([]object.HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool { return keyLess(pairs[i].Key, pairs[j].Key) })
+	return pairs, nil
+}
+
+// keyLess orders hash keys: by type, then integers by value, strings by their bytes
+// and false before true.
+func keyLess(a, b object.Object) bool {
+	if a.Type() != b.Type() {
+		return a.Type() < b.Type()
+	}
+	switch a := a.(type) {
+	case *object.Integer:
+		return a.Value < b.(*object.Integer).Value
+	case *object.String:
+		return a.Value < b.(*object.String).Value
+	case *object.Boolean:
+		return !a.Value && b.(*object.Boolean).Value
+	}
+	return false
+}
+
+// equal reports whether a and b are equal values: numbers, strings and booleans with
+// the same value, or arrays and hashes with equal contents. Other values are equal
+// only to themselves.
+func equal(a, b object.Object) bool {
+	switch a := a.(type) {
+	case *object.Integer:
+		b, ok := b.(*object.Integer)
+		return ok && a.Value == b.Value
+	case *object.Float:
+		b, ok := b.(*object.Float)
+		return ok && a.Value == b.Value
+	case *object.String:
+		b, ok := b.(*object.String)
+		return ok && a.Value == b.Value
+	case *object.Boolean:
+		b, ok := b.(*object.Boolean)
+		return ok && a.Value == b.Value
+	case *object.Null:
+		_, ok := b.(*object.Null)
+		return ok
+	case *object.Array:
+		b, ok := b.(*object.Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !equal(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *object.Hash:
+		b, ok := b.(*object.Hash)
+		if !ok || len(a.Pairs) != len(b.Pairs) {
+			return false
+		}
+		for k, pair := range a.Pairs {
+			other, ok := b.Pairs[k]
+			if !ok || !equal(pair.Value, other.Value) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// newArray returns a new array of elements followed by more.
+func newArray(elements []object.Object, more ...object.Object) *object.Array {
+	result := make([]object.Object, 0, len(elements)+len(more))
+	result = append(append(result, elements...), more...)
+	return &object.Array{Elements: result}
+}
+
+// newHash returns a copy of h.
+func newHash(h *object.Hash) *object.Hash {
+	pairs := make(map[object.HashKey]object.HashPair, len(h.Pairs))
+	for k, pair := range h.Pairs {
+		pairs[k] = pair
+	}
+	return &object.Hash{Pairs: pairs}
+}
@@ -0,0 +1,144 @@
+package stdlib
+
+import (
+	"capuchin/capuchin"
+	"capuchin/limits"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCollections(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`len(xs)`, int64(3)},
+		{`len(empty)`, int64(0)},
+		{`len(h)`, int64(2)},
+		{`first(xs)`, int64(3)},
+		{`last(xs)`, int64(2)},
+		{`rest(xs)`, []any{int64(1), int64(2)}},
+		{`rest(empty)`, []any{}},
+		{`push(xs, 4)`, []any{int64(3), int64(1), int64(2), int64(4)}},
+		{`push(empty, "a")`, []any{"a"}},
+		{`pop(xs)`, []any{int64(3), int64(1)}},
+		{`concat(xs, empty, rest(xs))`, []any{int64(3), int64(1), int64(2), int64(1), int64(2)}},
+		{`concat(empty)`, []any{}},
+		{`slice(xs, 1, 3)`, []any{int64(1), int64(2)}},
+		{`slice(xs, 0, 0)`, []any{}},
+		{`reverse(xs)`, []any{int64(2), int64(1), int64(3)}},
+		{`sort(xs, fn(a, b) { a < b })`, []any{int64(1), int64(2), int64(3)}},
+		{`sort(xs, fn(a, b) { a > b })`, []any{int64(3), int64(2), int64(1)}},
+		// Elements which go in neither order keep their order
+		{`sort(split("bb a cc d", " "), fn(a, b) { len(a) < len(b) })`, []any{"a", "d", "bb", "cc"}},
+		{`contains(xs, 1)`, true},
+		{`contains(xs, 4)`, false},
+		{`contains(xs, "1")`, false},
+		{`contains(push(empty, xs), xs)`, true},
+		{`keys(h)`, []any{"a", "b"}},
+		{`values(h)`, []any{int64(1), int64(2)}},
+		{`entries(h)`, []any{[]any{"a", int64(1)}, []any{"b", int64(2)}}},
+		{`has(h, "a")`, true},
+		{`has(h, "z")`, false},
+		{`has(h, 1)`, false},
+		{`delete(h, "a")`, map[string]any{"b": int64(2)}},
+		{`delete(h, "z")`, map[string]any{"a": int64(1), "b": int64(2)}},
+		{`merge(h, other)`, map[string]any{"a": int64(1), "b": int64(3), "c": int64(4)}},
+		{`merge(other, h)`, map[string]any{"a": int64(1), "b": int64(2), "c": int64(4)}},
+		// None of the functions changes its arguments
+		{`push(xs, 4); pop(xs); sort(xs, fn(a, b) { a < b }); xs`, []any{int64(3), int64(1), int64(2)}},
+		{`delete(h, "a"); merge(h, other); h`, map[string]any{"a": int64(1), "b": int64(2)}},
+		// Results can be indexed like any other array or hash
+		{`sort(xs, fn(a, b) { a < b })[0] + last(xs)`, int64(3)},
+		{`entries(h)[1][0]`, "b"},
+		{`merge({}, {"a": [1, 2]})["a"][1]`, int64(2)},
+		{`let counts = fn(words) { len(keys(merge({}, {words[0]: 1}))) }; counts(["x"])`, int64(1)},
+		{`keys({})`, []any{}},
+		{`reverse([[1], []])`, []any{[]any{}, []any{int64(1)}}},
+	}
+
+	in := newCollections(t)
+	for _, tt := range tests {
+		got, err := in.Run(tt.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: wrong result. want=%#v, got=%#v", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestCollectionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`first(empty)`, "first: empty array"},
+		{`last(h)`, "last: argument 1 must be ARRAY, got HASH"},
+		{`pop(empty)`, "pop: empty array"},
+		{`push(1, 2)`, "push: argument 1 must be ARRAY, got INTEGER"},
+		{`concat(xs, empty, h)`, "concat: argument 3 must be ARRAY, got HASH"},
+		{`slice(xs, 2, 4)`, "slice: bounds [2:4] out of range for length 3"},
+		{`slice(h, 0, 1)`, "slice: argument 1 must be STRING or ARRAY, got HASH"},
+		{`sort(xs, fn(a, b) { a < true })`, "sort: runtime error: type mismatch: INTEGER < BOOLEAN"},
+		{`sort(xs, fn(a, b) { 1 })`, "sort: result: cannot convert INTEGER to bool"},
+		{`contains(h, "a")`, "contains: argument 1 must be STRING or ARRAY, got HASH"},
+		{`keys(xs)`, "keys: argument 1 must be HASH, got ARRAY"},
+		{`has(h, xs)`, "has: unusable as hash key: ARRAY"},
+		{`delete(xs, "a")`, "delete: argument 1 must be HASH, got ARRAY"},
+		{`merge(h, xs)`, "merge: argument 2 must be HASH, got ARRAY"},
+		{`first(keys({}))`, "first: empty array"},
+	}
+
+	in := newCollections(t)
+	for _, tt := range tests {
+		_, err := in.Run(tt.input)
+		if err == nil {
+			t.Errorf("%q: expected an error, got none", tt.input)
+			continue
+		}
+		if rt, ok := err.(*capuchin.RuntimeError); !ok || rt.Message != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
+
+// TestSortLimits checks that the comparisons sort makes count towards the limits of
+// the program calling it, and that running out stops the program rather than being
+// reported as an error of sort.
+func TestSortLimits(t *testing.T) {
+	in := newInterpreter(t)
+	in.SetLimits(limits.Config{MaxSteps: 1000})
+
+	_, err := in.Run(`
+let spin = fn(n) { if (n > 0) { spin(n - 1) } else { true } };
+try { sort([3, 1, 2], fn(a, b) { spin(1000) }) } catch (e) { 0 }`)
+	if !errors.Is(err, limits.ErrSteps) {
+		t.Errorf("wrong error. want=%v, got=%v", limits.ErrSteps, err)
+	}
+
+	if got, err := in.Run(`sort([3, 1, 2], fn(a, b) { a < b })[0]`); err != nil || got != int64(1) {
+		t.Errorf("sort failed within the limits. got=%v (%v)", got, err)
+	}
+}
+
+// collections defines some collections for the tests to work on.
+const collections = `
+let xs = [3, 1, 2];
+let empty = [];
+let h = {"b": 2, "a": 1};
+let other = {"b": 3, "c": 4};
+`
+
+// newCollections returns an interpreter with the library and the collections
+// defined.
+func newCollections(t *testing.T) *capuchin.Interpreter {
+	in := newInterpreter(t)
+	if _, err := in.Run(collections); err != nil {
+		t.Fatal(err)
+	}
+	return in
+}
@@ -0,0 +1,95 @@
+// Package stdlib is the standard library of capuchin: functions written in Go which
+// are made available to scripts the way a host makes its own available, by
+// converting them with the capuchin package.
+//
+// Register adds the library to a capuchin.Interpreter, and Define to the environment
+// of a program run with the evaluator. Types gives the library's types for the
+// checker.
+package stdlib
+
+import (
+	"capuchin/capuchin"
+	"capuchin/object"
+	"capuchin/types"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// function is a function of the library and its type.
+type function struct {
+	fn any
+	t  types.Type
+}
+
+// library maps the name of each function in the library to it.
+var library = map[string]function{}
+
+// add adds the functions of fns to the library. Each name may be added only once.
+func add(fns map[string]function) {
+	for name, f := range fns {
+		if _, ok := library[name]; ok {
+			panic("stdlib: " + name + " added twice")
+		}
+		library[name] = f
+	}
+}
+
+// fnType returns the type of a function taking params and returning result.
+func fnType(result types.Type, params ...types.Type) types.Type {
+	return &types.Function{Params: params, Return: result}
+}
+
+// Names returns the names of the library's functions, sorted.
+func Names() []string {
+	names := make([]string, 0, len(library))
+	for name := range library {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Register registers the library's functions with in.
+func Register(in *capuchin.Interpreter) error {
+	for _, name := range Names() {
+		if err := in.Register(name, library[name].fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Define binds the library's functions in env.
+func Define(env *object.Environment) error {
+	for _, name := range Names() {
+		builtin, err := capuchin.Builtin(name, library[name].fn)
+		if err != nil {
+			return err
+		}
+		env.Set(name, builtin)
+	}
+	return nil
+}
+
+// Types adds the types of the library's functions to globals, the types given to a
+// program's predeclared names, and returns it.
+func Types(globals map[string]types.Type) map[string]types.Type {
+	for name, f := range library {
+		globals[name] = f.t
+	}
+	return globals
+}
+
+// typeError returns the error for argument n of a function, obj, not having one of
+// the types in want.
+func typeError(n int, obj object.Object, want ...object.ObjectType) error {
+	names := make([]string, len(want))
+	for i, t := range want {
+		names[i] = string(t)
+	}
+	if len(names) > 1 {
+		names = append(names[:len(names)-2], names[len(names)-2]+" or "+names[len(names)-1])
+	}
+	return fmt.Errorf("argument %d must be %s, got %s", n, strings.Join(names, ", "), obj.Type())
+}
@@ -0,0 +1,66 @@
+package stdlib
+
+import (
+	"capuchin/ast"
+	"capuchin/parsertest"
+	"capuchin/types"
+	"testing"
+)
+
+func TestTypes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		errors   []string
+	}{
+		{`split("a b", " ")`, "[string]", nil},
+		{`len("abc") + len(split("a", ","))`, "int", nil},
+		{`format("{}", 1, true)`, "string", nil},
+		{`first(split("a b", " "))`, "string", nil},
+		{`let xs = [3, 1, 2]; push(xs, 1)`, "[int]", nil},
+		{`let xs = [3, 1, 2]; concat(xs, xs, xs)`, "[int]", nil},
+		{`let xs = [3, 1, 2]; sort(xs, fn(a, b) { a < b })`, "[int]", nil},
+		{`let h = {"a": true}; keys(h)`, "[string]", nil},
+		{`let h = {"a": true}; values(h)`, "[bool]", nil},
+		{`let h = {"a": true}; merge(h, delete(h, "a"))`, "{string: bool}", nil},
+		{`let xs: [int] = []; first(xs) + len(push([], "a")[0])`, "int", nil},
+		{`entries({"a": 1})[0][1]`, "any", nil},
+		{
+			`len(1) + len(upper(2))`, "int",
+			[]string{`1:20-1:21: error: 2 has type int, expected string (type)`},
+		},
+		{
+			`let xs = [3, 1, 2]; push(xs, "a")`, "[int]",
+			[]string{`1:30-1:33: error: "a" has type string, expected int (type)`},
+		},
+		{
+			`let xs = [3, 1, 2]; sort(xs, fn(a) { true })`, "[int]",
+			[]string{`1:30-1:42: error: fn(a) { ... } has type fn(a) -> bool, expected fn(int, int) -> bool (type)`},
+		},
+		{
+			`let h = {"a": true}; has(h, 1)`, "bool",
+			[]string{`1:29-1:30: error: 1 has type int, expected string (type)`},
+		},
+	}
+
+	for _, tt := range tests {
+		program := parsertest.Parse(t, tt.input)
+		info, diags := types.Check(program, Types(types.Builtins()))
+
+		if len(diags) != len(tt.errors) {
+			t.Errorf("wrong number of errors for %q. want=%d, got=%d (%v)",
+				tt.input, len(tt.errors), len(diags), diags)
+			continue
+		}
+		for i, d := range diags {
+			if d.String() != tt.errors[i] {
+				t.Errorf("error %d wrong for %q. want=%q, got=%q", i, tt.input, tt.errors[i], d.String())
+			}
+		}
+
+		last := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+		if actual := info.TypeOf(last.Expression); actual == nil || actual.String() != tt.expected {
+			t.Errorf("wrong type for %q. want=%s, got=%v", tt.input, tt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package stdlib
+
+import (
+	"capuchin/object"
+	"capuchin/types"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// The string functions count and index strings in characters rather than bytes, so
+// "héllo" has a length of 5 and its "l" is at index 2. len, contains and slice take
+// strings too, and are with the collection functions.
+func init() {
+	s, strs := types.String, &types.Array{Element: types.String}
+
+	add(map[string]function{
+		"split":   {strings.Split, fnType(strs, s, s)},
+		"join":    {join, fnType(s, strs, s)},
+		"trim":    {strings.TrimSpace, fnType(s, s)},
+		"index":   {index, fnType(types.Int, s, s)},
+		"replace": {replace, fnType(s, s, s, s)},
+		"upper":   {strings.ToUpper, fnType(s, s)},
+		"lower":   {strings.ToLower, fnType(s, s)},
+		"repeat":  {repeat, fnType(s, s, types.Int)},
+		"format":  {format, &types.Function{Params: []types.Type{s, types.Any}, Return: s, Variadic: true}},
+	})
+}
+
+// join joins parts with sep between them. It takes the parts first, like split
+// returns them.
+func join(parts []string, sep string) string {
+	return strings.Join(parts, sep)
+}
+
+// index returns the index of the first sub in s, or -1 if there is none.
+func index(s, sub string) int {
+	i := strings.Index(s, sub)
+	if i < 0 {
+		return -1
+	}
+	return utf8.RuneCountInString(s[:i])
+}
+
+// replace replaces every old in s with new.
+func replace(s, old, new string) string {
+	return strings.ReplaceAll(s, old, new)
+}
+
+// repeat returns s repeated n times.
+func repeat(s string, n int) (string, error) {
+	if n < 0 {
+		return "", fmt.Errorf("negative count %d", n)
+	}
+	if n > 0 && len(s) > maxLength/n {
+		return "", errors.New("result too long")
+	}
+	return strings.Repeat(s, n), nil
+}
+
+// maxLength is the longest string repeat makes, so that a script cannot exhaust the
+// memory of its host with one call.
+const maxLength = 1 << 30
+
+// format replaces each {} in f with the next of args, as puts would print it. {{ and
+// }} stand for a brace of their own.
+func format(f string, args ...object.Object) (string, error) {
+	var out strings.Builder
+	next := 0
+
+	for i := 0; i < len(f); i++ {
+		switch {
+		case strings.HasPrefix(f[i:], "{{"), strings.HasPrefix(f[i:], "}}"):
+			out.WriteByte(f[i])
+			i++
+		case strings.HasPrefix(f[i:], "{}"):
+			if next == len(args) {
+				return "", fmt.Errorf("too few arguments for %q", f)
+			}
+			out.WriteString(args[next].Inspect())
+			next++
+			i++
+		case f[i] == '{' || f[i] == '}':
+			return "", fmt.Errorf("unmatched %c in %q", f[i], f)
+		default:
+			out.WriteByte(f[i])
+		}
+	}
+
+	if next < len(args) {
+		return "", fmt.Errorf("too many arguments for %q", f)
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,90 @@
+package stdlib
+
+import (
+	"capuchin/capuchin"
+	"reflect"
+	"testing"
+)
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`len("")`, int64(0)},
+		{`len("hello")`, int64(5)},
+		{`len("héllo")`, int64(5)},
+		{`split("a,b,,c", ",")`, []any{"a", "b", "", "c"}},
+		{`split("héy", "")`, []any{"h", "é", "y"}},
+		{`join(split("a b c", " "), "-")`, "a-b-c"},
+		{`join(split("", ","), "+")`, ""},
+		{`trim("  \t padded \n")`, "padded"},
+		{`contains("capuchin", "pu")`, true},
+		{`contains("capuchin", "x")`, false},
+		{`index("héllo", "l")`, int64(2)},
+		{`index("hello", "z")`, int64(-1)},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`upper("héllo")`, "HÉLLO"},
+		{`lower("ÀB")`, "àb"},
+		{`repeat("ab", 3)`, "ababab"},
+		{`repeat("ab", 0)`, ""},
+		{`slice("héllo", 1, 3)`, "él"},
+		{`slice("héllo", 0, 5)`, "héllo"},
+		{`slice("héllo", 2, 2)`, ""},
+		{`format("{} is {}", "x", 1)`, "x is 1"},
+		{`format("{} and {}", true, format("{}", "nested"))`, "true and nested"},
+		{`format("{{}} {}", 2)`, "{} 2"},
+		{`format("plain")`, "plain"},
+	}
+
+	in := newInterpreter(t)
+	for _, tt := range tests {
+		got, err := in.Run(tt.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: wrong result. want=%#v, got=%#v", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestStringErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`len(1)`, "len: argument 1 must be STRING, ARRAY or HASH, got INTEGER"},
+		{`contains("abc", 1)`, "contains: argument 2 must be STRING, got INTEGER"},
+		{`split("a")`, "wrong number of arguments: want=2, got=1"},
+		{`repeat("a", -1)`, "repeat: negative count -1"},
+		{`repeat("ab", 1073741824)`, "repeat: result too long"},
+		{`slice("héllo", 2, 6)`, "slice: bounds [2:6] out of range for length 5"},
+		{`slice("héllo", 3, 2)`, "slice: bounds [3:2] out of range for length 5"},
+		{`slice("héllo", -1, 2)`, "slice: bounds [-1:2] out of range for length 5"},
+		{`format("{} {}", 1)`, `format: too few arguments for "{} {}"`},
+		{`format("{}", 1, 2)`, `format: too many arguments for "{}"`},
+		{`format("{", 1)`, `format: unmatched { in "{"`},
+	}
+
+	in := newInterpreter(t)
+	for _, tt := range tests {
+		_, err := in.Run(tt.input)
+		if err == nil {
+			t.Errorf("%q: expected an error, got none", tt.input)
+			continue
+		}
+		if rt, ok := err.(*capuchin.RuntimeError); !ok || rt.Message != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func newInterpreter(t *testing.T) *capuchin.Interpreter {
+	in := capuchin.New()
+	if err := Register(in); err != nil {
+		t.Fatal(err)
+	}
+	return in
+}
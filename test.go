@@ -0,0 +1,149 @@
+package main
+
+import (
+	"capuchin/ast"
+	"capuchin/evaluator"
+	"capuchin/object"
+	"capuchin/stdlib"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// testSuffix ends the names of the files holding tests.
+const testSuffix = "_test.cap"
+
+// testCommand runs the tests in the named _test.cap files and in those found under
+// the named directories, by default the current one. A test is a function bound by a
+// let at the top level of its file whose name starts with "test", such as test_add.
+// Once the file has run each test is called with no arguments, and it fails if it
+// ends with an error, is stopped, as by calling exit, or returns false. The command
+// exits with 1 if any test failed and 2 if a file could not be read or parsed.
+func testCommand(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	verbose := flags.Bool("v", false, "list every test run, not only those failing")
+	searchPath := flags.String("path", "", "directories to look for imported modules in, separated as in PATH")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: capuchin test [-v] [-path dirs] [file_test.cap | directory]...")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files, err := testFiles(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	status := 0
+	for _, path := range files {
+		if code := runTests(path, *searchPath, *verbose); code > status {
+			status = code
+		}
+	}
+	return status
+}
+
+// testFiles returns the test files among paths and under the directories in them.
+func testFiles(paths []string) ([]string, error) {
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && strings.HasSuffix(p, testSuffix) {
+				files = append(files, p)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// runTests runs the tests in the file at path, with modules found in searchPath as
+// for runCommand, and returns the exit status for it.
+func runTests(path, searchPath string, verbose bool) int {
+	program, err := parseFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	globals := object.NewEnvironment()
+	globals.Set("args", stringArray(nil))
+	globals.Set("exit", &object.Builtin{Fn: exit})
+	if err := stdlib.Define(globals); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	env := object.NewEnclosedEnvironment(globals)
+	switch result := newLoader(searchPath, globals).Eval(program, env, path).(type) {
+	case *object.Error:
+		fmt.Printf("FAIL\t%s: runtime error: %s\n", path, result.Traceback())
+		return 1
+	case *object.Abort:
+		fmt.Printf("FAIL\t%s: %s\n", path, result.Err)
+		return 1
+	}
+
+	failed, run := 0, 0
+	for _, stmt := range program.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok || !strings.HasPrefix(let.Name.Value, "test") {
+			continue
+		}
+		fn, ok := env.Get(let.Name.Value)
+		if _, isFunction := fn.(*object.Function); !ok || !isFunction {
+			continue
+		}
+
+		run++
+		if reason := failure(evaluator.Apply(fn, nil)); reason != "" {
+			failed++
+			fmt.Printf("--- FAIL: %s\n\t%s\n", let.Name.Value, strings.ReplaceAll(reason, "\n", "\n\t"))
+		} else if verbose {
+			fmt.Printf("--- PASS: %s\n", let.Name.Value)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("FAIL\t%s\t%d of %d tests failed\n", path, failed, run)
+		return 1
+	}
+	fmt.Printf("ok\t%s\t%d tests\n", path, run)
+	return 0
+}
+
+// failure returns why a test which ended with result failed, or "" if it passed.
+func failure(result object.Object) string {
+	switch result := result.(type) {
+	case *object.Error:
+		return result.Traceback()
+	case *object.Abort:
+		return result.Err.Error()
+	}
+	if result == evaluator.FALSE {
+		return "returned false"
+	}
+	return ""
+}
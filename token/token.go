@@ -1,5 +1,10 @@
 package token
 
+import (
+	"fmt"
+	"sort"
+)
+
 // TokenType represents the particular type of source code token
 type TokenType string
 
@@ -7,6 +12,24 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position // Where the token starts in the source code
+}
+
+// Position identifies a location in the source code by its line and column.
+// Both are counted from 1, the zero value means the position is unknown.
+type Position struct {
+	Line   int
+	Column int
+}
+
+// IsValid reports whether the position refers to a real source location.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns the position in the "line:column" form.
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
 }
 
 const (
@@ -14,8 +37,9 @@ const (
 	EOF     = "EOF"
 
 	// Identifiers and literals
-	IDENT = "IDENT" // add, foobar, x ,y...
-	INT   = "INT"   // Integers 1,2,3,4...
+	IDENT  = "IDENT"  // add, foobar, x ,y...
+	INT    = "INT"    // Integers 1,2,3,4...
+	STRING = "STRING" // "foo", "a\tb"...
 
 	// Operators
 	ASSIGN   = "="
@@ -34,11 +58,16 @@ const (
 	// Delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
+	COLON     = ":"
+	ARROW     = "->"
+	DOT       = "."
 
-	LPAREN = "("
-	RPAREN = ")"
-	LBRACE = "{"
-	RBRACE = "}"
+	LPAREN   = "("
+	RPAREN   = ")"
+	LBRACE   = "{"
+	RBRACE   = "}"
+	LBRACKET = "["
+	RBRACKET = "]"
 
 	// Keywords
 	FUNCTION = "FUNCTION"
@@ -48,17 +77,51 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
+	IMPORT   = "IMPORT"
+	EXPORT   = "EXPORT"
+	AS       = "AS"
 )
 
 // keywords defines the language reserved keywords
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":      FUNCTION,
+	"let":     LET,
+	"true":    TRUE,
+	"false":   FALSE,
+	"if":      IF,
+	"else":    ELSE,
+	"return":  RETURN,
+	"try":     TRY,
+	"catch":   CATCH,
+	"finally": FINALLY,
+	"throw":   THROW,
+	"import":  IMPORT,
+	"export":  EXPORT,
+	"as":      AS,
+}
+
+// operators maps the source form of each expression operator to its token type.
+var operators = map[string]TokenType{
+	"+":  PLUS,
+	"-":  MINUS,
+	"!":  BANG,
+	"*":  ASTERISK,
+	"/":  SLASH,
+	"<":  LT,
+	">":  GT,
+	"==": EQ,
+	"!=": NOT_EQ,
+}
+
+// LookupOperator returns the token type of the supplied operator, such as PLUS for
+// "+". The boolean result is false if the string is not an operator.
+func LookupOperator(op string) (TokenType, bool) {
+	tok, ok := operators[op]
+	return tok, ok
 }
 
 // lookupIdent takes the supplied string and check first if it is reserved
@@ -71,3 +134,13 @@ func LookupIdent(ident string) TokenType {
 	}
 	return IDENT
 }
+
+// Keywords returns the language's reserved keywords in sorted order.
+func Keywords() []string {
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,9 @@
+package types
+
+// Builtins returns the types of the builtins which the evaluator and the virtual
+// machine provide, for checking the programs they run.
+func Builtins() map[string]Type {
+	return map[string]Type{
+		"puts": &Function{Params: []Type{Any}, Return: Null, Variadic: true},
+	}
+}
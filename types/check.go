@@ -0,0 +1,574 @@
+package types
+
+import (
+	"capuchin/ast"
+	"capuchin/diagnostic"
+	"capuchin/format"
+	"fmt"
+	"strings"
+)
+
+// TypeMismatch is the code of the diagnostics reported by the checker.
+const TypeMismatch = "type"
+
+// Env maps names to the type schemes of their bindings. The top level of a program
+// and each function body have their own Env.
+type Env struct {
+	outer *Env
+	names map[string]*Scheme
+}
+
+// NewEnv creates an Env enclosed by outer, which may be nil.
+func NewEnv(outer *Env) *Env {
+	return &Env{outer: outer, names: make(map[string]*Scheme)}
+}
+
+// Lookup returns the scheme bound to name in env or the environments enclosing it.
+func (e *Env) Lookup(name string) (*Scheme, bool) {
+	for env := e; env != nil; env = env.outer {
+		if s, ok := env.names[name]; ok {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// Define binds name to scheme in env.
+func (e *Env) Define(name string, scheme *Scheme) {
+	e.names[name] = scheme
+}
+
+// Info holds the types inferred by a Checker.
+type Info struct {
+	types map[ast.Expression]Type
+}
+
+// TypeOf returns the type inferred for exp, or nil if it was not checked.
+func (i *Info) TypeOf(exp ast.Expression) Type {
+	t, ok := i.types[exp]
+	if !ok {
+		return nil
+	}
+	return Resolve(t)
+}
+
+// Checker infers the types of programs. The bindings made by each program it checks
+// are kept, so a REPL can check one line at a time.
+type Checker struct {
+	env   *Env
+	level int
+	info  *Info
+	diags []diagnostic.Diagnostic
+
+	// returns holds the return type of each function being checked, innermost last.
+	returns []Type
+}
+
+// NewChecker creates a Checker whose programs can use the supplied builtins. The type
+// variables in the builtins' types, made with NewVariable, are generalised so that
+// each use of a builtin may differ.
+func NewChecker(builtins map[string]Type) *Checker {
+	universe := NewEnv(nil)
+	for name, t := range builtins {
+		universe.Define(name, &Scheme{Vars: freeVariables(t), Type: t})
+	}
+	return &Checker{env: NewEnv(universe)}
+}
+
+// Check is a convenience function which checks program with a new Checker.
+func Check(program *ast.Program, builtins map[string]Type) (*Info, []diagnostic.Diagnostic) {
+	return NewChecker(builtins).Check(program)
+}
+
+// Check infers the types in program, returning them along with a diagnostic for each
+// type error, ordered by position.
+func (c *Checker) Check(program *ast.Program) (*Info, []diagnostic.Diagnostic) {
+	c.info = &Info{types: make(map[ast.Expression]Type)}
+	c.diags = nil
+
+	c.returns = []Type{c.fresh()}
+	c.statements(program.Statements, false)
+	c.returns = nil
+
+	diagnostic.Sort(c.diags)
+	return c.info, c.diags
+}
+
+// Lookup returns the type scheme of a top level binding made by the checked programs.
+func (c *Checker) Lookup(name string) (*Scheme, bool) {
+	return c.env.Lookup(name)
+}
+
+// NewVariable returns a type variable which is not yet bound, for use in the types
+// of builtins.
+func NewVariable() *Variable {
+	return &Variable{}
+}
+
+func (c *Checker) fresh() *Variable {
+	return &Variable{level: c.level}
+}
+
+func (c *Checker) errorf(node ast.Node, format string, args ...interface{}) {
+	c.diags = append(c.diags, diagnostic.Diagnostic{
+		Pos:      ast.Pos(node),
+		End:      ast.End(node),
+		Severity: diagnostic.Error,
+		Code:     TypeMismatch,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// expect unifies the type of node with the expected type, reporting an error at node
+// if they cannot agree.
+func (c *Checker) expect(node ast.Expression, actual, expected Type) {
+	if err := unify(expected, actual); err != nil {
+		if _, ok := err.(*mismatch); ok {
+			c.errorf(node, "%s has type %s, expected %s",
+				source(node), Resolve(actual), Resolve(expected))
+		} else {
+			c.errorf(node, "%s has an infinite type: %v", source(node), err)
+		}
+	}
+}
+
+// source returns the source of node for use in messages, eliding the body of any
+// block spread over several lines.
+func source(node ast.Node) string {
+	src := format.Node(node)
+	if i := strings.IndexByte(src, '\n'); i >= 0 {
+		src = src[:i] + " ... }"
+	}
+	return src
+}
+
+// generalize turns t into a scheme, quantifying the variables created at a deeper
+// level than the current one. Those belong only to the let being generalised.
+func (c *Checker) generalize(t Type) *Scheme {
+	s := &Scheme{Type: t}
+	seen := make(map[*Variable]bool)
+
+	for _, v := range freeVariables(t) {
+		if v.level > c.level && !seen[v] {
+			seen[v] = true
+			s.Vars = append(s.Vars, v)
+		}
+	}
+
+	return s
+}
+
+// freeVariables returns the unbound variables within t.
+func freeVariables(t Type) []*Variable {
+	vars := []*Variable{}
+	var walk func(Type)
+	walk = func(t Type) {
+		t = prune(t)
+		if v, ok := t.(*Variable); ok {
+			vars = append(vars, v)
+		}
+		for _, p := range components(t) {
+			walk(p)
+		}
+	}
+	walk(t)
+	return vars
+}
+
+// instantiate returns the type of s with its quantified variables replaced by fresh
+// ones.
+func (c *Checker) instantiate(s *Scheme) Type {
+	if len(s.Vars) == 0 {
+		return s.Type
+	}
+
+	fresh := make(map[*Variable]Type)
+	for _, v := range s.Vars {
+		fresh[v] = c.fresh()
+	}
+
+	var copy func(Type) Type
+	copy = func(t Type) Type {
+		t = prune(t)
+		if v, ok := t.(*Variable); ok {
+			if f, ok := fresh[v]; ok {
+				return f
+			}
+			return v
+		}
+		return mapType(t, copy)
+	}
+
+	return copy(s.Type)
+}
+
+// statements checks a list of statements and returns the type of the value the list
+// evaluates to. The value of the last statement is only used if used is set, so that
+// if expressions whose value is discarded may have branches of different types.
+func (c *Checker) statements(stmts []ast.Statement, used bool) Type {
+	var result Type = Null
+
+	for i, stmt := range stmts {
+		last := used && i == len(stmts)-1
+
+		switch s := stmt.(type) {
+		case *ast.LetStatement:
+			c.let(s)
+			result = Null
+
+		case *ast.ExportStatement:
+			c.let(s.Statement)
+			result = Null
+
+		case *ast.ImportStatement:
+			// Modules are only loaded at run time, so their exports are not
+			// known, and each use of the name may take any type
+			v := c.fresh()
+			c.env.Define(s.Name.Value, &Scheme{Vars: []*Variable{v}, Type: v})
+			result = Null
+
+		case *ast.ReturnStatement:
+			var t Type = Null
+			if s.ReturnValue != nil {
+				t = c.expression(s.ReturnValue, true)
+				c.expect(s.ReturnValue, t, c.returns[len(c.returns)-1])
+			}
+			// Control never carries on past a return, so the list's own value
+			// can be whatever its context needs.
+			result = c.fresh()
+
+		case *ast.ThrowStatement:
+			// Any value may be thrown, and like a return a throw never
+			// carries on
+			c.expression(s.Value, true)
+			result = c.fresh()
+
+		case *ast.ExpressionStatement:
+			result = c.expression(s.Expression, last)
+
+		case *ast.BlockStatement:
+			result = c.statements(s.Statements, last)
+		}
+	}
+
+	return result
+}
+
+// let checks a let statement and binds its name. Function literals may refer to
+// themselves, so their name is bound, without generalising, while they are checked.
+// A type annotation must agree with the type of the value.
+func (c *Checker) let(s *ast.LetStatement) {
+	c.level++
+
+	var declared Type
+	if s.Type != nil {
+		declared = c.annotation(s.Type)
+	}
+
+	var self Type
+	if _, ok := s.Value.(*ast.FunctionLiteral); ok {
+		self = declared
+		if self == nil {
+			self = c.fresh()
+		}
+		c.env.Define(s.Name.Value, &Scheme{Type: self})
+	}
+
+	t := c.expression(s.Value, true)
+	if self != nil {
+		c.expect(s.Value, t, self)
+	} else if declared != nil {
+		c.expect(s.Value, t, declared)
+	}
+
+	// The binding takes the declared type, so "any" stays unchecked
+	if declared != nil {
+		t = declared
+	}
+
+	c.level--
+
+	c.env.Define(s.Name.Value, c.generalize(t))
+}
+
+// expression infers the type of exp. The used flag reports whether the value of exp
+// matters to its context.
+func (c *Checker) expression(exp ast.Expression, used bool) Type {
+	t := c.infer(exp, used)
+	if exp != nil {
+		c.info.types[exp] = t
+	}
+	return t
+}
+
+func (c *Checker) infer(exp ast.Expression, used bool) Type {
+	switch e := exp.(type) {
+	case *ast.IntegerLiteral:
+		return Int
+
+	case *ast.StringLiteral:
+		return String
+
+	case *ast.Boolean:
+		return Bool
+
+	case *ast.Identifier:
+		if s, ok := c.env.Lookup(e.Value); ok {
+			return c.instantiate(s)
+		}
+		// Undefined names are the resolver's to report, and names defined
+		// later in the program have no type yet.
+		return c.fresh()
+
+	case *ast.PrefixExpression:
+		right := c.expression(e.Right, true)
+		if e.Operator == "-" {
+			c.expect(e.Right, right, Int)
+			return Int
+		}
+		return Bool
+
+	case *ast.InfixExpression:
+		return c.infix(e)
+
+	case *ast.IfExpression:
+		c.expression(e.Condition, true)
+		consequence := c.block(e.Consequence, used)
+		if e.Alternative == nil {
+			return consequence
+		}
+		alternative := c.block(e.Alternative, used)
+		if used {
+			if err := unify(consequence, alternative); err != nil {
+				c.errorf(e, "if branches have different types %s and %s",
+					Resolve(consequence), Resolve(alternative))
+			}
+		}
+		return consequence
+
+	case *ast.TryExpression:
+		return c.try(e, used)
+
+	case *ast.MemberExpression:
+		// Properties are looked up at run time, in hashes of any shape
+		c.expression(e.Object, true)
+		return c.fresh()
+
+	case *ast.IndexExpression:
+		return c.index(e)
+
+	case *ast.ArrayLiteral:
+		return &Array{Element: c.elements(e.Elements)}
+
+	case *ast.HashLiteral:
+		return &Hash{Key: c.elements(e.Keys), Value: c.elements(e.Values)}
+
+	case *ast.FunctionLiteral:
+		return c.function(e)
+
+	case *ast.CallExpression:
+		return c.call(e)
+	}
+
+	return c.fresh()
+}
+
+// elements returns the type shared by the elements of an array, or the keys or the
+// values of a hash. Elements of different types, such as the fields of a hash used
+// as a record, are not an error: they give any, as the results of entries do.
+func (c *Checker) elements(exps []ast.Expression) Type {
+	var t Type = c.fresh()
+	for _, exp := range exps {
+		if err := unify(t, c.expression(exp, true)); err != nil {
+			t = Any
+		}
+	}
+	return t
+}
+
+// index checks an index expression. Whether an unknown value is indexed as an array
+// or a hash is only known at run time, so its element type is left unconstrained.
+func (c *Checker) index(e *ast.IndexExpression) Type {
+	left := c.expression(e.Left, true)
+	index := c.expression(e.Index, true)
+
+	switch l := prune(left).(type) {
+	case *Array:
+		c.expect(e.Index, index, Int)
+		return l.Element
+	case *Hash:
+		c.expect(e.Index, index, l.Key)
+		return l.Value
+	case *Variable:
+		return c.fresh()
+	}
+
+	if prune(left) != Any {
+		c.errorf(e, "cannot index %s in %s", Resolve(left), source(e))
+	}
+	return c.fresh()
+}
+
+func (c *Checker) block(block *ast.BlockStatement, used bool) Type {
+	if block == nil {
+		return Null
+	}
+	return c.statements(block.Statements, used)
+}
+
+// try checks a try expression, whose value is that of its body or its catch block.
+// What is caught may be any value, so the catch parameter is left unconstrained.
+func (c *Checker) try(e *ast.TryExpression, used bool) Type {
+	body := c.block(e.Body, used)
+
+	if e.Catch != nil {
+		c.env.Define(e.Parameter.Value, &Scheme{Type: c.fresh()})
+		catch := c.block(e.Catch, used)
+		if used {
+			if err := unify(body, catch); err != nil {
+				c.errorf(e, "try and catch blocks have different types %s and %s",
+					Resolve(body), Resolve(catch))
+			}
+		}
+	}
+
+	c.block(e.Finally, false)
+	return body
+}
+
+func (c *Checker) infix(e *ast.InfixExpression) Type {
+	left := c.expression(e.Left, true)
+	right := c.expression(e.Right, true)
+
+	switch e.Operator {
+	case "+":
+		// + joins strings as well as adding integers
+		if Resolve(left) == String || Resolve(right) == String {
+			c.expect(e.Left, left, String)
+			c.expect(e.Right, right, String)
+			return String
+		}
+		c.expect(e.Left, left, Int)
+		c.expect(e.Right, right, Int)
+		return Int
+
+	case "-", "*", "/":
+		c.expect(e.Left, left, Int)
+		c.expect(e.Right, right, Int)
+		return Int
+
+	case "<", ">":
+		c.expect(e.Left, left, Int)
+		c.expect(e.Right, right, Int)
+		return Bool
+
+	case "==", "!=":
+		if err := unify(left, right); err != nil {
+			c.errorf(e, "cannot compare %s with %s in %s",
+				Resolve(left), Resolve(right), source(e))
+		}
+		return Bool
+	}
+
+	return c.fresh()
+}
+
+func (c *Checker) function(fn *ast.FunctionLiteral) Type {
+	outer := c.env
+	c.env = NewEnv(outer)
+	defer func() { c.env = outer }()
+
+	// Annotations fix the types of parameters and the result, everything else
+	// is inferred
+	t := &Function{Return: c.fresh()}
+	if fn.ReturnType != nil {
+		t.Return = c.annotation(fn.ReturnType)
+	}
+	for i, param := range fn.Parameters {
+		var p Type = c.fresh()
+		if annotation := fn.ParameterType(i); annotation != nil {
+			p = c.annotation(annotation)
+		}
+		t.Params = append(t.Params, p)
+		c.env.Define(param.Value, &Scheme{Type: p})
+	}
+
+	c.returns = append(c.returns, t.Return)
+	body := c.block(fn.Body, true)
+	c.returns = c.returns[:len(c.returns)-1]
+
+	if err := unify(t.Return, body); err != nil {
+		c.errorf(fn, "function returns both %s and %s",
+			Resolve(t.Return), Resolve(body))
+	}
+
+	return t
+}
+
+// annotation returns the type written in a type annotation.
+func (c *Checker) annotation(te ast.TypeExpression) Type {
+	switch te := te.(type) {
+	case *ast.NamedType:
+		if b, ok := basics[te.Name]; ok {
+			return b
+		}
+		c.errorf(te, "unknown type %s", te.Name)
+		return Any
+
+	case *ast.ArrayType:
+		return &Array{Element: c.annotation(te.Element)}
+
+	case *ast.HashType:
+		return &Hash{Key: c.annotation(te.Key), Value: c.annotation(te.Value)}
+
+	case *ast.FunctionType:
+		f := &Function{Return: c.annotation(te.Return)}
+		for _, p := range te.Parameters {
+			f.Params = append(f.Params, c.annotation(p))
+		}
+		return f
+	}
+	return c.fresh()
+}
+
+func (c *Checker) call(e *ast.CallExpression) Type {
+	callee := c.expression(e.Function, true)
+
+	args := []Type{}
+	for _, arg := range e.Arguments {
+		args = append(args, c.expression(arg, true))
+	}
+
+	switch fn := prune(callee).(type) {
+	case *Function:
+		// Each argument is checked on its own, so errors point at it
+		required := len(fn.Params)
+		if fn.Variadic {
+			required--
+		}
+		if len(args) < required || (!fn.Variadic && len(args) > required) {
+			c.errorf(e, "%s takes %d arguments, got %d",
+				source(e.Function), len(fn.Params), len(args))
+			return fn.Return
+		}
+		for i, arg := range e.Arguments {
+			param := fn.Params[len(fn.Params)-1]
+			if i < required {
+				param = fn.Params[i]
+			}
+			c.expect(arg, args[i], param)
+		}
+		return fn.Return
+
+	case *Variable:
+		ret := c.fresh()
+		c.expect(e.Function, callee, &Function{Params: args, Return: ret})
+		return ret
+	}
+
+	if callee != Any {
+		c.errorf(e.Function, "%s is not a function, it has type %s",
+			source(e.Function), Resolve(callee))
+	}
+	return c.fresh()
+}
@@ -0,0 +1,237 @@
+package types
+
+import (
+	"capuchin/ast"
+	"capuchin/parsertest"
+	"testing"
+)
+
+// builtins returns the types the tests give to puts and to a generic apply.
+func builtins() map[string]Type {
+	a, b := NewVariable(), NewVariable()
+	return map[string]Type{
+		"puts":  &Function{Params: []Type{Any}, Return: Null, Variadic: true},
+		"apply": &Function{Params: []Type{&Function{Params: []Type{a}, Return: b}, a}, Return: b},
+	}
+}
+
+func TestInference(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5", "int"},
+		{"true", "bool"},
+		{"-5", "int"},
+		{"!5", "bool"},
+		{"1 + 2 * 3", "int"},
+		{"3 + 4 * 5 == 3 * 1 + 4 * 5", "bool"},
+		{"fn(x, y) { x + y; }", "fn(int, int) -> int"},
+		{"fn(x) { x }", "fn(a) -> a"},
+		{"fn(x, y) { x }", "fn(a, b) -> a"},
+		{"fn(x, y) { x == y }", "fn(a, a) -> bool"},
+		{"fn(f, x) { f(f(x)) }", "fn(fn(a) -> a, a) -> a"},
+		{"fn(f, g) { fn(x) { f(g(x)) } }", "fn(fn(a) -> b, fn(c) -> a) -> fn(c) -> b"},
+		{"fn(x) { fn(y) { x + y } }", "fn(int) -> fn(int) -> int"},
+		{"fn(x) { if (x) { return 1; } 2 }", "fn(a) -> int"},
+		{"fn(x) { if (x > 0) { true } else { false } }", "fn(int) -> bool"},
+		{"fn() { let y = 1; }", "fn() -> null"},
+		{"fn() { }", "fn() -> null"},
+		{"if (true) { 1 }", "int"},
+		{"puts(1, true)", "null"},
+		{"apply(fn(x) { x > 1 }, 2)", "bool"},
+		{"let id = fn(x) { x }; id(1); id(true)", "bool"},
+		{"let id = fn(x) { x }; let pair = fn(a, b) { a }; pair(id(1), id(true))", "int"},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact", "fn(int) -> int"},
+		{"let add = fn(x) { fn(y) { x + y } }; let inc = add(1); inc", "fn(int) -> int"},
+		{"let twice = fn(f) { fn(x) { f(f(x)) } }; twice(fn(x) { x * 2 })(5)", "int"},
+		{"try { 1 } catch (e) { 2 } finally { true }", "int"},
+		{"fn(x) { if (x) { throw 1; } 2 }", "fn(a) -> int"},
+		{"try { 1 } catch (e) { e.message }", "int"},
+		{`import "math" as m; m.add(1, 2) + 1`, "int"},
+		{`import "math" as m; let f = fn(x) { x }; f(m) == f(1)`, "bool"},
+		{"export let inc = fn(x) { x + 1 }; inc", "fn(int) -> int"},
+		{`"a" + "b"`, "string"},
+		{`fn(s) { s + "!" }`, "fn(string) -> string"},
+		{`fn(s) { "<" + s + ">" }`, "fn(string) -> string"},
+		{`"a" == "b"`, "bool"},
+		{"[1, 2 * 3]", "[int]"},
+		{"[]", "[a]"},
+		{"[[1], []]", "[[int]]"},
+		{`[1, "a"]`, "[any]"},
+		{`{"a": 1, "b": 2}`, "{string: int}"},
+		{`{"name": "x", "age": 3}`, "{string: any}"},
+		{"[1, 2][0]", "int"},
+		{`{"a": [true]}["a"][0]`, "bool"},
+		{"fn(xs) { xs[0] }", "fn(a) -> b"},
+		{"fn(xs: [int]) { xs[0] }", "fn([int]) -> int"},
+		{"let first = fn(xs) { xs[0] }; first([1]) + 1", "int"},
+	}
+
+	for _, tt := range tests {
+		program := parsertest.Parse(t, tt.input)
+		info, diags := Check(program, builtins())
+
+		if len(diags) != 0 {
+			t.Errorf("unexpected errors for %q: %v", tt.input, diags)
+			continue
+		}
+
+		last := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+		actual := info.TypeOf(last.Expression)
+		if actual == nil || actual.String() != tt.expected {
+			t.Errorf("wrong type for %q. want=%s, got=%v", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestTypeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"1 + true", []string{"1:5-1:9: error: true has type bool, expected int (type)"}},
+		{"let b = true; -b", []string{"1:16-1:17: error: b has type bool, expected int (type)"}},
+		{"1 < false", []string{"1:5-1:10: error: false has type bool, expected int (type)"}},
+		{"1 == true", []string{"1:1-1:10: error: cannot compare int with bool in 1 == true (type)"}},
+		{`"a" + 1`, []string{"1:7-1:8: error: 1 has type int, expected string (type)"}},
+		{`"a" - "b"`, []string{
+			`1:1-1:4: error: "a" has type string, expected int (type)`,
+			`1:7-1:10: error: "b" has type string, expected int (type)`,
+		}},
+		{
+			"let f = fn(x, y) { x + y; };\nf(1, true);",
+			[]string{"2:6-2:10: error: true has type bool, expected int (type)"},
+		},
+		{"let f = fn(x) { x }; f(1, 2)", []string{"1:22-1:28: error: f takes 1 arguments, got 2 (type)"}},
+		{"let x = 5; x(1)", []string{"1:12-1:13: error: x is not a function, it has type int (type)"}},
+		{
+			"let x = if (true) { 1 } else { false };",
+			[]string{"1:9-1:37: error: if branches have different types int and bool (type)"},
+		},
+		// Branches whose value is discarded may differ
+		{"if (true) { 1 } else { false }; 2", nil},
+		{
+			"fn(x) { if (x) { return 1; } true }",
+			[]string{"1:1-1:34: error: function returns both int and bool (type)"},
+		},
+		{"fn(x) { x(x) }", []string{"1:9-1:10: error: x has an infinite type: a would contain itself (type)"}},
+		// The parameter of a lambda bound by let is not generalised inside its body
+		{"fn(f) { f(1); f(true) }", []string{"1:17-1:21: error: true has type bool, expected int (type)"}},
+		{"puts(1 + true)", []string{"1:10-1:14: error: true has type bool, expected int (type)"}},
+		{
+			"let x = try { 1 } catch (e) { false };",
+			[]string{"1:9-1:36: error: try and catch blocks have different types int and bool (type)"},
+		},
+		{"throw 1 + true;", []string{"1:11-1:15: error: true has type bool, expected int (type)"}},
+		{`[1, 2]["a"]`, []string{`1:8-1:11: error: "a" has type string, expected int (type)`}},
+		{`{"a": 1}[1]`, []string{"1:10-1:11: error: 1 has type int, expected string (type)"}},
+		{"5[0]", []string{"1:1-1:4: error: cannot index int in 5[0] (type)"}},
+		{"[1][0] + true", []string{"1:10-1:14: error: true has type bool, expected int (type)"}},
+	}
+
+	for _, tt := range tests {
+		_, diags := Check(parsertest.Parse(t, tt.input), builtins())
+
+		if len(diags) != len(tt.expected) {
+			t.Errorf("wrong number of errors for %q. want=%d, got=%d (%v)",
+				tt.input, len(tt.expected), len(diags), diags)
+			continue
+		}
+		for i, d := range diags {
+			if d.String() != tt.expected[i] {
+				t.Errorf("error %d wrong for %q. want=%q, got=%q",
+					i, tt.input, tt.expected[i], d.String())
+			}
+		}
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		errors   []string
+	}{
+		{"let x: int = 5; x", "int", nil},
+		{"let x: any = 5; x", "any", nil},
+		{"fn(a: int, b) { b }", "fn(int, a) -> a", nil},
+		{"fn(a) -> bool { a }", "fn(bool) -> bool", nil},
+		{"let f: fn(int) -> int = fn(x) { x }; f", "fn(int) -> int", nil},
+		{"let id = fn(x: any) -> any { x }; id(1); id(true)", "any", nil},
+		{"let xs: [int] = ys; xs", "[int]", nil},
+		{"let h: {string: [bool]} = g; h", "{string: [bool]}", nil},
+		{
+			"let x: bool = 5; x", "bool",
+			[]string{"1:15-1:16: error: 5 has type int, expected bool (type)"},
+		},
+		{
+			"let f = fn(a: int, b: string) -> bool { a };", "",
+			[]string{"1:9-1:42: error: function returns both bool and int (type)"},
+		},
+		{
+			"let f = fn(a: int) { a }; f(true)", "int",
+			[]string{"1:29-1:33: error: true has type bool, expected int (type)"},
+		},
+		{
+			"let x: integer = 5; x", "any",
+			[]string{"1:8-1:15: error: unknown type integer (type)"},
+		},
+		{
+			"let fact: fn(int) -> bool = fn(n) { fact(n - 1) * n }; fact", "fn(int) -> bool",
+			[]string{
+				"1:29-1:52: error: fn(n) { ... } has type fn(int) -> int, expected fn(int) -> bool (type)",
+				"1:37-1:47: error: fact(n - 1) has type bool, expected int (type)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		program := parsertest.Parse(t, tt.input)
+		info, diags := Check(program, builtins())
+
+		if len(diags) != len(tt.errors) {
+			t.Errorf("wrong number of errors for %q. want=%d, got=%d (%v)",
+				tt.input, len(tt.errors), len(diags), diags)
+			continue
+		}
+		for i, d := range diags {
+			if d.String() != tt.errors[i] {
+				t.Errorf("error %d wrong for %q. want=%q, got=%q",
+					i, tt.input, tt.errors[i], d.String())
+			}
+		}
+
+		if tt.expected == "" {
+			continue
+		}
+		last := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+		actual := info.TypeOf(last.Expression)
+		if actual == nil || actual.String() != tt.expected {
+			t.Errorf("wrong type for %q. want=%s, got=%v", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestCheckerKeepsBindings(t *testing.T) {
+	c := NewChecker(builtins())
+
+	if _, diags := c.Check(parsertest.Parse(t, "let id = fn(x) { x };")); len(diags) != 0 {
+		t.Fatalf("unexpected errors: %v", diags)
+	}
+	if _, diags := c.Check(parsertest.Parse(t, "let n = id(5) + 1;")); len(diags) != 0 {
+		t.Fatalf("unexpected errors: %v", diags)
+	}
+
+	tests := map[string]string{"id": "fn(a) -> a", "n": "int"}
+	for name, expected := range tests {
+		scheme, ok := c.Lookup(name)
+		if !ok {
+			t.Errorf("%s is not bound", name)
+			continue
+		}
+		if scheme.String() != expected {
+			t.Errorf("wrong type for %s. want=%s, got=%s", name, expected, scheme)
+		}
+	}
+}
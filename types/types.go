@@ -0,0 +1,303 @@
+// Package types provides the optional static type checker for capuchin.
+//
+// Capuchin is dynamically typed, so checking never changes how a program runs. The
+// checker infers a type for every expression with Hindley-Milner inference,
+// generalising let bound values so that a function such as "fn(x) { x }" can be used
+// at many types, and reports the places where types cannot agree, such as "1 + true".
+package types
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Type is the type of a capuchin expression.
+type Type interface {
+	String() string
+	typeNode()
+}
+
+// Basic is a type with no structure, such as int.
+type Basic struct {
+	Name string
+}
+
+// The basic types. Any is the type of values which are not checked, such as the
+// arguments of puts, and agrees with every other type.
+var (
+	Int    = &Basic{Name: "int"}
+	Bool   = &Basic{Name: "bool"}
+	String = &Basic{Name: "string"}
+	Null   = &Basic{Name: "null"}
+	Any    = &Basic{Name: "any"}
+)
+
+// basics maps the names used in type annotations to the basic types.
+var basics = map[string]*Basic{
+	Int.Name:    Int,
+	Bool.Name:   Bool,
+	String.Name: String,
+	Null.Name:   Null,
+	Any.Name:    Any,
+}
+
+func (b *Basic) typeNode()      {}
+func (b *Basic) String() string { return b.Name }
+
+// Function is the type of a function taking Params and returning Return. A variadic
+// function accepts any number of trailing arguments of its last parameter's type.
+type Function struct {
+	Params   []Type
+	Return   Type
+	Variadic bool
+}
+
+func (f *Function) typeNode()      {}
+func (f *Function) String() string { return typeString(f) }
+
+// Array is the type of an array whose elements all have the type Element.
+type Array struct {
+	Element Type
+}
+
+func (a *Array) typeNode()      {}
+func (a *Array) String() string { return typeString(a) }
+
+// Hash is the type of a hash mapping keys of type Key to values of type Value.
+type Hash struct {
+	Key   Type
+	Value Type
+}
+
+func (h *Hash) typeNode()      {}
+func (h *Hash) String() string { return typeString(h) }
+
+// Variable is a type which is not known yet. Inference binds it to another type
+// once enough is known, after which it stands for that type.
+type Variable struct {
+	level    int  // The let nesting level the variable was created at
+	instance Type // The type the variable is bound to, nil while unbound
+}
+
+func (v *Variable) typeNode()      {}
+func (v *Variable) String() string { return typeString(v) }
+
+// Scheme is a type which may be used at many types, such as the "fn(a) -> a" of the
+// identity function. Each use instantiates Vars with fresh variables.
+type Scheme struct {
+	Vars []*Variable
+	Type Type
+}
+
+func (s *Scheme) String() string {
+	return typeString(s.Type)
+}
+
+// prune follows bound variables to the type they stand for.
+func prune(t Type) Type {
+	if v, ok := t.(*Variable); ok && v.instance != nil {
+		v.instance = prune(v.instance)
+		return v.instance
+	}
+	return t
+}
+
+// components returns the types directly within the composite type t, in a fixed
+// order, or nil if t is not composite.
+func components(t Type) []Type {
+	switch t := t.(type) {
+	case *Function:
+		return append(append([]Type{}, t.Params...), t.Return)
+	case *Array:
+		return []Type{t.Element}
+	case *Hash:
+		return []Type{t.Key, t.Value}
+	}
+	return nil
+}
+
+// rebuild returns a copy of the composite type t made up of parts, which are in the
+// order returned by components.
+func rebuild(t Type, parts []Type) Type {
+	switch t := t.(type) {
+	case *Function:
+		n := len(parts) - 1
+		return &Function{Params: parts[:n:n], Return: parts[n], Variadic: t.Variadic}
+	case *Array:
+		return &Array{Element: parts[0]}
+	case *Hash:
+		return &Hash{Key: parts[0], Value: parts[1]}
+	}
+	return t
+}
+
+// mapType returns t with f applied to each of its components.
+func mapType(t Type, f func(Type) Type) Type {
+	parts := components(t)
+	if parts == nil {
+		return t
+	}
+	for i, p := range parts {
+		parts[i] = f(p)
+	}
+	return rebuild(t, parts)
+}
+
+// Resolve returns t with every bound variable replaced by the type it stands for.
+func Resolve(t Type) Type {
+	return mapType(prune(t), Resolve)
+}
+
+// typeString prints t, naming its unbound variables a, b, c... in order of appearance.
+func typeString(t Type) string {
+	p := &typePrinter{names: make(map[*Variable]string)}
+	p.print(t)
+	return p.out.String()
+}
+
+type typePrinter struct {
+	out   bytes.Buffer
+	names map[*Variable]string
+}
+
+func (p *typePrinter) print(t Type) {
+	switch t := prune(t).(type) {
+	case *Basic:
+		p.out.WriteString(t.Name)
+
+	case *Variable:
+		name, ok := p.names[t]
+		if !ok {
+			name = variableName(len(p.names))
+			p.names[t] = name
+		}
+		p.out.WriteString(name)
+
+	case *Function:
+		p.out.WriteString("fn(")
+		for i, param := range t.Params {
+			if i > 0 {
+				p.out.WriteString(", ")
+			}
+			if t.Variadic && i == len(t.Params)-1 {
+				p.out.WriteString("...")
+			}
+			p.print(param)
+		}
+		p.out.WriteString(") -> ")
+		p.print(t.Return)
+
+	case *Array:
+		p.out.WriteString("[")
+		p.print(t.Element)
+		p.out.WriteString("]")
+
+	case *Hash:
+		p.out.WriteString("{")
+		p.print(t.Key)
+		p.out.WriteString(": ")
+		p.print(t.Value)
+		p.out.WriteString("}")
+	}
+}
+
+// variableName returns the name of the n-th type variable: a to z, then a1 to z1...
+func variableName(n int) string {
+	name := string(rune('a' + n%26))
+	if n >= 26 {
+		name += fmt.Sprint(n / 26)
+	}
+	return name
+}
+
+// occurs reports whether v appears within t, which would make binding v to t build
+// an infinite type.
+func occurs(v *Variable, t Type) bool {
+	t = prune(t)
+	if t == v {
+		return true
+	}
+	for _, p := range components(t) {
+		if occurs(v, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// mismatch is the error returned when two types cannot be unified.
+type mismatch struct {
+	a, b Type
+}
+
+func (m *mismatch) Error() string {
+	return fmt.Sprintf("%s and %s do not match", m.a, m.b)
+}
+
+// unify makes a and b the same type by binding variables, or returns an error if
+// that is impossible.
+func unify(a, b Type) error {
+	a, b = prune(a), prune(b)
+
+	if a == b || a == Any || b == Any {
+		return nil
+	}
+
+	if v, ok := a.(*Variable); ok {
+		return bind(v, b)
+	}
+	if v, ok := b.(*Variable); ok {
+		return bind(v, a)
+	}
+
+	if !sameShape(a, b) {
+		return &mismatch{a, b}
+	}
+
+	partsA, partsB := components(a), components(b)
+	for i := range partsA {
+		if err := unify(partsA[i], partsB[i]); err != nil {
+			return &mismatch{a, b}
+		}
+	}
+
+	return nil
+}
+
+// sameShape reports whether a and b are composite types of the same kind and arity,
+// so they can be unified component by component.
+func sameShape(a, b Type) bool {
+	switch a := a.(type) {
+	case *Function:
+		fb, ok := b.(*Function)
+		return ok && len(a.Params) == len(fb.Params) && a.Variadic == fb.Variadic
+	case *Array:
+		_, ok := b.(*Array)
+		return ok
+	case *Hash:
+		_, ok := b.(*Hash)
+		return ok
+	}
+	return false
+}
+
+// bind binds v to t, first lowering the level of the variables in t so that none
+// outlives the let that v belongs to when generalising.
+func bind(v *Variable, t Type) error {
+	if occurs(v, t) {
+		return fmt.Errorf("%s would contain itself", typeString(v))
+	}
+	adjustLevels(t, v.level)
+	v.instance = t
+	return nil
+}
+
+func adjustLevels(t Type, level int) {
+	t = prune(t)
+	if v, ok := t.(*Variable); ok && v.level > level {
+		v.level = level
+	}
+	for _, p := range components(t) {
+		adjustLevels(p, level)
+	}
+}
@@ -0,0 +1,223 @@
+package vm
+
+import (
+	"capuchin/compiler"
+	"capuchin/evaluator"
+	"capuchin/object"
+	"testing"
+)
+
+// differentialPrograms are run through both the evaluator and the VM, which must
+// agree on the result or on the runtime error.
+var differentialPrograms = []string{
+	// Arithmetic and comparison
+	"1 + 2 * 3 - 4 / 2",
+	"-(5 + 5) * -2",
+	"7 / 2",
+	"-7 / 2",
+	"9223372036854775807 + 1",
+	"1 < 2 == true",
+	"1 > 2 != false",
+	"1 == true",
+	"true == true",
+	"!0",
+	"!!false",
+
+	// Strings
+	`"mon" + "key"`,
+	`"a" + "b" == "ab"`,
+	`"a" != "a"`,
+	`"a" == 1`,
+	`"a" - "b"`,
+	`"a" < "b"`,
+	`"a" + 1`,
+	`-"a"`,
+
+	// Arrays and hashes
+	"[1, 2 * 2, 3 + 3]",
+	"[]",
+	"let xs = [1, 2, 3]; xs[0] + xs[1] + xs[2]",
+	"[1, 2, 3][3]",
+	"[1, 2, 3][-1]",
+	"[[1, 2], [3]][0][1]",
+	"let f = fn(x) { [x, x * 2] }; f(2)[1]",
+	`{"one": 10 - 9, "two": 1 + 1, "th" + "ree": 6 / 2, 4: 4, true: 5}`,
+	"{}",
+	`{"a": 1, "a": 2}`,
+	`{"foo": 5}["bar"]`,
+	`let h = {"xs": [1, 2]}; h.xs[1] + h["xs"][0]`,
+	`[{"name": "a"}][0].name`,
+	"let f = fn(h, k) { h[k] }; f({1: fn() { 7 }}, 1)()",
+	"[1, 2][true]",
+	"5[0]",
+	`{"a": 1}[[1]]`,
+	"let h = {\"a\": 1,\n  [2]: 3};",
+	"let xs = [1, -true];",
+	"let f = fn(xs) { xs[0] + true }; f([1])",
+	"let f = fn() { z }; [if (true) { let z = 5; }]; f()",
+	"let f = fn() { y }; {1: 2}[if (true) { let y = 1; }]; f()",
+
+	// Conditionals
+	"if (1 < 2) { 10 } else { 20 }",
+	"if (1 > 2) { 10 }",
+	"if (0) { 1 } else { 2 }",
+	"if (true) { }",
+	"if (true) { let a = 1; }",
+	"if (if (false) { 1 }) { 1 } else { 2 }",
+	"let x = if (true) { 1 } else { 2 }; x",
+	"if (true) { let inner = 4; }; inner",
+
+	// Bindings
+	"let a = 1; let b = a + 1; let c = a + b; c",
+	"let a = 1; let a = a + 10; a",
+	"let f = fn() { later() }; let later = fn() { 42 }; f()",
+
+	// Returns
+	"return 1; 2",
+	"if (true) { return 3; }; 4",
+	"let f = fn(x) { if (x > 0) { return x; }; -x }; f(-5) + f(5)",
+	"let f = fn() { let a = 1; }; f()",
+	"let f = fn() { }; f()",
+
+	// Functions and closures
+	"let add = fn(a, b) { a + b }; add(add(1, 2), add(3, 4))",
+	"fn(x) { x * x }(9)",
+	"let newAdder = fn(a) { fn(b) { a + b } }; let addTwo = newAdder(2); addTwo(40)",
+	"let compose = fn(f, g) { fn(x) { f(g(x)) } }; compose(fn(x) { x + 1 }, fn(x) { x * 2 })(5)",
+	"let outer = fn(a) { let b = a * 2; fn(c) { fn(d) { a + b + c + d } } }; outer(1)(2)(3)",
+	"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)",
+	`let wrapper = fn() {
+		let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } };
+		fact(10)
+	};
+	wrapper()`,
+	"let apply = fn(f, n) { if (n == 0) { 0 } else { f(n) + apply(f, n - 1) } }; apply(fn(x) { x * x }, 10)",
+	"let counter = fn(x) { if (x > 100) { return x; }; counter(x + 1) }; counter(0)",
+	"puts()",
+	"let f = fn() { puts() }; f()",
+	"let add = fn(a, b) { a + b }; let f = fn(x) { return add(x, 1); }; f(1)",
+	`let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+	let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+	even(100001)`,
+	"let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } }; sum(100000, 0)",
+	"let puts = fn(x) { x + 1 }; puts(1)",
+	"let f = fn() { let x = 1; let g = fn() { x }; let x = 2; g() }; f()",
+	"let f = fn() { let g = fn() { h() }; let h = fn() { 1 }; g() }; f()",
+	"let f = fn(x) { let g = fn() { x }; let x = x + 1; g() }; f(1)",
+	"let make = fn() { let n = 0; let get = fn() { n }; let n = n + 5; get }; make()()",
+	"let f = fn(a) { fn() { fn() { a } } }; f(3)()()",
+	"let x = 1; let f = fn() { let y = x; let x = 2; y * 10 + x }; f()",
+	"let f = fn() { let g = fn() { h() }; let r = g(); let h = fn() { 1 }; r }; f()",
+	"let f = fn() { y; let y = 1; }; f()",
+	"let f = fn(c) { if (c) { let a = 1; }; a }; f(false)",
+	"let f = fn(n) { if (n == 0) { a } else { let a = n; f(0) } }; f(3)",
+
+	// Exceptions
+	"try { 1 } catch (e) { 2 }",
+	"try { throw 5; 1 } catch (e) { e + 1 }",
+	"try { 1 + true } catch (e) { e.message }",
+	"try {\n  1 + true\n} catch (e) { e.line * 100 + e.column }",
+	"try { 1 + true } catch (e) { e.missing }",
+	"let f = fn() { -true };\nlet g = fn() { 1 + f() };\ntry { g() } catch (e) { e.stack }",
+	"let f = fn() { -true };\nlet g = fn() { try { 1 + f() } catch (e) { e } };\n1 + g().stack",
+	"let f = fn(n) { if (n == 0) { throw 42 } else { f(n - 1) } }; try { f(10) } catch (e) { e }",
+	"let f = fn() { try { throw 3 } catch (e) { e } }; f() + 1",
+	"try { try { throw 1 } catch (e) { throw e + 1 } } catch (e) { e * 10 }",
+	"try { try { throw 1 } finally { let a = 2; } } catch (e) { e + a }",
+	"try { let a = 1; throw 2; } catch (e) { let b = e; } finally { let c = 3; }; a * 100 + b * 10 + c",
+	"let f = fn(x) { try { if (x) { throw 1 } } catch (e) { let b = e; } finally { let c = 3; }; b + c }; f(true)",
+	"let f = fn(x) { try { if (x) { throw 1 } } finally { let c = 3; }; c }; f(false)",
+	"let f = fn() { try { return 1; } finally { let c = 3; }; 0 }; f()",
+	"try { 1 } finally { 2 }",
+	"try { let a = 1; } catch (e) { 2 }",
+	"let f = fn() { try { return 1; } finally { return 2; } }; f()",
+	"let f = fn() { try { 1 + true } finally { return 5; } }; f()",
+	"let f = fn() { try { return 1; } catch (e) { 2 }; 3 }; f()",
+	"let f = fn() { try { return g(); } catch (e) { 0 } }; let g = fn() { throw 1 }; f()",
+	"let f = fn() { try { throw 1 } catch (e) { return g(); } finally { 0 } }; let g = fn() { throw 2 }; try { f() } catch (e) { e }",
+	"let f = fn() { try { try { return 1; } finally { throw 2; } } catch (e) { e + 10 } }; f()",
+	"let f = fn() { try { try { return 1; } finally { let a = 2; } } finally { return a + 10; } }; f()",
+	"let f = fn(n) { try { if (n == 0) { throw 0 } else { f(n - 1) } } catch (e) { e + 1 } }; f(5)",
+	"let f = fn(n) { try { if (n == 0) { throw 0 } else { f(n - 1) } } finally { 0 } }; try { f(5) } catch (e) { e + 1 }",
+	"let loop = fn(n) { if (n == 0) { 0 } else { try { loop(n - 1) } catch (e) { -1 } } }; loop(200) + 1",
+	"try { try { throw 1; } finally { let g = fn() { let a = 1; let b = 2; a + b }; throw g(); } } catch (e) { e }",
+	"let f = fn() { try { throw 1; } finally { let g = fn() { let a = 1; let b = 2; let c = 3; a + b + c }; return g(); } }; f()",
+	"return try { 1 } finally { 2 }",
+	"throw 5",
+	"throw -1 < 0",
+	"let f = fn() { throw true };\nlet g = fn() { 1 + f() };\ng()",
+	"let f = fn() { 1 + true };\ntry { f() } finally { 0 }",
+	"let f = fn() { 1 + true };\nlet g = fn() { try { f() } finally { 0 } };\n1 + g()",
+	"try { throw 1 } catch (e) { e + true }",
+	"try { 1 } finally { -true }",
+	"5.x",
+	"let f = fn(x) { x.y }; f(1)",
+
+	// Runtime errors
+	"5 + true",
+	"5 + true; 5",
+	"true + false",
+	"-true",
+	"true < false",
+	"10 / (5 - 5)",
+	"let f = fn(a) { a }; f(1, 2)",
+	"let f = fn(a, b) { a }; f(1)",
+	"5(1)",
+	"let f = fn() { undefinedLater }; let r = f(); let undefinedLater = 1; r",
+	"let f = fn(x) { if (x == 0) { 1 + true } else { f(x - 1) } }; f(3)",
+	"let g = fn(a) { a }; let f = fn() { g(1, 2) }; f()",
+	"let f = fn() { 5(1) }; f()",
+	`let inner = fn(x) { x + true };
+	let outer = fn(y) { inner(y) * 2 };
+	outer(1)`,
+	"let a = fn() { b() }; let b = fn() { 1 / 0 }; let c = fn() { a() + 1 }; c()",
+	"fn(x) { fn() { -true }() + x }(1)",
+	"let f = fn(n) { if (n == 0) { -true } else { 1 + f(n - 1) } }; f(3)",
+}
+
+func TestDifferential(t *testing.T) {
+	for _, input := range differentialPrograms {
+		program := parse(input)
+
+		expected := evaluator.Eval(program, object.NewEnvironment())
+		if expected == nil {
+			t.Fatalf("evaluator produced no value for %q", input)
+		}
+
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Errorf("compiler error for %q: %s", input, err)
+			continue
+		}
+
+		vm := New(comp.Bytecode())
+		err := vm.Run()
+
+		if errObj, ok := expected.(*object.Error); ok {
+			if err == nil {
+				t.Errorf("%q: evaluator failed with %q, but the VM returned %s",
+					input, errObj.Message, vm.LastPoppedStackElem().Inspect())
+			} else if err.Error() != errObj.Message {
+				t.Errorf("%q: errors differ. evaluator=%q, vm=%q", input, errObj.Message, err)
+			} else if vmErr, ok := err.(*object.Error); !ok {
+				t.Errorf("%q: VM error is %T, not *object.Error", input, err)
+			} else if vmErr.Traceback() != errObj.Traceback() {
+				t.Errorf("%q: tracebacks differ.\nevaluator=%q\nvm       =%q",
+					input, errObj.Traceback(), vmErr.Traceback())
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: evaluator returned %s, but the VM failed with %q",
+				input, expected.Inspect(), err)
+			continue
+		}
+
+		got := vm.LastPoppedStackElem()
+		if got.Type() != expected.Type() || got.Inspect() != expected.Inspect() {
+			t.Errorf("%q: results differ. evaluator=%s (%s), vm=%s (%s)",
+				input, expected.Inspect(), expected.Type(), got.Inspect(), got.Type())
+		}
+	}
+}
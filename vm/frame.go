@@ -0,0 +1,28 @@
+package vm
+
+import (
+	"capuchin/code"
+	"capuchin/object"
+)
+
+// Frame is the activation of a closure: the closure, the offset of the next
+// instruction to run and where the closure's locals start on the stack.
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+}
+
+// NewFrame creates a frame which will start running cl at its first instruction.
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{
+		cl:          cl,
+		ip:          -1,
+		basePointer: basePointer,
+	}
+}
+
+// Instructions returns the bytecode of the frame's function.
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
@@ -0,0 +1,895 @@
+// Package vm executes the bytecode produced by the compiler on a stack machine.
+//
+// Every value computed by the program passes through the VM's stack. Each function
+// call pushes a Frame whose locals live on the stack above the base pointer, except
+// tail calls, which reuse the caller's frame. The locals start out unset, apart from
+// the parameters, and those captured by closures are moved into cells. Globals are
+// kept in a separate store so the REPL can share them between runs. Runtime errors
+// carry the same messages as the evaluator's.
+//
+// Runtime errors and thrown values unwind the program to the handler most recently
+// installed by OpTry, which is still installed only while its try is running.
+//
+// A VM given a limits.Config with SetLimits counts every instruction it runs as a
+// step, and every call frame, closure and integer it creates as allocated.
+package vm
+
+import (
+	"capuchin/code"
+	"capuchin/compiler"
+	"capuchin/limits"
+	"capuchin/object"
+	"fmt"
+)
+
+// The sizes of the VM's fixed areas.
+const (
+	StackSize   = 2048
+	GlobalsSize = 65536
+	MaxFrames   = 1024
+)
+
+// The values which only ever need one instance.
+var (
+	True  = &object.Boolean{Value: true}
+	False = &object.Boolean{Value: false}
+	Null  = &object.Null{}
+)
+
+// VM runs one compiled program.
+type VM struct {
+	constants []object.Object
+
+	stack []object.Object
+	sp    int // Always points to the next free slot. Top of stack is stack[sp-1]
+
+	globals     []object.Object
+	globalNames []string
+
+	frames      []*Frame
+	framesIndex int
+
+	handlers []handler
+
+	result object.Object // the value the program ended with, see Result
+
+	meter *limits.Meter // nil when there are no limits
+}
+
+// handler is where the program resumes when an error unwinds it: the depth of the
+// frame stack, the stack pointer and the offset of the next instruction in the frame
+// which installed it.
+type handler struct {
+	framesIndex int
+	sp          int
+	ip          int
+}
+
+// New creates a VM for bytecode with an empty global store.
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, Lines: bytecode.Lines}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
+	return &VM{
+		constants: bytecode.Constants,
+
+		stack: make([]object.Object, StackSize),
+		sp:    0,
+
+		globals:     make([]object.Object, GlobalsSize),
+		globalNames: bytecode.Globals,
+
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+// NewWithGlobalsStore creates a VM for bytecode which uses, and updates, the globals
+// left by an earlier run.
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+// SetLimits makes Run stop with an error as soon as the program goes beyond the
+// limits of config, or its context is done.
+func (vm *VM) SetLimits(config limits.Config) {
+	vm.meter = limits.NewMeter(config)
+}
+
+// LastPoppedStackElem returns the value most recently popped off the stack, which
+// after Run is the value of the program's last expression statement.
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.stack[vm.sp]
+}
+
+// Result returns the value the program ended with, as the evaluator has it: the value
+// returned at the top level, or else that of the last statement if it is an
+// expression statement. It is nil until Run has finished, and if the program ended
+// with a let.
+func (vm *VM) Result() object.Object {
+	return vm.result
+}
+
+// Run executes the program until it finishes or fails. A runtime error which is not
+// caught is returned as an *object.Error, with the position of the failing
+// instruction and the calls in progress, as far as the line tables tell them.
+func (vm *VM) Run() error {
+	for {
+		err := vm.run()
+		if err == nil || limits.Stopped(err) {
+			return err
+		}
+
+		rerr := vm.runtimeError(err)
+		if !vm.catch(rerr) {
+			return rerr
+		}
+	}
+}
+
+func (vm *VM) run() error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		if err := vm.meter.Step(); err != nil {
+			return err
+		}
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if err := vm.push(vm.constants[constIndex]); err != nil {
+				return err
+			}
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+			if err := vm.executeBinaryOperation(op); err != nil {
+				return err
+			}
+
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+			if err := vm.executeComparison(op); err != nil {
+				return err
+			}
+
+		case code.OpBang:
+			operand := vm.pop()
+			if err := vm.push(nativeBoolToBooleanObject(!isTruthy(operand))); err != nil {
+				return err
+			}
+
+		case code.OpMinus:
+			if err := vm.executeMinusOperator(); err != nil {
+				return err
+			}
+
+		case code.OpTrue:
+			if err := vm.push(True); err != nil {
+				return err
+			}
+
+		case code.OpFalse:
+			if err := vm.push(False); err != nil {
+				return err
+			}
+
+		case code.OpNull:
+			if err := vm.push(Null); err != nil {
+				return err
+			}
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			condition := vm.pop()
+			if !isTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			global := vm.globals[globalIndex]
+			if global == nil {
+				return fmt.Errorf("identifier not found: %s", vm.globalName(int(globalIndex)))
+			}
+			if err := vm.push(global); err != nil {
+				return err
+			}
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			local := vm.stack[frame.basePointer+int(localIndex)]
+			if local == nil {
+				return fmt.Errorf("identifier not found: %s", vm.localName(int(localIndex)))
+			}
+			if err := vm.push(local); err != nil {
+				return err
+			}
+
+		case code.OpCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			slot := &vm.stack[vm.currentFrame().basePointer+int(localIndex)]
+			vm.meter.Alloc(limits.ObjectSize)
+			*slot = &object.Cell{Name: vm.localName(int(localIndex)), Value: *slot}
+
+		case code.OpGetCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			cell := vm.stack[vm.currentFrame().basePointer+int(localIndex)].(*object.Cell)
+			if cell.Value == nil {
+				return fmt.Errorf("identifier not found: %s", cell.Name)
+			}
+			if err := vm.push(cell.Value); err != nil {
+				return err
+			}
+
+		case code.OpSetCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			cell := vm.stack[vm.currentFrame().basePointer+int(localIndex)].(*object.Cell)
+			cell.Value = vm.pop()
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			definition := object.Builtins[builtinIndex]
+			if err := vm.push(definition.Builtin); err != nil {
+				return err
+			}
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			free := vm.currentFrame().cl.Free[freeIndex]
+			if cell, ok := free.(*object.Cell); ok {
+				if cell.Value == nil {
+					return fmt.Errorf("identifier not found: %s", cell.Name)
+				}
+				free = cell.Value
+			}
+			if err := vm.push(free); err != nil {
+				return err
+			}
+
+		case code.OpGetFreeCell:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			if err := vm.push(currentClosure.Free[freeIndex]); err != nil {
+				return err
+			}
+
+		case code.OpCurrentClosure:
+			currentClosure := vm.currentFrame().cl
+			if err := vm.push(currentClosure); err != nil {
+				return err
+			}
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.executeCall(int(numArgs)); err != nil {
+				return err
+			}
+
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.executeTailCall(int(numArgs)); err != nil {
+				return err
+			}
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+			if vm.returnFromMain(returnValue) {
+				return nil
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(returnValue); err != nil {
+				return err
+			}
+
+		case code.OpReturn:
+			if vm.returnFromMain(Null) {
+				return nil
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(Null); err != nil {
+				return err
+			}
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			if err := vm.pushClosure(int(constIndex), int(numFree)); err != nil {
+				return err
+			}
+
+		case code.OpTry:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			vm.handlers = append(vm.handlers, handler{framesIndex: vm.framesIndex, sp: vm.sp, ip: pos})
+
+		case code.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case code.OpThrow:
+			value := vm.pop()
+			if err, ok := value.(*object.Error); ok {
+				return rethrow{err}
+			}
+			return &object.Error{Message: "uncaught exception: " + value.Inspect(), Value: value}
+
+		case code.OpCatch:
+			err := vm.pop().(*object.Error)
+			if err := vm.push(err.Caught()); err != nil {
+				return err
+			}
+
+		case code.OpMember:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if err := vm.executeMember(vm.constants[constIndex].(*object.String).Value); err != nil {
+				return err
+			}
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			elements := make([]object.Object, numElements)
+			copy(elements, vm.stack[vm.sp-numElements:vm.sp])
+			vm.sp -= numElements
+
+			vm.meter.Alloc(limits.ObjectSize + limits.SlotSize*int64(numElements))
+			if err := vm.push(&object.Array{Elements: elements}); err != nil {
+				return err
+			}
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp -= numElements
+
+			if err := vm.push(hash); err != nil {
+				return err
+			}
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+
+			if err := vm.executeIndexExpression(left, index); err != nil {
+				return err
+			}
+
+		default:
+			def, err := code.Lookup(byte(op))
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("unhandled opcode %s", def.Name)
+		}
+	}
+
+	if op == code.OpPop {
+		vm.result = vm.LastPoppedStackElem()
+	}
+	return nil
+}
+
+// rethrow is an error thrown again by the finally block which caught it.
+type rethrow struct {
+	err *object.Error
+}
+
+func (r rethrow) Error() string { return r.err.Message }
+
+// runtimeError locates err at the instruction the current frame has reached, and
+// adds a stack frame for each function call in progress. Calls replaced by a tail
+// call are not among them. An error thrown again keeps its position and the frames
+// it has unwound so far.
+func (vm *VM) runtimeError(err error) *object.Error {
+	frame := vm.currentFrame()
+
+	var rerr *object.Error
+	switch err := err.(type) {
+	case rethrow:
+		rerr = err.err
+	case *object.Error:
+		rerr = err
+		rerr.Pos = frame.cl.Fn.Lines.Lookup(frame.ip)
+	default:
+		rerr = &object.Error{Message: err.Error(), Pos: frame.cl.Fn.Lines.Lookup(frame.ip)}
+	}
+
+	for i := vm.framesIndex - 1; i > 0; i-- {
+		caller := vm.frames[i-1]
+		rerr.Stack = append(rerr.Stack, object.StackFrame{
+			Function: object.FunctionName(vm.frames[i].cl.Fn.Name),
+			Pos:      caller.cl.Fn.Lines.Lookup(caller.ip),
+		})
+	}
+
+	return rerr
+}
+
+// catch unwinds the program to the most recent handler, which it removes, and pushes
+// err for the handler's code, keeping only the stack frames of the calls unwound. It
+// reports whether there was a handler.
+func (vm *VM) catch(err *object.Error) bool {
+	if len(vm.handlers) == 0 {
+		return false
+	}
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	err.Stack = err.Stack[:len(err.Stack)-(h.framesIndex-1)]
+
+	vm.framesIndex = h.framesIndex
+	vm.sp = h.sp
+	vm.currentFrame().ip = h.ip - 1
+
+	return vm.push(err) == nil
+}
+
+// returnFromMain handles a return statement at the top level of the program, which
+// ends it with the returned value as its result. It reports whether the main frame
+// was the one returning.
+func (vm *VM) returnFromMain(returnValue object.Object) bool {
+	if vm.framesIndex > 1 {
+		return false
+	}
+
+	vm.sp = 0
+	vm.stack[vm.sp] = returnValue
+	vm.result = returnValue
+	return true
+}
+
+func (vm *VM) globalName(index int) string {
+	if index < len(vm.globalNames) && vm.globalNames[index] != "" {
+		return vm.globalNames[index]
+	}
+	return fmt.Sprintf("global %d", index)
+}
+
+func (vm *VM) localName(index int) string {
+	locals := vm.currentFrame().cl.Fn.Locals
+	if index < len(locals) && locals[index] != "" {
+		return locals[index]
+	}
+	return fmt.Sprintf("local %d", index)
+}
+
+func (vm *VM) executeBinaryOperation(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	leftType := left.Type()
+	rightType := right.Type()
+
+	if leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ {
+		return vm.executeBinaryIntegerOperation(op, left, right)
+	}
+	if leftType == object.STRING_OBJ && rightType == object.STRING_OBJ && op == code.OpAdd {
+		value := left.(*object.String).Value + right.(*object.String).Value
+		vm.meter.Alloc(limits.ObjectSize + int64(len(value)))
+		return vm.push(&object.String{Value: value})
+	}
+
+	if leftType != rightType {
+		return fmt.Errorf("type mismatch: %s %s %s", leftType, operators[op], rightType)
+	}
+	return fmt.Errorf("unknown operator: %s %s %s", leftType, operators[op], rightType)
+}
+
+// operators maps the opcodes of the binary operators back to their source form, for
+// error messages.
+var operators = map[code.Opcode]string{
+	code.OpAdd:         "+",
+	code.OpSub:         "-",
+	code.OpMul:         "*",
+	code.OpDiv:         "/",
+	code.OpEqual:       "==",
+	code.OpNotEqual:    "!=",
+	code.OpGreaterThan: ">",
+	code.OpLessThan:    "<",
+}
+
+func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.Integer).Value
+	rightValue := right.(*object.Integer).Value
+
+	var result int64
+
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
+	case code.OpSub:
+		result = leftValue - rightValue
+	case code.OpMul:
+		result = leftValue * rightValue
+	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = leftValue / rightValue
+	default:
+		return fmt.Errorf("unknown integer operator: %d", op)
+	}
+
+	vm.meter.Alloc(limits.ObjectSize)
+	return vm.push(&object.Integer{Value: result})
+}
+
+func (vm *VM) executeComparison(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
+		return vm.executeIntegerComparison(op, left, right)
+	}
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		return vm.executeStringComparison(op, left, right)
+	}
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(right == left))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(right != left))
+	}
+
+	if left.Type() != right.Type() {
+		return fmt.Errorf("type mismatch: %s %s %s", left.Type(), operators[op], right.Type())
+	}
+	return fmt.Errorf("unknown operator: %s %s %s", left.Type(), operators[op], right.Type())
+}
+
+// executeStringComparison compares two strings by value. Only == and != apply to
+// strings.
+func (vm *VM) executeStringComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
+	default:
+		return fmt.Errorf("unknown operator: %s %s %s", left.Type(), operators[op], right.Type())
+	}
+}
+
+func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.Integer).Value
+	rightValue := right.(*object.Integer).Value
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(rightValue == leftValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+func (vm *VM) executeMinusOperator() error {
+	operand := vm.pop()
+
+	if operand.Type() != object.INTEGER_OBJ {
+		return fmt.Errorf("unknown operator: -%s", operand.Type())
+	}
+
+	value := operand.(*object.Integer).Value
+	vm.meter.Alloc(limits.ObjectSize)
+	return vm.push(&object.Integer{Value: -value})
+}
+
+// executeMember replaces the hash on top of the stack with the value of its property
+// name, or null if it has none.
+func (vm *VM) executeMember(name string) error {
+	hash, ok := vm.pop().(*object.Hash)
+	if !ok {
+		return fmt.Errorf("no property %s on %s", name, vm.stack[vm.sp].Type())
+	}
+
+	pair, ok := hash.Pairs[(&object.String{Value: name}).HashKey()]
+	if !ok {
+		return vm.push(Null)
+	}
+	return vm.push(pair.Value)
+}
+
+// buildHash makes a hash of the alternate keys and values on the stack from start
+// up to end. A key given twice keeps its last value.
+func (vm *VM) buildHash(start, end int) (*object.Hash, error) {
+	pairs := make(map[object.HashKey]object.HashPair, (end-start)/2)
+
+	for i := start; i < end; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashable, ok := key.(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+		pairs[hashable.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	vm.meter.Alloc(limits.ObjectSize + 2*limits.SlotSize*int64(len(pairs)))
+	return &object.Hash{Pairs: pairs}, nil
+}
+
+// executeIndexExpression pushes the element of an array, or the value of a hash,
+// found at index. An index outside the array, or a key missing from the hash, gives
+// null.
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		elements := left.(*object.Array).Elements
+		i := index.(*object.Integer).Value
+		if i < 0 || i >= int64(len(elements)) {
+			return vm.push(Null)
+		}
+		return vm.push(elements[i])
+
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		pair, ok := left.(*object.Hash).Pairs[key.HashKey()]
+		if !ok {
+			return vm.push(Null)
+		}
+		return vm.push(pair.Value)
+
+	default:
+		return fmt.Errorf("index operator not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return fmt.Errorf("not a function: %s", callee.Type())
+	}
+}
+
+// executeTailCall makes a call whose result the current function returns. A call to
+// a closure takes over the current frame: the callee and its arguments are moved
+// down to where the current closure and its locals were, so the depth of the frame
+// stack does not change. Anything else, or a call from the main program, is made as
+// usual and its result returned by the OpReturnValue which follows.
+func (vm *VM) executeTailCall(numArgs int) error {
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok || vm.framesIndex == 1 {
+		return vm.executeCall(numArgs)
+	}
+
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs)
+	}
+
+	frame := vm.currentFrame()
+	if frame.basePointer+cl.Fn.NumLocals >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	frame.cl = cl
+	frame.ip = -1
+
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	vm.unsetLocals(frame, numArgs)
+
+	return nil
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs)
+	}
+
+	if vm.framesIndex >= MaxFrames {
+		return fmt.Errorf("stack overflow")
+	}
+	if err := vm.meter.Call(vm.framesIndex); err != nil {
+		return err
+	}
+
+	vm.meter.Alloc(limits.ObjectSize)
+	frame := NewFrame(cl, vm.sp-numArgs)
+	if frame.basePointer+cl.Fn.NumLocals >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+	vm.pushFrame(frame)
+
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	vm.unsetLocals(frame, numArgs)
+
+	return nil
+}
+
+// unsetLocals clears the locals of frame which follow its arguments, so that a use
+// of one before its let fails rather than finding what was left on the stack.
+func (vm *VM) unsetLocals(frame *Frame, numArgs int) {
+	for i := frame.basePointer + numArgs; i < vm.sp; i++ {
+		vm.stack[i] = nil
+	}
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result := builtin.Call(callFromBuiltin, args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	// A builtin fails as the evaluator has it fail, raising its error where it is
+	// called
+	if err, ok := result.(*object.Error); ok {
+		return err
+	}
+	if result != nil {
+		return vm.push(result)
+	}
+	return vm.push(Null)
+}
+
+// callFromBuiltin is the object.Caller given to builtins. The VM cannot stop part
+// way through an instruction to run a closure, so builtins may only call builtins.
+func callFromBuiltin(fn object.Object, args ...object.Object) object.Object {
+	if builtin, ok := fn.(*object.Builtin); ok {
+		return builtin.Call(callFromBuiltin, args...)
+	}
+	return &object.Error{Message: fmt.Sprintf("cannot call %s from a builtin", fn.Type())}
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	vm.meter.Alloc(limits.ObjectSize + limits.SlotSize*int64(numFree))
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+func (vm *VM) push(o object.Object) error {
+	if vm.sp >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return True
+	}
+	return False
+}
+
+// isTruthy reports whether obj counts as true in a condition: everything but null
+// and false does.
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
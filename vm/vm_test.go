@@ -0,0 +1,390 @@
+package vm
+
+import (
+	"capuchin/ast"
+	"capuchin/compiler"
+	"capuchin/lexer"
+	"capuchin/limits"
+	"capuchin/object"
+	"capuchin/parser"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type vmTestCase struct {
+	input    string
+	expected interface{}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1", 1},
+		{"1 + 2", 3},
+		{"4 / 2", 2},
+		{"50 / 2 * 2 + 10 - 5", 55},
+		{"5 * (2 + 10)", 60},
+		{"-50 + 100 + -50", 0},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 == 2", false},
+		{"true != false", true},
+		{"(1 < 2) == true", true},
+		{"!(if (false) { 5; })", true},
+		{"!!5", true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (false) { 10 }", Null},
+		{"if (true) { let a = 1; }", Null},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let one = 1; one", 1},
+		{"let one = 1; let two = one + one; one + two", 3},
+		{"let f = fn() { g() }; let g = fn() { 7 }; f()", 7},
+		{"export let f = fn() { g() }; export let g = fn() { 7 }; f()", 7},
+		{"return 5; 6", 5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestResult(t *testing.T) {
+	tests := []vmTestCase{
+		{"1; 2", 2},
+		{"let x = 5", nil},
+		{"let x = 5; x; let y = 6", nil},
+		{"", nil},
+		{"return 3; let y = 1", 3},
+		{"if (true) { return 4 }; let y = 1", 4},
+		{"try { 1 / 0 } catch (e) { 7 }", 7},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+
+		if tt.expected == nil {
+			if result := vm.Result(); result != nil {
+				t.Errorf("%q: wrong result. want=nil, got=%s", tt.input, result.Inspect())
+			}
+			continue
+		}
+		testExpectedObject(t, tt.input, tt.expected, vm.Result())
+	}
+}
+
+func TestCallingFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn() { 5 + 10; }; f();", 15},
+		{"let f = fn() { return 99; 100; }; f();", 99},
+		{"let f = fn() { }; f();", Null},
+		{"let sum = fn(a, b) { let c = a + b; c; }; sum(1, 2) + sum(3, 4);", 10},
+		{"let newAdder = fn(a) { fn(b) { a + b } }; newAdder(2)(3);", 5},
+		{`let wrapper = fn() {
+			let countDown = fn(x) { if (x == 0) { return 0; } else { countDown(x - 1); } };
+			countDown(1);
+		};
+		wrapper();`, 0},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{"let countdown = fn(n) { if (n == 0) { 0 } else { countdown(n - 1) } }; countdown(1000000)", 0},
+		{"let countdown = fn(n) { if (n == 0) { return 0; }; return countdown(n - 1); }; countdown(1000000)", 0},
+		{`let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+		let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+		even(10000)`, true},
+		{`let loop = fn(n, acc) {
+			let next = fn(x) { x + 1 };
+			if (n == 0) { acc } else { loop(n - 1, next(acc)) }
+		};
+		loop(5000, 0)`, 5000},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestArrayLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"[]", []int{}},
+		{"[1, 2, 3]", []int{1, 2, 3}},
+		{"[1 + 2, 3 * 4, 5 + 6]", []int{3, 12, 11}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3][1]", 2},
+		{"[[1, 1, 1]][0][0]", 1},
+		{"[][0]", Null},
+		{"[1, 2, 3][99]", Null},
+		{"[1][-1]", Null},
+		{"{1: 1, 2: 2}[1]", 1},
+		{"{1: 1, 2: 2}[2]", 2},
+		{"{1: 1}[0]", Null},
+		{"{}[0]", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + true", "type mismatch: INTEGER + BOOLEAN"},
+		{"true > false", "unknown operator: BOOLEAN > BOOLEAN"},
+		{"-true", "unknown operator: -BOOLEAN"},
+		{"1 / 0", "division by zero"},
+		{"fn(a) { a }()", "wrong number of arguments: want=1, got=0"},
+		{"1()", "not a function: INTEGER"},
+		{"let f = fn() { g }; f(); let g = 1;", "identifier not found: g"},
+		{"let f = fn() { 1 + f() }; f()", "stack overflow"},
+		{"[1][true]", "index operator not supported: ARRAY[BOOLEAN]"},
+		{"{fn() { 1 }: 2}", "unusable as hash key: CLOSURE"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err := vm.Run()
+		if err == nil {
+			t.Errorf("expected VM error for %q but resulted in none.", tt.input)
+			continue
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error for %q: want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestBuiltinErrors(t *testing.T) {
+	failing := &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return &object.Error{Message: "failed"}
+	}}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = 0;\n1 + f()", "failed\n\tat <main> (2:5)"},
+		{"let f = 0;\nlet g = fn() { f() };\ng()", "failed\n\tat g (2:16)\n\tat <main> (3:1)"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		// The builtin takes the place of the 0 bound to f
+		bytecode := comp.Bytecode()
+		bytecode.Constants[0] = failing
+
+		err := New(bytecode).Run()
+		rerr, ok := err.(*object.Error)
+		if !ok {
+			t.Errorf("%q: wrong error. want=*object.Error, got=%T (%v)", tt.input, err, err)
+			continue
+		}
+		if got := rerr.Traceback(); got != tt.expected {
+			t.Errorf("%q: wrong traceback.\nwant=%q\ngot =%q", tt.input, tt.expected, got)
+		}
+	}
+
+	// The error can be caught like any other
+	comp := compiler.New()
+	if err := comp.Compile(parse("let f = 0; try { f() } catch (e) { e.message }")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+	bytecode.Constants[0] = failing
+	vm := New(bytecode)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if got := vm.LastPoppedStackElem().Inspect(); got != "failed" {
+		t.Errorf("wrong value caught. want=%q, got=%q", "failed", got)
+	}
+}
+
+func TestTraceback(t *testing.T) {
+	input := "let inner = fn(x) { x / 0 };\nlet outer = fn() {\n  1 + inner(2)\n};\nouter()"
+	expected := "division by zero\n\tat inner (1:23)\n\tat outer (3:7)\n\tat <main> (5:1)"
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	err := New(comp.Bytecode()).Run()
+	rerr, ok := err.(*object.Error)
+	if !ok {
+		t.Fatalf("wrong error. want=*object.Error, got=%T (%v)", err, err)
+	}
+	if got := rerr.Traceback(); got != expected {
+		t.Errorf("wrong traceback.\nwant=%q\ngot =%q", expected, got)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		config   limits.Config
+		expected error
+	}{
+		{"let f = fn() { f() }; f()", limits.Config{MaxSteps: 10000}, limits.ErrSteps},
+		{"let f = fn() { 1 + f() }; f()", limits.Config{MaxDepth: 100}, limits.ErrDepth},
+		{"let f = fn(n) { f(n + 1) }; f(0)", limits.Config{MaxMemory: 1 << 20}, limits.ErrMemory},
+		{"let f = fn() { fn() { f } }; let g = fn() { f(); g() }; g()", limits.Config{MaxMemory: 1 << 20}, limits.ErrMemory},
+		{"1 + 2", limits.Config{Context: cancelled}, context.Canceled},
+		{"let f = fn() { try { f() } catch (e) { 0 } }; f()", limits.Config{MaxDepth: 100}, limits.ErrDepth},
+		{"let f = fn() { try { f() } finally { 0 } }; f()", limits.Config{MaxSteps: 1000}, limits.ErrSteps},
+		{"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)",
+			limits.Config{MaxSteps: 100000, MaxDepth: 20, MaxMemory: 1 << 20}, nil},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.SetLimits(tt.config)
+		err := vm.Run()
+
+		if tt.expected == nil {
+			if err != nil {
+				t.Errorf("%q: unexpected error within limits: %s", tt.input, err)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%q: wrong error. want=%v, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	comp := compiler.New()
+	if err := comp.Compile(parse("let f = fn() { f() }; f()")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	vm.SetLimits(limits.Config{Context: ctx})
+
+	start := time.Now()
+	err := vm.Run()
+
+	if !errors.Is(err, limits.ErrCancelled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wrong error. want=%v, got=%v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("program stopped %s after it started, long after its deadline", elapsed)
+	}
+}
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+
+		testExpectedObject(t, tt.input, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func testExpectedObject(t *testing.T, input string, expected interface{}, actual object.Object) {
+	t.Helper()
+
+	switch expected := expected.(type) {
+	case int:
+		result, ok := actual.(*object.Integer)
+		if !ok || result.Value != int64(expected) {
+			t.Errorf("%q: wrong integer. want=%d, got=%T (%+v)", input, expected, actual, actual)
+		}
+
+	case bool:
+		result, ok := actual.(*object.Boolean)
+		if !ok || result.Value != expected {
+			t.Errorf("%q: wrong boolean. want=%t, got=%T (%+v)", input, expected, actual, actual)
+		}
+
+	case []int:
+		array, ok := actual.(*object.Array)
+		if !ok || len(array.Elements) != len(expected) {
+			t.Errorf("%q: wrong array. want=%v, got=%T (%+v)", input, expected, actual, actual)
+			return
+		}
+		for i, e := range expected {
+			testExpectedObject(t, input, e, array.Elements[i])
+		}
+
+	case *object.Null:
+		if actual != Null {
+			t.Errorf("%q: object is not Null: %T (%+v)", input, actual, actual)
+		}
+	}
+}